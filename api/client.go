@@ -24,6 +24,7 @@ import (
 	"net/http"
 	"net/url"
 	"runtime"
+	"strconv"
 
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
@@ -289,6 +290,19 @@ func (c *Client) Create(ctx context.Context, req *CreateRequest, fn CreateProgre
 	})
 }
 
+// CreateBatch creates several models at once. If any model fails to build, no
+// model is written. fn is called for each progress update as in [Client.Create].
+func (c *Client) CreateBatch(ctx context.Context, req *CreateBatchRequest, fn CreateProgressFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/create/batch", req, func(bts []byte) error {
+		var resp ProgressResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
 // List lists models that are available locally.
 func (c *Client) List(ctx context.Context) (*ListResponse, error) {
 	var lr ListResponse
@@ -298,6 +312,16 @@ func (c *Client) List(ctx context.Context) (*ListResponse, error) {
 	return &lr, nil
 }
 
+// ListAll lists the models that are available locally, including drafts,
+// which [Client.List] leaves out.
+func (c *Client) ListAll(ctx context.Context) (*ListResponse, error) {
+	var lr ListResponse
+	if err := c.do(ctx, http.MethodGet, "/api/tags?drafts=true", nil, &lr); err != nil {
+		return nil, err
+	}
+	return &lr, nil
+}
+
 // ListRunning lists running models.
 func (c *Client) ListRunning(ctx context.Context) (*ProcessResponse, error) {
 	var lr ProcessResponse
@@ -307,6 +331,16 @@ func (c *Client) ListRunning(ctx context.Context) (*ProcessResponse, error) {
 	return &lr, nil
 }
 
+// Quantizations lists the quantization types the server can create models
+// with.
+func (c *Client) Quantizations(ctx context.Context) (*QuantizationsResponse, error) {
+	var qr QuantizationsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/quantizations", nil, &qr); err != nil {
+		return nil, err
+	}
+	return &qr, nil
+}
+
 // Copy copies a model - creating a model with another name from an existing
 // model.
 func (c *Client) Copy(ctx context.Context, req *CopyRequest) error {
@@ -324,6 +358,160 @@ func (c *Client) Delete(ctx context.Context, req *DeleteRequest) error {
 	return nil
 }
 
+// CancelCreate cancels the creates in progress which match req. Their blobs
+// which no model uses are removed and they fail with an error saying they
+// were canceled.
+func (c *Client) CancelCreate(ctx context.Context, req *CancelCreateRequest) (*CancelCreateResponse, error) {
+	var resp CancelCreateResponse
+	if err := c.do(ctx, http.MethodPost, "/api/create/cancel", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreatePreview estimates the size of a model once it's quantized, from the
+// shapes of its tensors, so it can be checked before a long quantization.
+func (c *Client) CreatePreview(ctx context.Context, req *CreatePreviewRequest) (*CreatePreviewResponse, error) {
+	var resp CreatePreviewResponse
+	if err := c.do(ctx, http.MethodPost, "/api/create/preview", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Rollback restores the version of a model that existed before it was last
+// created. The create must have kept the previous layers with NoPrune.
+func (c *Client) Rollback(ctx context.Context, req *RollbackRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/rollback", req, nil)
+}
+
+// Protect protects a model from being overwritten by a create, or unprotects
+// it so it can be.
+func (c *Client) Protect(ctx context.Context, req *ProtectRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/protect", req, nil)
+}
+
+// Promote clears the draft status of a model created as a draft so it's
+// listed by [Client.List].
+func (c *Client) Promote(ctx context.Context, req *PromoteRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/promote", req, nil)
+}
+
+// Template renders a prompt template with sample conversations so it can be
+// checked before it's used to create a model.
+func (c *Client) Template(ctx context.Context, req *TemplateRequest) (*TemplateResponse, error) {
+	var resp TemplateResponse
+	if err := c.do(ctx, http.MethodPost, "/api/template", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Export writes a model to w as a single GGUF file. Its template, system
+// prompt, parameters, messages and license are stored in the file's metadata.
+// If req.Bundle is set only the metadata is written, as a JSON [ModelBundle].
+// fn is called with the progress of the export as it's written.
+func (c *Client) Export(ctx context.Context, req *ExportRequest, w io.Writer, fn func(ProgressResponse) error) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base.JoinPath("/api/export").String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/octet-stream")
+	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+
+		return checkError(response, body)
+	}
+
+	status := "exporting " + req.Model
+	if err := fn(ProgressResponse{Status: status, Total: response.ContentLength}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1<<20)
+	var completed int64
+	for {
+		n, err := response.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+
+			completed += int64(n)
+			if err := fn(ProgressResponse{Status: status, Total: response.ContentLength, Completed: completed}); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return fn(ProgressResponse{Status: "success"})
+}
+
+// Diff compares a model with a base model, reporting the layers which differ
+// and line diffs of their templates, system prompts and parameters.
+func (c *Client) Diff(ctx context.Context, req *DiffRequest) (*DiffResponse, error) {
+	var resp DiffResponse
+	if err := c.do(ctx, http.MethodPost, "/api/diff", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Layers lists the layers of a model, with their media types, digests and
+// sizes, and its config. The model isn't loaded and its blobs aren't read.
+func (c *Client) Layers(ctx context.Context, req *LayersRequest) (*LayersResponse, error) {
+	var resp LayersResponse
+	if err := c.do(ctx, http.MethodPost, "/api/layers", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Verify checks the blobs of a model against their digests, and that those
+// of its model, adapter and projector layers can be parsed, reporting the
+// result of each layer. The model isn't changed.
+func (c *Client) Verify(ctx context.Context, req *VerifyRequest) (*VerifyResponse, error) {
+	var resp VerifyResponse
+	if err := c.do(ctx, http.MethodPost, "/api/verify", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Recover reports the models whose blobs no manifest references, and
+// rebuilds their manifests if req.Write is set.
+func (c *Client) Recover(ctx context.Context, req *RecoverRequest) (*RecoverResponse, error) {
+	var resp RecoverResponse
+	if err := c.do(ctx, http.MethodPost, "/api/recover", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Show obtains model information, including details, modelfile, license etc.
 func (c *Client) Show(ctx context.Context, req *ShowRequest) (*ShowResponse, error) {
 	var resp ShowResponse
@@ -366,6 +554,65 @@ func (c *Client) CreateBlob(ctx context.Context, digest string, r io.Reader) err
 	return c.do(ctx, http.MethodPost, fmt.Sprintf("/api/blobs/%s", digest), r, nil)
 }
 
+// uploadChunkSize is the size of each request [Client.Upload] sends.
+const uploadChunkSize = 64 << 20
+
+// Upload streams r to the server in chunks without knowing its digest up
+// front, for files too large to buffer or hash before creating a model. It
+// returns the ID of the upload, which is referred to as "upload:<id>" in the
+// Files or Adapters of a [CreateRequest].
+func (c *Client) Upload(ctx context.Context, r io.Reader) (string, error) {
+	var upload UploadResponse
+	if err := c.do(ctx, http.MethodPost, "/api/uploads", nil, &upload); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, uploadChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := c.uploadChunk(ctx, &upload, buf[:n]); err != nil {
+				return "", err
+			}
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return upload.ID, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
+
+func (c *Client) uploadChunk(ctx context.Context, upload *UploadResponse, chunk []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPatch, c.base.JoinPath("/api/uploads", upload.ID).String(), bytes.NewReader(chunk))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Upload-Offset", strconv.FormatInt(upload.Size, 10))
+	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := checkError(response, body); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, upload)
+}
+
 // Version returns the Ollama server version as a string.
 func (c *Client) Version(ctx context.Context) (string, error) {
 	var version struct {
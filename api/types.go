@@ -1,6 +1,7 @@
 package api
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -53,6 +54,10 @@ type GenerateRequest struct {
 	// System overrides the model's default system message/prompt.
 	System string `json:"system"`
 
+	// Locale selects the model's system prompt for a locale, if it has one,
+	// as a BCP 47 language tag such as "fr" or "pt-BR".
+	Locale string `json:"locale,omitempty"`
+
 	// Template overrides the model's default prompt template.
 	Template string `json:"template"`
 
@@ -73,6 +78,10 @@ type GenerateRequest struct {
 	// this request.
 	KeepAlive *Duration `json:"keep_alive,omitempty"`
 
+	// Think enables or disables reasoning for models which support it. If
+	// unset, the model's think parameter is used.
+	Think *bool `json:"think,omitempty"`
+
 	// Images is an optional list of base64-encoded images accompanying this
 	// request, for multimodal models.
 	Images []ImageData `json:"images,omitempty"`
@@ -100,6 +109,12 @@ type ChatRequest struct {
 	// following the request.
 	KeepAlive *Duration `json:"keep_alive,omitempty"`
 
+	// Think enables or disables reasoning, as in [GenerateRequest].
+	Think *bool `json:"think,omitempty"`
+
+	// Locale selects the model's system prompt, as in [GenerateRequest].
+	Locale string `json:"locale,omitempty"`
+
 	// Tools is an optional list of tools the model has access to.
 	Tools `json:"tools,omitempty"`
 
@@ -298,7 +313,39 @@ type CreateRequest struct {
 	Stream   *bool  `json:"stream,omitempty"`
 	Quantize string `json:"quantize,omitempty"`
 
-	From       string            `json:"from,omitempty"`
+	// QuantizePolicy quantizes groups of the model's tensors to their own
+	// types rather than Quantize, e.g. {"attention": "q8_0", "ffn": "q4_0"}
+	// keeps attention at a higher precision than the feed forward network.
+	// The groups are attention, ffn, output and token_embd. It requires
+	// Quantize.
+	QuantizePolicy map[string]string `json:"quantize_policy,omitempty"`
+
+	// Aliases are additional names the model is written under. Every name
+	// shares the same layers.
+	Aliases []string `json:"aliases,omitempty"`
+
+	// Headers are added to the requests which pull the model in From when
+	// it isn't local, e.g. an Authorization header for a gated registry.
+	// They're only used by this request and aren't stored with the model.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// UserAgent replaces the server's User-Agent in the requests which pull
+	// the model in From.
+	UserAgent string `json:"user_agent,omitempty"`
+
+	// Archive is the digest of a tar, or gzipped tar, blob of the model's
+	// files such as a safetensors directory. Its files are used as if they
+	// were listed in Files.
+	Archive string `json:"archive,omitempty"`
+
+	// TemplateName references a template in the server's template directory
+	// as name or name:version, where the version defaults to "latest". It
+	// can't be combined with Template.
+	TemplateName string `json:"template_name,omitempty"`
+
+	From string `json:"from,omitempty"`
+	// Files maps the names of the model's files to the digests of their
+	// blobs or, for files sent with [Client.Upload], to "upload:<id>".
 	Files      map[string]string `json:"files,omitempty"`
 	Adapters   map[string]string `json:"adapters,omitempty"`
 	Template   string            `json:"template,omitempty"`
@@ -307,12 +354,217 @@ type CreateRequest struct {
 	Parameters map[string]any    `json:"parameters,omitempty"`
 	Messages   []Message         `json:"messages,omitempty"`
 
+	// InlineFiles and InlineAdapters map the names of small files, such as
+	// tokenizer configurations or adapters, to their base64 encoded content.
+	// They're used as if their blobs were listed in Files and Adapters.
+	InlineFiles    map[string]string `json:"inline_files,omitempty"`
+	InlineAdapters map[string]string `json:"inline_adapters,omitempty"`
+
+	// Systems maps locales, as BCP 47 language tags such as "fr" or
+	// "pt-BR", to the system prompts used for requests in them. System is
+	// used for requests in any other locale. An empty map removes the
+	// prompts of the model being created from.
+	Systems map[string]string `json:"systems"`
+
+	// StrictMessages rejects Messages whose roles are out of order, such as
+	// two user messages in a row, rather than warning about them.
+	StrictMessages bool `json:"strict_messages,omitempty"`
+
+	// Preset names a set of sampling parameters to use, one of "creative",
+	// "balanced", "precise" or "deterministic". Parameters set in
+	// Parameters take precedence over the preset's.
+	Preset string `json:"preset,omitempty"`
+
+	// Grammar is a GBNF grammar and Schema a JSON schema which constrain
+	// the model's output unless a request sets its own format. Only one of
+	// them can be set.
+	Grammar string          `json:"grammar,omitempty"`
+	Schema  json.RawMessage `json:"schema,omitempty"`
+
+	// Format is the format the model responds in unless a request sets its
+	// own, either "json" or a JSON schema like [GenerateRequest.Format]. It
+	// can't be combined with Grammar or Schema.
+	Format json.RawMessage `json:"format,omitempty"`
+
+	// Safety classifies the model's content for compliance. It's only
+	// metadata: it's shown with the model but doesn't change how it runs.
+	Safety *SafetyClassification `json:"safety,omitempty"`
+
+	// Card describes the model for people choosing it, e.g. in a UI. Like
+	// Safety, it's only metadata and doesn't change how the model runs.
+	Card *ModelCard `json:"card,omitempty"`
+
+	// Bundle applies the template, system prompt, parameters, messages and
+	// licenses exported from another model, e.g. to the model in From. The
+	// fields of the request which are set take precedence over the bundle's.
+	Bundle *ModelBundle `json:"bundle,omitempty"`
+
+	// NoTemplateDetection disables choosing a template from the chat
+	// template embedded in the model's files.
+	NoTemplateDetection bool `json:"no_template_detection,omitempty"`
+
+	// LayersFrom names a model to copy the layers listed in LayerTypes from,
+	// e.g. to reuse its template and system prompt with the model in From.
+	LayersFrom string `json:"layers_from,omitempty"`
+
+	// LayerTypes lists the types of layer copied from LayersFrom. Each is
+	// one of "template", "system", "params", "messages", "license",
+	// "safety" or "card".
+	LayerTypes []string `json:"layer_types,omitempty"`
+
+	// ContextFromModel sets the num_ctx parameter to the context length the
+	// model was trained with, capped by OLLAMA_MAX_DEFAULT_CONTEXT, when
+	// num_ctx isn't otherwise set.
+	ContextFromModel bool `json:"context_from_model,omitempty"`
+
+	// NoPrune keeps the layers of the model being replaced so it can be
+	// restored with [Client.Rollback].
+	NoPrune bool `json:"no_prune,omitempty"`
+
+	// Prune removes every blob which isn't used by a model, or kept to roll
+	// one back, once the model has been created.
+	Prune bool `json:"prune,omitempty"`
+
+	// OCI writes the model's manifest as an OCI image manifest rather than a
+	// Docker image manifest so it can be used with OCI registries and tools.
+	OCI bool `json:"oci,omitempty"`
+
+	// Protected stops the model from being overwritten by a later create
+	// until it's unprotected with [Client.Protect].
+	Protected bool `json:"protected,omitempty"`
+
+	// Draft hides the model from [Client.List] until it's promoted with
+	// [Client.Promote], e.g. while iterating on it.
+	Draft bool `json:"draft,omitempty"`
+
+	// KVOverrides replaces the values of GGUF metadata keys of the model, such
+	// as "llama.rope.freq_base", when it's loaded. Each key must already be
+	// set in the model and its value must be of the same type.
+	KVOverrides map[string]any `json:"kv_overrides,omitempty"`
+
+	// Tokenizer replaces parts of the model's tokenizer, such as its special
+	// tokens, without converting the model again. The tokenizer is written
+	// into a new model layer when the model is created.
+	Tokenizer *Tokenizer `json:"tokenizer,omitempty"`
+
+	// StripMetadata rewrites the model's GGUF files without the metadata
+	// which isn't needed to run them, such as training logs, to make their
+	// blobs smaller. The architecture, tokenizer and hyperparameters, such
+	// as rope settings, are always kept.
+	StripMetadata bool `json:"strip_metadata,omitempty"`
+
+	// Reproducible writes the model so the same request, with the same
+	// files and server settings, always produces the same manifest digest.
+	// Its layers don't record the paths of the blobs, or the names of the
+	// models, they were created from; the model's lineage is still kept in
+	// its config.
+	Reproducible bool `json:"reproducible,omitempty"`
+
+	// AllowNewerGGUF creates the model from GGUF files of a newer version
+	// than this version of ollama supports, with a warning, rather than
+	// failing. The model may not load until ollama is updated.
+	AllowNewerGGUF bool `json:"allow_newer_gguf,omitempty"`
+
+	// EstimateMemory estimates the memory the model needs to run and warns,
+	// without failing the create, if it's unlikely to fit in GPU memory. The
+	// estimate uses a context of EstimateContext tokens, or the model's
+	// num_ctx if it's 0.
+	EstimateMemory  bool `json:"estimate_memory,omitempty"`
+	EstimateContext int  `json:"estimate_context,omitempty"`
+
+	// DigestAlgorithm is the algorithm the model's blobs are addressed with,
+	// "sha256" or "sha512". The blobs of a model created from another are
+	// hashed again if they use a different algorithm. It defaults to sha256
+	// for new blobs and keeps the digests of existing ones.
+	DigestAlgorithm string `json:"digest_algorithm,omitempty"`
+
+	// SigningKey is the name of an OpenSSH private key in the server's
+	// signing keys directory to sign the model's manifest with. The
+	// signature is checked against the server's trusted signers whenever
+	// the model is used.
+	SigningKey string `json:"signing_key,omitempty"`
+
+	// RootFSType is the type of the root filesystem in the model's config.
+	// Only "layers", the default, is supported.
+	RootFSType string `json:"rootfs_type,omitempty"`
+
+	// ParameterSize overrides the parameter count reported for the model,
+	// e.g. "7B". If empty, it's read from the model or estimated from its
+	// tensors.
+	ParameterSize string `json:"parameter_size,omitempty"`
+
 	// Deprecated: set the model name with Model instead
 	Name string `json:"name"`
 	// Deprecated: use Quantize instead
 	Quantization string `json:"quantization,omitempty"`
 }
 
+// CreateBatchRequest is the request passed to [Client.CreateBatch]. Either
+// every model in Models is created or none are.
+type CreateBatchRequest struct {
+	Models []CreateRequest `json:"models"`
+	Stream *bool           `json:"stream,omitempty"`
+}
+
+// CancelCreateRequest is the request passed to [Client.CancelCreate]. Creates
+// in progress are matched by Model, one of the names they write, or by
+// RequestID, the request ID sent with their progress. If both are set, a
+// create must match both.
+type CancelCreateRequest struct {
+	Model     string `json:"model,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// CancelCreateResponse is the response returned from [Client.CancelCreate].
+type CancelCreateResponse struct {
+	// Canceled is set if a matching create was in progress and canceled.
+	Canceled bool `json:"canceled"`
+
+	// RequestIDs are the request IDs of the canceled creates.
+	RequestIDs []string `json:"request_ids,omitempty"`
+}
+
+// CreatePreviewRequest is the request passed to [Client.CreatePreview]. It
+// previews quantizing Model, an existing model, to Quantize without
+// quantizing it.
+type CreatePreviewRequest struct {
+	Model    string `json:"model"`
+	Quantize string `json:"quantize"`
+}
+
+// CreatePreviewResponse is the response returned from [Client.CreatePreview].
+type CreatePreviewResponse struct {
+	Model string `json:"model"`
+
+	// Quantization and Size are the file type and size of the model's
+	// weights as they are.
+	Quantization string `json:"quantization"`
+	Size         int64  `json:"size"`
+
+	// EstimatedSize is the estimated size of the model's weights once
+	// they're quantized to Quantize.
+	Quantize      string `json:"quantize"`
+	EstimatedSize int64  `json:"estimated_size"`
+}
+
+// RollbackRequest is the request passed to [Client.Rollback].
+type RollbackRequest struct {
+	Model string `json:"model"`
+}
+
+// ProtectRequest is the request passed to [Client.Protect].
+type ProtectRequest struct {
+	Model string `json:"model"`
+
+	// Protected protects the model if true and unprotects it if false.
+	Protected bool `json:"protected"`
+}
+
+// PromoteRequest is the request passed to [Client.Promote].
+type PromoteRequest struct {
+	Model string `json:"model"`
+}
+
 // DeleteRequest is the request passed to [Client.Delete].
 type DeleteRequest struct {
 	Model string `json:"model"`
@@ -338,16 +590,234 @@ type ShowRequest struct {
 
 // ShowResponse is the response returned from [Client.Show].
 type ShowResponse struct {
-	License       string         `json:"license,omitempty"`
-	Modelfile     string         `json:"modelfile,omitempty"`
-	Parameters    string         `json:"parameters,omitempty"`
-	Template      string         `json:"template,omitempty"`
-	System        string         `json:"system,omitempty"`
-	Details       ModelDetails   `json:"details,omitempty"`
-	Messages      []Message      `json:"messages,omitempty"`
-	ModelInfo     map[string]any `json:"model_info,omitempty"`
-	ProjectorInfo map[string]any `json:"projector_info,omitempty"`
-	ModifiedAt    time.Time      `json:"modified_at,omitempty"`
+	License       string                `json:"license,omitempty"`
+	Modelfile     string                `json:"modelfile,omitempty"`
+	Parameters    string                `json:"parameters,omitempty"`
+	Template      string                `json:"template,omitempty"`
+	System        string                `json:"system,omitempty"`
+	Systems       map[string]string     `json:"systems,omitempty"`
+	Details       ModelDetails          `json:"details,omitempty"`
+	Messages      []Message             `json:"messages,omitempty"`
+	ModelInfo     map[string]any        `json:"model_info,omitempty"`
+	ProjectorInfo map[string]any        `json:"projector_info,omitempty"`
+	ModifiedAt    time.Time             `json:"modified_at,omitempty"`
+	Sources       []LayerSource         `json:"sources,omitempty"`
+	SpecialTokens *SpecialTokens        `json:"special_tokens,omitempty"`
+	Safety        *SafetyClassification `json:"safety,omitempty"`
+	Card          *ModelCard            `json:"card,omitempty"`
+	Grammar       string                `json:"grammar,omitempty"`
+	Schema        json.RawMessage       `json:"schema,omitempty"`
+	Format        json.RawMessage       `json:"format,omitempty"`
+
+	// DerivedFrom is the lineage of a model created from another, as
+	// name@digest, starting with the model it was created from.
+	DerivedFrom []string `json:"derived_from,omitempty"`
+
+	// SignedBy is the SHA256 fingerprint of the key which signed the model's
+	// manifest, if it's signed.
+	SignedBy string `json:"signed_by,omitempty"`
+}
+
+// SafetyClassification describes the content a model is suitable for.
+type SafetyClassification struct {
+	// ContentRating rates the content the model may generate, e.g.
+	// "general" or "mature".
+	ContentRating string `json:"content_rating,omitempty"`
+
+	// IntendedUse describes what the model is meant to be used for.
+	IntendedUse string `json:"intended_use,omitempty"`
+}
+
+// ModelBundleVersion is the version of the [ModelBundle] format.
+const ModelBundleVersion = 1
+
+// ModelBundle is a model's metadata without its weights, so it can be moved
+// to another machine and applied to a model there. It's returned by an
+// export with [ExportRequest.Bundle] set.
+type ModelBundle struct {
+	// Version is the version of the format, [ModelBundleVersion].
+	Version int `json:"version"`
+
+	Template   string         `json:"template,omitempty"`
+	System     string         `json:"system,omitempty"`
+	Parameters map[string]any `json:"parameters,omitempty"`
+	Messages   []Message      `json:"messages,omitempty"`
+	License    []string       `json:"license,omitempty"`
+}
+
+// ModelCard describes a model and how to use it.
+type ModelCard struct {
+	Description string `json:"description,omitempty"`
+
+	// Examples are prompts which show what the model is good at.
+	Examples []string `json:"examples,omitempty"`
+
+	// Tags categorize the model, e.g. "code" or "vision".
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Tokenizer replaces parts of a model's tokenizer. Only the fields which are
+// set are replaced. Tokens, Scores and TokenTypes must have an entry for
+// every token in the model's vocabulary.
+type Tokenizer struct {
+	Tokens     []string  `json:"tokens,omitempty"`
+	Scores     []float32 `json:"scores,omitempty"`
+	TokenTypes []int32   `json:"token_types,omitempty"`
+	Merges     []string  `json:"merges,omitempty"`
+
+	// SpecialTokens maps kinds of special tokens, such as "bos", "eos" or
+	// "padding", to their IDs.
+	SpecialTokens map[string]uint32 `json:"special_tokens,omitempty"`
+}
+
+// LayerSource describes where a layer of a model came from.
+type LayerSource struct {
+	MediaType string `json:"media_type"`
+	Digest    string `json:"digest"`
+	Source    string `json:"source"`
+}
+
+// DiffRequest is the request passed to [Client.Diff].
+type DiffRequest struct {
+	Base  string `json:"base"`
+	Model string `json:"model"`
+}
+
+// DiffResponse is the response returned from [Client.Diff]. The text diffs
+// prefix lines only in Base with "-", lines only in Model with "+" and shared
+// lines with " ". They're empty when the models match.
+type DiffResponse struct {
+	Layers     []LayerDiff `json:"layers,omitempty"`
+	Template   string      `json:"template,omitempty"`
+	System     string      `json:"system,omitempty"`
+	Parameters string      `json:"parameters,omitempty"`
+}
+
+// LayerDiff is a layer in only one of the models compared by [Client.Diff].
+// Change is "removed" for layers only in the base model and "added" for
+// layers only in the other model.
+type LayerDiff struct {
+	MediaType string `json:"media_type"`
+	Digest    string `json:"digest"`
+	Change    string `json:"change"`
+}
+
+// LayersRequest is the request passed to [Client.Layers].
+type LayersRequest struct {
+	Model string `json:"model"`
+}
+
+// LayersResponse is the response returned from [Client.Layers]: the layers
+// of a model's manifest, in order, and its config.
+type LayersResponse struct {
+	Model string `json:"model"`
+
+	// Digest is the digest of the model's manifest.
+	Digest string `json:"digest"`
+
+	Config ModelLayer   `json:"config"`
+	Layers []ModelLayer `json:"layers"`
+}
+
+// ModelLayer is a layer of a model. Size is the size of the layer's blob, or
+// the size in the model's manifest if the blob is missing.
+type ModelLayer struct {
+	MediaType string `json:"media_type"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+
+	// Source is the file the layer was created from, if any.
+	Source string `json:"source,omitempty"`
+
+	// Missing is set if the layer's blob doesn't exist.
+	Missing bool `json:"missing,omitempty"`
+}
+
+// VerifyRequest is the request passed to [Client.Verify].
+type VerifyRequest struct {
+	Model string `json:"model"`
+}
+
+// VerifyResponse is the response returned from [Client.Verify]. OK is set if
+// every layer of the model, including its config, was verified.
+type VerifyResponse struct {
+	Model  string          `json:"model"`
+	OK     bool            `json:"ok"`
+	Layers []VerifiedLayer `json:"layers"`
+}
+
+// VerifiedLayer is the result of verifying a layer of a model. Status is "ok"
+// or "failed", with Error saying why the layer failed.
+type VerifiedLayer struct {
+	MediaType string `json:"media_type"`
+	Digest    string `json:"digest"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// RecoverRequest is the request passed to [Client.Recover]. The models which
+// can be recovered are only reported unless Write is set.
+type RecoverRequest struct {
+	Write bool `json:"write,omitempty"`
+}
+
+// RecoverResponse is the response returned from [Client.Recover].
+type RecoverResponse struct {
+	Models []RecoveredModel `json:"models"`
+}
+
+// RecoveredModel is a model blob which no manifest references. Its name and
+// template are guessed from the blob's metadata, so they may need to be
+// corrected once it's recovered.
+type RecoveredModel struct {
+	// Model is the name the model is, or would be, recovered as.
+	Model string `json:"model"`
+
+	// Digest is the digest of the model's blob.
+	Digest string `json:"digest"`
+
+	Architecture  string `json:"architecture,omitempty"`
+	ParameterSize string `json:"parameter_size,omitempty"`
+	Quantization  string `json:"quantization,omitempty"`
+
+	// Template is the name of the template detected from the model's chat
+	// template, if any.
+	Template string `json:"template,omitempty"`
+
+	// Written is set if the model's manifest was written.
+	Written bool `json:"written,omitempty"`
+}
+
+// TemplateRequest is the request passed to [Client.Template]. Template is
+// rendered once for each of Samples, a list of conversations.
+type TemplateRequest struct {
+	Template string      `json:"template"`
+	Samples  [][]Message `json:"samples"`
+	Tools    `json:"tools,omitempty"`
+	Think    bool `json:"think,omitempty"`
+}
+
+// TemplateResponse is the response returned from [Client.Template].
+type TemplateResponse struct {
+	Prompts []TemplatePrompt `json:"prompts"`
+}
+
+// TemplatePrompt is the prompt rendered from a sample of a [TemplateRequest].
+// If the sample couldn't be rendered Error is set and Message is the index of
+// the first message the template failed on.
+type TemplatePrompt struct {
+	Prompt  string `json:"prompt,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Message *int   `json:"message,omitempty"`
+}
+
+// ExportRequest is the request passed to [Client.Export].
+type ExportRequest struct {
+	Model string `json:"model"`
+
+	// Bundle exports only the model's metadata, as a JSON [ModelBundle],
+	// rather than the model as a GGUF file.
+	Bundle bool `json:"bundle,omitempty"`
 }
 
 // CopyRequest is the request passed to [Client.Copy].
@@ -375,6 +845,73 @@ type ProgressResponse struct {
 	Digest    string `json:"digest,omitempty"`
 	Total     int64  `json:"total,omitempty"`
 	Completed int64  `json:"completed,omitempty"`
+
+	// SpecialTokens is reported by create once the model has been parsed.
+	SpecialTokens *SpecialTokens `json:"special_tokens,omitempty"`
+
+	// File is the name of the file the progress of a create from several
+	// files is for, whose digest is Digest, so clients can show the progress
+	// of each file.
+	File string `json:"file,omitempty"`
+
+	// Files is reported by create once the format of the files it's
+	// converting is detected, and maps each file's name to what it's used
+	// as: "model", "tokenizer", "config" or "ignored".
+	Files map[string]string `json:"files,omitempty"`
+
+	// Warning is set on the responses of a create which warn about the model
+	// being created. Status is the warning too, for clients which only show
+	// statuses.
+	Warning string `json:"warning,omitempty"`
+
+	// RequestID correlates the progress of a create with the server's logs.
+	RequestID string `json:"request_id,omitempty"`
+
+	// Error is set when the operation failed and is the last response. The
+	// StatusCode of the failure is set if it isn't a server error.
+	Error      string `json:"error,omitempty"`
+	StatusCode int    `json:"status_code,omitempty"`
+
+	// Created summarizes the model on the final "success" response of a
+	// create.
+	Created *CreateSummary `json:"created,omitempty"`
+}
+
+// CreateSummary describes a model written by [Client.Create].
+type CreateSummary struct {
+	Model string `json:"model"`
+
+	// Digest is the digest of the model's manifest.
+	Digest string `json:"digest"`
+
+	// Size is the total size of the model's layers and config.
+	Size   int64 `json:"size"`
+	Layers int   `json:"layers"`
+
+	Quantization  string `json:"quantization,omitempty"`
+	ParameterSize string `json:"parameter_size,omitempty"`
+
+	// TensorQuantizations are the types of the model's tensor groups when it
+	// was quantized with a policy, e.g. {"attention": "Q8_0", "ffn": "Q4_0"}.
+	TensorQuantizations map[string]string `json:"tensor_quantizations,omitempty"`
+
+	// Warnings are the warnings sent while the model was created.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// SpecialTokens are the tokens a model uses to mark the start and end of
+// text and padding, along with any tokens added to its vocabulary.
+type SpecialTokens struct {
+	BOS   *Token  `json:"bos,omitempty"`
+	EOS   *Token  `json:"eos,omitempty"`
+	PAD   *Token  `json:"pad,omitempty"`
+	Added []Token `json:"added,omitempty"`
+}
+
+// Token is a token in a model's vocabulary.
+type Token struct {
+	ID   int    `json:"id"`
+	Text string `json:"text,omitempty"`
 }
 
 // PushRequest is the request passed to [Client.Push].
@@ -389,11 +926,36 @@ type PushRequest struct {
 	Name string `json:"name"`
 }
 
+// UploadResponse is the state of a chunked upload started with
+// [Client.Upload].
+type UploadResponse struct {
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+}
+
+// ReadyResponse reports whether the models directory can be used to store
+// models.
+type ReadyResponse struct {
+	// Status is "ready" when the models directory exists, is writable and
+	// has at least Required bytes free.
+	Status   string `json:"status"`
+	Path     string `json:"path"`
+	Writable bool   `json:"writable"`
+	Free     uint64 `json:"free"`
+	Required uint64 `json:"required"`
+	Error    string `json:"error,omitempty"`
+}
+
 // ListResponse is the response from [Client.List].
 type ListResponse struct {
 	Models []ListModelResponse `json:"models"`
 }
 
+// QuantizationsResponse is the response from [Client.Quantizations].
+type QuantizationsResponse struct {
+	Quantizations []string `json:"quantizations"`
+}
+
 // ProcessResponse is the response from [Client.Process].
 type ProcessResponse struct {
 	Models []ProcessModelResponse `json:"models"`
@@ -407,6 +969,9 @@ type ListModelResponse struct {
 	Size       int64        `json:"size"`
 	Digest     string       `json:"digest"`
 	Details    ModelDetails `json:"details,omitempty"`
+
+	// Draft is set for drafts, which are only listed by [Client.ListAll].
+	Draft bool `json:"draft,omitempty"`
 }
 
 // ProcessModelResponse is a single model description in [ProcessResponse].
@@ -661,7 +1226,21 @@ func (d *Duration) UnmarshalJSON(b []byte) (err error) {
 	return nil
 }
 
-// FormatParams converts specified parameter options to their correct types
+// ParameterAliases maps the deprecated names of parameters to their current
+// names. Deprecated names are still accepted, and the server stores them
+// under their current names.
+var ParameterAliases = map[string]string{
+	"n_ctx":        "num_ctx",
+	"n_batch":      "num_batch",
+	"n_gpu_layers": "num_gpu",
+	"n_keep":       "num_keep",
+	"n_predict":    "num_predict",
+	"n_threads":    "num_thread",
+}
+
+// FormatParams converts specified parameter options to their correct types.
+// Parameters with deprecated names are converted to the type of the
+// parameter they're an alias of and keep their names.
 func FormatParams(params map[string][]string) (map[string]interface{}, error) {
 	opts := Options{}
 	valueOpts := reflect.ValueOf(&opts).Elem() // names of the fields in the options struct
@@ -679,7 +1258,7 @@ func FormatParams(params map[string][]string) (map[string]interface{}, error) {
 	out := make(map[string]interface{})
 	// iterate params and set values based on json struct tags
 	for key, vals := range params {
-		if opt, ok := jsonOpts[key]; !ok {
+		if opt, ok := jsonOpts[cmp.Or(ParameterAliases[key], key)]; !ok {
 			return nil, fmt.Errorf("unknown parameter '%s'", key)
 		} else {
 			field := valueOpts.FieldByName(opt.Name)
@@ -212,6 +212,15 @@ func TestUseMmapFormatParams(t *testing.T) {
 	}
 }
 
+func TestAliasFormatParams(t *testing.T) {
+	resp, err := FormatParams(map[string][]string{"n_ctx": {"4096"}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"n_ctx": int64(4096)}, resp)
+
+	_, err = FormatParams(map[string][]string{"n_ctx": {"large"}})
+	require.Error(t, err)
+}
+
 func TestMessage_UnmarshalJSON(t *testing.T) {
 	tests := []struct {
 		input    string
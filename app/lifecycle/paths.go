@@ -1,6 +1,7 @@
 package lifecycle
 
 import (
+    "cmp"
     "errors"
     "fmt"
     "log/slog"
@@ -27,6 +28,8 @@ var (
     getExecutable = os.Executable
     osStat        = os.Stat
     osMkdirAll    = os.MkdirAll
+
+    getPersistentPath = persistentPath
 )
 
 func init() {
@@ -34,12 +37,20 @@ func init() {
 }
 
 func initialize(goos string) {
+    // Rebranded builds can rename the app and CLI. The names are set before
+    // any OS specific suffix is added
+    AppName = cmp.Or(getEnv("OLLAMA_APP_NAME"), "ollama app")
+    CLIName = cmp.Or(getEnv("OLLAMA_CLI_NAME"), "ollama")
+
+    // directories are named after the CLI when it's renamed
+    dirName := cmp.Or(getEnv("OLLAMA_CLI_NAME"), "Ollama")
+
     if goos == "windows" {
         AppName += ".exe"
         CLIName += ".exe"
         // Logs, configs, downloads go to LOCALAPPDATA
         localAppData := getEnv("LOCALAPPDATA")
-        AppDataDir = filepath.Join(localAppData, "Ollama")
+        AppDataDir = filepath.Join(localAppData, dirName)
         UpdateStageDir = filepath.Join(AppDataDir, "updates")
         AppLogFile = filepath.Join(AppDataDir, "app.log")
         ServerLogFile = filepath.Join(AppDataDir, "server.log")
@@ -48,7 +59,7 @@ func initialize(goos string) {
         exe, err := getExecutable()
         if err != nil {
             slog.Warn("error discovering executable directory", "error", err)
-            AppDir = filepath.Join(localAppData, "Programs", "Ollama")
+            AppDir = filepath.Join(localAppData, "Programs", dirName)
         } else {
             AppDir = filepath.Dir(exe)
         }
@@ -56,17 +67,7 @@ func initialize(goos string) {
         // Make sure we have PATH set correctly for any spawned children
         paths := strings.Split(getEnv("PATH"), ";")
         // Start with whatever we find in the PATH/LD_LIBRARY_PATH
-        found := false
-        for _, path := range paths {
-            d, err := filepath.Abs(path)
-            if err != nil {
-                continue
-            }
-            if strings.EqualFold(AppDir, d) {
-                found = true
-            }
-        }
-        if !found {
+        if !pathContains(paths, AppDir) {
             paths = append(paths, AppDir)
 
             pathVal := strings.Join(paths, ";")
@@ -77,6 +78,15 @@ func initialize(goos string) {
             }
         }
 
+        // Setting PATH above only helps this process and its children. The
+        // CLI can't be found once we exit unless the installer put AppDir on
+        // the user or system PATH, which we only check rather than change
+        if persistent, err := getPersistentPath(); err != nil {
+            slog.Debug("unable to read the persistent PATH", "error", err)
+        } else if !pathContains(strings.Split(persistent, ";"), AppDir) {
+            slog.Warn(fmt.Sprintf("%s is not on the user or system PATH so %s won't be found in new terminals. Add it to your PATH in Settings > System > About > Advanced system settings > Environment Variables, or reinstall", AppDir, CLIName))
+        }
+
         // Make sure our logging dir exists
         _, err = osStat(AppDataDir)
         if errors.Is(err, os.ErrNotExist) {
@@ -90,4 +100,54 @@ func initialize(goos string) {
         // } else if runtime.GOOS == "linux" {
         // TODO
     }
+
+    if goos != "windows" {
+        AppLogFile = filepath.Join("/tmp", CLIName+"_app.log")
+        ServerLogFile = filepath.Join("/tmp", CLIName+".log")
+        UpgradeLogFile = filepath.Join("/tmp", CLIName+"_update.log")
+    }
+}
+
+// Paths are the locations of the app's files for the OS it's running on.
+type Paths struct {
+    AppDir         string
+    AppDataDir     string
+    UpdateStageDir string
+    AppLogFile     string
+    ServerLogFile  string
+    UpgradeLogFile string
+}
+
+// ResolvedPaths returns the locations of the app's files so other tools can
+// find them without repeating the OS specific logic of initialize.
+func ResolvedPaths() Paths {
+    return Paths{
+        AppDir:         AppDir,
+        AppDataDir:     AppDataDir,
+        UpdateStageDir: UpdateStageDir,
+        AppLogFile:     AppLogFile,
+        ServerLogFile:  ServerLogFile,
+        UpgradeLogFile: UpgradeLogFile,
+    }
+}
+
+// LogFiles returns the current app, server and upgrade logs. Older logs are
+// rotated next to them with a numbered suffix, e.g. server-1.log.
+func LogFiles() []string {
+    return []string{AppLogFile, ServerLogFile, UpgradeLogFile}
+}
+
+// pathContains reports whether dir is one of the entries of a PATH
+func pathContains(paths []string, dir string) bool {
+    for _, path := range paths {
+        d, err := filepath.Abs(path)
+        if err != nil {
+            continue
+        }
+        if strings.EqualFold(dir, d) {
+            return true
+        }
+    }
+
+    return false
 }
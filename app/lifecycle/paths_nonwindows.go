@@ -0,0 +1,9 @@
+//go:build !windows
+
+package lifecycle
+
+import "errors"
+
+func persistentPath() (string, error) {
+	return "", errors.ErrUnsupported
+}
@@ -1,6 +1,13 @@
 package lifecycle
 
 import (
+    "bytes"
+    "errors"
+    "log/slog"
+    "maps"
+    "os"
+    "path/filepath"
+    "runtime"
     "strings"
     "testing"
 )
@@ -43,3 +50,156 @@ func TestInitialize_WindowsPathAlreadyContainsAppDir(t *testing.T) {
         t.Errorf("Expected PATH to contain AppDir only once, but found multiple occurrences")
     }
 }
+
+func TestInitialize_Rebranded(t *testing.T) {
+    // initialize adds AppDir to this process's PATH on windows
+    t.Setenv("PATH", os.Getenv("PATH"))
+
+    originalGetEnv, originalGetExecutable, originalOsStat := getEnv, getExecutable, osStat
+    defer func() {
+        getEnv, getExecutable, osStat = originalGetEnv, originalGetExecutable, originalOsStat
+        initialize(runtime.GOOS)
+    }()
+
+    env := map[string]string{
+        "LOCALAPPDATA": "C:\\Users\\TestUser\\AppData\\Local",
+    }
+    getEnv = func(key string) string {
+        return env[key]
+    }
+    getExecutable = func() (string, error) {
+        return "", errors.New("no executable")
+    }
+    // the logging dir doesn't need creating
+    osStat = func(string) (os.FileInfo, error) {
+        return nil, nil
+    }
+
+    cases := []struct {
+        goos, appName, cliName, serverLog string
+        env                                map[string]string
+    }{
+        {"linux", "ollama app", "ollama", "/tmp/ollama.log", nil},
+        {"darwin", "ollama app.app", "ollama", "/tmp/ollama.log", nil},
+        {"windows", "ollama app.exe", "ollama.exe", filepath.Join("C:\\Users\\TestUser\\AppData\\Local", "Ollama", "server.log"), nil},
+        {"linux", "acme app", "acme", "/tmp/acme.log", map[string]string{"OLLAMA_APP_NAME": "acme app", "OLLAMA_CLI_NAME": "acme"}},
+        {"windows", "acme app.exe", "acme.exe", filepath.Join("C:\\Users\\TestUser\\AppData\\Local", "acme", "server.log"), map[string]string{"OLLAMA_APP_NAME": "acme app", "OLLAMA_CLI_NAME": "acme"}},
+    }
+
+    for _, tt := range cases {
+        delete(env, "OLLAMA_APP_NAME")
+        delete(env, "OLLAMA_CLI_NAME")
+        maps.Copy(env, tt.env)
+
+        // initializing twice shouldn't add the suffixes twice
+        initialize(tt.goos)
+        initialize(tt.goos)
+
+        if AppName != tt.appName {
+            t.Errorf("%s: expected AppName %q, got %q", tt.goos, tt.appName, AppName)
+        }
+        if CLIName != tt.cliName {
+            t.Errorf("%s: expected CLIName %q, got %q", tt.goos, tt.cliName, CLIName)
+        }
+        if ServerLogFile != tt.serverLog {
+            t.Errorf("%s: expected ServerLogFile %q, got %q", tt.goos, tt.serverLog, ServerLogFile)
+        }
+    }
+}
+
+func TestInitialize_WindowsPersistentPath(t *testing.T) {
+    t.Setenv("PATH", os.Getenv("PATH"))
+
+    originalGetEnv, originalGetExecutable, originalOsStat, originalGetPersistentPath := getEnv, getExecutable, osStat, getPersistentPath
+    originalLogger := slog.Default()
+    defer func() {
+        getEnv, getExecutable, osStat, getPersistentPath = originalGetEnv, originalGetExecutable, originalOsStat, originalGetPersistentPath
+        slog.SetDefault(originalLogger)
+        initialize(runtime.GOOS)
+    }()
+
+    getEnv = func(key string) string {
+        if key == "PATH" {
+            return "/usr/bin;/opt/ollama"
+        }
+        return ""
+    }
+    getExecutable = func() (string, error) {
+        return "/opt/ollama/ollama app.exe", nil
+    }
+    osStat = func(string) (os.FileInfo, error) {
+        return nil, nil
+    }
+
+    cases := []struct {
+        name       string
+        persistent string
+        err        error
+        warn       bool
+    }{
+        {"on path", "/usr/bin;/opt/ollama", nil, false},
+        {"missing", "/usr/bin", nil, true},
+        {"unreadable", "", errors.New("access denied"), false},
+    }
+
+    for _, tt := range cases {
+        t.Run(tt.name, func(t *testing.T) {
+            var logs bytes.Buffer
+            slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+            getPersistentPath = func() (string, error) {
+                return tt.persistent, tt.err
+            }
+
+            initialize("windows")
+
+            if warned := strings.Contains(logs.String(), "is not on the user or system PATH"); warned != tt.warn {
+                t.Errorf("expected warning %t, got logs %q", tt.warn, logs.String())
+            }
+        })
+    }
+}
+
+func TestResolvedPaths(t *testing.T) {
+    t.Setenv("PATH", os.Getenv("PATH"))
+
+    originalGetEnv, originalGetExecutable, originalOsStat := getEnv, getExecutable, osStat
+    defer func() {
+        getEnv, getExecutable, osStat = originalGetEnv, originalGetExecutable, originalOsStat
+        initialize(runtime.GOOS)
+    }()
+
+    getEnv = func(key string) string {
+        if key == "LOCALAPPDATA" {
+            return "C:\\Users\\TestUser\\AppData\\Local"
+        }
+        return ""
+    }
+    getExecutable = func() (string, error) {
+        return filepath.Join("C:\\Program Files", "Ollama", "ollama app.exe"), nil
+    }
+    osStat = func(string) (os.FileInfo, error) {
+        return nil, nil
+    }
+
+    initialize("windows")
+
+    dataDir := filepath.Join("C:\\Users\\TestUser\\AppData\\Local", "Ollama")
+    expect := Paths{
+        AppDir:         filepath.Join("C:\\Program Files", "Ollama"),
+        AppDataDir:     dataDir,
+        UpdateStageDir: filepath.Join(dataDir, "updates"),
+        AppLogFile:     filepath.Join(dataDir, "app.log"),
+        ServerLogFile:  filepath.Join(dataDir, "server.log"),
+        UpgradeLogFile: filepath.Join(dataDir, "upgrade.log"),
+    }
+
+    if paths := ResolvedPaths(); paths != expect {
+        t.Errorf("expected %+v, got %+v", expect, paths)
+    }
+
+    logs := LogFiles()
+    if len(logs) != 3 || logs[0] != expect.AppLogFile || logs[1] != expect.ServerLogFile || logs[2] != expect.UpgradeLogFile {
+        t.Errorf("expected the app, server and upgrade logs, got %v", logs)
+    }
+}
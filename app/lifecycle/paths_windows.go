@@ -0,0 +1,48 @@
+package lifecycle
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// persistentPath returns the user and system PATH stored in the registry,
+// which is the PATH new processes start with, as opposed to the PATH of this
+// process.
+func persistentPath() (string, error) {
+	keys := []struct {
+		root registry.Key
+		path string
+	}{
+		{registry.CURRENT_USER, `Environment`},
+		{registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\Session Manager\Environment`},
+	}
+
+	var paths []string
+	for _, key := range keys {
+		k, err := registry.OpenKey(key.root, key.path, registry.QUERY_VALUE)
+		if errors.Is(err, registry.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		v, _, err := k.GetStringValue("Path")
+		k.Close()
+		if errors.Is(err, registry.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		// entries such as %LOCALAPPDATA%\Programs\Ollama are stored unexpanded
+		if expanded, err := registry.ExpandString(v); err == nil {
+			v = expanded
+		}
+
+		paths = append(paths, v)
+	}
+
+	return strings.Join(paths, ";"), nil
+}
@@ -1,6 +1,7 @@
 package convert
 
 import (
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,9 +13,63 @@ import (
 	"github.com/ollama/ollama/llm"
 )
 
+// ErrUnsupportedQuantization is returned for models whose safetensors are
+// already quantized, such as with AWQ or GPTQ. Only unquantized weights can
+// be converted.
+var ErrUnsupportedQuantization = errors.New("quantized safetensors models aren't supported")
+
 type ModelParameters struct {
 	Architectures []string `json:"architectures"`
 	VocabSize     uint32   `json:"vocab_size"`
+
+	QuantizationConfig *QuantizationConfig `json:"quantization_config"`
+}
+
+// QuantizationConfig describes how a model's weights were quantized.
+type QuantizationConfig struct {
+	QuantMethod string `json:"quant_method"`
+	Bits        int    `json:"bits"`
+}
+
+// quantizationNames are the names of the common quantization methods in
+// errors, by their quant_method
+var quantizationNames = map[string]string{
+	"awq":          "AWQ",
+	"gptq":         "GPTQ",
+	"bitsandbytes": "bitsandbytes",
+	"fp8":          "FP8",
+}
+
+// checkQuantization fails with [ErrUnsupportedQuantization] if the model's
+// weights are quantized. It's described by quantization_config in
+// config.json or, for models quantized with AutoGPTQ, by
+// quantize_config.json.
+func checkQuantization(fsys fs.FS, p ModelParameters) error {
+	q := p.QuantizationConfig
+	if q == nil {
+		bts, err := fs.ReadFile(fsys, "quantize_config.json")
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		q = &QuantizationConfig{QuantMethod: "gptq"}
+		if err := json.Unmarshal(bts, q); err != nil {
+			return err
+		}
+	}
+
+	if q.QuantMethod == "" {
+		return nil
+	}
+
+	method := cmp.Or(quantizationNames[strings.ToLower(q.QuantMethod)], q.QuantMethod)
+	if q.Bits > 0 {
+		method = fmt.Sprintf("%d-bit %s", q.Bits, method)
+	}
+
+	return fmt.Errorf("%w: the model is quantized with %s, convert the unquantized model and quantize it when it's created instead", ErrUnsupportedQuantization, method)
 }
 
 type AdapterParameters struct {
@@ -171,6 +226,10 @@ func ConvertModel(fsys fs.FS, ws io.WriteSeeker) error {
 		return err
 	}
 
+	if err := checkQuantization(fsys, p); err != nil {
+		return err
+	}
+
 	if len(p.Architectures) < 1 {
 		return errors.New("unknown architecture")
 	}
@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -17,6 +18,7 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	"golang.org/x/exp/maps"
 
@@ -476,3 +478,60 @@ func generateLoraTestData(t *testing.T, tempDir string) {
 		t.Fatal(err)
 	}
 }
+
+func TestConvertQuantized(t *testing.T) {
+	cases := []struct {
+		name  string
+		files fstest.MapFS
+		err   string
+	}{
+		{
+			name: "awq",
+			files: fstest.MapFS{
+				"config.json": {Data: []byte(`{"architectures":["LlamaForCausalLM"],"quantization_config":{"quant_method":"awq","bits":4,"group_size":128}}`)},
+			},
+			err: "quantized safetensors models aren't supported: the model is quantized with 4-bit AWQ, convert the unquantized model and quantize it when it's created instead",
+		},
+		{
+			name: "gptq",
+			files: fstest.MapFS{
+				"config.json": {Data: []byte(`{"architectures":["MistralForCausalLM"],"quantization_config":{"quant_method":"gptq","bits":8}}`)},
+			},
+			err: "quantized safetensors models aren't supported: the model is quantized with 8-bit GPTQ, convert the unquantized model and quantize it when it's created instead",
+		},
+		{
+			name: "autogptq",
+			files: fstest.MapFS{
+				"config.json":          {Data: []byte(`{"architectures":["LlamaForCausalLM"]}`)},
+				"quantize_config.json": {Data: []byte(`{"bits":4,"group_size":128,"desc_act":false}`)},
+			},
+			err: "quantized safetensors models aren't supported: the model is quantized with 4-bit GPTQ, convert the unquantized model and quantize it when it's created instead",
+		},
+		{
+			name: "other",
+			files: fstest.MapFS{
+				"config.json": {Data: []byte(`{"architectures":["LlamaForCausalLM"],"quantization_config":{"quant_method":"hqq"}}`)},
+			},
+			err: "quantized safetensors models aren't supported: the model is quantized with hqq, convert the unquantized model and quantize it when it's created instead",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.CreateTemp(t.TempDir(), "testmodel")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			err = ConvertModel(tt.files, f)
+			if !errors.Is(err, ErrUnsupportedQuantization) {
+				t.Fatalf("expected %v, actual %v", ErrUnsupportedQuantization, err)
+			}
+
+			if err.Error() != tt.err {
+				t.Errorf("expected error %q, actual %q", tt.err, err.Error())
+			}
+		})
+	}
+}
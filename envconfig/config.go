@@ -93,6 +93,22 @@ func Models() string {
 	return filepath.Join(home, ".ollama", "models")
 }
 
+// Templates returns the path to the directory of named chat templates which create requests can reference. Templates directory can be configured via the OLLAMA_TEMPLATES environment variable.
+// Default is the templates directory inside the models directory
+func Templates() string {
+	if s := Var("OLLAMA_TEMPLATES"); s != "" {
+		return s
+	}
+
+	return filepath.Join(Models(), "templates")
+}
+
+// ScratchDir returns the directory the blobs written by creates are written to, and hashed in, before they're moved into the models directory. ScratchDir can be configured via the OLLAMA_SCRATCH_DIR environment variable.
+// Default is "", which writes them in the models directory.
+func ScratchDir() string {
+	return Var("OLLAMA_SCRATCH_DIR")
+}
+
 // KeepAlive returns the duration that models stay loaded in memory. KeepAlive can be configured via the OLLAMA_KEEP_ALIVE environment variable.
 // Negative values are treated as infinite. Zero is treated as no keep alive.
 // Default is 5 minutes.
@@ -133,6 +149,25 @@ func LoadTimeout() (loadTimeout time.Duration) {
 	return loadTimeout
 }
 
+// UploadTTL returns how long an upload is kept after it was last written to, and how long a blob uploaded for a create is kept before a create uses it. UploadTTL can be configured via the OLLAMA_UPLOAD_TTL environment variable.
+// Default is 24 hours.
+func UploadTTL() (ttl time.Duration) {
+	ttl = 24 * time.Hour
+	if s := Var("OLLAMA_UPLOAD_TTL"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			ttl = d
+		} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			ttl = time.Duration(n) * time.Second
+		}
+	}
+
+	if ttl <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return ttl
+}
+
 func Bool(k string) func() bool {
 	return func() bool {
 		if s := Var(k); s != "" {
@@ -165,6 +200,8 @@ var (
 	IntelGPU = Bool("OLLAMA_INTEL_GPU")
 	// MultiUserCache optimizes prompt caching for multi-user scenarios
 	MultiUserCache = Bool("OLLAMA_MULTIUSER_CACHE")
+	// CreateLogs writes the progress of each create to its own log file next to the server log.
+	CreateLogs = Bool("OLLAMA_CREATE_LOGS")
 )
 
 func String(s string) func() string {
@@ -175,6 +212,14 @@ func String(s string) func() string {
 
 var (
 	LLMLibrary = String("OLLAMA_LLM_LIBRARY")
+	// DefaultQuantize is the quantization applied on create to F16 and F32 models when the request doesn't set one. DefaultQuantize can be configured via the OLLAMA_DEFAULT_QUANTIZE environment variable.
+	DefaultQuantize = String("OLLAMA_DEFAULT_QUANTIZE")
+	// DefaultLicense is the path of a license file added to created models when the request doesn't set a license. DefaultLicense can be configured via the OLLAMA_DEFAULT_LICENSE environment variable.
+	DefaultLicense = String("OLLAMA_DEFAULT_LICENSE")
+	// SigningKeys is the directory of the OpenSSH private keys creates can sign models with. SigningKeys can be configured via the OLLAMA_SIGNING_KEYS environment variable.
+	SigningKeys = String("OLLAMA_SIGNING_KEYS")
+	// TrustedSigners is the path of a file of public keys, in authorized_keys format, whose model signatures are trusted. TrustedSigners can be configured via the OLLAMA_TRUSTED_SIGNERS environment variable.
+	TrustedSigners = String("OLLAMA_TRUSTED_SIGNERS")
 
 	CudaVisibleDevices    = String("CUDA_VISIBLE_DEVICES")
 	HipVisibleDevices     = String("HIP_VISIBLE_DEVICES")
@@ -206,6 +251,12 @@ var (
 	MaxQueue = Uint("OLLAMA_MAX_QUEUE", 512)
 	// MaxVRAM sets a maximum VRAM override in bytes. MaxVRAM can be configured via the OLLAMA_MAX_VRAM environment variable.
 	MaxVRAM = Uint("OLLAMA_MAX_VRAM", 0)
+	// MaxDefaultContext caps the num_ctx derived from a model's trained context length during create. MaxDefaultContext can be configured via the OLLAMA_MAX_DEFAULT_CONTEXT environment variable.
+	MaxDefaultContext = Uint("OLLAMA_MAX_DEFAULT_CONTEXT", 8192)
+	// MaxLayers sets the maximum number of layers a created model can have. MaxLayers can be configured via the OLLAMA_MAX_LAYERS environment variable.
+	MaxLayers = Uint("OLLAMA_MAX_LAYERS", 256)
+	// MaxModels sets the maximum number of models a create can add to the models directory. MaxModels can be configured via the OLLAMA_MAX_MODELS environment variable.
+	MaxModels = Uint("OLLAMA_MAX_MODELS", 0)
 )
 
 func Uint64(key string, defaultValue uint64) func() uint64 {
@@ -222,8 +273,20 @@ func Uint64(key string, defaultValue uint64) func() uint64 {
 	}
 }
 
-// Set aside VRAM per GPU
-var GpuOverhead = Uint64("OLLAMA_GPU_OVERHEAD", 0)
+var (
+	// Set aside VRAM per GPU
+	GpuOverhead = Uint64("OLLAMA_GPU_OVERHEAD", 0)
+	// MaxArchiveSize limits the total size of the files unpacked from an archive during create. MaxArchiveSize can be configured via the OLLAMA_MAX_ARCHIVE_SIZE environment variable.
+	MaxArchiveSize = Uint64("OLLAMA_MAX_ARCHIVE_SIZE", 256<<30)
+	// MaxInlineSize limits the total decoded size of the files sent inline in a create. MaxInlineSize can be configured via the OLLAMA_MAX_INLINE_SIZE environment variable.
+	MaxInlineSize = Uint64("OLLAMA_MAX_INLINE_SIZE", 16<<20)
+	// MaxUploadSize limits the size of each chunked upload. MaxUploadSize can be configured via the OLLAMA_MAX_UPLOAD_SIZE environment variable.
+	MaxUploadSize = Uint64("OLLAMA_MAX_UPLOAD_SIZE", 256<<30)
+	// MinFreeSpace is the free space the models directory needs to be ready for creates and pulls. MinFreeSpace can be configured via the OLLAMA_MIN_FREE_SPACE environment variable.
+	MinFreeSpace = Uint64("OLLAMA_MIN_FREE_SPACE", 1<<30)
+	// MaxStoreSize is the size of the models directory's models beyond which creates are refused. MaxStoreSize can be configured via the OLLAMA_MAX_STORE_SIZE environment variable.
+	MaxStoreSize = Uint64("OLLAMA_MAX_STORE_SIZE", 0)
+)
 
 type EnvVar struct {
 	Name        string
@@ -233,23 +296,39 @@ type EnvVar struct {
 
 func AsMap() map[string]EnvVar {
 	ret := map[string]EnvVar{
-		"OLLAMA_DEBUG":             {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
-		"OLLAMA_FLASH_ATTENTION":   {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
-		"OLLAMA_KV_CACHE_TYPE":     {"OLLAMA_KV_CACHE_TYPE", KvCacheType(), "Quantization type for the K/V cache (default: f16)"},
-		"OLLAMA_GPU_OVERHEAD":      {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
-		"OLLAMA_HOST":              {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
-		"OLLAMA_KEEP_ALIVE":        {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
-		"OLLAMA_LLM_LIBRARY":       {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
-		"OLLAMA_LOAD_TIMEOUT":      {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
-		"OLLAMA_MAX_LOADED_MODELS": {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
-		"OLLAMA_MAX_QUEUE":         {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
-		"OLLAMA_MODELS":            {"OLLAMA_MODELS", Models(), "The path to the models directory"},
-		"OLLAMA_NOHISTORY":         {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
-		"OLLAMA_NOPRUNE":           {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
-		"OLLAMA_NUM_PARALLEL":      {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
-		"OLLAMA_ORIGINS":           {"OLLAMA_ORIGINS", Origins(), "A comma separated list of allowed origins"},
-		"OLLAMA_SCHED_SPREAD":      {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
-		"OLLAMA_MULTIUSER_CACHE":   {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
+		"OLLAMA_CREATE_LOGS":         {"OLLAMA_CREATE_LOGS", CreateLogs(), "Log the progress of each create to its own file"},
+		"OLLAMA_DEBUG":               {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
+		"OLLAMA_DEFAULT_LICENSE":     {"OLLAMA_DEFAULT_LICENSE", DefaultLicense(), "Path of a license file added to models on create when none is requested"},
+		"OLLAMA_DEFAULT_QUANTIZE":    {"OLLAMA_DEFAULT_QUANTIZE", DefaultQuantize(), "Quantization applied to F16 and F32 models on create when none is requested (e.g. q4_K_M)"},
+		"OLLAMA_FLASH_ATTENTION":     {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
+		"OLLAMA_KV_CACHE_TYPE":       {"OLLAMA_KV_CACHE_TYPE", KvCacheType(), "Quantization type for the K/V cache (default: f16)"},
+		"OLLAMA_GPU_OVERHEAD":        {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
+		"OLLAMA_HOST":                {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
+		"OLLAMA_KEEP_ALIVE":          {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
+		"OLLAMA_LLM_LIBRARY":         {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
+		"OLLAMA_LOAD_TIMEOUT":        {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
+		"OLLAMA_MAX_LOADED_MODELS":   {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
+		"OLLAMA_MAX_QUEUE":           {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
+		"OLLAMA_MAX_ARCHIVE_SIZE":    {"OLLAMA_MAX_ARCHIVE_SIZE", MaxArchiveSize(), "Maximum total size of the files unpacked from an archive on create (bytes)"},
+		"OLLAMA_MAX_INLINE_SIZE":     {"OLLAMA_MAX_INLINE_SIZE", MaxInlineSize(), "Maximum total decoded size of the files sent inline on create (bytes)"},
+		"OLLAMA_MAX_DEFAULT_CONTEXT": {"OLLAMA_MAX_DEFAULT_CONTEXT", MaxDefaultContext(), "Maximum num_ctx derived from a model's trained context length on create"},
+		"OLLAMA_MAX_LAYERS":          {"OLLAMA_MAX_LAYERS", MaxLayers(), "Maximum number of layers in a created model (0 for no limit)"},
+		"OLLAMA_MAX_MODELS":          {"OLLAMA_MAX_MODELS", MaxModels(), "Maximum number of models creates can add (0 for no limit)"},
+		"OLLAMA_MAX_STORE_SIZE":      {"OLLAMA_MAX_STORE_SIZE", MaxStoreSize(), "Size of the stored models beyond which creates are refused (bytes, 0 for no limit)"},
+		"OLLAMA_MAX_UPLOAD_SIZE":     {"OLLAMA_MAX_UPLOAD_SIZE", MaxUploadSize(), "Maximum size of each chunked upload (bytes)"},
+		"OLLAMA_MIN_FREE_SPACE":      {"OLLAMA_MIN_FREE_SPACE", MinFreeSpace(), "Minimum free space in the models directory for the server to report ready (bytes)"},
+		"OLLAMA_MODELS":              {"OLLAMA_MODELS", Models(), "The path to the models directory"},
+		"OLLAMA_NOHISTORY":           {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
+		"OLLAMA_NOPRUNE":             {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
+		"OLLAMA_NUM_PARALLEL":        {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
+		"OLLAMA_ORIGINS":             {"OLLAMA_ORIGINS", Origins(), "A comma separated list of allowed origins"},
+		"OLLAMA_SCHED_SPREAD":        {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
+		"OLLAMA_SCRATCH_DIR":         {"OLLAMA_SCRATCH_DIR", ScratchDir(), "Fast local directory creates write blobs to before moving them to the models directory"},
+		"OLLAMA_SIGNING_KEYS":        {"OLLAMA_SIGNING_KEYS", SigningKeys(), "The path to the directory of keys creates can sign models with"},
+		"OLLAMA_TEMPLATES":           {"OLLAMA_TEMPLATES", Templates(), "The path to the directory of named chat templates"},
+		"OLLAMA_TRUSTED_SIGNERS":     {"OLLAMA_TRUSTED_SIGNERS", TrustedSigners(), "The path to a file of public keys whose model signatures are trusted"},
+		"OLLAMA_UPLOAD_TTL":          {"OLLAMA_UPLOAD_TTL", UploadTTL(), "How long unused uploads are kept (default \"24h\")"},
+		"OLLAMA_MULTIUSER_CACHE":     {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
 
 		// Informational
 		"HTTP_PROXY":  {"HTTP_PROXY", String("HTTP_PROXY")(), "HTTP proxy"},
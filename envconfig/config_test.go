@@ -253,6 +253,25 @@ func TestLoadTimeout(t *testing.T) {
 	}
 }
 
+func TestUploadTTL(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":    24 * time.Hour,
+		"1h":  time.Hour,
+		"60":  time.Minute,
+		"0":   time.Duration(math.MaxInt64),
+		"???": 24 * time.Hour,
+	}
+
+	for tt, expect := range cases {
+		t.Run(tt, func(t *testing.T) {
+			t.Setenv("OLLAMA_UPLOAD_TTL", tt)
+			if actual := UploadTTL(); actual != expect {
+				t.Errorf("%s: expected %s, got %s", tt, expect, actual)
+			}
+		})
+	}
+}
+
 func TestVar(t *testing.T) {
 	cases := map[string]string{
 		"value":       "value",
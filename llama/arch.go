@@ -0,0 +1,38 @@
+package llama
+
+import (
+	_ "embed"
+	"regexp"
+	"strings"
+)
+
+//go:embed llama.cpp/src/llama-arch.cpp
+var archSource string
+
+var archNamePattern = regexp.MustCompile(`\{\s*LLM_ARCH_\w+,\s*"([^"]+)"\s*\}`)
+
+// Architectures returns the model architectures the vendored llama.cpp can
+// load, as named in LLM_ARCH_NAMES in llama.cpp/src/llama-arch.cpp, so the
+// list is updated with llama.cpp.
+func Architectures() []string {
+	return parseArchitectures(archSource)
+}
+
+// parseArchitectures returns the names in the LLM_ARCH_NAMES map of src,
+// leaving out the name of unknown architectures.
+func parseArchitectures(src string) []string {
+	_, names, ok := strings.Cut(src, "LLM_ARCH_NAMES = {")
+	if !ok {
+		return nil
+	}
+	names, _, _ = strings.Cut(names, "};")
+
+	var archs []string
+	for _, m := range archNamePattern.FindAllStringSubmatch(names, -1) {
+		if m[1] != "(unknown)" {
+			archs = append(archs, m[1])
+		}
+	}
+
+	return archs
+}
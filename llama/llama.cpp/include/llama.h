@@ -357,6 +357,12 @@ extern "C" {
         void *              abort_callback_data;
     };
 
+    // the type to quantize the tensors whose names match pattern to
+    typedef struct llama_model_tensor_type {
+        const char * pattern;
+        enum ggml_type type;
+    } llama_model_tensor_type;
+
     // model quantization parameters
     typedef struct llama_model_quantize_params {
         int32_t nthread;                     // number of threads to use for quantizing, if <=0 will use std::thread::hardware_concurrency()
@@ -370,6 +376,7 @@ extern "C" {
         bool keep_split;                     // quantize to the same number of shards
         void * imatrix;                      // pointer to importance matrix data
         void * kv_overrides;                 // pointer to vector containing overrides
+        const struct llama_model_tensor_type * tensor_types; // tensor types overriding ftype, terminated by a NULL pattern
     } llama_model_quantize_params;
 
     typedef struct llama_logit_bias {
@@ -9,6 +9,7 @@
 #include <cstring>
 #include <fstream>
 #include <mutex>
+#include <regex>
 #include <thread>
 #include <unordered_map>
 
@@ -782,6 +783,17 @@ static void llama_model_quantize_internal(const std::string & fname_inp, const s
             if (params->output_tensor_type < GGML_TYPE_COUNT && strcmp(tensor->name, "output.weight") == 0) {
                 new_type = params->output_tensor_type;
             }
+            if (params->tensor_types) {
+                for (const llama_model_tensor_type * tt = params->tensor_types; tt->pattern != nullptr; tt++) {
+                    if (std::regex_search(name, std::regex(tt->pattern))) {
+                        // rows which aren't whole blocks of the type keep the type they'd have otherwise
+                        if (tensor->ne[0] % ggml_blck_size(tt->type) == 0) {
+                            new_type = tt->type;
+                        }
+                        break;
+                    }
+                }
+            }
 
             // If we've decided to quantize to the same type the tensor is already
             // in then there's nothing to do.
@@ -911,6 +923,7 @@ struct llama_model_quantize_params llama_model_quantize_default_params() {
         /*.keep_split                  =*/ false,
         /*.imatrix                     =*/ nullptr,
         /*.kv_overrides                =*/ nullptr,
+        /*.tensor_types                =*/ nullptr,
     };
 
     return result;
@@ -10,6 +10,7 @@ package llama
 #cgo CPPFLAGS: -I${SRCDIR}/../ml/backend/ggml/ggml/include
 
 #include <stdlib.h>
+#include <string.h>
 #include "ggml.h"
 #include "llama.h"
 #include "clip.h"
@@ -21,6 +22,25 @@ package llama
 extern bool llamaProgressCallback(float progress, void *user_data);
 extern void llamaLog(int level, char* text, void* user_data);
 
+static void set_kv_override(struct llama_model_kv_override *o, enum llama_model_kv_override_type tag, const char *key, int64_t i, double f, bool b, const char *s) {
+	o->tag = tag;
+	strncpy(o->key, key, sizeof(o->key) - 1);
+	switch (tag) {
+	case LLAMA_KV_OVERRIDE_TYPE_INT:
+		o->val_i64 = i;
+		break;
+	case LLAMA_KV_OVERRIDE_TYPE_FLOAT:
+		o->val_f64 = f;
+		break;
+	case LLAMA_KV_OVERRIDE_TYPE_BOOL:
+		o->val_bool = b;
+		break;
+	case LLAMA_KV_OVERRIDE_TYPE_STR:
+		strncpy(o->val_str, s, sizeof(o->val_str) - 1);
+		break;
+	}
+}
+
 typedef enum {COMP_UNKNOWN,COMP_GCC,COMP_CLANG} COMPILER;
 COMPILER inline get_compiler() {
 #if defined(__clang__)
@@ -228,6 +248,10 @@ type ModelParams struct {
 	TensorSplit  []float32
 	Progress     func(float32)
 	VocabOnly    bool
+
+	// KVOverrides replaces the values of metadata keys of the model. Values
+	// are int64, float64, bool or string.
+	KVOverrides map[string]any
 }
 
 //export llamaProgressCallback
@@ -268,6 +292,36 @@ func LoadModelFromFile(modelPath string, params ModelParams) (*Model, error) {
 		cparams.progress_callback_user_data = unsafe.Pointer(&handle)
 	}
 
+	if len(params.KVOverrides) > 0 {
+		// the list is terminated by an override with an empty key
+		overrides := unsafe.Slice((*C.struct_llama_model_kv_override)(C.calloc(C.size_t(len(params.KVOverrides)+1), C.size_t(unsafe.Sizeof(C.struct_llama_model_kv_override{})))), len(params.KVOverrides)+1)
+		defer C.free(unsafe.Pointer(&overrides[0]))
+
+		var i int
+		for key, value := range params.KVOverrides {
+			ckey := C.CString(key)
+			defer C.free(unsafe.Pointer(ckey))
+
+			switch v := value.(type) {
+			case int64:
+				C.set_kv_override(&overrides[i], C.LLAMA_KV_OVERRIDE_TYPE_INT, ckey, C.int64_t(v), 0, false, nil)
+			case float64:
+				C.set_kv_override(&overrides[i], C.LLAMA_KV_OVERRIDE_TYPE_FLOAT, ckey, 0, C.double(v), false, nil)
+			case bool:
+				C.set_kv_override(&overrides[i], C.LLAMA_KV_OVERRIDE_TYPE_BOOL, ckey, 0, 0, C.bool(v), nil)
+			case string:
+				cvalue := C.CString(v)
+				defer C.free(unsafe.Pointer(cvalue))
+				C.set_kv_override(&overrides[i], C.LLAMA_KV_OVERRIDE_TYPE_STR, ckey, 0, 0, false, cvalue)
+			default:
+				return nil, fmt.Errorf("unsupported type %T for override of %s", value, key)
+			}
+			i++
+		}
+
+		cparams.kv_overrides = &overrides[0]
+	}
+
 	m := Model{c: C.llama_load_model_from_file(C.CString(modelPath), cparams)}
 	if m.c == nil {
 		return nil, fmt.Errorf("unable to load model: %s", modelPath)
@@ -483,7 +537,10 @@ func (m *Model) NEmbd() int {
 	return int(C.llama_n_embd(m.c))
 }
 
-func Quantize(infile, outfile string, ftype uint32) error {
+// Quantize quantizes the model in infile to ftype. The tensors whose names
+// match a regular expression in tensorTypes are quantized to its tensor type
+// instead, unless their rows aren't whole blocks of it.
+func Quantize(infile, outfile string, ftype uint32, tensorTypes map[string]uint32) error {
 	cinfile := C.CString(infile)
 	defer C.free(unsafe.Pointer(cinfile))
 
@@ -494,6 +551,24 @@ func Quantize(infile, outfile string, ftype uint32) error {
 	params.nthread = -1
 	params.ftype = ftype
 
+	if len(tensorTypes) > 0 {
+		// the list is terminated by a type with a NULL pattern
+		types := unsafe.Slice((*C.struct_llama_model_tensor_type)(C.calloc(C.size_t(len(tensorTypes)+1), C.size_t(unsafe.Sizeof(C.struct_llama_model_tensor_type{})))), len(tensorTypes)+1)
+		defer C.free(unsafe.Pointer(&types[0]))
+
+		var i int
+		for pattern, kind := range tensorTypes {
+			cpattern := C.CString(pattern)
+			defer C.free(unsafe.Pointer(cpattern))
+
+			types[i].pattern = cpattern
+			types[i]._type = kind
+			i++
+		}
+
+		params.tensor_types = &types[0]
+	}
+
 	if rc := C.llama_model_quantize(cinfile, coutfile, &params); rc != 0 {
 		return fmt.Errorf("llama_model_quantize: %d", rc)
 	}
@@ -3,6 +3,8 @@ package llama
 import (
 	"bufio"
 	"bytes"
+	"os"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -103,3 +105,44 @@ func TestSchemaToGrammer(t *testing.T) {
 		})
 	}
 }
+
+func TestArchitectures(t *testing.T) {
+	src := `
+static const std::map<llm_arch, const char *> LLM_ARCH_NAMES = {
+    { LLM_ARCH_LLAMA,            "llama"            },
+    { LLM_ARCH_NOMIC_BERT,       "nomic-bert"       },
+    { LLM_ARCH_UNKNOWN,          "(unknown)"        },
+};
+
+static const std::map<llm_kv, const char *> LLM_KV_NAMES = {
+    { LLM_KV_GENERAL_TYPE, "general.type" },
+};
+`
+
+	if archs, expect := parseArchitectures(src), []string{"llama", "nomic-bert"}; !slices.Equal(archs, expect) {
+		t.Errorf("expected %v, actual %v", expect, archs)
+	}
+
+	if archs := parseArchitectures("no architectures"); archs != nil {
+		t.Errorf("expected no architectures, actual %v", archs)
+	}
+
+	// every architecture in the vendored llama.cpp is named, apart from the
+	// unknown architecture
+	header, err := os.ReadFile("llama.cpp/src/llama-arch.h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, enum, _ := strings.Cut(string(header), "enum llm_arch {")
+	enum, _, _ = strings.Cut(enum, "};")
+
+	archs := Architectures()
+	if n := strings.Count(enum, "LLM_ARCH_") - 1; len(archs) != n {
+		t.Errorf("expected %d architectures, actual %d: %v", n, len(archs), archs)
+	}
+
+	if !slices.Contains(archs, "llama") {
+		t.Errorf("expected llama to be supported, actual %v", archs)
+	}
+}
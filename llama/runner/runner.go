@@ -833,6 +833,46 @@ func (m *multiLPath) String() string {
 	return strings.Join(*m, ", ")
 }
 
+// multiKVOverride collects the metadata overrides given in the key=type:value
+// form llama.cpp accepts, where type is int, float, bool or str
+type multiKVOverride map[string]any
+
+func (m multiKVOverride) Set(value string) error {
+	key, typed, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("invalid override %q", value)
+	}
+
+	kind, v, ok := strings.Cut(typed, ":")
+	if !ok {
+		return fmt.Errorf("invalid override %q", value)
+	}
+
+	var err error
+	switch kind {
+	case "int":
+		m[key], err = strconv.ParseInt(v, 10, 64)
+	case "float":
+		m[key], err = strconv.ParseFloat(v, 64)
+	case "bool":
+		m[key], err = strconv.ParseBool(v)
+	case "str":
+		m[key] = v
+	default:
+		return fmt.Errorf("invalid override type %q", kind)
+	}
+
+	return err
+}
+
+func (m multiKVOverride) String() string {
+	var s []string
+	for k, v := range m {
+		s = append(s, fmt.Sprintf("%s=%v", k, v))
+	}
+	return strings.Join(s, ", ")
+}
+
 func (s *Server) loadModel(
 	params llama.ModelParams,
 	mpath string,
@@ -909,6 +949,9 @@ func Execute(args []string) error {
 	var lpaths multiLPath
 	fs.Var(&lpaths, "lora", "Path to lora layer file (can be specified multiple times)")
 
+	kvOverrides := make(multiKVOverride)
+	fs.Var(kvOverrides, "override-kv", "Override a model metadata key as key=type:value (can be specified multiple times)")
+
 	fs.Usage = func() {
 		fmt.Fprintf(fs.Output(), "Runner usage\n")
 		fs.PrintDefaults()
@@ -961,6 +1004,7 @@ func Execute(args []string) error {
 		UseMmap:      !*noMmap && lpaths.String() == "",
 		UseMlock:     *mlock,
 		TensorSplit:  tensorSplitFloats,
+		KVOverrides:  kvOverrides,
 		Progress: func(progress float32) {
 			server.progress = progress
 		},
@@ -1,6 +1,10 @@
 package llm
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
 
 type fileType uint32
 
@@ -180,6 +184,118 @@ func (t fileType) String() string {
 	}
 }
 
+var (
+	// fileTypeBPW is the bits per weight llama.cpp appends to the names of
+	// some file types, e.g. "IQ4_NL - 4.5 bpw"
+	fileTypeBPW = regexp.MustCompile(`\s*-\s*[0-9.]+\s*BPW$`)
+
+	fileTypeSizes = strings.NewReplacer(
+		" - SMALL", "_S",
+		" - MEDIUM", "_M",
+		" - LARGE", "_L",
+		" - ", "_",
+		"-", "_",
+		" ", "_",
+	)
+
+	// fileTypeAliases are the other names of file types which don't follow
+	// from their canonical names
+	fileTypeAliases = map[string]string{
+		"ALL_F32":        "F32",
+		"FP32":           "F32",
+		"FLOAT32":        "F32",
+		"FP16":           "F16",
+		"FLOAT16":        "F16",
+		"BFLOAT16":       "BF16",
+		"Q4_1,_SOME_F16": "Q4_1_F16",
+	}
+)
+
+// NormalizeFileType returns the canonical name of the file type s names in
+// any of the forms it's written in by llama.cpp and other tools, e.g.
+// "Q4_K_M" for "Q4_K - Medium", "mostly Q4_K_M" or "q4_k_m". Names which
+// aren't of a known file type are returned unchanged.
+func NormalizeFileType(s string) string {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	t = strings.TrimSpace(strings.TrimSuffix(t, "(GUESSED)"))
+	t = strings.TrimLeft(strings.TrimPrefix(t, "MOSTLY"), " _")
+	t = fileTypeSizes.Replace(fileTypeBPW.ReplaceAllString(t, ""))
+	if alias, ok := fileTypeAliases[t]; ok {
+		t = alias
+	}
+
+	if ft, err := ParseFileType(t); err == nil {
+		return ft.String()
+	}
+
+	return s
+}
+
+// TensorKind returns the tensor kind most of the weights of a model of the
+// file type are quantized to.
+func (t fileType) TensorKind() (uint32, error) {
+	switch t {
+	case fileTypeF32:
+		return 0, nil
+	case fileTypeF16:
+		return 1, nil
+	case fileTypeQ4_0:
+		return 2, nil
+	case fileTypeQ4_1, fileTypeQ4_1_F16:
+		return 3, nil
+	case fileTypeQ8_0:
+		return 8, nil
+	case fileTypeQ5_0:
+		return 6, nil
+	case fileTypeQ5_1:
+		return 7, nil
+	case fileTypeQ2_K, fileTypeQ2_K_S:
+		return 10, nil
+	case fileTypeQ3_K_S, fileTypeQ3_K_M, fileTypeQ3_K_L:
+		return 11, nil
+	case fileTypeQ4_K_S, fileTypeQ4_K_M:
+		return 12, nil
+	case fileTypeQ5_K_S, fileTypeQ5_K_M:
+		return 13, nil
+	case fileTypeQ6_K:
+		return 14, nil
+	case fileTypeIQ2_XXS:
+		return 16, nil
+	case fileTypeIQ2_XS:
+		return 17, nil
+	case fileTypeIQ3_XXS:
+		return 18, nil
+	case fileTypeIQ1_S:
+		return 19, nil
+	case fileTypeIQ4_NL:
+		return 20, nil
+	case fileTypeIQ3_S, fileTypeIQ3_M, fileTypeIQ3_XS:
+		return 21, nil
+	case fileTypeIQ2_S, fileTypeIQ2_M:
+		return 22, nil
+	case fileTypeIQ4_XS:
+		return 23, nil
+	case fileTypeIQ1_M:
+		return 29, nil
+	case fileTypeBF16:
+		return 30, nil
+	default:
+		return 0, fmt.Errorf("no tensor kind for file type %s", t)
+	}
+}
+
 func (t fileType) Value() uint32 {
 	return uint32(t)
 }
+
+// FileTypes returns the names of the file types accepted by ParseFileType.
+func FileTypes() []string {
+	var s []string
+	for t := fileTypeF32; t < fileTypeUnknown; t++ {
+		if _, err := ParseFileType(t.String()); err == nil {
+			s = append(s, t.String())
+		}
+	}
+
+	return s
+}
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -30,6 +32,8 @@ func (kv KV) u64(key string) uint64 {
 		return v
 	case uint32:
 		return uint64(v)
+	case uint16:
+		return uint64(v)
 	case float64:
 		return uint64(v)
 	default:
@@ -57,6 +61,12 @@ func (kv KV) ParameterCount() uint64 {
 	return kv.u64("general.parameter_count")
 }
 
+// SplitCount returns the number of files the model's tensor data is split
+// across, or 0 if the model isn't split.
+func (kv KV) SplitCount() uint64 {
+	return kv.u64("split.count")
+}
+
 func (kv KV) FileType() fileType {
 	if u64 := kv.u64("general.file_type"); u64 > 0 {
 		return fileType(uint32(u64))
@@ -122,6 +132,127 @@ func (kv KV) ChatTemplate() string {
 	return s
 }
 
+// Tokens returns the model's vocabulary. It's nil if the vocabulary wasn't
+// collected when the model was decoded.
+func (kv KV) Tokens() []string {
+	a, _ := kv["tokenizer.ggml.tokens"].(*array)
+	if a == nil || len(a.values) == 0 {
+		return nil
+	}
+
+	s := make([]string, len(a.values))
+	for i, v := range a.values {
+		s[i], _ = v.(string)
+	}
+
+	return s
+}
+
+// VocabSize returns the number of tokens in the model's vocabulary. It's
+// known even if the vocabulary wasn't collected when the model was decoded.
+func (kv KV) VocabSize() uint64 {
+	if a, ok := kv["tokenizer.ggml.tokens"].(*array); ok {
+		return uint64(a.size)
+	}
+
+	return kv.u64(fmt.Sprintf("%s.vocab_size", kv.Architecture()))
+}
+
+// TokenTypes returns the type of each token in the model's vocabulary. It's
+// nil if the token types weren't collected when the model was decoded.
+func (kv KV) TokenTypes() []int32 {
+	a, _ := kv["tokenizer.ggml.token_type"].(*array)
+	if a == nil || len(a.values) == 0 {
+		return nil
+	}
+
+	s := make([]int32, len(a.values))
+	for i, v := range a.values {
+		s[i], _ = v.(int32)
+	}
+
+	return s
+}
+
+// SpecialTokenID returns the ID of the special token of the given kind, such
+// as "bos", "eos" or "padding", and whether the model sets it.
+func (kv KV) SpecialTokenID(kind string) (uint64, bool) {
+	key := fmt.Sprintf("tokenizer.ggml.%s_token_id", kind)
+	_, ok := kv[key]
+	return kv.u64(key), ok
+}
+
+// kvOverrideMaxLength is the size of the key and string value buffers of a
+// llama.cpp metadata override, including the terminating NUL
+const kvOverrideMaxLength = 128
+
+// Override formats value as an override of the metadata key in the
+// key=type:value form accepted by llama.cpp. The key must already be set in
+// kv and value must be of the same kind as its current value. Arrays can't be
+// overridden.
+func (kv KV) Override(key string, value any) (string, error) {
+	if len(key) >= kvOverrideMaxLength {
+		return "", fmt.Errorf("key %q is too long", key)
+	}
+
+	current, ok := kv[key]
+	if !ok {
+		return "", fmt.Errorf("unknown key %q", key)
+	}
+
+	switch current.(type) {
+	case uint8, int8, uint16, int16, uint32, int32, uint64, int64:
+		var n int64
+		switch v := value.(type) {
+		case float64:
+			if v != math.Trunc(v) {
+				return "", fmt.Errorf("key %q must be an integer, got %v", key, v)
+			}
+			n = int64(v)
+		case int:
+			n = int64(v)
+		case int64:
+			n = v
+		default:
+			return "", fmt.Errorf("key %q must be an integer, got %T", key, value)
+		}
+
+		return fmt.Sprintf("%s=int:%d", key, n), nil
+	case float32, float64:
+		var f float64
+		switch v := value.(type) {
+		case float64:
+			f = v
+		case int:
+			f = float64(v)
+		case int64:
+			f = float64(v)
+		default:
+			return "", fmt.Errorf("key %q must be a number, got %T", key, value)
+		}
+
+		return fmt.Sprintf("%s=float:%s", key, strconv.FormatFloat(f, 'g', -1, 64)), nil
+	case bool:
+		v, ok := value.(bool)
+		if !ok {
+			return "", fmt.Errorf("key %q must be a boolean, got %T", key, value)
+		}
+
+		return fmt.Sprintf("%s=bool:%t", key, v), nil
+	case string:
+		v, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("key %q must be a string, got %T", key, value)
+		} else if len(v) >= kvOverrideMaxLength {
+			return "", fmt.Errorf("value of key %q is too long", key)
+		}
+
+		return fmt.Sprintf("%s=str:%s", key, v), nil
+	default:
+		return "", fmt.Errorf("key %q can't be overridden", key)
+	}
+}
+
 type Tensors struct {
 	Items  []*Tensor
 	Offset uint64
@@ -155,6 +286,15 @@ func (ts *Tensors) Layers() map[string]Layer {
 	return ts.layers
 }
 
+// ParameterCount returns the total number of parameters across all tensors.
+func (ts *Tensors) ParameterCount() (n uint64) {
+	for _, t := range ts.Items {
+		n += t.parameters()
+	}
+
+	return n
+}
+
 type Layer map[string]*Tensor
 
 func (l Layer) size() (size uint64) {
@@ -262,6 +402,23 @@ func (t Tensor) typeSize() uint64 {
 	}
 }
 
+// tensorTypeNames are the names of tensor kinds, indexed by kind.
+var tensorTypeNames = []string{
+	"F32", "F16", "Q4_0", "Q4_1", "Q4_2", "Q4_3", "Q5_0", "Q5_1", "Q8_0", "Q8_1",
+	"Q2_K", "Q3_K", "Q4_K", "Q5_K", "Q6_K", "Q8_K",
+	"IQ2_XXS", "IQ2_XS", "IQ3_XXS", "IQ1_S", "IQ4_NL", "IQ3_S", "IQ2_S", "IQ4_XS",
+	"I8", "I16", "I32", "I64", "F64", "IQ1_M", "BF16",
+}
+
+// TypeName returns the name of the tensor's kind, e.g. "Q4_K".
+func (t Tensor) TypeName() string {
+	if int(t.Kind) < len(tensorTypeNames) {
+		return tensorTypeNames[t.Kind]
+	}
+
+	return fmt.Sprintf("unknown (%d)", t.Kind)
+}
+
 func (t Tensor) parameters() uint64 {
 	var count uint64 = 1
 	for _, n := range t.Shape {
@@ -274,6 +431,47 @@ func (t Tensor) Size() uint64 {
 	return t.parameters() * t.typeSize() / t.blockSize()
 }
 
+// EstimateQuantizedSize estimates the size of the GGUF file of size bytes
+// decoded as g once it's quantized to the file type named ft, from the
+// shapes of its tensors. Like the quantizer, only the weights of matrices
+// whose rows are whole blocks are quantized and the output weights of
+// K-quants are Q6_K. Mixes such as Q4_K_M also keep some other weights at a
+// higher precision, which the estimate doesn't include.
+func EstimateQuantizedSize(g *GGML, size uint64, ft string) (uint64, error) {
+	t, err := ParseFileType(ft)
+	if err != nil {
+		return 0, err
+	}
+
+	kind, err := t.TensorKind()
+	if err != nil {
+		return 0, err
+	}
+
+	var source, estimate uint64
+	for _, tensor := range g.Tensors().Items {
+		source += tensor.Size()
+
+		quantized := *tensor
+		if strings.HasSuffix(tensor.Name, "weight") && len(tensor.Shape) >= 2 {
+			quantized.Kind = kind
+			if tensor.Name == "output.weight" && quantized.blockSize() == 256 {
+				quantized.Kind = 14 // Q6_K
+			}
+
+			// the first dimension is the length of the rows
+			if tensor.Shape[0]%quantized.blockSize() != 0 {
+				quantized.Kind = tensor.Kind
+			}
+		}
+
+		estimate += quantized.Size()
+	}
+
+	// the metadata is the same size however the tensors are quantized
+	return size - min(source, size) + estimate, nil
+}
+
 type container interface {
 	Name() string
 	Decode(io.ReadSeeker) (model, error)
@@ -1 +1,128 @@
 package llm
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestKVOverride(t *testing.T) {
+	kv := KV{
+		"general.name":                 "test",
+		"llama.context_length":         uint32(4096),
+		"llama.rope.freq_base":         float32(10000),
+		"tokenizer.ggml.add_bos_token": true,
+		"tokenizer.ggml.tokens":        &array{},
+	}
+
+	cases := []struct {
+		key    string
+		value  any
+		expect string
+		err    bool
+	}{
+		{"llama.rope.freq_base", float64(500000), "llama.rope.freq_base=float:500000", false},
+		{"llama.rope.freq_base", 1.5, "llama.rope.freq_base=float:1.5", false},
+		{"llama.context_length", float64(8192), "llama.context_length=int:8192", false},
+		{"llama.context_length", 8192.5, "", true},
+		{"llama.context_length", "8192", "", true},
+		{"tokenizer.ggml.add_bos_token", false, "tokenizer.ggml.add_bos_token=bool:false", false},
+		{"general.name", "renamed", "general.name=str:renamed", false},
+		{"general.name", 1.0, "", true},
+		{"tokenizer.ggml.tokens", "a", "", true},
+		{"llama.unknown", 1.0, "", true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.key, func(t *testing.T) {
+			actual, err := kv.Override(tt.key, tt.value)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", actual)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if actual != tt.expect {
+				t.Errorf("expected %q, actual %q", tt.expect, actual)
+			}
+		})
+	}
+}
+
+func TestEstimateQuantizedSize(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := WriteGGUF(f, KV{"general.architecture": "llama", "general.file_type": uint32(1)}, []Tensor{
+		{Name: "token_embd.weight", Kind: 1, Shape: []uint64{4, 512}, WriterTo: bytes.NewReader(make([]byte, 4096))},
+		{Name: "blk.0.attn_norm.weight", Kind: 0, Shape: []uint64{512}, WriterTo: bytes.NewReader(make([]byte, 2048))},
+		// rows of 96 are whole Q4_0 blocks but not K-quant blocks
+		{Name: "blk.0.ffn_down.weight", Kind: 1, Shape: []uint64{4, 96}, WriterTo: bytes.NewReader(make([]byte, 768))},
+		{Name: "output.weight", Kind: 1, Shape: []uint64{4, 512}, WriterTo: bytes.NewReader(make([]byte, 4096))},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	ggml, _, err := DecodeGGML(f, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := uint64(fi.Size())
+	metadata := size - 4096 - 2048 - 768 - 4096
+
+	cases := []struct {
+		fileType string
+		expect   uint64
+	}{
+		{"F16", size},
+		{"Q8_0", metadata + 2176 + 2048 + 408 + 2176},
+		{"Q4_0", metadata + 1152 + 2048 + 216 + 1152},
+		// the output weights are Q6_K
+		{"Q4_K_M", metadata + 1152 + 2048 + 768 + 1680},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.fileType, func(t *testing.T) {
+			actual, err := EstimateQuantizedSize(ggml, size, tt.fileType)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if actual != tt.expect {
+				t.Errorf("expected %d, actual %d", tt.expect, actual)
+			}
+		})
+	}
+
+	if _, err := EstimateQuantizedSize(ggml, size, "Q9_K"); err == nil {
+		t.Error("expected an error for an unknown file type")
+	}
+
+	// every file type a model can be quantized to has a tensor kind
+	for _, ft := range FileTypes() {
+		if _, err := EstimateQuantizedSize(ggml, size, ft); err != nil {
+			t.Errorf("%s: %v", ft, err)
+		}
+	}
+
+	if _, err := fileTypeQ4_2.TensorKind(); err == nil {
+		t.Error("expected an error for a file type without a tensor kind")
+	}
+}
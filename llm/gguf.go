@@ -1,17 +1,22 @@
 package llm
 
 import (
+	"bufio"
 	"bytes"
 	"cmp"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"slices"
 	"strings"
 
 	"golang.org/x/exp/maps"
+
+	"github.com/ollama/ollama/llama"
 )
 
 type containerGGUF struct {
@@ -139,17 +144,95 @@ func (llm *gguf) numKV() uint64 {
 	}
 }
 
+// ggmlMaxDims is the most dimensions a tensor can have
+const ggmlMaxDims = 4
+
+// ErrGGUFTruncated is returned when a GGUF file ends before all of the key
+// values or tensors its header declares.
+var ErrGGUFTruncated = errors.New("GGUF truncated")
+
+// The GGUF versions the runner can load. Version 1 files are no longer
+// supported by llama.cpp, and later versions may change the file's layout.
+const (
+	MinGGUFVersion = 2
+	MaxGGUFVersion = 3
+)
+
+var (
+	ErrGGUFVersionTooOld = errors.New("GGUF version is too old")
+	ErrGGUFVersionTooNew = errors.New("GGUF version is too new")
+)
+
+// ReadGGUFVersion reads the version from the header of the GGUF file at the
+// start of r, without decoding the rest of the file.
+func ReadGGUFVersion(r io.Reader) (uint32, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+
+	switch magic := binary.LittleEndian.Uint32(header[:4]); magic {
+	case FILE_MAGIC_GGUF_LE:
+		return binary.LittleEndian.Uint32(header[4:]), nil
+	case FILE_MAGIC_GGUF_BE:
+		return binary.BigEndian.Uint32(header[4:]), nil
+	default:
+		return 0, errors.New("not a GGUF file")
+	}
+}
+
+// CheckGGUFVersion reports whether the runner can load a GGUF file of
+// version v, failing with [ErrGGUFVersionTooOld] or [ErrGGUFVersionTooNew]
+// if it can't.
+func CheckGGUFVersion(v uint32) error {
+	if v < MinGGUFVersion {
+		return fmt.Errorf("%w: version %d isn't supported, convert the model again", ErrGGUFVersionTooOld, v)
+	} else if v > MaxGGUFVersion {
+		return fmt.Errorf("%w: version %d is newer than this version of ollama supports (%d to %d), update ollama to load it", ErrGGUFVersionTooNew, v, MinGGUFVersion, MaxGGUFVersion)
+	}
+
+	return nil
+}
+
+var ErrUnsupportedArchitecture = errors.New("unsupported architecture")
+
+// SupportedArchitectures are the model architectures the runner can load,
+// as named in LLM_ARCH_NAMES in llama/llama.cpp/src/llama-arch.cpp.
+var SupportedArchitectures = llama.Architectures()
+
+// CheckArchitecture reports whether the runner can load a model of the
+// architecture arch, failing with [ErrUnsupportedArchitecture] and the
+// architectures it supports if it can't.
+func CheckArchitecture(arch string) error {
+	if !slices.Contains(SupportedArchitectures, arch) {
+		return fmt.Errorf("%w %q, the runner supports %s", ErrUnsupportedArchitecture, arch, strings.Join(SupportedArchitectures, ", "))
+	}
+
+	return nil
+}
+
+// ggufTruncated replaces err with an [ErrGGUFTruncated] error if it's caused
+// by the end of the file, reporting how many of n key values or tensors were
+// found.
+func ggufTruncated(err error, n, found uint64, what string) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: expected %d %s, found %d", ErrGGUFTruncated, n, what, found)
+	}
+
+	return err
+}
+
 func (llm *gguf) Decode(rs io.ReadSeeker) error {
 	// decode key-values
-	for i := 0; uint64(i) < llm.numKV(); i++ {
+	for i := range llm.numKV() {
 		k, err := readGGUFString(llm, rs)
 		if err != nil {
-			return err
+			return ggufTruncated(err, llm.numKV(), i, "key values")
 		}
 
 		t, err := readGGUF[uint32](llm, rs)
 		if err != nil {
-			return err
+			return ggufTruncated(err, llm.numKV(), i, "key values")
 		}
 
 		var v any
@@ -185,41 +268,43 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 		}
 
 		if err != nil {
-			return err
+			return ggufTruncated(err, llm.numKV(), i, "key values")
 		}
 
 		llm.kv[k] = v
 	}
 
 	// decode tensors
-	for range llm.numTensor() {
+	for n := range llm.numTensor() {
 		name, err := readGGUFString(llm, rs)
 		if err != nil {
-			return fmt.Errorf("failed to read tensor name: %w", err)
+			return ggufTruncated(fmt.Errorf("failed to read tensor name: %w", err), llm.numTensor(), n, "tensors")
 		}
 
 		// dims is the number of dimensions in the tensor
 		dims, err := readGGUF[uint32](llm, rs)
 		if err != nil {
-			return fmt.Errorf("failed to read tensor dimensions: %w", err)
+			return ggufTruncated(fmt.Errorf("failed to read tensor dimensions: %w", err), llm.numTensor(), n, "tensors")
+		} else if dims > ggmlMaxDims {
+			return fmt.Errorf("invalid tensor %q: %d dimensions", name, dims)
 		}
 
 		shape := make([]uint64, dims)
 		for i := 0; uint32(i) < dims; i++ {
 			shape[i], err = readGGUF[uint64](llm, rs)
 			if err != nil {
-				return fmt.Errorf("failed to read tensor shape: %w", err)
+				return ggufTruncated(fmt.Errorf("failed to read tensor shape: %w", err), llm.numTensor(), n, "tensors")
 			}
 		}
 
 		kind, err := readGGUF[uint32](llm, rs)
 		if err != nil {
-			return fmt.Errorf("failed to read tensor kind: %w", err)
+			return ggufTruncated(fmt.Errorf("failed to read tensor kind: %w", err), llm.numTensor(), n, "tensors")
 		}
 
 		offset, err := readGGUF[uint64](llm, rs)
 		if err != nil {
-			return fmt.Errorf("failed to read tensor offset: %w", err)
+			return ggufTruncated(fmt.Errorf("failed to read tensor offset: %w", err), llm.numTensor(), n, "tensors")
 		}
 
 		tensor := Tensor{
@@ -249,7 +334,18 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 	padding := ggufPadding(offset, int64(alignment))
 	llm.tensorOffset = uint64(offset + padding)
 
-	for _, tensor := range llm.tensors {
+	// seeking past the end of the file doesn't fail so tensor data is checked
+	// against its size
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	for i, tensor := range llm.tensors {
 		offset, err := rs.Seek(0, io.SeekCurrent)
 		if err != nil {
 			return fmt.Errorf("failed to get current offset: %w", err)
@@ -260,8 +356,11 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 			return fmt.Errorf("failed to seek to init padding: %w", err)
 		}
 
-		if _, err := rs.Seek(int64(tensor.Size()), io.SeekCurrent); err != nil {
+		n, err := rs.Seek(int64(tensor.Size()), io.SeekCurrent)
+		if err != nil {
 			return fmt.Errorf("failed to seek to tensor: %w", err)
+		} else if n > end {
+			return fmt.Errorf("%w: expected %d tensors, found data for %d", ErrGGUFTruncated, len(llm.tensors), i)
 		}
 	}
 
@@ -328,12 +427,20 @@ func readGGUFString(llm *gguf, r io.Reader) (string, error) {
 		return "", err
 	}
 
-	length := int(llm.ByteOrder.Uint64(buf))
-	if length > len(llm.scratch) {
-		buf = make([]byte, length)
-	} else {
-		buf = llm.scratch[:length]
+	length := llm.ByteOrder.Uint64(buf)
+	if length > math.MaxInt64 {
+		return "", fmt.Errorf("invalid string length %d", length)
+	} else if length > uint64(len(llm.scratch)) {
+		// copied as it's read rather than allocated up front so a corrupt
+		// length fails at the end of the file
+		var sb strings.Builder
+		if _, err := io.CopyN(&sb, r, int64(length)); err != nil {
+			return "", err
+		}
+		return sb.String(), nil
 	}
+
+	buf = llm.scratch[:length]
 	clear(buf)
 
 	_, err = io.ReadFull(r, buf)
@@ -561,51 +668,67 @@ func WriteGGUF(ws io.WriteSeeker, kv KV, ts []Tensor) error {
 	return nil
 }
 
-func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
+func ggufWriteKV(w io.Writer, k string, v any) error {
 	slog.Debug(k, "type", fmt.Sprintf("%T", v))
-	if err := binary.Write(ws, binary.LittleEndian, uint64(len(k))); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(k))); err != nil {
 		return err
 	}
 
-	if err := binary.Write(ws, binary.LittleEndian, []byte(k)); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, []byte(k)); err != nil {
 		return err
 	}
 
 	var err error
 	switch v := v.(type) {
+	case uint8:
+		err = writeGGUF(w, ggufTypeUint8, v)
+	case int8:
+		err = writeGGUF(w, ggufTypeInt8, v)
+	case uint16:
+		err = writeGGUF(w, ggufTypeUint16, v)
+	case int16:
+		err = writeGGUF(w, ggufTypeInt16, v)
 	case uint32:
-		err = writeGGUF(ws, ggufTypeUint32, v)
+		err = writeGGUF(w, ggufTypeUint32, v)
+	case int32:
+		err = writeGGUF(w, ggufTypeInt32, v)
+	case uint64:
+		err = writeGGUF(w, ggufTypeUint64, v)
+	case int64:
+		err = writeGGUF(w, ggufTypeInt64, v)
 	case float32:
-		err = writeGGUF(ws, ggufTypeFloat32, v)
+		err = writeGGUF(w, ggufTypeFloat32, v)
+	case float64:
+		err = writeGGUF(w, ggufTypeFloat64, v)
 	case bool:
-		err = writeGGUF(ws, ggufTypeBool, v)
+		err = writeGGUF(w, ggufTypeBool, v)
 	case string:
-		err = writeGGUFString(ws, v)
+		err = writeGGUFString(w, v)
 	case []int32:
-		err = writeGGUFArray(ws, ggufTypeInt32, v)
+		err = writeGGUFArray(w, ggufTypeInt32, v)
 	case []uint32:
-		err = writeGGUFArray(ws, ggufTypeUint32, v)
+		err = writeGGUFArray(w, ggufTypeUint32, v)
 	case []float32:
-		err = writeGGUFArray(ws, ggufTypeFloat32, v)
+		err = writeGGUFArray(w, ggufTypeFloat32, v)
 	case []string:
-		if err := binary.Write(ws, binary.LittleEndian, ggufTypeArray); err != nil {
+		if err := binary.Write(w, binary.LittleEndian, ggufTypeArray); err != nil {
 			return err
 		}
 
-		if err := binary.Write(ws, binary.LittleEndian, ggufTypeString); err != nil {
+		if err := binary.Write(w, binary.LittleEndian, ggufTypeString); err != nil {
 			return err
 		}
 
-		if err := binary.Write(ws, binary.LittleEndian, uint64(len(v))); err != nil {
+		if err := binary.Write(w, binary.LittleEndian, uint64(len(v))); err != nil {
 			return err
 		}
 
 		for _, e := range v {
-			if err := binary.Write(ws, binary.LittleEndian, uint64(len(e))); err != nil {
+			if err := binary.Write(w, binary.LittleEndian, uint64(len(e))); err != nil {
 				return err
 			}
 
-			if err := binary.Write(ws, binary.LittleEndian, []byte(e)); err != nil {
+			if err := binary.Write(w, binary.LittleEndian, []byte(e)); err != nil {
 				return err
 			}
 		}
@@ -660,3 +783,192 @@ func ggufWriteTensor(ws io.WriteSeeker, t Tensor, alignment int64) error {
 func ggufPadding(offset, align int64) int64 {
 	return (align - offset%align) % align
 }
+
+// ggufTypeSizes are the sizes of the GGUF types with fixed size values
+var ggufTypeSizes = map[uint32]int64{
+	ggufTypeUint8:   1,
+	ggufTypeInt8:    1,
+	ggufTypeBool:    1,
+	ggufTypeUint16:  2,
+	ggufTypeInt16:   2,
+	ggufTypeUint32:  4,
+	ggufTypeInt32:   4,
+	ggufTypeFloat32: 4,
+	ggufTypeUint64:  8,
+	ggufTypeInt64:   8,
+	ggufTypeFloat64: 8,
+}
+
+// SetGGUFKV returns the GGUF file in r, which is size bytes long, with the
+// key values in kv set, replacing any it already has, and the size of the new
+// file. Key values and tensor infos are copied as they're encoded. Tensor data
+// isn't copied until it's read from the returned reader.
+func SetGGUFKV(r io.ReaderAt, size int64, kv KV) (io.Reader, int64, error) {
+	return rewriteGGUF(r, size, kv, nil)
+}
+
+// StripGGUFKV is like [SetGGUFKV] but removes the key values for which keep
+// returns false rather than setting any.
+func StripGGUFKV(r io.ReaderAt, size int64, keep func(key string) bool) (io.Reader, int64, error) {
+	return rewriteGGUF(r, size, nil, keep)
+}
+
+func rewriteGGUF(r io.ReaderAt, size int64, kv KV, keep func(string) bool) (io.Reader, int64, error) {
+	br := bufio.NewReaderSize(io.NewSectionReader(r, 0, size), 32<<10)
+
+	var header struct {
+		Magic     uint32
+		Version   uint32
+		NumTensor uint64
+		NumKV     uint64
+	}
+
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, 0, err
+	}
+
+	if header.Magic != FILE_MAGIC_GGUF_LE {
+		return nil, 0, errors.New("not a little endian GGUF file")
+	} else if header.Version < 2 {
+		return nil, 0, fmt.Errorf("unsupported GGUF version %d", header.Version)
+	}
+
+	// offset is the number of bytes read from the original file
+	offset := int64(binary.Size(header))
+
+	var kvs bytes.Buffer
+	var numKV uint64
+	alignment := int64(32)
+	for range header.NumKV {
+		var b bytes.Buffer
+		tr := io.TeeReader(br, &b)
+
+		k, err := readGGUFKey(tr)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var t uint32
+		if err := binary.Read(tr, binary.LittleEndian, &t); err != nil {
+			return nil, 0, err
+		}
+
+		if k == "general.alignment" && t == ggufTypeUint32 {
+			var v uint32
+			if err := binary.Read(tr, binary.LittleEndian, &v); err != nil {
+				return nil, 0, err
+			}
+			alignment = int64(v)
+		} else if err := discardGGUFValue(tr, t); err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", k, err)
+		}
+
+		offset += int64(b.Len())
+		if _, ok := kv[k]; !ok && (keep == nil || keep(k)) {
+			kvs.Write(b.Bytes())
+			numKV++
+		}
+	}
+
+	keys := maps.Keys(kv)
+	slices.Sort(keys)
+	for _, k := range keys {
+		if err := ggufWriteKV(&kvs, k, kv[k]); err != nil {
+			return nil, 0, err
+		}
+		numKV++
+	}
+
+	// tensor offsets are relative to the start of tensor data so their infos
+	// don't change
+	var infos bytes.Buffer
+	tr := io.TeeReader(br, &infos)
+	for range header.NumTensor {
+		if _, err := readGGUFKey(tr); err != nil {
+			return nil, 0, err
+		}
+
+		var dims uint32
+		if err := binary.Read(tr, binary.LittleEndian, &dims); err != nil {
+			return nil, 0, err
+		}
+
+		// dimensions, kind and offset
+		if _, err := io.CopyN(io.Discard, tr, int64(dims)*8+4+8); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	offset += int64(infos.Len())
+	// files without tensors may end before the padding
+	offset = min(offset+ggufPadding(offset, alignment), size)
+
+	var b bytes.Buffer
+	header.NumKV = numKV
+	if err := binary.Write(&b, binary.LittleEndian, header); err != nil {
+		return nil, 0, err
+	}
+
+	b.Write(kvs.Bytes())
+	b.Write(infos.Bytes())
+	b.Write(make([]byte, ggufPadding(int64(b.Len()), alignment)))
+
+	return io.MultiReader(&b, io.NewSectionReader(r, offset, size-offset)), int64(b.Len()) + size - offset, nil
+}
+
+func readGGUFKey(r io.Reader) (string, error) {
+	var n uint64
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", err
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func discardGGUFValue(r io.Reader, t uint32) error {
+	if size, ok := ggufTypeSizes[t]; ok {
+		_, err := io.CopyN(io.Discard, r, size)
+		return err
+	}
+
+	switch t {
+	case ggufTypeString:
+		var n uint64
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return err
+		}
+
+		_, err := io.CopyN(io.Discard, r, int64(n))
+		return err
+	case ggufTypeArray:
+		var e uint32
+		if err := binary.Read(r, binary.LittleEndian, &e); err != nil {
+			return err
+		}
+
+		var n uint64
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return err
+		}
+
+		if size, ok := ggufTypeSizes[e]; ok {
+			_, err := io.CopyN(io.Discard, r, int64(n)*size)
+			return err
+		}
+
+		for range n {
+			if err := discardGGUFValue(r, e); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("invalid type: %d", t)
+	}
+}
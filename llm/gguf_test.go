@@ -0,0 +1,240 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestSetGGUFKV(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var data []byte
+	var tensors []Tensor
+	for i, name := range []string{"blk.0.attn.weight", "blk.1.attn.weight", "output.weight"} {
+		b := bytes.Repeat([]byte{byte(i + 1)}, 32)
+		data = append(data, b...)
+		tensors = append(tensors, Tensor{Name: name, Kind: 0, Shape: []uint64{8}, WriterTo: bytes.NewReader(b)})
+	}
+
+	if err := WriteGGUF(f, KV{
+		"general.architecture":  "llama",
+		"general.license":       "old",
+		"llama.block_count":     uint32(2),
+		"tokenizer.ggml.tokens": []string{"a", "b", "c"},
+		"tokenizer.ggml.scores": []float32{0, 1, 2},
+	}, tensors); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, size, err := SetGGUFKV(f, fi.Size(), KV{
+		"general.license":   "new",
+		"llama.block_count": uint32(3),
+		"ollama.system":     "be brief",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if int64(len(b)) != size {
+		t.Fatalf("expected %d bytes, actual %d", size, len(b))
+	}
+
+	ggml, _, err := DecodeGGML(bytes.NewReader(b), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kv := ggml.KV()
+	if len(kv) != 7 {
+		t.Errorf("expected 7 key values, actual %d: %v", len(kv), kv)
+	}
+
+	for k, expect := range map[string]any{
+		"general.architecture": "llama",
+		"general.license":      "new",
+		"llama.block_count":    uint32(3),
+		"ollama.system":        "be brief",
+	} {
+		if kv[k] != expect {
+			t.Errorf("%s: expected %v, actual %v", k, expect, kv[k])
+		}
+	}
+
+	if tokens := kv.Tokens(); !slices.Equal(tokens, []string{"a", "b", "c"}) {
+		t.Errorf("expected tokens to be kept, actual %v", tokens)
+	}
+
+	var names []string
+	for _, tensor := range ggml.Tensors().Items {
+		names = append(names, tensor.Name)
+	}
+
+	if expect := []string{"blk.0.attn.weight", "blk.1.attn.weight", "output.weight"}; !slices.Equal(names, expect) {
+		t.Errorf("expected tensors %v, actual %v", expect, names)
+	}
+
+	if !bytes.HasSuffix(b, data) {
+		t.Error("expected tensor data to be copied unchanged")
+	}
+
+	t.Run("strip", func(t *testing.T) {
+		r, size, err := StripGGUFKV(f, fi.Size(), func(k string) bool {
+			return k != "general.license" && k != "tokenizer.ggml.scores"
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if int64(len(b)) != size || size >= fi.Size() {
+			t.Fatalf("expected a smaller file of %d bytes, actual %d bytes of %d", size, len(b), fi.Size())
+		}
+
+		ggml, _, err := DecodeGGML(bytes.NewReader(b), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys := slices.Sorted(maps.Keys(ggml.KV()))
+		if expect := []string{"general.architecture", "general.parameter_count", "llama.block_count", "tokenizer.ggml.tokens"}; !slices.Equal(keys, expect) {
+			t.Errorf("expected keys %v, actual %v", expect, keys)
+		}
+
+		if !bytes.HasSuffix(b, data) {
+			t.Error("expected tensor data to be copied unchanged")
+		}
+	})
+
+	t.Run("not gguf", func(t *testing.T) {
+		b := []byte("not a gguf file")
+		if _, _, err := SetGGUFKV(bytes.NewReader(b), int64(len(b)), nil); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestDecodeGGUFTruncated(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var tensors []Tensor
+	for _, name := range []string{"blk.0.attn.weight", "blk.1.attn.weight", "output.weight"} {
+		tensors = append(tensors, Tensor{Name: name, Kind: 0, Shape: []uint64{8}, WriterTo: bytes.NewReader(make([]byte, 32))})
+	}
+
+	if err := WriteGGUF(f, KV{
+		"general.architecture": "llama",
+		"general.name":         "test",
+	}, tensors); err != nil {
+		t.Fatal(err)
+	}
+
+	full, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		size   int
+		expect string
+	}{
+		{"key values", bytes.Index(full, []byte("general.name")), "GGUF truncated: expected 2 key values, found 1"},
+		{"tensor infos", bytes.Index(full, []byte("blk.1.attn.weight")), "GGUF truncated: expected 3 tensors, found 1"},
+		{"tensor data", len(full) - 1, "GGUF truncated: expected 3 tensors, found data for 2"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := DecodeGGML(bytes.NewReader(full[:tt.size]), 0)
+			if !errors.Is(err, ErrGGUFTruncated) || err.Error() != tt.expect {
+				t.Errorf("expected %q, actual %v", tt.expect, err)
+			}
+		})
+	}
+
+	t.Run("more tensors than present", func(t *testing.T) {
+		b := slices.Clone(full)
+		// the number of tensors follows the magic and version
+		binary.LittleEndian.PutUint64(b[8:], 4)
+
+		if _, _, err := DecodeGGML(bytes.NewReader(b), 0); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	if _, _, err := DecodeGGML(bytes.NewReader(full), 0); err != nil {
+		t.Errorf("expected the complete file to decode, actual %v", err)
+	}
+}
+
+func TestNormalizeFileType(t *testing.T) {
+	cases := map[string]string{
+		"Q4_K_M":               "Q4_K_M",
+		"q4_k_m":               "Q4_K_M",
+		"Q4_K - Medium":        "Q4_K_M",
+		"Q3_K - Small":         "Q3_K_S",
+		"Q3_K - Large":         "Q3_K_L",
+		"mostly Q4_0":          "Q4_0",
+		"MOSTLY_Q8_0":          "Q8_0",
+		"Q6_K (guessed)":       "Q6_K",
+		"IQ4_NL - 4.5 bpw":     "IQ4_NL",
+		"IQ2_XXS - 2.0625 bpw": "IQ2_XXS",
+		"Q4_1, some F16":       "Q4_1_F16",
+		"all F32":              "F32",
+		"fp16":                 "F16",
+		"bfloat16":             "BF16",
+		"unknown":              "unknown",
+		"Q9_Z":                 "Q9_Z",
+		"":                     "",
+	}
+
+	for s, expect := range cases {
+		if actual := NormalizeFileType(s); actual != expect {
+			t.Errorf("%q: expected %q, actual %q", s, expect, actual)
+		}
+	}
+}
+
+func TestSupportedArchitectures(t *testing.T) {
+	for _, arch := range []string{"llama", "mllama", "gemma2", "wavtokenizer-dec"} {
+		if !slices.Contains(SupportedArchitectures, arch) {
+			t.Errorf("expected %s to be supported, actual %v", arch, SupportedArchitectures)
+		}
+	}
+
+	if err := CheckArchitecture("llama"); err != nil {
+		t.Error(err)
+	}
+
+	if err := CheckArchitecture("other"); !errors.Is(err, ErrUnsupportedArchitecture) {
+		t.Errorf("expected %v, actual %v", ErrUnsupportedArchitecture, err)
+	}
+}
@@ -733,6 +733,24 @@ MESSAGE assistant Hi! How are you?
 				},
 			},
 		},
+		{
+			`FROM test
+PARAMETER keep_alive 10m
+`,
+			&api.CreateRequest{
+				From:       "test",
+				Parameters: map[string]any{"keep_alive": "10m"},
+			},
+		},
+		{
+			`FROM test
+PARAMETER think true
+`,
+			&api.CreateRequest{
+				From:       "test",
+				Parameters: map[string]any{"think": "true"},
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+var errArchiveTooLarge = errors.New("archive is too large")
+
+// unpackArchive writes each regular file in the tar, or gzipped tar, blob
+// with the given digest to its own blob and returns the files' names mapped
+// to their digests. If every file is in the same top level directory, such
+// as when a model's directory is archived, that directory is removed from
+// the names.
+func unpackArchive(ctx context.Context, digest string, fn func(resp api.ProgressResponse)) (_ map[string]string, err error) {
+	fn(api.ProgressResponse{Status: "unpacking archive", Digest: digest})
+
+	layer, err := NewLayerFromLayer(digest, "", "")
+	if err != nil {
+		return nil, badRequestError{fmt.Errorf("archive: %w", err)}
+	}
+
+	f, err := layer.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if magic, _ := r.(*bufio.Reader).Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, badRequestError{fmt.Errorf("invalid archive: %w", err)}
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	files := make(map[string]string)
+	defer func() {
+		if err != nil {
+			removeUnpackedFiles(ctx, files, nil)
+		}
+	}()
+
+	remaining := envconfig.MaxArchiveSize()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, badRequestError{fmt.Errorf("invalid archive: %w", err)}
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, badRequestError{fmt.Errorf("invalid archive: invalid file name %q", hdr.Name)}
+		}
+
+		if hdr.Size < 0 || uint64(hdr.Size) > remaining {
+			return nil, badRequestError{fmt.Errorf("%w: files exceed the %d byte limit", errArchiveTooLarge, envconfig.MaxArchiveSize())}
+		}
+		remaining -= uint64(hdr.Size)
+
+		layer, err := newCreateLayer(ctx, tr, "")
+		if err != nil {
+			return nil, err
+		}
+
+		files[name] = layer.Digest
+	}
+
+	if len(files) == 0 {
+		return nil, badRequestError{errors.New("invalid archive: no files found")}
+	}
+
+	return trimArchiveDir(files), nil
+}
+
+// trimArchiveDir removes the top level directory from the names in files if
+// every file shares it.
+func trimArchiveDir(files map[string]string) map[string]string {
+	var dir string
+	for name := range files {
+		d, _, ok := strings.Cut(name, "/")
+		if !ok || (dir != "" && d != dir) {
+			return files
+		}
+		dir = d
+	}
+
+	trimmed := make(map[string]string, len(files))
+	for name, digest := range files {
+		trimmed[strings.TrimPrefix(name, dir+"/")] = digest
+	}
+
+	return trimmed
+}
+
+// removeUnpackedFiles removes the blobs unpacked from an archive unless
+// they're used by layers, by an existing model or by another create. Blobs
+// which were already stored before the create, such as uploaded files, are
+// kept.
+func removeUnpackedFiles(ctx context.Context, files map[string]string, layers []Layer) {
+	pins := blobPinsFrom(ctx)
+	for digest := range maps.Values(files) {
+		if slices.ContainsFunc(layers, func(l Layer) bool { return l.Digest == digest }) || !pins.wasCreated(digest) {
+			continue
+		}
+
+		pins.unpin(digest)
+		layer := Layer{Digest: digest}
+		if err := layer.Remove(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("couldn't remove unpacked file", "digest", digest, "error", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+)
+
+// runnerBackends are the backends parameters can be specific to. They're the
+// libraries GPUs are discovered with, or cpu for models run without a GPU.
+var runnerBackends = []string{"cpu", "cuda", "rocm", "metal", "oneapi"}
+
+// optionNames are the names of the parameters the runner accepts
+var optionNames = sync.OnceValue(func() []string {
+	var names []string
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(api.Options{})) {
+		if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+})
+
+// backendParameters reads the parameters which are only applied when a model
+// is run with a specific backend. They're stored with the rest of a model's
+// parameters under "backends", e.g.
+//
+//	{"temperature": 0.5, "backends": {"cuda": {"num_batch": 1024}}}
+//
+// The parameters of every backend are validated so a model doesn't only fail
+// to load on some backends.
+func backendParameters(v any) (map[string]map[string]any, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	backends, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("must be a map of backend to parameters")
+	}
+
+	params := make(map[string]map[string]any, len(backends))
+	for _, backend := range slices.Sorted(maps.Keys(backends)) {
+		if !slices.Contains(runnerBackends, backend) {
+			return nil, fmt.Errorf("unknown backend %q, expected one of %s", backend, strings.Join(runnerBackends, ", "))
+		}
+
+		p, ok := backends[backend].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: must be a map of parameters", backend)
+		}
+
+		for _, k := range slices.Sorted(maps.Keys(p)) {
+			if !slices.Contains(optionNames(), k) {
+				return nil, fmt.Errorf("%s: unknown parameter %q", backend, k)
+			}
+		}
+
+		opts := api.DefaultOptions()
+		if err := opts.FromMap(p); err != nil {
+			return nil, fmt.Errorf("%s: %w", backend, err)
+		}
+
+		params[backend] = p
+	}
+
+	return params, nil
+}
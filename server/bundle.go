@@ -0,0 +1,59 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/template"
+)
+
+// modelBundle returns the layers of m which aren't its weights as a bundle.
+// Like an export, the default template isn't included.
+func modelBundle(m *Model) api.ModelBundle {
+	b := api.ModelBundle{
+		Version:    api.ModelBundleVersion,
+		System:     m.System,
+		Parameters: m.Options,
+		License:    m.License,
+	}
+
+	if m.Template != nil && m.Template.String() != template.DefaultTemplate.String() {
+		b.Template = m.Template.String()
+	}
+
+	for _, msg := range m.Messages {
+		b.Messages = append(b.Messages, api.Message{Role: msg.Role, Content: msg.Content})
+	}
+
+	return b
+}
+
+// applyBundle sets the fields of r from its bundle which r doesn't set
+// itself. The bundle's layers are then written like the request's own.
+func applyBundle(r api.CreateRequest) (api.CreateRequest, error) {
+	b := r.Bundle
+	if b.Version != api.ModelBundleVersion {
+		return r, badRequestError{fmt.Errorf("unsupported bundle version %d, expected %d", b.Version, api.ModelBundleVersion)}
+	}
+
+	r.Template = cmp.Or(r.Template, b.Template)
+	r.System = cmp.Or(r.System, b.System)
+
+	if len(b.Parameters) > 0 {
+		parameters := maps.Clone(b.Parameters)
+		maps.Copy(parameters, r.Parameters)
+		r.Parameters = parameters
+	}
+
+	if len(r.Messages) == 0 {
+		r.Messages = b.Messages
+	}
+
+	if r.License == nil && len(b.License) > 0 {
+		r.License = b.License
+	}
+
+	return r, nil
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+var errCreateCanceled = errors.New("create was canceled")
+
+// activeCreate is a create in progress which can be canceled.
+type activeCreate struct {
+	id     string
+	names  []model.Name
+	cancel func(cause error)
+}
+
+var (
+	createsMu     sync.Mutex
+	activeCreates = make(map[*activeCreate]struct{})
+)
+
+// trackCreate lets the create with request ID id, which writes names, be
+// canceled by calling cancel until the returned func is called.
+func trackCreate(id string, names []model.Name, cancel func(cause error)) func() {
+	create := &activeCreate{id: id, names: names, cancel: cancel}
+
+	createsMu.Lock()
+	defer createsMu.Unlock()
+	activeCreates[create] = struct{}{}
+
+	return func() {
+		createsMu.Lock()
+		defer createsMu.Unlock()
+		delete(activeCreates, create)
+	}
+}
+
+// cancelCreates cancels the creates in progress which write name, if it's
+// valid, and have request ID id, if it isn't empty. It returns the request
+// IDs of the canceled creates, sorted, and whether any were canceled.
+func cancelCreates(name model.Name, id string) ([]string, bool) {
+	createsMu.Lock()
+	defer createsMu.Unlock()
+
+	var ids []string
+	var canceled bool
+	for create := range activeCreates {
+		if name.IsValid() && !slices.ContainsFunc(create.names, name.EqualFold) {
+			continue
+		}
+
+		if id != "" && create.id != id {
+			continue
+		}
+
+		create.cancel(errCreateCanceled)
+		canceled = true
+		if create.id != "" {
+			ids = append(ids, create.id)
+		}
+	}
+
+	slices.Sort(ids)
+	return ids, canceled
+}
+
+// CancelCreateHandler cancels the creates in progress which match the
+// request. A canceled create removes the blobs it wrote and fails with
+// [errCreateCanceled].
+func (s *Server) CancelCreateHandler(c *gin.Context) {
+	var r api.CancelCreateRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if r.Model == "" && r.RequestID == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model or request_id is required"})
+		return
+	}
+
+	var name model.Name
+	if r.Model != "" {
+		name = model.ParseName(r.Model)
+		if !name.IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+			return
+		}
+	}
+
+	ids, canceled := cancelCreates(name, r.RequestID)
+	c.JSON(http.StatusOK, api.CancelCreateResponse{Canceled: canceled, RequestIDs: ids})
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+)
+
+// canonicalJSON encodes v as canonical JSON: the keys of every object are
+// sorted, including the fields of structs and the keys inside
+// json.RawMessage values, and there's no whitespace between tokens or after
+// the value. Equal values always encode to the same bytes, so the digests
+// of the layers they're written to are stable.
+//
+// Numbers are kept as they're written, so 1 and 1.0 still differ.
+func canonicalJSON(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	var a any
+	if err := d.Decode(&a); err != nil {
+		return nil, err
+	}
+
+	// maps are encoded with their keys sorted
+	return json.Marshal(a)
+}
+
+// newCanonicalJSONLayer creates a layer from the canonical JSON encoding of
+// v. Unlike [newJSONLayer] the encoding is buffered, so it's only used for
+// small values.
+func newCanonicalJSONLayer(ctx context.Context, v any, mediatype string) (Layer, error) {
+	b, err := canonicalJSON(v)
+	if err != nil {
+		return Layer{}, err
+	}
+
+	return newCreateLayer(ctx, bytes.NewReader(b), mediatype)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// contextCapabilities are the capabilities a model can have a default
+// context length for. Requests which use any of them load the model with
+// that context length rather than the model's num_ctx.
+var contextCapabilities = []Capability{CapabilityTools, CapabilityInsert, CapabilityVision}
+
+// capabilityContexts reads the default context lengths of a model's
+// capabilities. They're stored with the rest of a model's parameters under
+// "capability_num_ctx", e.g.
+//
+//	{"num_ctx": 4096, "capability_num_ctx": {"tools": 16384, "vision": 8192}}
+func capabilityContexts(v any) (map[Capability]int, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("must be a map of capability to num_ctx")
+	}
+
+	names := make([]string, len(contextCapabilities))
+	for i, c := range contextCapabilities {
+		names[i] = string(c)
+	}
+
+	contexts := make(map[Capability]int, len(m))
+	for _, name := range slices.Sorted(maps.Keys(m)) {
+		if !slices.Contains(names, name) {
+			return nil, fmt.Errorf("unknown capability %q, expected one of %s", name, strings.Join(names, ", "))
+		}
+
+		var n int
+		switch v := m[name].(type) {
+		case int:
+			n = v
+		case float64:
+			n = int(v)
+			if float64(n) != v {
+				return nil, fmt.Errorf("%s: num_ctx must be an integer", name)
+			}
+		default:
+			return nil, fmt.Errorf("%s: num_ctx must be an integer", name)
+		}
+
+		if n <= 0 {
+			return nil, fmt.Errorf("%s: num_ctx must be positive", name)
+		}
+
+		contexts[Capability(name)] = n
+	}
+
+	return contexts, nil
+}
+
+// capabilityContext returns the default context length of a request which
+// uses caps: the longest of their context lengths, so the context fits
+// every capability the request uses. It's 0 if none of caps have one.
+func capabilityContext(contexts map[Capability]int, caps []Capability) int {
+	var n int
+	for _, c := range caps {
+		n = max(n, contexts[c])
+	}
+
+	return n
+}
+
+// checkCapabilityContexts checks that none of the capability context lengths
+// in p are longer than the context the model in baseLayers was trained with,
+// since the model can't use more context than that.
+func checkCapabilityContexts(baseLayers []*layerGGML, p map[string]any) error {
+	contexts, err := capabilityContexts(p["capability_num_ctx"])
+	if err != nil || len(contexts) == 0 {
+		return err
+	}
+
+	i := slices.IndexFunc(baseLayers, func(l *layerGGML) bool {
+		return l.GGML != nil && l.MediaType == "application/vnd.ollama.image.model"
+	})
+	if i < 0 {
+		return nil
+	}
+
+	trained := baseLayers[i].KV().ContextLength()
+	if trained == 0 {
+		return nil
+	}
+
+	for _, c := range slices.Sorted(maps.Keys(contexts)) {
+		if n := contexts[c]; uint64(n) > trained {
+			return fmt.Errorf("%s num_ctx %d is longer than the context of %d the model was trained with", c, n, trained)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+var errCheckpointMismatch = errors.New("checkpoint doesn't match")
+
+// quantizeCheckpointTTL is how long a checkpoint is kept for a create to be
+// run again.
+const quantizeCheckpointTTL = 24 * time.Hour
+
+// quantizeCheckpoint records the layer a create which hasn't finished
+// quantized a model to. It's kept until the manifest which uses the layer is
+// written so a create which fails, or is interrupted, after quantizing a
+// model can be run again without quantizing the model again. Quantization is
+// a single call into llama.cpp, so the checkpoint is the whole quantized
+// model rather than the tensors quantized so far.
+//
+// A checkpoint is a JSON description in the checkpoints directory of the
+// models directory. The quantized model is the layer's blob, which isn't
+// pruned until the checkpoint expires, rather than a copy of it.
+type quantizeCheckpoint struct {
+	// Source is the digest of the model which was quantized
+	Source string `json:"source"`
+
+	// Type is the file type the model was quantized to
+	Type string `json:"type"`
+
+	// Policy is the quantize policy the model was quantized with, if any
+	Policy map[string]string `json:"policy,omitempty"`
+
+	// Digest is the digest of the quantized model's layer
+	Digest string `json:"digest"`
+}
+
+func (c *quantizeCheckpoint) path() string {
+	name := fmt.Sprintf("quantize-%s-%s", strings.TrimPrefix(c.Source, "sha256:"), c.Type)
+	if len(c.Policy) > 0 {
+		h := sha256.New()
+		for _, group := range slices.Sorted(maps.Keys(c.Policy)) {
+			fmt.Fprintf(h, "%s=%s\n", group, c.Policy[group])
+		}
+		name += fmt.Sprintf("-%x", h.Sum(nil)[:6])
+	}
+
+	return filepath.Join(envconfig.Models(), "checkpoints", name+".json")
+}
+
+// open returns the layer of a checkpoint. It fails with [os.ErrNotExist] if
+// there isn't a checkpoint or its layer's blob has been removed.
+func (c *quantizeCheckpoint) open(mediatype string) (Layer, error) {
+	b, err := os.ReadFile(c.path())
+	if err != nil {
+		return Layer{}, err
+	}
+
+	var saved quantizeCheckpoint
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return Layer{}, err
+	}
+
+	if saved.Source != c.Source || saved.Type != c.Type || !maps.Equal(saved.Policy, c.Policy) {
+		return Layer{}, fmt.Errorf("%w: quantized %s to %s", errCheckpointMismatch, saved.Source, saved.Type)
+	}
+
+	layer, err := NewLayerFromLayer(saved.Digest, mediatype, "")
+	if err != nil {
+		return Layer{}, err
+	}
+
+	c.Digest = saved.Digest
+	return layer, nil
+}
+
+// save records that the model was quantized to the layer of digest.
+func (c *quantizeCheckpoint) save(digest string) error {
+	c.Digest = digest
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(c.path())
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, "quantize-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), c.path())
+}
+
+// remove removes the checkpoint. Its layer is kept if a manifest references
+// it, and is otherwise left to be pruned.
+func (c *quantizeCheckpoint) remove() error {
+	if err := os.Remove(c.path()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// keepCheckpointBlobs removes the digests of the layers of checkpoints saved
+// within [quantizeCheckpointTTL] from digests, and removes the checkpoints
+// saved before then along with anything else left in the checkpoints
+// directory, such as a quantized model an older version kept.
+func keepCheckpointBlobs(digests map[string]struct{}) error {
+	dir := filepath.Join(envconfig.Models(), "checkpoints")
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		fi, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		p := filepath.Join(dir, entry.Name())
+		if time.Since(fi.ModTime()) >= quantizeCheckpointTTL {
+			if err := os.RemoveAll(p); err != nil {
+				return err
+			}
+			continue
+		} else if filepath.Ext(p) != ".json" {
+			continue
+		}
+
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		var c quantizeCheckpoint
+		if err := json.Unmarshal(b, &c); err != nil {
+			continue
+		}
+
+		delete(digests, c.Digest)
+	}
+
+	return nil
+}
+
+// removeExpiredCheckpoints removes the checkpoints saved more than
+// [quantizeCheckpointTTL] ago.
+func removeExpiredCheckpoints() error {
+	return keepCheckpointBlobs(nil)
+}
@@ -9,16 +9,22 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/convert"
+	"github.com/ollama/ollama/discover"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/llama"
@@ -34,107 +40,129 @@ var (
 	errOnlyGGUFSupported       = errors.New("supplied file was not in GGUF format")
 	errUnknownType             = errors.New("unknown type")
 	errNeitherFromOrFiles      = errors.New("neither 'from' or 'files' was specified")
+	errSplitGGUFUnsupported    = errors.New("GGUF files with tensor data split across multiple files are not supported yet")
+	errMultipleBaseModels      = errors.New("only one base model file is supported")
+	errNotAdapter              = errors.New("adapter is not a LoRA adapter")
+	errFileAndAdapter          = errors.New("blob can't be both a model file and an adapter")
+	errTemplateAndName         = errors.New("only one of 'template' or 'template_name' can be specified")
+	errTooManyLayers           = errors.New("model has too many layers")
+	errMissingConfigLayer      = errors.New("source model manifest is missing its config layer")
+	errMessageRoles            = errors.New("messages are out of order")
+	errLFSPointer              = errors.New("files are Git LFS pointers rather than their content")
 )
 
 func (s *Server) CreateHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCreateBodySize())
+
 	var r api.CreateRequest
+	var tooLarge *http.MaxBytesError
 	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
 		return
+	} else if errors.As(err, &tooLarge) {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("request body exceeds the %d byte limit", tooLarge.Limit)})
+		return
 	} else if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	name := model.ParseName(cmp.Or(r.Model, r.Name))
-	if !name.IsValid() {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+	names, err := createNames(r)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	name, err := getExistingName(name)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := checkProtected(names); errors.Is(err, errModelProtected) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkStoreLimits(names); err != nil {
+		c.AbortWithStatusJSON(storeLimitStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
+	id := c.GetHeader(requestIDHeader)
+	if id == "" {
+		id = uuid.NewString()
+	}
+	c.Header(requestIDHeader, id)
+
+	ctx, cancel := context.WithCancelCause(withRequestID(c.Request.Context(), id))
+	untrack := trackCreate(id, names, cancel)
+	log := requestLogger(ctx)
+	log.Info("creating model", "model", names[0].DisplayShortest())
+
+	var progressLog *createLog
+	if envconfig.CreateLogs() {
+		// the create goes ahead without its log, which is only a record
+		if progressLog, err = openCreateLog(names[0]); err != nil {
+			log.Warn("couldn't open create log", "error", err)
+		}
+	}
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
-		fn := func(resp api.ProgressResponse) {
-			ch <- resp
+		defer cancel(nil)
+		defer untrack()
+		if progressLog != nil {
+			defer progressLog.Close()
 		}
 
-		oldManifest, _ := ParseNamedManifest(name)
-
-		var baseLayers []*layerGGML
-		if r.From != "" {
-			slog.Debug("create model from model name")
-			fromName := model.ParseName(r.From)
-			if !fromName.IsValid() {
-				ch <- gin.H{"error": errtypes.InvalidModelNameErrMsg, "status": http.StatusBadRequest}
-				return
-			}
-
-			ctx, cancel := context.WithCancel(c.Request.Context())
-			defer cancel()
-
-			baseLayers, err = parseFromModel(ctx, fromName, fn)
-			if err != nil {
-				ch <- gin.H{"error": err.Error()}
-			}
-		} else if r.Files != nil {
-			baseLayers, err = convertModelFromFiles(r.Files, baseLayers, false, fn)
-			if err != nil {
-				for _, badReq := range []error{errNoFilesProvided, errOnlyGGUFSupported, errUnknownType} {
-					if errors.Is(err, badReq) {
-						ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
-						return
-					}
+		fn := func(resp api.ProgressResponse) {
+			resp.RequestID = id
+			log.Debug("create progress", "status", resp.Status, "digest", resp.Digest)
+			if progressLog != nil {
+				if err := progressLog.write(resp); err != nil {
+					log.Warn("couldn't write create log", "error", err)
 				}
-				ch <- gin.H{"error": err.Error()}
-				return
 			}
-		} else {
-			ch <- gin.H{"error": errNeitherFromOrFiles.Error(), "status": http.StatusBadRequest}
-			return
+
+			ch <- resp
 		}
 
-		var adapterLayers []*layerGGML
-		if r.Adapters != nil {
-			adapterLayers, err = convertModelFromFiles(r.Adapters, baseLayers, true, fn)
-			if err != nil {
-				for _, badReq := range []error{errNoFilesProvided, errOnlyOneAdapterSupported, errOnlyGGUFSupported, errUnknownType} {
-					if errors.Is(err, badReq) {
-						ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
-						return
-					}
-				}
-				ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
-				return
+		created, err := createModels(ctx, r, names, fn)
+		if err != nil {
+			if errors.Is(context.Cause(ctx), errCreateCanceled) {
+				err = errCreateCanceled
 			}
-		}
 
-		if len(adapterLayers) > 0 {
-			baseLayers = append(baseLayers, adapterLayers...)
+			log.Error("create failed", "error", err)
+			fn(createErrorResponse(err))
+			return
 		}
 
-		if err := createModel(r, name, baseLayers, fn); err != nil {
-			if errors.Is(err, errBadTemplate) {
-				ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
-				return
+		if r.EstimateMemory {
+			getGpuFn := discover.GetGPUInfo
+			if s.sched != nil {
+				getGpuFn = s.sched.getGpuFn
 			}
-			ch <- gin.H{"error": err.Error()}
-			return
-		}
 
-		if !envconfig.NoPrune() && oldManifest != nil {
-			if err := oldManifest.RemoveLayers(); err != nil {
-				ch <- gin.H{"error": err.Error()}
+			// the estimate is advisory so the create still succeeds without it
+			if warning, err := memoryWarning(names[0], r.EstimateContext, getGpuFn()); err != nil {
+				log.Warn("couldn't estimate memory", "error", err)
+			} else if warning != "" {
+				warn(fn, warning)
+				created.Warnings = append(created.Warnings, warning)
 			}
 		}
 
-		ch <- api.ProgressResponse{Status: "success"}
+		log.Info("created model", "model", names[0].DisplayShortest(), "digest", created.Digest)
+		fn(api.ProgressResponse{Status: "success", Created: &api.CreateSummary{
+			Model:               created.Name.DisplayShortest(),
+			Digest:              created.Digest,
+			Size:                created.Size,
+			Layers:              created.Layers,
+			Quantization:        created.Config.FileType,
+			ParameterSize:       created.Config.ModelType,
+			TensorQuantizations: created.Config.TensorQuantizations,
+			Warnings:            created.Warnings,
+		}})
 	}()
 
 	if r.Stream != nil && !*r.Stream {
@@ -142,307 +170,1980 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		return
 	}
 
-	streamResponse(c, ch)
-}
-
-func convertModelFromFiles(files map[string]string, baseLayers []*layerGGML, isAdapter bool, fn func(resp api.ProgressResponse)) ([]*layerGGML, error) {
-	switch detectModelTypeFromFiles(files) {
-	case "safetensors":
-		layers, err := convertFromSafetensors(files, baseLayers, isAdapter, fn)
-		if err != nil {
-			slog.Error("error converting from safetensors", "error", err)
-			return nil, err
-		}
-		return layers, nil
-	case "gguf":
-		if len(files) == 0 {
-			return nil, errNoFilesProvided
-		} else if len(files) > 1 && isAdapter {
-			return nil, errOnlyOneAdapterSupported
-		}
-
-		var digest string
-		var allLayers []*layerGGML
-		for _, v := range files {
-			digest = v
-			layers, err := ggufLayers(digest, fn)
-			if err != nil {
-				return nil, err
-			}
-			allLayers = append(allLayers, layers...)
-		}
-		return allLayers, nil
+	// NDJSON is preferred unless the client only accepts JSON or
+	// server-sent events
+	switch c.NegotiateFormat("application/x-ndjson", "application/json", "text/event-stream") {
+	case "application/json":
+		streamJSONArrayResponse(c, ch)
+	case "text/event-stream":
+		streamEventsResponse(c, ch)
 	default:
-		return nil, errUnknownType
+		streamResponse(c, ch)
 	}
 }
 
-func detectModelTypeFromFiles(files map[string]string) string {
-	for fn := range files {
-		if strings.HasSuffix(fn, ".safetensors") {
-			return "safetensors"
-		} else if strings.HasSuffix(fn, ".gguf") {
-			return "gguf"
-		} else {
-			// try to see if we can find a gguf file even without the file extension
-			blobPath, err := GetBlobsPath(files[fn])
-			if err != nil {
-				slog.Error("error getting blobs path", "file", fn)
-				return ""
-			}
+// CreatedModel is a model written by [CreateModel].
+type CreatedModel struct {
+	// Name is the model's name. Its aliases share its manifest.
+	Name model.Name
 
-			f, err := os.Open(blobPath)
-			if err != nil {
-				slog.Error("error reading file", "error", err)
-				return ""
-			}
-			defer f.Close()
+	// Digest is the digest of the model's manifest, the digest models are
+	// listed with.
+	Digest string
 
-			buf := make([]byte, 4)
-			_, err = f.Read(buf)
-			if err != nil {
-				slog.Error("error reading file", "error", err)
-				return ""
-			}
+	// Size is the total size of the model's layers and config
+	Size   int64
+	Layers int
 
-			ct := llm.DetectGGMLType(buf)
-			if ct == "gguf" {
-				return "gguf"
-			}
-		}
-	}
+	Config ConfigV2
 
-	return ""
+	// Warnings are the warnings sent while the model was created
+	Warnings []string
 }
 
-func convertFromSafetensors(files map[string]string, baseLayers []*layerGGML, isAdapter bool, fn func(resp api.ProgressResponse)) ([]*layerGGML, error) {
-	tmpDir, err := os.MkdirTemp("", "ollama-safetensors")
+// CreateModel creates the model described by r, as a create request to the
+// server would, for Go programs which embed ollama rather than run its
+// server. It returns once the model is written. Progress is sent to progress
+// if it isn't nil; the caller must receive from it until CreateModel
+// returns, and it isn't closed.
+func CreateModel(ctx context.Context, r api.CreateRequest, progress chan<- api.ProgressResponse) (*CreatedModel, error) {
+	names, err := createNames(r)
 	if err != nil {
+		return nil, badRequestError{err}
+	}
+
+	if err := checkProtected(names); err != nil {
 		return nil, err
 	}
-	defer os.RemoveAll(tmpDir)
 
-	for fp, digest := range files {
-		blobPath, err := GetBlobsPath(digest)
-		if err != nil {
-			return nil, err
+	if err := checkStoreLimits(names); err != nil {
+		return nil, err
+	}
+
+	return createModels(ctx, r, names, func(resp api.ProgressResponse) {
+		if progress != nil {
+			progress <- resp
 		}
-		if err := createLink(blobPath, filepath.Join(tmpDir, fp)); err != nil {
+	})
+}
+
+// createModels builds the model described by r and writes its manifest under
+// each of names, which must already have been checked by createNames and
+// checkProtected.
+func createModels(ctx context.Context, r api.CreateRequest, names []model.Name, fn func(resp api.ProgressResponse)) (*CreatedModel, error) {
+	var signer ssh.Signer
+	if r.SigningKey != "" {
+		var err error
+		signer, err = loadSigningKey(r.SigningKey)
+		if err != nil {
 			return nil, err
 		}
 	}
 
-	t, err := os.CreateTemp(tmpDir, "fp16")
+	pins := pinBlobs()
+	defer pins.release()
+	ctx = withBlobPins(ctx, pins)
+	defer removeCanceledBlobs(ctx, pins)
+
+	ctx, removeTemp, err := withCreateTemp(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer t.Close()
+	defer removeTemp()
 
-	var mediaType string
-	if !isAdapter {
-		fn(api.ProgressResponse{Status: "converting model"})
-		mediaType = "application/vnd.ollama.image.model"
-		if err := convert.ConvertModel(os.DirFS(tmpDir), t); err != nil {
-			return nil, err
-		}
-	} else {
-		kv, err := kvFromLayers(baseLayers)
-		if err != nil {
-			return nil, err
-		}
-		fn(api.ProgressResponse{Status: "converting adapter"})
-		mediaType = "application/vnd.ollama.image.adapter"
-		if err := convert.ConvertAdapter(os.DirFS(tmpDir), t, kv); err != nil {
-			return nil, err
+	var warnings []string
+	progress := fn
+	fn = func(resp api.ProgressResponse) {
+		if resp.Warning != "" {
+			warnings = append(warnings, resp.Warning)
 		}
+		progress(resp)
 	}
 
-	if _, err := t.Seek(0, io.SeekStart); err != nil {
-		return nil, err
+	pending := make([]pendingModel, len(names))
+	for i, name := range names {
+		pending[i].pins = pins
+		pending[i].name = name
+		pending[i].noPrune = r.NoPrune
+		pending[i].oldManifest, _ = ParseNamedManifest(name)
+		pending[i].signer = signer
 	}
 
-	layer, err := NewLayer(t, mediaType)
+	configLayer, layers, err := buildModel(ctx, r, fn)
 	if err != nil {
 		return nil, err
 	}
 
-	bin, err := layer.Open()
-	if err != nil {
+	// a create canceled while it was built mustn't write its manifests
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	ggml, _, err := llm.DecodeGGML(bin, 0)
-	if err != nil {
+	for i := range pending {
+		pending[i].config, pending[i].layers = *configLayer, layers
+	}
+
+	fn(api.ProgressResponse{Status: "writing manifest"})
+	if err := writeManifests(pending); err != nil {
 		return nil, err
 	}
-	layers := []*layerGGML{{layer, ggml}}
 
-	if !isAdapter {
-		return detectChatTemplate(layers)
+	// the model is already written so a failure to remove the layers it
+	// replaced is only reported
+	if err := removeOldLayers(pending); err != nil {
+		fn(api.ProgressResponse{Error: err.Error()})
 	}
-	return layers, nil
-}
 
-func kvFromLayers(baseLayers []*layerGGML) (llm.KV, error) {
-	for _, l := range baseLayers {
-		if l.GGML != nil {
-			return l.KV(), nil
+	if r.Prune {
+		fn(api.ProgressResponse{Status: "removing unused blobs"})
+		if err := PruneLayers(); err != nil {
+			return nil, err
 		}
 	}
-	return llm.KV{}, fmt.Errorf("no base model was found")
+
+	m, err := ParseNamedManifest(names[0])
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := readManifestConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreatedModel{
+		Name:     names[0],
+		Digest:   m.digest,
+		Size:     m.Size(),
+		Layers:   len(m.Layers),
+		Config:   config,
+		Warnings: warnings,
+	}, nil
 }
 
-func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML, fn func(resp api.ProgressResponse)) (err error) {
-	config := ConfigV2{
-		OS:           "linux",
-		Architecture: "amd64",
-		RootFS: RootFS{
-			Type: "layers",
-		},
+// CreateBatchHandler creates several models as a single unit. Every model's
+// layers are built before any manifest is written so a failure in one model
+// leaves all of the named models untouched.
+func (s *Server) CreateBatchHandler(c *gin.Context) {
+	var r api.CreateBatchRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	var layers []Layer
-	for _, layer := range baseLayers {
-		if layer.GGML != nil {
-			quantType := strings.ToUpper(cmp.Or(r.Quantize, r.Quantization))
-			if quantType != "" && layer.GGML.Name() == "gguf" && layer.MediaType == "application/vnd.ollama.image.model" {
-				want, err := llm.ParseFileType(quantType)
-				if err != nil {
-					return err
-				}
+	if len(r.Models) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "models are required"})
+		return
+	}
 
-				ft := layer.GGML.KV().FileType()
-				if !slices.Contains([]string{"F16", "F32"}, ft.String()) {
-					return errors.New("quantization is only supported for F16 and F32 models")
-				} else if ft != want {
-					layer, err = quantizeLayer(layer, quantType, fn)
-					if err != nil {
-						return err
-					}
-				}
+	var all []model.Name
+	names := make([][]model.Name, len(r.Models))
+	for i, m := range r.Models {
+		var err error
+		names[i], err = createNames(m)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		for _, name := range names[i] {
+			if slices.ContainsFunc(all, name.EqualFold) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("model %q is specified more than once", name.DisplayShortest())})
+				return
 			}
-			config.ModelFormat = cmp.Or(config.ModelFormat, layer.GGML.Name())
-			config.ModelFamily = cmp.Or(config.ModelFamily, layer.GGML.KV().Architecture())
-			config.ModelType = cmp.Or(config.ModelType, format.HumanNumber(layer.GGML.KV().ParameterCount()))
-			config.FileType = cmp.Or(config.FileType, layer.GGML.KV().FileType().String())
-			config.ModelFamilies = append(config.ModelFamilies, layer.GGML.KV().Architecture())
+			all = append(all, name)
 		}
-		layers = append(layers, layer.Layer)
 	}
 
-	if r.Template != "" {
-		layers, err = setTemplate(layers, r.Template)
-		if err != nil {
-			return err
+	// a model can only be created from a model earlier in the batch, as
+	// the later ones haven't been built yet
+	for i, m := range r.Models {
+		if m.From == "" {
+			continue
 		}
-	}
 
-	if r.System != "" {
-		layers, err = setSystem(layers, r.System)
+		from, _, err := parseFromName(m.From)
 		if err != nil {
-			return err
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-	}
 
-	if r.License != nil {
-		switch l := r.License.(type) {
-		case string:
-			if l != "" {
-				layers, err = setLicense(layers, l)
-				if err != nil {
-					return err
-				}
-			}
-		case any:
-			var licenses []string
-			b, _ := json.Marshal(l) // re-marshal to JSON
-			if err := json.Unmarshal(b, &licenses); err != nil {
-				return err
-			}
-			for _, v := range licenses {
-				layers, err = setLicense(layers, v)
-				if err != nil {
-					return err
-				}
+		for _, later := range names[i+1:] {
+			if slices.ContainsFunc(later, from.EqualFold) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("model %q is created from %q, which must come before it in the batch", names[i][0].DisplayShortest(), from.DisplayShortest())})
+				return
 			}
-		default:
-			return fmt.Errorf("unknown license type: %T", l)
 		}
 	}
 
-	layers, err = setParameters(layers, r.Parameters)
-	if err != nil {
-		return err
+	if err := checkProtected(all); errors.Is(err, errModelProtected) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	layers, err = setMessages(layers, r.Messages)
-	if err != nil {
-		return err
+	if err := checkStoreLimits(all); err != nil {
+		c.AbortWithStatusJSON(storeLimitStatus(err), gin.H{"error": err.Error()})
+		return
 	}
 
-	configLayer, err := createConfigLayer(layers, config)
-	if err != nil {
-		return err
+	id := c.GetHeader(requestIDHeader)
+	if id == "" {
+		id = uuid.NewString()
 	}
-
-	for _, layer := range layers {
-		if layer.status != "" {
-			fn(api.ProgressResponse{Status: layer.status})
+	c.Header(requestIDHeader, id)
+
+	ctx, cancel := context.WithCancelCause(withRequestID(c.Request.Context(), id))
+	untrack := trackCreate(id, all, cancel)
+	log := requestLogger(ctx)
+	log.Info("creating models", "models", len(r.Models), "model", names[0][0].DisplayShortest())
+
+	// a batch is logged under its first model
+	var progressLog *createLog
+	if envconfig.CreateLogs() {
+		var err error
+		if progressLog, err = openCreateLog(names[0][0]); err != nil {
+			log.Warn("couldn't open create log", "error", err)
 		}
 	}
 
-	fn(api.ProgressResponse{Status: "writing manifest"})
-	if err := WriteManifest(name, *configLayer, layers); err != nil {
-		return err
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		defer cancel(nil)
+		defer untrack()
+		if progressLog != nil {
+			defer progressLog.Close()
+		}
+
+		fn := func(resp api.ProgressResponse) {
+			resp.RequestID = id
+			log.Debug("create progress", "status", resp.Status, "digest", resp.Digest)
+			if progressLog != nil {
+				if err := progressLog.write(resp); err != nil {
+					log.Warn("couldn't write create log", "error", err)
+				}
+			}
+
+			ch <- resp
+		}
+
+		if err := createBatch(ctx, r, names, fn); err != nil {
+			if errors.Is(context.Cause(ctx), errCreateCanceled) {
+				err = errCreateCanceled
+			}
+
+			log.Error("create failed", "error", err)
+			fn(createErrorResponse(err))
+			return
+		}
+
+		log.Info("created models", "models", len(r.Models), "model", names[0][0].DisplayShortest())
+		fn(api.ProgressResponse{Status: "success"})
+	}()
+
+	if r.Stream != nil && !*r.Stream {
+		waitForStream(c, ch)
+		return
 	}
 
-	return nil
+	streamResponse(c, ch)
 }
 
-func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.ProgressResponse)) (*layerGGML, error) {
-	ft := layer.GGML.KV().FileType()
-	fn(api.ProgressResponse{Status: fmt.Sprintf("quantizing %s model to %s", ft, quantizeType)})
-
-	want, err := llm.ParseFileType(quantizeType)
+// createBatch builds every model of the batch and then writes their
+// manifests. The pins of the blobs it writes are released when it returns,
+// before the batch is reported as created.
+func createBatch(ctx context.Context, r api.CreateBatchRequest, names [][]model.Name, fn func(resp api.ProgressResponse)) (err error) {
+	pins := pinBlobs()
+	defer pins.release()
+	ctx = withBlobPins(ctx, pins)
+	defer removeCanceledBlobs(ctx, pins)
+	defer func() {
+		// nothing of a failed batch is committed, so the blobs it wrote are
+		// removed as they are for a canceled create
+		if err != nil && ctx.Err() == nil {
+			if err := pins.removeCreated(); err != nil {
+				requestLogger(ctx).Warn("couldn't remove blobs of a failed create", "error", err)
+			}
+		}
+	}()
+
+	// models later in the batch can be created from earlier ones
+	built := make(pendingManifests)
+	ctx = withPendingManifests(ctx, built)
+
+	var pending []pendingModel
+	for i, m := range r.Models {
+		fn(api.ProgressResponse{Status: fmt.Sprintf("creating %s", names[i][0].DisplayShortest())})
+
+		var signer ssh.Signer
+		if m.SigningKey != "" {
+			var err error
+			signer, err = loadSigningKey(m.SigningKey)
+			if err != nil {
+				return err
+			}
+		}
+
+		config, layers, err := buildModel(ctx, m, fn)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names[i] {
+			oldManifest, _ := ParseNamedManifest(name)
+			pending = append(pending, pendingModel{name: name, config: *config, layers: layers, oldManifest: oldManifest, noPrune: m.NoPrune, signer: signer, pins: pins})
+			if err := built.add(name, *config, layers); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fn(api.ProgressResponse{Status: "writing manifests"})
+	if err := writeManifests(pending); err != nil {
+		return err
+	}
+
+	if err := removeOldLayers(pending); err != nil {
+		fn(api.ProgressResponse{Error: err.Error()})
+	}
+
+	if slices.ContainsFunc(r.Models, func(m api.CreateRequest) bool { return m.Prune }) {
+		fn(api.ProgressResponse{Status: "removing unused blobs"})
+		if err := PruneLayers(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createNames returns the canonical names a create request writes: the model
+// name followed by any aliases.
+func createNames(r api.CreateRequest) ([]model.Name, error) {
+	var names []model.Name
+	for _, s := range append([]string{cmp.Or(r.Model, r.Name)}, r.Aliases...) {
+		name := model.ParseName(s)
+		if !name.IsValid() {
+			return nil, errors.New(errtypes.InvalidModelNameErrMsg)
+		}
+
+		name, err := getExistingName(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.ContainsFunc(names, name.EqualFold) {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+// RollbackHandler restores the manifest a model had before it was last
+// created. The previous layers must still exist, so this is only useful when
+// the create that replaced them didn't prune them.
+func (s *Server) RollbackHandler(c *gin.Context) {
+	var r api.RollbackRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(r.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return
+	}
+
+	name, err := getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkProtected([]model.Name{name}); errors.Is(err, errModelProtected) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	previous, err := parsePreviousManifest(name)
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no previous version of model %q", r.Model)})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, layer := range append(previous.Layers, previous.Config) {
+		blob, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := os.Stat(blob); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("previous version of model %q is missing layer %s", r.Model, layer.Digest)})
+			return
+		}
+	}
+
+	current, _ := ParseNamedManifest(name)
+
+	// the restored manifest is signed with the key which signed the model
+	// it replaces, so a rollback doesn't drop the model's signature
+	manifests, err := GetManifestPath()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	p := filepath.Join(manifests, name.Filepath())
+	signer, err := manifestSigner(p)
+	if isSignatureError(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := WriteManifest(name, previous.Config, previous.Layers); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if signer != nil {
+		if err := signManifest(p, signer); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := removePreviousManifest(name); err != nil {
+		slog.Warn("couldn't remove previous manifest", "name", name, "error", err)
+	}
+
+	if current != nil && !envconfig.NoPrune() {
+		if err := current.RemoveLayers(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// pendingModel is a model whose layers have been written to the blob store
+// but whose manifest hasn't been written yet.
+type pendingModel struct {
+	name        model.Name
+	config      Layer
+	layers      []Layer
+	oldManifest *Manifest
+	noPrune     bool
+
+	// oldSignature is the signature of oldManifest, which is restored with
+	// it on rollback
+	oldSignature []byte
+
+	// signer signs the manifest once it's written, if it's set
+	signer ssh.Signer
+
+	// pins keep the model's blobs from being pruned until its manifest is
+	// written
+	pins *blobPins
+}
+
+// rollback restores the manifest that existed before the model was written,
+// and its signature, or removes the manifest if the model is new.
+func (m pendingModel) rollback() error {
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return err
+	}
+
+	p := filepath.Join(manifests, m.name.Filepath())
+	if m.oldManifest != nil {
+		if err := WriteManifest(m.name, m.oldManifest.Config, m.oldManifest.Layers); err != nil {
+			return err
+		}
+
+		if m.oldSignature != nil {
+			return writeSignature(p, m.oldSignature)
+		}
+
+		return nil
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return PruneDirectory(manifests)
+}
+
+// sign signs the manifest written for the model.
+func (m pendingModel) sign() error {
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return err
+	}
+
+	return signManifest(filepath.Join(manifests, m.name.Filepath()), m.signer)
+}
+
+// writeManifests writes the manifest for every pending model. If any write
+// fails, the manifests already written are rolled back and the blobs written
+// for the models are removed.
+func writeManifests(pending []pendingModel) error {
+	// the signatures of the old manifests are read before any are replaced
+	// so they can be restored with them
+	for i, m := range pending {
+		if m.oldManifest == nil {
+			continue
+		}
+
+		signature, err := readSignature(m.oldManifest.filepath)
+		if err != nil {
+			removeCreatedLayers(pending)
+			return err
+		}
+
+		pending[i].oldSignature = signature
+	}
+
+	for i, m := range pending {
+		// the previous manifest is only kept when its layers are, as it
+		// keeps them from being removed
+		if m.oldManifest != nil && (m.noPrune || envconfig.NoPrune()) {
+			if err := writePreviousManifest(m.name, m.oldManifest); err != nil {
+				slog.Warn("couldn't keep previous manifest", "name", m.name, "error", err)
+			}
+		} else if err := removePreviousManifest(m.name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("couldn't remove previous manifest", "name", m.name, "error", err)
+		}
+
+		err := WriteManifest(m.name, m.config, m.layers)
+		if err == nil && m.signer != nil {
+			err = m.sign()
+		}
+
+		if err != nil {
+			for _, m := range pending[:i+1] {
+				if err := m.rollback(); err != nil {
+					slog.Error("couldn't restore manifest", "name", m.name, "error", err)
+				}
+			}
+
+			removeCreatedLayers(pending)
+			return fmt.Errorf("couldn't write the manifest of %s: %w", m.name.DisplayShortest(), err)
+		}
+	}
+
+	// the quantized layers are now kept by the manifests
+	for _, m := range pending {
+		for _, layer := range m.layers {
+			for _, checkpoint := range layer.checkpoints {
+				if err := checkpoint.remove(); err != nil {
+					slog.Warn("couldn't remove quantization checkpoint", "error", err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeCanceledBlobs removes the blobs written for a create whose ctx was
+// canceled, e.g. by [Server.CancelCreateHandler] or its client going away,
+// so they aren't left for a prune.
+func removeCanceledBlobs(ctx context.Context, pins *blobPins) {
+	if ctx.Err() == nil {
+		return
+	}
+
+	if err := pins.removeCreated(); err != nil {
+		requestLogger(ctx).Warn("couldn't remove blobs of a canceled create", "error", err)
+	}
+}
+
+// removeCreatedLayers removes the blobs written for pending models whose
+// manifests couldn't be written, unless another manifest references them.
+// The digests of blobs which can't be removed are logged so they can be
+// pruned later.
+func removeCreatedLayers(pending []pendingModel) {
+	removed := make(map[string]bool)
+	for _, m := range pending {
+		for _, layer := range append([]Layer{m.config}, m.layers...) {
+			if !layer.created || removed[layer.Digest] {
+				continue
+			}
+			removed[layer.Digest] = true
+
+			// a quantized model is kept for its checkpoint
+			if len(layer.checkpoints) > 0 {
+				continue
+			}
+
+			// the blob won't be referenced now so it's only kept if another
+			// create is using it
+			m.pins.unpin(layer.Digest)
+			if err := layer.Remove(); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("couldn't remove blob of a failed create", "digest", layer.Digest, "error", err)
+			}
+		}
+	}
+}
+
+// removeOldLayers removes layers of the replaced manifests which are no longer
+// referenced, unless pruning is disabled.
+func removeOldLayers(pending []pendingModel) error {
+	if envconfig.NoPrune() {
+		return nil
+	}
+
+	for _, m := range pending {
+		if m.oldManifest != nil && !m.noPrune {
+			if err := m.oldManifest.RemoveLayers(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// badRequestError marks an error caused by the create request itself.
+type badRequestError struct {
+	error
+}
+
+func (e badRequestError) Unwrap() error {
+	return e.error
+}
+
+// createErrorResponse converts an error from the create pipeline into the
+// message sent to the client, reporting errors caused by the request as 400s.
+func createErrorResponse(err error) api.ProgressResponse {
+	var badReq badRequestError
+	if errors.As(err, &badReq) {
+		return api.ProgressResponse{Error: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+
+	for _, badReq := range []error{
+		errNoFilesProvided, errOnlyOneAdapterSupported, errOnlyGGUFSupported,
+		errUnknownType, errNeitherFromOrFiles, errBadTemplate,
+		errSplitGGUFUnsupported, errMultipleBaseModels, errNotAdapter, errFileAndAdapter,
+		errTemplateAndName, errUnknownTemplate, errTooManyLayers,
+		errCircularInclude, errIncludedModel, errFromDigestMismatch,
+		errUnknownPreset, llm.ErrGGUFTruncated, errLFSPointer,
+		convert.ErrUnsupportedQuantization,
+	} {
+		if errors.Is(err, badReq) {
+			return api.ProgressResponse{Error: err.Error(), StatusCode: http.StatusBadRequest}
+		}
+	}
+
+	return api.ProgressResponse{Error: err.Error()}
+}
+
+// createBaseLayers resolves the model and adapter layers a create request
+// builds on, either from an existing model or from uploaded files.
+func createBaseLayers(ctx context.Context, r api.CreateRequest, fn func(resp api.ProgressResponse)) ([]*layerGGML, error) {
+	var baseLayers []*layerGGML
+	var err error
+	if r.From != "" {
+		requestLogger(ctx).Debug("create model from model name")
+		fromName, digest, err := parseFromName(r.From)
+		if err != nil {
+			return nil, err
+		}
+
+		regOpts, err := fromRegistryOptions(r)
+		if err != nil {
+			return nil, err
+		}
+
+		baseLayers, err = parseFromModel(ctx, fromName, digest, regOpts, fn)
+		if err != nil {
+			return nil, err
+		}
+	} else if r.Files != nil {
+		// uploads are resolved first so they're checked by their digests
+		if r.Files, err = resolveUploads(r.Files); err != nil {
+			return nil, err
+		}
+
+		if r.Adapters != nil {
+			if r.Adapters, err = resolveUploads(r.Adapters); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := checkAdapterFiles(r.Files, r.Adapters); err != nil {
+			return nil, err
+		}
+
+		baseLayers, err = convertModelFromFiles(ctx, r.Files, baseLayers, false, fn)
+		if err != nil {
+			return nil, err
+		}
+
+		if !r.NoTemplateDetection {
+			baseLayers, err = detectChatTemplate(ctx, baseLayers)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		return nil, errNeitherFromOrFiles
+	}
+
+	if r.Adapters != nil {
+		adapterLayers, err := convertModelFromFiles(ctx, r.Adapters, baseLayers, true, fn)
+		if err != nil {
+			return nil, badRequestError{err}
+		}
+
+		baseLayers = append(baseLayers, adapterLayers...)
+	}
+
+	return baseLayers, nil
+}
+
+// checkAdapterFiles fails if a blob is listed in both the model's files and
+// its adapters. Its layers would be both the base model and applied to it.
+func checkAdapterFiles(files, adapters map[string]string) error {
+	for _, k := range slices.Sorted(maps.Keys(adapters)) {
+		for _, f := range slices.Sorted(maps.Keys(files)) {
+			if canonicalDigest(files[f]) == canonicalDigest(adapters[k]) {
+				return fmt.Errorf("%w: %s is also adapter %s", errFileAndAdapter, f, k)
+			}
+		}
+	}
+
+	return nil
+}
+
+func convertModelFromFiles(ctx context.Context, files map[string]string, baseLayers []*layerGGML, isAdapter bool, fn func(resp api.ProgressResponse)) ([]*layerGGML, error) {
+	files, err := resolveUploads(files)
+	if err != nil {
+		return nil, err
+	}
+
+	modelType, err := detectModelTypeFromFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	fn(api.ProgressResponse{
+		Status: fmt.Sprintf("detected %s files", modelType),
+		Files:  classifyFiles(files, modelType),
+	})
+
+	switch modelType {
+	case "safetensors":
+		layers, err := convertFromSafetensors(ctx, files, baseLayers, isAdapter, fn)
+		if err != nil {
+			requestLogger(ctx).Error("error converting from safetensors", "error", err)
+			return nil, err
+		}
+		return layers, nil
+	case "gguf":
+		if len(files) == 0 {
+			return nil, errNoFilesProvided
+		} else if len(files) > 1 && isAdapter {
+			return nil, errOnlyOneAdapterSupported
+		}
+
+		var allLayers []*layerGGML
+		// the file each architecture's base model came from
+		bases := make(map[string]string)
+		for _, k := range slices.Sorted(maps.Keys(files)) {
+			layers, err := ggufLayers(ctx, files[k], fileProgress(k, files[k], fn))
+			if err != nil {
+				return nil, err
+			}
+
+			for _, layer := range layers {
+				layer.Source = cmp.Or(layer.Source, k)
+				if isAdapter && layer.MediaType != "application/vnd.ollama.image.adapter" {
+					// a full model passed as an adapter would be applied to the base model
+					return nil, fmt.Errorf("%w: %s is a %s model", errNotAdapter, k, cmp.Or(layer.KV().Architecture(), "GGUF"))
+				}
+
+				if layer.MediaType != "application/vnd.ollama.image.model" {
+					continue
+				}
+
+				arch := layer.KV().Architecture()
+				if _, ok := layer.KV()["general.architecture"]; ok {
+					// the model is created anyway, as a newer runner may load it
+					if err := llm.CheckArchitecture(arch); err != nil {
+						warn(fn, fmt.Sprintf("%s won't load: %s", k, err))
+					}
+				}
+
+				if other, ok := bases[arch]; ok {
+					return nil, fmt.Errorf("%w: %s and %s are both %s models", errMultipleBaseModels, other, k, arch)
+				}
+				bases[arch] = k
+			}
+			allLayers = append(allLayers, layers...)
+		}
+		return allLayers, nil
+	default:
+		return nil, errUnknownType
+	}
+}
+
+// fileProgress identifies the progress reported to fn as the progress of
+// the file name with digest.
+func fileProgress(name, digest string, fn func(resp api.ProgressResponse)) func(resp api.ProgressResponse) {
+	return func(resp api.ProgressResponse) {
+		resp.File = name
+		resp.Digest = cmp.Or(resp.Digest, digest)
+		fn(resp)
+	}
+}
+
+// progressReader reports the bytes read of a file to fn at most every
+// progressInterval, and when it's been read. completed starts at the offset
+// being read from. Reads fail once ctx, if it's set, is canceled.
+type progressReader struct {
+	io.Reader
+	ctx              context.Context
+	status           string
+	total, completed int64
+	reported         time.Time
+	fn               func(resp api.ProgressResponse)
+}
+
+const progressInterval = 100 * time.Millisecond
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	if r.ctx != nil {
+		if err := r.ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.Reader.Read(b)
+	r.completed += int64(n)
+	if time.Since(r.reported) >= progressInterval || err != nil {
+		r.reported = time.Now()
+		r.fn(api.ProgressResponse{Status: r.status, Total: r.total, Completed: r.completed})
+	}
+
+	return n, err
+}
+
+// detectModelTypeFromFiles returns the format of the model in files. If the
+// format can't be determined, the returned error wraps errUnknownType and
+// describes why.
+func detectModelTypeFromFiles(files map[string]string) (string, error) {
+	// a repository cloned without its LFS files has pointers to them in
+	// their place, which would otherwise fail to convert
+	if pointers := lfsPointers(files); len(pointers) > 0 {
+		return "", fmt.Errorf("%w: %s; run `git lfs pull` in the model's repository to download them", errLFSPointer, strings.Join(pointers, ", "))
+	}
+
+	var unrecognized []string
+	for fn := range files {
+		if strings.HasSuffix(fn, ".safetensors") {
+			return "safetensors", nil
+		} else if strings.HasSuffix(fn, ".gguf") {
+			return "gguf", nil
+		} else {
+			// try to see if we can find a gguf file even without the file extension
+			blobPath, err := GetBlobsPath(files[fn])
+			if err != nil {
+				return "", fmt.Errorf("%w: %s: %w", errUnknownType, fn, err)
+			}
+
+			f, err := os.Open(blobPath)
+			if err != nil {
+				return "", fmt.Errorf("%w: couldn't open %s: %w", errUnknownType, fn, err)
+			}
+			defer f.Close()
+
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(f, buf); err != nil {
+				return "", fmt.Errorf("%w: couldn't read %s: %w", errUnknownType, fn, err)
+			}
+
+			ct := llm.DetectGGMLType(buf)
+			if ct == "gguf" {
+				return "gguf", nil
+			}
+
+			unrecognized = append(unrecognized, fn)
+		}
+	}
+
+	if len(unrecognized) > 0 {
+		slices.Sort(unrecognized)
+		return "", fmt.Errorf("%w: unrecognized file format: %s", errUnknownType, strings.Join(unrecognized, ", "))
+	}
+
+	return "", errUnknownType
+}
+
+// safetensorsFiles are the patterns of the files converting a safetensors
+// model reads, and what they're used as. Files matching none are ignored.
+var safetensorsFiles = []struct {
+	pattern, kind string
+}{
+	{"*.safetensors", "model"},
+	{"pytorch_model-*-of-*.bin", "model"},
+	{"pytorch_model.bin", "model"},
+	{"consolidated.*.pth", "model"},
+	{"tokenizer.json", "tokenizer"},
+	{"tokenizer.model", "tokenizer"},
+	{"tokenizer_config.json", "tokenizer"},
+	{"added_tokens.json", "tokenizer"},
+	{"special_tokens_map.json", "tokenizer"},
+	{"config.json", "config"},
+	{"adapter_config.json", "config"},
+	{"quantize_config.json", "config"},
+	{"modules.json", "config"},
+	{"*/config.json", "config"},
+}
+
+// classifyFiles reports what each of files is used as when converting a
+// model of modelType. Every file of a GGUF model is a model.
+func classifyFiles(files map[string]string, modelType string) map[string]string {
+	kinds := make(map[string]string, len(files))
+	for fn := range files {
+		if modelType == "gguf" {
+			kinds[fn] = "model"
+			continue
+		}
+
+		kinds[fn] = "ignored"
+		for _, f := range safetensorsFiles {
+			if ok, _ := path.Match(f.pattern, filepath.ToSlash(fn)); ok {
+				kinds[fn] = f.kind
+				break
+			}
+		}
+	}
+
+	return kinds
+}
+
+// lfsPointerPrefix starts every Git LFS pointer file
+const lfsPointerPrefix = "version https://git-lfs."
+
+// lfsPointers returns the sorted names of the files which are Git LFS
+// pointers. Files which can't be read are left to be reported when their
+// format is detected.
+func lfsPointers(files map[string]string) []string {
+	var pointers []string
+	for name, digest := range files {
+		blob, err := GetBlobsPath(digest)
+		if err != nil {
+			continue
+		}
+
+		f, err := os.Open(blob)
+		if err != nil {
+			continue
+		}
+
+		buf := make([]byte, len(lfsPointerPrefix))
+		_, err = io.ReadFull(f, buf)
+		f.Close()
+		if err == nil && string(buf) == lfsPointerPrefix {
+			pointers = append(pointers, name)
+		}
+	}
+
+	slices.Sort(pointers)
+	return pointers
+}
+
+func convertFromSafetensors(ctx context.Context, files map[string]string, baseLayers []*layerGGML, isAdapter bool, fn func(resp api.ProgressResponse)) ([]*layerGGML, error) {
+	tmpDir, err := mkdirCreateTemp(ctx, "safetensors-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for fp, digest := range files {
+		blobPath, err := GetBlobsPath(digest)
+		if err != nil {
+			return nil, err
+		}
+		if err := createLink(blobPath, filepath.Join(tmpDir, fp)); err != nil {
+			return nil, err
+		}
+	}
+
+	t, err := os.CreateTemp(tmpDir, "fp16")
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+
+	var mediaType string
+	if !isAdapter {
+		fn(api.ProgressResponse{Status: "converting model"})
+		mediaType = "application/vnd.ollama.image.model"
+		if err := convert.ConvertModel(os.DirFS(tmpDir), t); err != nil {
+			return nil, err
+		}
+	} else {
+		kv, err := kvFromLayers(baseLayers)
+		if err != nil {
+			return nil, err
+		}
+		fn(api.ProgressResponse{Status: "converting adapter"})
+		mediaType = "application/vnd.ollama.image.adapter"
+		if err := convert.ConvertAdapter(os.DirFS(tmpDir), t, kv); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := t.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	layer, err := newCreateLayer(ctx, t, mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	bin, err := layer.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	ggml, _, err := llm.DecodeGGML(bin, 0)
+	if err != nil {
+		return nil, err
+	}
+	layer.Source = strings.Join(slices.Sorted(maps.Keys(files)), ",")
+	return []*layerGGML{{layer, ggml}}, nil
+}
+
+func kvFromLayers(baseLayers []*layerGGML) (llm.KV, error) {
+	for _, l := range baseLayers {
+		if l.GGML != nil {
+			return l.KV(), nil
+		}
+	}
+	return llm.KV{}, fmt.Errorf("no base model was found")
+}
+
+// buildModel returns the config layer and layers of the model described by
+// r. Requests which only change the text layers of a local model reuse its
+// manifest rather than decoding its model blobs.
+func buildModel(ctx context.Context, r api.CreateRequest, fn func(resp api.ProgressResponse)) (_ *Layer, layers []Layer, err error) {
+	// check the digest algorithm, rootfs type, quantization and registry
+	// headers before any blobs are written
+	if d, err := parseDigester(r.DigestAlgorithm); err != nil {
+		return nil, nil, badRequestError{err}
+	} else if r.DigestAlgorithm != "" {
+		// the blobs are hashed with the algorithm as they're written
+		ctx = withDigester(ctx, d)
+	}
+
+	if _, err := parseRootFSType(r.RootFSType); err != nil {
+		return nil, nil, badRequestError{err}
+	}
+
+	if _, err := fromRegistryOptions(r); err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := parseQuantization(cmp.Or(r.Quantize, r.Quantization)); err != nil {
+		return nil, nil, badRequestError{err}
+	}
+
+	if _, err := parseQuantizePolicy(r.QuantizePolicy); err != nil {
+		return nil, nil, badRequestError{err}
+	} else if len(r.QuantizePolicy) > 0 && cmp.Or(r.Quantize, r.Quantization) == "" {
+		return nil, nil, badRequestError{errors.New("quantize_policy requires quantize")}
+	}
+
+	if r.AllowNewerGGUF {
+		ctx = withNewerGGUF(ctx)
+	}
+
+	if r.Reproducible {
+		defer func() {
+			if err == nil {
+				layers = reproducibleLayers(layers)
+			}
+		}()
+	}
+
+	if r.Archive != "" {
+		files, err := unpackArchive(ctx, r.Archive, fn)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer func() {
+			removeUnpackedFiles(ctx, files, layers)
+		}()
+
+		// files listed in the request take precedence
+		maps.Copy(files, r.Files)
+		r.Files = files
+	}
+
+	if len(r.InlineFiles) > 0 || len(r.InlineAdapters) > 0 {
+		files, adapters, err := writeInlineFiles(ctx, r.InlineFiles, r.InlineAdapters)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer func() {
+			removeUnpackedFiles(ctx, files, layers)
+			removeUnpackedFiles(ctx, adapters, layers)
+		}()
+
+		if r.Files, err = mergeInlineFiles(r.Files, files); err != nil {
+			return nil, nil, err
+		}
+
+		if r.Adapters, err = mergeInlineFiles(r.Adapters, adapters); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if metadataOnly(r) {
+		name, digest, _ := parseFromName(r.From)
+		m, err := parseLocalManifest(ctx, name)
+		if err == nil {
+			if err := checkFromDigest(name, m, digest); err != nil {
+				return nil, nil, err
+			}
+
+			return updateManifestLayers(ctx, r, name, m, fn)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+		// the model isn't local so createBaseLayers pulls it
+	}
+
+	baseLayers, err := createBaseLayers(ctx, r, fn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return createLayers(ctx, r, baseLayers, fn)
+}
+
+// reproducibleLayers removes what layers record about where they were
+// created from. A layer's From is the path of the blob it was read from,
+// which depends on where the models directory is, or the name of a model,
+// so it would change the manifest's digest between otherwise identical
+// creates. The config and every other field of a manifest, including the
+// maps in its JSON layers whose keys are sorted, are already deterministic.
+func reproducibleLayers(layers []Layer) []Layer {
+	layers = slices.Clone(layers)
+	for i := range layers {
+		layers[i].From = ""
+	}
+
+	return layers
+}
+
+// metadataOnly reports whether r is derived from another model without
+// changing its model, adapter or projector layers.
+func metadataOnly(r api.CreateRequest) bool {
+	_, _, err := parseFromName(r.From)
+	return r.From != "" && err == nil &&
+		len(r.Files) == 0 && len(r.Adapters) == 0 && r.Archive == "" &&
+		r.Quantize == "" && r.Quantization == "" && envconfig.DefaultQuantize() == "" &&
+		!r.ContextFromModel && len(r.KVOverrides) == 0 && r.Tokenizer == nil && !r.StripMetadata
+}
+
+// sourceConfig reads the config of a model which another is created from,
+// reporting a manifest without a usable config as a bad request.
+func sourceConfig(m *Manifest) (ConfigV2, error) {
+	if m.Config.Digest == "" {
+		return ConfigV2{}, badRequestError{errMissingConfigLayer}
+	}
+
+	config, err := readManifestConfig(m)
+	if errors.Is(err, os.ErrNotExist) {
+		return ConfigV2{}, badRequestError{errMissingConfigLayer}
+	} else if err != nil {
+		return ConfigV2{}, badRequestError{fmt.Errorf("source model config is invalid: %w", err)}
+	}
+
+	return config, nil
+}
+
+// updateManifestLayers applies r to the layers of the existing manifest m of
+// the model name.
+func updateManifestLayers(ctx context.Context, r api.CreateRequest, name model.Name, m *Manifest, fn func(resp api.ProgressResponse)) (*Layer, []Layer, error) {
+	config, err := sourceConfig(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.ModelType = cmp.Or(r.ParameterSize, config.ModelType)
+	// models pulled from elsewhere may name their file type differently
+	config.FileType = llm.NormalizeFileType(config.FileType)
+
+	var layers []Layer
+	for _, l := range m.Layers {
+		layer, err := NewLayerFromLayer(l.Digest, l.MediaType, name.DisplayShortest())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		layer.Source = cmp.Or(l.Source, name.DisplayShortest())
+		layers = append(layers, layer)
+	}
+
+	return updateLayers(ctx, r, config, layers, nil, fn)
+}
+
+// createLayers writes every blob for the model described by r and returns its
+// config layer and layers without writing a manifest.
+func createLayers(ctx context.Context, r api.CreateRequest, baseLayers []*layerGGML, fn func(resp api.ProgressResponse)) (_ *Layer, _ []Layer, err error) {
+	config := ConfigV2{
+		OS:           "linux",
+		Architecture: "amd64",
+		RootFS: RootFS{
+			Type: "layers",
+		},
+	}
+
+	var layers []Layer
+	for _, layer := range baseLayers {
+		if layer.GGML != nil {
+			quantType := strings.ToUpper(cmp.Or(r.Quantize, r.Quantization))
+			isDefaultQuant := quantType == ""
+			if isDefaultQuant {
+				quantType = strings.ToUpper(envconfig.DefaultQuantize())
+			}
+
+			// only the model itself is quantized. Adapters and projectors are
+			// kept as they are, whatever their file type
+			if quantType != "" && layer.GGML.Name() == "gguf" && layer.MediaType == "application/vnd.ollama.image.model" {
+				want, err := llm.ParseFileType(quantType)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				ft := layer.GGML.KV().FileType()
+				if !slices.Contains([]string{"F16", "F32"}, ft.String()) {
+					// models which are already quantized are kept as they are
+					// unless the request asked for a quantization
+					if !isDefaultQuant {
+						return nil, nil, errors.New("quantization is only supported for F16 and F32 models")
+					}
+				} else if ft != want || len(r.QuantizePolicy) > 0 {
+					layer, err = quantizeLayer(ctx, layer, quantType, r.QuantizePolicy, fn)
+					if err != nil {
+						return nil, nil, err
+					}
+
+					if len(r.QuantizePolicy) > 0 {
+						config.TensorQuantizations = tensorQuantizations(layer.GGML)
+					}
+				} else if !isDefaultQuant {
+					warn(fn, fmt.Sprintf("the model is already %s so it isn't quantized", ft))
+				}
+			}
+
+			if r.StripMetadata && layer.GGML.Name() == "gguf" {
+				layer, err = stripLayer(ctx, layer, fn)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+
+			config.ModelFormat = cmp.Or(config.ModelFormat, layer.GGML.Name())
+			config.ModelType = cmp.Or(r.ParameterSize, config.ModelType)
+			if config.ModelType == "" {
+				// counting the parameters of the tensors is only worth it
+				// when the size isn't already known
+				config.ModelType = parameterSize(layer.GGML)
+			}
+			config.FileType = llm.NormalizeFileType(cmp.Or(config.FileType, layer.GGML.KV().FileType().String()))
+
+			if config.SpecialTokens == nil && layer.MediaType == "application/vnd.ollama.image.model" {
+				config.SpecialTokens, err = specialTokens(layer.Layer)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				if config.SpecialTokens != nil {
+					fn(api.ProgressResponse{Status: "reading special tokens", SpecialTokens: config.SpecialTokens})
+				}
+			}
+		}
+		layers = append(layers, layer.Layer)
+	}
+
+	config.ModelFamilies = modelFamilies(baseLayers)
+	if len(config.ModelFamilies) > 0 {
+		config.ModelFamily = config.ModelFamilies[0]
+	}
+
+	return updateLayers(ctx, r, config, layers, baseLayers, fn)
+}
+
+// modelFamilies returns the architectures of layers without duplicates. The
+// architecture of the model itself comes first, followed by those of any
+// adapters and projectors in the order of their layers.
+func modelFamilies(layers []*layerGGML) []string {
+	var families []string
+	for _, layer := range layers {
+		if layer.GGML != nil && layer.MediaType == "application/vnd.ollama.image.model" {
+			families = append(families, layer.GGML.KV().Architecture())
+		}
+	}
+
+	for _, layer := range layers {
+		if layer.GGML != nil {
+			families = append(families, layer.GGML.KV().Architecture())
+		}
+	}
+
+	return uniqueFamilies(families)
+}
+
+// uniqueFamilies removes repeated families, keeping the first of each.
+// Models created before families were deduplicated may list one several times.
+func uniqueFamilies(families []string) []string {
+	var unique []string
+	for _, family := range families {
+		if family != "" && !slices.Contains(unique, family) {
+			unique = append(unique, family)
+		}
+	}
+
+	return unique
+}
+
+// derivedFrom returns the lineage of a model created from the model in
+// r.From, which is local by the time its layers are updated.
+func derivedFrom(ctx context.Context, r api.CreateRequest) ([]DerivedModel, error) {
+	if r.From == "" {
+		return nil, nil
+	}
+
+	name, _, err := parseFromName(r.From)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := parseLocalManifest(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := sourceConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]DerivedModel{{Model: name.DisplayShortest(), Digest: m.qualifiedDigest()}}, config.DerivedFrom...), nil
+}
+
+// updateLayers applies the text layers in r, such as the template, system
+// prompt and parameters, to layers and returns the new config layer and
+// layers.
+func updateLayers(ctx context.Context, r api.CreateRequest, config ConfigV2, layers []Layer, baseLayers []*layerGGML, fn func(resp api.ProgressResponse)) (_ *Layer, _ []Layer, err error) {
+	if r.Bundle != nil {
+		r, err = applyBundle(r)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r.LayersFrom != "" {
+		layers, err = setLayersFrom(ctx, layers, r.LayersFrom, r.LayerTypes)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r.TemplateName != "" {
+		if r.Template != "" {
+			return nil, nil, errTemplateAndName
+		}
+
+		r.Template, err = registeredTemplate(r.TemplateName)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r.Template != "" {
+		var stack []string
+		if r.TemplateName != "" {
+			stack = append(stack, r.TemplateName)
+		}
+
+		r.Template, err = resolveIncludes(r.Template, stack)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		layers, err = setTemplate(ctx, layers, r.Template)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r.System != "" {
+		layers, err = setSystem(ctx, layers, r.System)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r.Systems != nil {
+		systems, err := parseSystems(r.Systems)
+		if err != nil {
+			return nil, nil, badRequestError{err}
+		}
+
+		layers, err = setSystems(ctx, layers, systems)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var licensed bool
+	if r.License != nil {
+		switch l := r.License.(type) {
+		case string:
+			if l != "" {
+				layers, err = setLicense(ctx, layers, l)
+				if err != nil {
+					return nil, nil, err
+				}
+				licensed = true
+			}
+		case any:
+			var licenses []string
+			b, _ := json.Marshal(l) // re-marshal to JSON
+			if err := json.Unmarshal(b, &licenses); err != nil {
+				return nil, nil, err
+			}
+			for _, v := range licenses {
+				layers, err = setLicense(ctx, layers, v)
+				if err != nil {
+					return nil, nil, err
+				}
+				licensed = true
+			}
+		default:
+			return nil, nil, fmt.Errorf("unknown license type: %T", l)
+		}
+	}
+
+	if !licensed {
+		layers, err = setDefaultLicense(ctx, layers)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// aliases are resolved first so they take precedence over presets like
+	// the parameters they're an alias of
+	var warnings []string
+	r.Parameters, warnings = resolveParameterAliases(r.Parameters)
+	for _, warning := range warnings {
+		warn(fn, warning)
+	}
+
+	if r.Preset != "" {
+		r.Parameters, err = presetParameters(r.Preset, r.Parameters)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r.ContextFromModel {
+		r.Parameters, err = setDefaultContext(layers, baseLayers, r.Parameters)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	layers, err = setParameters(ctx, layers, r.Parameters)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	layers, warnings, err = setMessages(ctx, layers, r.Messages, r.StrictMessages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, warning := range warnings {
+		warn(fn, warning)
+	}
+
+	if warning := contextWarning(baseLayers, r.Parameters); warning != "" {
+		warn(fn, warning)
+	}
+
+	if err := checkCapabilityContexts(baseLayers, r.Parameters); err != nil {
+		return nil, nil, badRequestError{fmt.Errorf("invalid capability_num_ctx parameter: %w", err)}
+	}
+
+	switch {
+	case len(r.Format) > 0:
+		if r.Grammar != "" || len(r.Schema) > 0 {
+			return nil, nil, badRequestError{errFormatAndGrammar}
+		}
+
+		layers, err = setFormat(ctx, layers, r.Format)
+		if err != nil {
+			return nil, nil, err
+		}
+	case r.Grammar != "" || len(r.Schema) > 0:
+		layers, err = setGrammar(ctx, layers, r.Grammar, r.Schema)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r.Safety != nil {
+		layers, err = setSafety(ctx, layers, *r.Safety)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r.Card != nil {
+		layers, err = setCard(ctx, layers, *r.Card)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(r.KVOverrides) > 0 {
+		layers, err = setKVOverrides(ctx, layers, baseLayers, r.KVOverrides)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r.Tokenizer != nil {
+		layers, err = setTokenizer(ctx, layers, baseLayers, *r.Tokenizer, fn)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// the special tokens are read again from the model's new tokenizer
+		i := slices.IndexFunc(layers, func(l Layer) bool { return l.MediaType == "application/vnd.ollama.image.model" })
+		config.SpecialTokens, err = specialTokens(layers[i])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if warning, err := toolTemplateWarning(layers); err != nil {
+		return nil, nil, err
+	} else if warning != "" {
+		warn(fn, warning)
+	}
+
+	if n, limit := len(layers), envconfig.MaxLayers(); limit > 0 && uint(n) > limit {
+		return nil, nil, fmt.Errorf("%w: %d layers exceeds the maximum of %d", errTooManyLayers, n, limit)
+	}
+
+	// protection and draft status aren't inherited from the model in From
+	config.Protected = r.Protected
+	config.Draft = r.Draft
+
+	config.DerivedFrom, err = derivedFrom(ctx, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d, err := parseDigester(r.DigestAlgorithm)
+	if err != nil {
+		return nil, nil, badRequestError{err}
+	}
+
+	// layers are only re-addressed when an algorithm is chosen, so a model
+	// created from another keeps its digests by default
+	if r.DigestAlgorithm != "" {
+		layers, err = readdressLayers(layers, d)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// the rootfs type isn't inherited either
+	config.RootFS.Type, err = parseRootFSType(r.RootFSType)
+	if err != nil {
+		return nil, nil, badRequestError{err}
+	}
+
+	configLayer, err := createConfigLayer(layers, config, d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if r.OCI {
+		configLayer.MediaType = ociConfigMediaType
+	}
+
+	for _, layer := range layers {
+		if layer.status != "" {
+			fn(api.ProgressResponse{Status: layer.status})
+		}
+	}
+
+	return configLayer, layers, nil
+}
+
+// specialTokens reads the BOS, EOS and padding tokens of the model in layer
+// along with the control and user defined tokens added to its vocabulary.
+// The model is decoded again since its vocabulary is usually too large to be
+// collected by default.
+func specialTokens(layer Layer) (*api.SpecialTokens, error) {
+	f, err := layer.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ggml, _, err := llm.DecodeGGML(f, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	kv := ggml.KV()
+	tokens := kv.Tokens()
+	token := func(id uint64) api.Token {
+		t := api.Token{ID: int(id)}
+		if id < uint64(len(tokens)) {
+			t.Text = tokens[id]
+		}
+		return t
+	}
+
+	special := func(kind string) *api.Token {
+		id, ok := kv.SpecialTokenID(kind)
+		if !ok {
+			return nil
+		}
+
+		t := token(id)
+		return &t
+	}
+
+	st := api.SpecialTokens{
+		BOS: special("bos"),
+		EOS: special("eos"),
+		PAD: special("padding"),
+	}
+
+	for id, t := range kv.TokenTypes() {
+		// control and user defined tokens, matching convert's token types
+		if t == 3 || t == 4 {
+			st.Added = append(st.Added, token(uint64(id)))
+		}
+	}
+
+	if st.BOS == nil && st.EOS == nil && st.PAD == nil && len(st.Added) == 0 {
+		return nil, nil
+	}
+
+	return &st, nil
+}
+
+// parameterSize returns the human readable parameter count of ggml. Some
+// converted models don't record general.parameter_count so it's estimated
+// from the tensor shapes instead.
+func parameterSize(ggml *llm.GGML) string {
+	n := ggml.KV().ParameterCount()
+	if n == 0 {
+		n = ggml.Tensors().ParameterCount()
+	}
+
+	return format.HumanNumber(n)
+}
+
+// quantizeLayer quantizes the model in layer to quantizeType, with the
+// tensor groups in policy quantized to their own types.
+func quantizeLayer(ctx context.Context, layer *layerGGML, quantizeType string, policy map[string]string, fn func(resp api.ProgressResponse)) (*layerGGML, error) {
+	ft := layer.GGML.KV().FileType()
+	fn(api.ProgressResponse{Status: fmt.Sprintf("quantizing %s model to %s", ft, quantizeType)})
+
+	// the policy's groups are checked before anything is quantized
+	if err := checkQuantizePolicy(layer.GGML, policy); err != nil {
+		return nil, badRequestError{err}
+	}
+
+	want, err := llm.ParseFileType(quantizeType)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := parseQuantizePolicy(policy)
+	if err != nil {
+		return nil, badRequestError{err}
+	}
+
+	// a create which quantized the model the same way but didn't finish
+	// left the quantized model's layer
+	checkpoint := &quantizeCheckpoint{Source: layer.Digest, Type: want.String(), Policy: parsed}
+	newLayer, err := checkpoint.open(layer.MediaType)
+	if err == nil {
+		fn(api.ProgressResponse{Status: "resuming from an earlier quantization"})
+	} else {
+		if !errors.Is(err, os.ErrNotExist) {
+			requestLogger(ctx).Warn("ignoring quantization checkpoint", "error", err)
+		}
+
+		newLayer, err = quantizeModel(ctx, layer, quantizeType, parsed, fn)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkpoint.save(newLayer.Digest); err != nil {
+			requestLogger(ctx).Warn("couldn't save quantization checkpoint", "error", err)
+		}
+	}
+	newLayer.checkpoints = []*quantizeCheckpoint{checkpoint}
+
+	blob, err := newLayer.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	ggml, _, err := llm.DecodeGGML(blob, 0)
+	if err != nil {
+		requestLogger(ctx).Error(fmt.Sprintf("error decoding ggml: %s\n", err))
+		if err := checkpoint.remove(); err != nil {
+			requestLogger(ctx).Warn("couldn't remove quantization checkpoint", "error", err)
+		}
+		return nil, err
+	}
+
+	return &layerGGML{newLayer, ggml}, nil
+}
+
+// quantizeModel quantizes the model in layer to quantizeType, with the
+// tensor groups in policy quantized to their own types, and writes the
+// quantized model's layer.
+func quantizeModel(ctx context.Context, layer *layerGGML, quantizeType string, policy map[string]string, fn func(resp api.ProgressResponse)) (Layer, error) {
+	tensorTypes, err := quantizeTensorTypes(policy, quantizeType)
+	if err != nil {
+		return Layer{}, err
+	}
+
+	for _, q := range slices.Compact(slices.Sorted(maps.Values(policy))) {
+		if q == strings.ToUpper(quantizeType) {
+			// the groups are quantized to the model's type
+			continue
+		}
+
+		var groups []string
+		for _, group := range tensorGroups {
+			if policy[group] == q {
+				groups = append(groups, group)
+			}
+		}
+
+		fn(api.ProgressResponse{Status: fmt.Sprintf("quantizing %s tensors to %s", strings.Join(groups, " and "), q)})
+	}
+
+	temp, err := quantizeBlob(ctx, layer, quantizeType, tensorTypes)
+	if err != nil {
+		return Layer{}, err
+	}
+	defer temp.Close()
+
+	return newCreateLayer(ctx, temp, layer.MediaType)
+}
+
+// quantizedFile is a model quantized into a temp directory of a create. The
+// directory is removed when the file is closed.
+type quantizedFile struct {
+	*os.File
+}
+
+func (f quantizedFile) Close() error {
+	defer os.RemoveAll(filepath.Dir(f.Name()))
+	return f.File.Close()
+}
+
+// quantizeBlob quantizes the model in layer to quantizeType, with the
+// tensors matching the patterns of tensorTypes quantized to their types, and
+// opens the quantized model.
+func quantizeBlob(ctx context.Context, layer *layerGGML, quantizeType string, tensorTypes map[string]uint32) (quantizedFile, error) {
+	want, err := llm.ParseFileType(quantizeType)
+	if err != nil {
+		return quantizedFile{}, err
+	}
+
+	blob, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		return quantizedFile{}, err
+	}
+
+	dir, err := mkdirCreateTemp(ctx, "quantize-")
+	if err != nil {
+		return quantizedFile{}, err
+	}
+
+	p := filepath.Join(dir, "model.gguf")
+	if err := llama.Quantize(blob, p, uint32(want), tensorTypes); err != nil {
+		os.RemoveAll(dir)
+		return quantizedFile{}, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		os.RemoveAll(dir)
+		return quantizedFile{}, err
+	}
+
+	return quantizedFile{f}, nil
+}
+
+// essentialKeys are the general GGUF keys kept when metadata is stripped.
+// Keys under the model's architecture, such as its hyperparameters and rope
+// settings, and the tokenizer and adapter keys are also kept.
+var essentialKeys = []string{
+	"general.architecture",
+	"general.alignment",
+	"general.file_type",
+	"general.quantization_version",
+	"general.type",
+	"general.name",
+	"general.parameter_count",
+}
+
+func essentialKey(arch, key string) bool {
+	if slices.Contains(essentialKeys, key) {
+		return true
+	}
+
+	for _, prefix := range []string{arch + ".", "tokenizer.", "adapter.", "split."} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stripLayer rewrites a GGUF layer without the key values which aren't
+// essential to run it, such as training logs.
+func stripLayer(ctx context.Context, layer *layerGGML, fn func(resp api.ProgressResponse)) (*layerGGML, error) {
+	fn(api.ProgressResponse{Status: "stripping metadata"})
+
+	blob, err := GetBlobsPath(layer.Digest)
 	if err != nil {
 		return nil, err
 	}
 
-	blob, err := GetBlobsPath(layer.Digest)
+	f, err := os.Open(blob)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	temp, err := os.CreateTemp(filepath.Dir(blob), quantizeType)
+	arch := layer.GGML.KV().Architecture()
+	r, size, err := llm.StripGGUFKV(f, layer.Size, func(key string) bool {
+		return essentialKey(arch, key)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer temp.Close()
-	defer os.Remove(temp.Name())
 
-	if err := llama.Quantize(blob, temp.Name(), uint32(want)); err != nil {
-		return nil, err
+	if size == layer.Size {
+		return layer, nil
 	}
 
-	newLayer, err := NewLayer(temp, layer.MediaType)
+	newLayer, err := newCreateLayer(ctx, r, layer.MediaType)
 	if err != nil {
 		return nil, err
 	}
+	newLayer.Source = layer.Source
+	newLayer.checkpoints = layer.checkpoints
 
-	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+	newBlob, err := newLayer.Open()
+	if err != nil {
 		return nil, err
 	}
+	defer newBlob.Close()
 
-	ggml, _, err := llm.DecodeGGML(temp, 0)
+	ggml, _, err := llm.DecodeGGML(newBlob, 0)
 	if err != nil {
-		slog.Error(fmt.Sprintf("error decoding ggml: %s\n", err))
 		return nil, err
 	}
 
+	fn(api.ProgressResponse{Status: fmt.Sprintf("stripped %d metadata keys, saving %s", len(layer.GGML.KV())-len(ggml.KV()), format.HumanBytes2(uint64(layer.Size-size)))})
 	return &layerGGML{newLayer, ggml}, nil
 }
 
-func ggufLayers(digest string, fn func(resp api.ProgressResponse)) ([]*layerGGML, error) {
+// isAdapterKV reports whether the GGUF whose metadata is kv is an adapter,
+// either by its type or by the adapter keys converted adapters are written
+// with.
+func isAdapterKV(kv llm.KV) bool {
+	if kv.Kind() == "adapter" {
+		return true
+	}
+
+	_, ok := kv["adapter.type"]
+	return ok
+}
+
+type newerGGUFKey struct{}
+
+// withNewerGGUF lets the create ctx is for use GGUF files newer than the
+// runner supports, warning about them rather than failing.
+func withNewerGGUF(ctx context.Context) context.Context {
+	return context.WithValue(ctx, newerGGUFKey{}, true)
+}
+
+// checkGGUFVersion checks the version of the GGUF file at the start of r is
+// one the runner can load, so a model which won't load isn't created.
+func checkGGUFVersion(ctx context.Context, r io.Reader, fn func(resp api.ProgressResponse)) error {
+	v, err := llm.ReadGGUFVersion(r)
+	if err != nil {
+		return err
+	}
+
+	err = llm.CheckGGUFVersion(v)
+	if allowed, _ := ctx.Value(newerGGUFKey{}).(bool); allowed && errors.Is(err, llm.ErrGGUFVersionTooNew) {
+		warn(fn, err.Error())
+		return nil
+	} else if err != nil {
+		return badRequestError{err}
+	}
+
+	return nil
+}
+
+func ggufLayers(ctx context.Context, digest string, fn func(resp api.ProgressResponse)) ([]*layerGGML, error) {
 	var layers []*layerGGML
 
 	fn(api.ProgressResponse{Status: "parsing GGUF"})
@@ -464,7 +2165,7 @@ func ggufLayers(digest string, fn func(resp api.ProgressResponse)) ([]*layerGGML
 	}
 
 	if contentType != "gguf" {
-		slog.Error(fmt.Sprintf("unsupported content type: %s", contentType))
+		requestLogger(ctx).Error(fmt.Sprintf("unsupported content type: %s", contentType))
 		return nil, errOnlyGGUFSupported
 	}
 
@@ -475,6 +2176,14 @@ func ggufLayers(digest string, fn func(resp api.ProgressResponse)) ([]*layerGGML
 
 	var offset int64
 	for offset < stat.Size() {
+		// the version is checked first since a newer version's layout may
+		// not decode
+		if err := checkGGUFVersion(ctx, io.NewSectionReader(blob, offset, 8), fn); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
 		ggml, n, err := llm.DecodeGGML(blob, 0)
 		if errors.Is(err, io.EOF) {
 			break
@@ -482,8 +2191,12 @@ func ggufLayers(digest string, fn func(resp api.ProgressResponse)) ([]*layerGGML
 			return nil, err
 		}
 
+		if n := ggml.KV().SplitCount(); n > 1 {
+			return nil, fmt.Errorf("%w: model is split into %d files, merge them with llama-gguf-split --merge first", errSplitGGUFUnsupported, n)
+		}
+
 		mediatype := "application/vnd.ollama.image.model"
-		if ggml.KV().Kind() == "adapter" {
+		if isAdapterKV(ggml.KV()) {
 			mediatype = "application/vnd.ollama.image.adapter"
 		} else if _, ok := ggml.KV()[fmt.Sprintf("%s.vision.block_count", ggml.KV().Architecture())]; ok || ggml.KV().Kind() == "projector" {
 			mediatype = "application/vnd.ollama.image.projector"
@@ -493,14 +2206,21 @@ func ggufLayers(digest string, fn func(resp api.ProgressResponse)) ([]*layerGGML
 		if digest != "" && n == stat.Size() && offset == 0 {
 			layer, err = NewLayerFromLayer(digest, mediatype, blob.Name())
 			if err != nil {
-				slog.Debug("could not create new layer from layer", "error", err)
+				requestLogger(ctx).Debug("could not create new layer from layer", "error", err)
 				return nil, err
 			}
 		}
 
 		// Fallback to creating layer from file copy (either NewLayerFromLayer failed, or digest empty/n != stat.Size())
 		if layer.Digest == "" {
-			layer, err = NewLayer(io.NewSectionReader(blob, offset, n), mediatype)
+			layer, err = newCreateLayer(ctx, &progressReader{
+				Reader:    io.NewSectionReader(blob, offset, n-offset),
+				ctx:       ctx,
+				status:    "copying GGUF",
+				total:     stat.Size(),
+				completed: offset,
+				fn:        fn,
+			}, mediatype)
 			if err != nil {
 				return nil, err
 			}
@@ -510,7 +2230,7 @@ func ggufLayers(digest string, fn func(resp api.ProgressResponse)) ([]*layerGGML
 		offset = n
 	}
 
-	return detectChatTemplate(layers)
+	return layers, nil
 }
 
 func removeLayer(layers []Layer, mediatype string) []Layer {
@@ -528,7 +2248,44 @@ func removeLayer(layers []Layer, mediatype string) []Layer {
 	})
 }
 
-func setTemplate(layers []Layer, t string) ([]Layer, error) {
+// setLayersFrom replaces the layers of each type in types with the layers of
+// that type from the model named from.
+func setLayersFrom(ctx context.Context, layers []Layer, from string, types []string) ([]Layer, error) {
+	name := model.ParseName(from)
+	if !name.IsValid() {
+		return nil, badRequestError{fmt.Errorf("layers_from: %s", errtypes.InvalidModelNameErrMsg)}
+	}
+
+	if len(types) == 0 {
+		return nil, badRequestError{errors.New("layer_types must be set with layers_from")}
+	}
+
+	m, err := parseLocalManifest(ctx, name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, badRequestError{fmt.Errorf("layers_from: model '%s' not found", from)}
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, t := range types {
+		if !slices.Contains([]string{"template", "system", "params", "messages", "license", "safety", "card"}, t) {
+			return nil, badRequestError{fmt.Errorf("unknown layer type '%s'", t)}
+		}
+
+		mediatype := "application/vnd.ollama.image." + t
+		layers = removeLayer(layers, mediatype)
+		for _, layer := range m.Layers {
+			if layer.MediaType == mediatype {
+				layer.Source = cmp.Or(layer.Source, name.DisplayShortest())
+				layers = append(layers, layer)
+			}
+		}
+	}
+
+	return layers, nil
+}
+
+func setTemplate(ctx context.Context, layers []Layer, t string) ([]Layer, error) {
 	layers = removeLayer(layers, "application/vnd.ollama.image.template")
 	if _, err := template.Parse(t); err != nil {
 		return nil, fmt.Errorf("%w: %s", errBadTemplate, err)
@@ -538,7 +2295,7 @@ func setTemplate(layers []Layer, t string) ([]Layer, error) {
 	}
 
 	blob := strings.NewReader(t)
-	layer, err := NewLayer(blob, "application/vnd.ollama.image.template")
+	layer, err := newCreateLayer(ctx, blob, "application/vnd.ollama.image.template")
 	if err != nil {
 		return nil, err
 	}
@@ -547,11 +2304,93 @@ func setTemplate(layers []Layer, t string) ([]Layer, error) {
 	return layers, nil
 }
 
-func setSystem(layers []Layer, s string) ([]Layer, error) {
+// warn sends a warning about the model being created. Warnings are collected
+// for the summary sent once the model is created.
+func warn(fn func(resp api.ProgressResponse), warning string) {
+	fn(api.ProgressResponse{Status: "warning: " + warning, Warning: warning})
+}
+
+// contextWarning returns a warning if the num_ctx parameter is longer than
+// the context the model in baseLayers was trained with.
+func contextWarning(baseLayers []*layerGGML, p map[string]any) string {
+	var numCtx uint64
+	switch v := p["num_ctx"].(type) {
+	case int:
+		numCtx = uint64(max(v, 0))
+	case float64:
+		numCtx = uint64(max(v, 0))
+	default:
+		return ""
+	}
+
+	for _, layer := range baseLayers {
+		if layer.GGML != nil && layer.MediaType == "application/vnd.ollama.image.model" {
+			if n := layer.KV().ContextLength(); n > 0 && numCtx > n {
+				return fmt.Sprintf("num_ctx %d is longer than the context of %d the model was trained with", numCtx, n)
+			}
+			break
+		}
+	}
+
+	return ""
+}
+
+// toolTemplateWarning checks that the template of a model which supports
+// tools renders tool calls as JSON which parseToolCalls can read back from
+// the model's output, and that it renders tool results. It returns a warning
+// if it doesn't since the model's tool calls would never be parsed.
+func toolTemplateWarning(layers []Layer) (string, error) {
+	i := slices.IndexFunc(layers, func(l Layer) bool {
+		return l.MediaType == "application/vnd.ollama.image.template"
+	})
+	if i < 0 {
+		return "", nil
+	}
+
+	f, err := layers[i].Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.Parse(string(b))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errBadTemplate, err)
+	}
+
+	if !slices.Contains(tmpl.Vars(), "tools") {
+		return "", nil
+	}
+
+	if _, _, ok := toolCallFormat(tmpl); !ok {
+		return "the template uses tools but doesn't render tool calls as JSON with a name and arguments, so the model's tool calls won't be parsed", nil
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, template.Values{
+		Messages: []api.Message{
+			{Role: "user", Content: "hi"},
+			{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "@@name@@", Arguments: api.ToolCallFunctionArguments{}}}}},
+			{Role: "tool", Content: "@@result@@"},
+		},
+		Tools: api.Tools{{Type: "function", Function: api.ToolFunction{Name: "@@name@@"}}},
+	}); err != nil || !strings.Contains(sb.String(), "@@result@@") {
+		return "the template uses tools but doesn't render tool results, so the model won't see them", nil
+	}
+
+	return "", nil
+}
+
+func setSystem(ctx context.Context, layers []Layer, s string) ([]Layer, error) {
 	layers = removeLayer(layers, "application/vnd.ollama.image.system")
 	if s != "" {
 		blob := strings.NewReader(s)
-		layer, err := NewLayer(blob, "application/vnd.ollama.image.system")
+		layer, err := newCreateLayer(ctx, blob, "application/vnd.ollama.image.system")
 		if err != nil {
 			return nil, err
 		}
@@ -560,17 +2399,73 @@ func setSystem(layers []Layer, s string) ([]Layer, error) {
 	return layers, nil
 }
 
-func setLicense(layers []Layer, l string) ([]Layer, error) {
+func setLicense(ctx context.Context, layers []Layer, l string) ([]Layer, error) {
 	blob := strings.NewReader(l)
-	layer, err := NewLayer(blob, "application/vnd.ollama.image.license")
+	layer, err := newCreateLayer(ctx, blob, "application/vnd.ollama.image.license")
 	if err != nil {
 		return nil, err
 	}
+
+	// identical licenses share a single layer
+	if slices.ContainsFunc(layers, func(existing Layer) bool {
+		return existing.MediaType == layer.MediaType && existing.Digest == layer.Digest
+	}) {
+		return layers, nil
+	}
+
 	layers = append(layers, layer)
 	return layers, nil
 }
 
-func setParameters(layers []Layer, p map[string]any) ([]Layer, error) {
+// setDefaultLicense adds the license in the file OLLAMA_DEFAULT_LICENSE names
+// to a model whose create didn't set a license. Like any other license, it
+// isn't added again if the model inherited the same license.
+func setDefaultLicense(ctx context.Context, layers []Layer) ([]Layer, error) {
+	p := envconfig.DefaultLicense()
+	if p == "" {
+		return layers, nil
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("reading the default license: %w", err)
+	}
+
+	if len(bytes.TrimSpace(b)) == 0 {
+		return layers, nil
+	}
+
+	return setLicense(ctx, layers, string(b))
+}
+
+// resolveParameterAliases renames the parameters in p which have deprecated
+// names to their current names and returns a warning for each. A parameter
+// set under both names keeps the value of its current name. p itself isn't
+// changed, since it's the caller's request.
+func resolveParameterAliases(p map[string]any) (map[string]any, []string) {
+	p = maps.Clone(p)
+	var warnings []string
+	for _, alias := range slices.Sorted(maps.Keys(api.ParameterAliases)) {
+		v, ok := p[alias]
+		if !ok {
+			continue
+		}
+
+		name := api.ParameterAliases[alias]
+		delete(p, alias)
+		if _, ok := p[name]; ok {
+			warnings = append(warnings, fmt.Sprintf("parameter %s is deprecated and ignored since %s is set", alias, name))
+			continue
+		}
+
+		p[name] = v
+		warnings = append(warnings, fmt.Sprintf("parameter %s is deprecated, use %s instead", alias, name))
+	}
+
+	return p, warnings
+}
+
+func setParameters(ctx context.Context, layers []Layer, p map[string]any) ([]Layer, error) {
 	if p == nil {
 		p = make(map[string]any)
 	}
@@ -579,21 +2474,10 @@ func setParameters(layers []Layer, p map[string]any) ([]Layer, error) {
 			continue
 		}
 
-		digestPath, err := GetBlobsPath(layer.Digest)
-		if err != nil {
-			return nil, err
-		}
-
-		fn, err := os.Open(digestPath)
+		existing, err := readParameters(layer)
 		if err != nil {
 			return nil, err
 		}
-		defer fn.Close()
-
-		var existing map[string]any
-		if err := json.NewDecoder(fn).Decode(&existing); err != nil {
-			return nil, err
-		}
 
 		for k, v := range existing {
 			if _, exists := p[k]; exists {
@@ -607,13 +2491,41 @@ func setParameters(layers []Layer, p map[string]any) ([]Layer, error) {
 		return layers, nil
 	}
 
-	layers = removeLayer(layers, "application/vnd.ollama.image.params")
+	if v, ok := p["keep_alive"]; ok {
+		if _, err := parseKeepAlive(v); err != nil {
+			return nil, badRequestError{fmt.Errorf("invalid keep_alive parameter: %w", err)}
+		}
+	}
 
-	var b bytes.Buffer
-	if err := json.NewEncoder(&b).Encode(p); err != nil {
-		return nil, err
+	if v, ok := p["think"]; ok {
+		think, err := parseThink(v)
+		if err != nil {
+			return nil, badRequestError{fmt.Errorf("invalid think parameter: %w", err)}
+		}
+
+		// stored as a boolean however it was written
+		p["think"] = think
+	}
+
+	if v, ok := p["backends"]; ok {
+		if _, err := backendParameters(v); err != nil {
+			return nil, badRequestError{fmt.Errorf("invalid backends parameter: %w", err)}
+		}
+	}
+
+	if v, ok := p["capability_num_ctx"]; ok {
+		if _, err := capabilityContexts(v); err != nil {
+			return nil, badRequestError{fmt.Errorf("invalid capability_num_ctx parameter: %w", err)}
+		}
+	}
+
+	if err := checkContextParameters(p); err != nil {
+		return nil, badRequestError{err}
 	}
-	layer, err := NewLayer(&b, "application/vnd.ollama.image.params")
+
+	layers = removeLayer(layers, "application/vnd.ollama.image.params")
+
+	layer, err := newCanonicalJSONLayer(ctx, p, "application/vnd.ollama.image.params")
 	if err != nil {
 		return nil, err
 	}
@@ -621,39 +2533,278 @@ func setParameters(layers []Layer, p map[string]any) ([]Layer, error) {
 	return layers, nil
 }
 
-func setMessages(layers []Layer, m []api.Message) ([]Layer, error) {
+// checkContextParameters checks the parameters which are limited by num_ctx,
+// including those inherited from the model being created from, so a model
+// whose parameters can't be used together fails to create rather than load.
+// num_keep of -1, which keeps the whole context, isn't limited.
+func checkContextParameters(p map[string]any) error {
+	numCtx, ok := intParameter(p["num_ctx"])
+	if !ok || numCtx <= 0 {
+		return nil
+	}
+
+	if n, ok := intParameter(p["num_keep"]); ok && n >= numCtx {
+		return fmt.Errorf("num_keep %d must be less than num_ctx %d", n, numCtx)
+	}
+
+	if n, ok := intParameter(p["num_batch"]); ok && n > numCtx {
+		return fmt.Errorf("num_batch %d must not be greater than num_ctx %d", n, numCtx)
+	}
+
+	return nil
+}
+
+// intParameter returns the value of a numeric parameter as an int. It's
+// false for parameters which aren't set or aren't whole numbers.
+func intParameter(v any) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case float64:
+		if float64(int(v)) == v {
+			return int(v), true
+		}
+	}
+
+	return 0, false
+}
+
+// newJSONLayer creates a layer from the JSON encoding of v. The encoding is
+// streamed into the layer so large values aren't buffered in memory.
+func newJSONLayer(ctx context.Context, v any, mediatype string) (Layer, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(json.NewEncoder(pw).Encode(v))
+	}()
+
+	layer, err := newCreateLayer(ctx, pr, mediatype)
+	// unblock the encoder if NewLayer returned before reading everything
+	pr.CloseWithError(err)
+	return layer, err
+}
+
+func readParameters(layer Layer) (map[string]any, error) {
+	var params map[string]any
+	if err := readJSONLayer(layer, &params); err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+// readJSONLayer decodes the JSON blob of layer into v.
+func readJSONLayer(layer Layer, v any) error {
+	f, err := layer.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewDecoder(f).Decode(v)
+}
+
+// setDefaultContext sets num_ctx in p to the context length the model was
+// trained with, capped at envconfig.MaxDefaultContext, unless num_ctx is
+// already set by p or an existing params layer.
+func setDefaultContext(layers []Layer, baseLayers []*layerGGML, p map[string]any) (map[string]any, error) {
+	if _, ok := p["num_ctx"]; ok {
+		return p, nil
+	}
+
+	for _, layer := range layers {
+		if layer.MediaType == "application/vnd.ollama.image.params" {
+			existing, err := readParameters(layer)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := existing["num_ctx"]; ok {
+				return p, nil
+			}
+		}
+	}
+
+	for _, layer := range baseLayers {
+		if layer.GGML != nil && layer.MediaType == "application/vnd.ollama.image.model" {
+			if n := layer.KV().ContextLength(); n > 0 {
+				if p == nil {
+					p = make(map[string]any)
+				}
+
+				p["num_ctx"] = min(n, uint64(envconfig.MaxDefaultContext()))
+			}
+			break
+		}
+	}
+
+	return p, nil
+}
+
+// setKVOverrides adds overrides of the model's metadata to any the model
+// already overrides. Overrides are validated against the metadata of the
+// model in baseLayers.
+func setKVOverrides(ctx context.Context, layers []Layer, baseLayers []*layerGGML, overrides map[string]any) ([]Layer, error) {
+	i := slices.IndexFunc(baseLayers, func(l *layerGGML) bool {
+		return l.GGML != nil && l.MediaType == "application/vnd.ollama.image.model"
+	})
+	if i < 0 {
+		return nil, badRequestError{errors.New("kv_overrides requires a model")}
+	}
+
+	kv := baseLayers[i].KV()
+	for k, v := range overrides {
+		if _, err := kv.Override(k, v); err != nil {
+			return nil, badRequestError{fmt.Errorf("invalid kv_overrides: %w", err)}
+		}
+	}
+
+	merged := make(map[string]any)
+	for _, layer := range layers {
+		if layer.MediaType != "application/vnd.ollama.image.overrides" {
+			continue
+		}
+
+		if err := readJSONLayer(layer, &merged); err != nil {
+			return nil, err
+		}
+	}
+	maps.Copy(merged, overrides)
+
+	layers = removeLayer(layers, "application/vnd.ollama.image.overrides")
+	layer, err := newJSONLayer(ctx, merged, "application/vnd.ollama.image.overrides")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(layers, layer), nil
+}
+
+// setMessages replaces the messages the model's conversations start with.
+// Roles which are out of order are returned as warnings, or rejected if
+// strict is set.
+func setMessages(ctx context.Context, layers []Layer, m []api.Message, strict bool) ([]Layer, []string, error) {
 	// this leaves the old messages intact if no new messages were specified
 	// which may not be the correct behaviour
 	if len(m) == 0 {
-		return layers, nil
+		return layers, nil, nil
+	}
+
+	problems := checkMessageRoles(m)
+	if len(problems) > 0 && strict {
+		return nil, nil, badRequestError{fmt.Errorf("%w: %s", errMessageRoles, strings.Join(problems, "; "))}
 	}
 
 	fmt.Printf("removing old messages\n")
 	layers = removeLayer(layers, "application/vnd.ollama.image.messages")
-	var b bytes.Buffer
-	if err := json.NewEncoder(&b).Encode(m); err != nil {
+	layer, err := newCanonicalJSONLayer(ctx, m, "application/vnd.ollama.image.messages")
+	if err != nil {
+		return nil, nil, err
+	}
+	layers = append(layers, layer)
+	return layers, problems, nil
+}
+
+// checkMessageRoles describes the messages whose roles don't follow the
+// order chat templates assume: system messages come first, users and the
+// assistant take turns, and tool results follow the assistant's tool calls.
+func checkMessageRoles(m []api.Message) []string {
+	var problems []string
+	for i, msg := range m {
+		var prev string
+		if i > 0 {
+			prev = m[i-1].Role
+		}
+
+		switch msg.Role {
+		case "system":
+			if i > 0 && prev != "system" {
+				problems = append(problems, fmt.Sprintf("message %d is a system message after the conversation started", i+1))
+			}
+		case "user", "assistant":
+			if prev == msg.Role {
+				problems = append(problems, fmt.Sprintf("messages %d and %d are both %s messages", i, i+1, msg.Role))
+			}
+		case "tool":
+			if prev != "assistant" && prev != "tool" {
+				problems = append(problems, fmt.Sprintf("message %d is a tool result which doesn't follow an assistant message", i+1))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("message %d has unknown role %q", i+1, msg.Role))
+		}
+	}
+
+	return problems
+}
+
+// setSafety replaces the model's safety classification. Models created from
+// another model keep its classification unless a new one is set.
+func setSafety(ctx context.Context, layers []Layer, s api.SafetyClassification) ([]Layer, error) {
+	if s.ContentRating == "" && s.IntendedUse == "" {
+		return nil, badRequestError{errors.New("safety must set content_rating or intended_use")}
+	}
+
+	layers = removeLayer(layers, "application/vnd.ollama.image.safety")
+	layer, err := newJSONLayer(ctx, s, "application/vnd.ollama.image.safety")
+	if err != nil {
 		return nil, err
 	}
-	layer, err := NewLayer(&b, "application/vnd.ollama.image.messages")
+
+	return append(layers, layer), nil
+}
+
+// setCard replaces the model's card. Like the safety classification, models
+// created from another model keep its card unless a new one is set.
+func setCard(ctx context.Context, layers []Layer, card api.ModelCard) ([]Layer, error) {
+	if card.Description == "" && len(card.Examples) == 0 && len(card.Tags) == 0 {
+		return nil, badRequestError{errors.New("card must set description, examples or tags")}
+	}
+
+	for i, example := range card.Examples {
+		if strings.TrimSpace(example) == "" {
+			return nil, badRequestError{fmt.Errorf("card example %d is empty", i+1)}
+		}
+	}
+
+	for i, tag := range card.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return nil, badRequestError{fmt.Errorf("card tag %d is empty", i+1)}
+		}
+
+		if slices.Contains(card.Tags[:i], tag) {
+			return nil, badRequestError{fmt.Errorf("card tag %q is repeated", tag)}
+		}
+	}
+
+	layers = removeLayer(layers, "application/vnd.ollama.image.card")
+	layer, err := newJSONLayer(ctx, card, "application/vnd.ollama.image.card")
 	if err != nil {
 		return nil, err
 	}
-	layers = append(layers, layer)
-	return layers, nil
+
+	return append(layers, layer), nil
 }
 
-func createConfigLayer(layers []Layer, config ConfigV2) (*Layer, error) {
-	digests := make([]string, len(layers))
-	for i, layer := range layers {
+// createConfigLayer writes the config of a model with layers. The config is
+// addressed by d.
+func createConfigLayer(layers []Layer, config ConfigV2, d digester) (*Layer, error) {
+	// sort a copy of the layers so the config digest doesn't depend on the
+	// order in which the layers were added
+	sorted := slices.Clone(layers)
+	slices.SortStableFunc(sorted, func(a, b Layer) int {
+		return cmp.Or(cmp.Compare(a.MediaType, b.MediaType), cmp.Compare(a.Digest, b.Digest))
+	})
+
+	digests := make([]string, len(sorted))
+	for i, layer := range sorted {
 		digests[i] = layer.Digest
 	}
 	config.RootFS.DiffIDs = digests
 
-	var b bytes.Buffer
-	if err := json.NewEncoder(&b).Encode(config); err != nil {
+	b, err := canonicalJSON(config)
+	if err != nil {
 		return nil, err
 	}
-	layer, err := NewLayer(&b, "application/vnd.docker.container.image.v1+json")
+	layer, err := newLayer(bytes.NewReader(b), "application/vnd.docker.container.image.v1+json", d)
 	if err != nil {
 		return nil, err
 	}
@@ -661,6 +2812,8 @@ func createConfigLayer(layers []Layer, config ConfigV2) (*Layer, error) {
 }
 
 func createLink(src, dst string) error {
+	src, dst = longPath(src), longPath(dst)
+
 	// make any subdirs for dst
 	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
 		return err
@@ -675,19 +2828,34 @@ func createLink(src, dst string) error {
 	return nil
 }
 
+// copyFile copies src to dst. The copy is written to a temp file next to dst
+// and renamed to it once it's complete, so dst is never partly written.
 func copyFile(src, dst string) error {
-	srcFile, err := os.Open(src)
+	srcFile, err := os.Open(longPath(src))
 	if err != nil {
 		return err
 	}
 	defer srcFile.Close()
 
-	dstFile, err := os.Create(dst)
+	dstFile, err := os.CreateTemp(filepath.Dir(longPath(dst)), filepath.Base(dst)+"-")
 	if err != nil {
 		return err
 	}
 	defer dstFile.Close()
+	defer os.Remove(dstFile.Name())
+
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		return err
+	}
+
+	// temp files are only readable by their owner
+	if err := dstFile.Chmod(0o644); err != nil {
+		return err
+	}
+
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
 
-	_, err = io.Copy(dstFile, srcFile)
-	return err
+	return os.Rename(dstFile.Name(), longPath(dst))
 }
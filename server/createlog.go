@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/app/lifecycle"
+	"github.com/ollama/ollama/types/model"
+)
+
+// createLogDir returns the directory of the create logs, next to the
+// server's log.
+func createLogDir() string {
+	return filepath.Join(filepath.Dir(lifecycle.ResolvedPaths().ServerLogFile), "creates")
+}
+
+// createLogTime is the layout of the times in the names of create logs. The
+// names sort in the order the creates started.
+const createLogTime = "20060102T150405.000000000"
+
+// createLog records the progress of a create, one JSON response per line.
+type createLog struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+// openCreateLog opens a new log for a create of name, named by the model and
+// the time it started. Only the newest logs of each model are kept: the new
+// log and [lifecycle.LogRotationCount] older ones.
+func openCreateLog(name model.Name) (*createLog, error) {
+	dir := createLogDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	prefix := strings.NewReplacer("/", "_", ":", "_").Replace(name.DisplayShortest()) + "-"
+	f, err := os.OpenFile(filepath.Join(dir, prefix+time.Now().UTC().Format(createLogTime)+".log"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rotateCreateLogs(dir, prefix); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &createLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// rotateCreateLogs removes the oldest logs in dir of the model whose logs
+// are named with prefix.
+func rotateCreateLogs(dir, prefix string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var logs []string
+	for _, e := range entries {
+		// the rest of the name must be the time so the logs of a model whose
+		// name only starts with the same prefix don't match
+		rest, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+
+		if _, err := time.Parse(createLogTime+".log", rest); err == nil {
+			logs = append(logs, e.Name())
+		}
+	}
+
+	slices.Sort(logs)
+	for len(logs) > lifecycle.LogRotationCount+1 {
+		if err := os.Remove(filepath.Join(dir, logs[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		logs = logs[1:]
+	}
+
+	return nil
+}
+
+func (l *createLog) write(resp api.ProgressResponse) error {
+	return l.enc.Encode(resp)
+}
+
+func (l *createLog) Close() error {
+	return l.f.Close()
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// createTempDir is where creates write their intermediate files, such as the
+// GGUF a safetensors model is converted to. It's in the models directory so
+// intermediates are on the same file system as the blobs made from them.
+func createTempDir() string {
+	return filepath.Join(envconfig.Models(), "tmp")
+}
+
+type createTempKey struct{}
+
+// withCreateTemp makes the temp directory of a create, named "create-*" in
+// [createTempDir], and returns ctx carrying it. Calling the returned func
+// removes the directory and everything in it, whether the create succeeded
+// or not.
+func withCreateTemp(ctx context.Context) (context.Context, func(), error) {
+	if err := os.MkdirAll(createTempDir(), 0o755); err != nil {
+		return nil, nil, err
+	}
+
+	dir, err := os.MkdirTemp(createTempDir(), "create-")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return context.WithValue(ctx, createTempKey{}, dir), func() { os.RemoveAll(dir) }, nil
+}
+
+// mkdirCreateTemp makes a temp directory in the temp directory of the create
+// ctx is for, or in [createTempDir] if there isn't one. The caller should
+// still remove it once it's done with it.
+func mkdirCreateTemp(ctx context.Context, pattern string) (string, error) {
+	dir, ok := ctx.Value(createTempKey{}).(string)
+	if !ok {
+		dir = createTempDir()
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return "", err
+		}
+	}
+
+	return os.MkdirTemp(dir, pattern)
+}
+
+// removeCreateTemps removes the intermediates of creates which didn't finish,
+// e.g. because the server was stopped. It's only called before the server
+// starts handling requests.
+func removeCreateTemps() error {
+	return os.RemoveAll(createTempDir())
+}
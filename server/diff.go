@@ -0,0 +1,175 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+func (s *Server) DiffHandler(c *gin.Context) {
+	var req api.DiffRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, n := range []string{req.Base, req.Model} {
+		if !model.ParseName(n).IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %q", errtypes.InvalidModelNameErrMsg, n)})
+			return
+		}
+	}
+
+	resp, err := diffModels(req.Base, req.Model)
+	var notFound modelNotFoundError
+	if errors.As(err, &notFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+type modelNotFoundError string
+
+func (e modelNotFoundError) Error() string {
+	return fmt.Sprintf("model '%s' not found", string(e))
+}
+
+// diffModels compares the model named m with the model named base.
+func diffModels(base, m string) (*api.DiffResponse, error) {
+	a, am, err := diffModel(base)
+	if err != nil {
+		return nil, err
+	}
+
+	b, bm, err := diffModel(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp api.DiffResponse
+	for _, l := range am.Layers {
+		if !slices.ContainsFunc(bm.Layers, l.sameAs) {
+			resp.Layers = append(resp.Layers, api.LayerDiff{MediaType: l.MediaType, Digest: l.Digest, Change: "removed"})
+		}
+	}
+
+	for _, l := range bm.Layers {
+		if !slices.ContainsFunc(am.Layers, l.sameAs) {
+			resp.Layers = append(resp.Layers, api.LayerDiff{MediaType: l.MediaType, Digest: l.Digest, Change: "added"})
+		}
+	}
+
+	resp.Template = diffLines(a.Template.String(), b.Template.String())
+	resp.System = diffLines(a.System, b.System)
+	resp.Parameters = diffLines(formatParameters(a.Options), formatParameters(b.Options))
+	return &resp, nil
+}
+
+func diffModel(name string) (*Model, *Manifest, error) {
+	mf, err := ParseNamedManifest(model.ParseName(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, modelNotFoundError(name)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	m, err := GetModel(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return m, mf, nil
+}
+
+func (l Layer) sameAs(other Layer) bool {
+	return l.MediaType == other.MediaType && l.Digest == other.Digest
+}
+
+// formatParameters formats parameters one per line, sorted by name, with a
+// line for each value of list parameters such as stop.
+func formatParameters(p map[string]any) string {
+	var lines []string
+	for k, v := range p {
+		if vs, ok := v.([]any); ok {
+			for _, v := range vs {
+				lines = append(lines, fmt.Sprintf("%s %v", k, v))
+			}
+		} else {
+			lines = append(lines, fmt.Sprintf("%s %v", k, v))
+		}
+	}
+
+	slices.Sort(lines)
+	return strings.Join(lines, "\n")
+}
+
+// diffLines returns a line diff of a and b using their longest common
+// subsequence of lines, or an empty string if they're equal.
+func diffLines(a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	as, bs := splitLines(a), splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of as[i:] and bs[j:]
+	lcs := make([][]int, len(as)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bs)+1)
+	}
+
+	for i := len(as) - 1; i >= 0; i-- {
+		for j := len(bs) - 1; j >= 0; j-- {
+			if as[i] == bs[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(as) || j < len(bs) {
+		switch {
+		case i < len(as) && j < len(bs) && as[i] == bs[j]:
+			fmt.Fprintln(&sb, " "+as[i])
+			i++
+			j++
+		case i < len(as) && (j == len(bs) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintln(&sb, "-"+as[i])
+			i++
+		default:
+			fmt.Fprintln(&sb, "+"+bs[j])
+			j++
+		}
+	}
+
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestDiffLines(t *testing.T) {
+	cases := []struct {
+		a, b, expect string
+	}{
+		{"", "", ""},
+		{"a\nb", "a\nb", ""},
+		{"", "a", "+a\n"},
+		{"a", "", "-a\n"},
+		{"a\nb\nc", "a\nx\nc", " a\n-b\n+x\n c\n"},
+		{"a\nb", "a\nb\nc\n", " a\n b\n+c\n"},
+		{"a\nb\nc", "b\nc\nd", "-a\n b\n c\n+d\n"},
+	}
+
+	for _, tt := range cases {
+		if actual := diffLines(tt.a, tt.b); actual != tt.expect {
+			t.Errorf("diffLines(%q, %q): expected %q, actual %q", tt.a, tt.b, tt.expect, actual)
+		}
+	}
+}
+
+func TestDiffHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:      "base",
+		Files:      map[string]string{"test.gguf": digest},
+		Template:   "{{ .System }}\n{{ .Prompt }}",
+		Parameters: map[string]any{"temperature": 0.5, "stop": []string{"<|end|>"}},
+		Stream:     &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:      "derived",
+		From:       "base",
+		System:     "Say hi!",
+		Parameters: map[string]any{"temperature": 0.8},
+		Stream:     &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	diff := func(t *testing.T, req api.DiffRequest) *httptest.ResponseRecorder {
+		t.Helper()
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(req); err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/diff", &b)
+		s.DiffHandler(c)
+		return w
+	}
+
+	t.Run("derived", func(t *testing.T) {
+		w := diff(t, api.DiffRequest{Base: "base", Model: "derived"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.DiffResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		var changes []string
+		for _, l := range resp.Layers {
+			changes = append(changes, l.Change+" "+l.MediaType)
+		}
+
+		expect := []string{
+			"removed application/vnd.ollama.image.params",
+			"added application/vnd.ollama.image.system",
+			"added application/vnd.ollama.image.params",
+		}
+
+		if !slices.Equal(changes, expect) {
+			t.Errorf("expected layer changes %v, actual %v", expect, changes)
+		}
+
+		if resp.Template != "" {
+			t.Errorf("expected no template diff, actual %q", resp.Template)
+		}
+
+		if resp.System != "+Say hi!\n" {
+			t.Errorf("unexpected system diff %q", resp.System)
+		}
+
+		if expect := " stop <|end|>\n-temperature 0.5\n+temperature 0.8\n"; resp.Parameters != expect {
+			t.Errorf("expected parameters diff %q, actual %q", expect, resp.Parameters)
+		}
+	})
+
+	t.Run("same", func(t *testing.T) {
+		w := diff(t, api.DiffRequest{Base: "base", Model: "base"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		if body := w.Body.String(); body != "{}" {
+			t.Errorf("expected no differences, actual %s", body)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		w := diff(t, api.DiffRequest{Base: "base", Model: "missing"})
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected status code 404, actual %d", w.Code)
+		}
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		w := diff(t, api.DiffRequest{Base: "base"})
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+	})
+}
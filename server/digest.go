@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// digester hashes the content of blobs for their digests. Digests are the
+// digester's name and the hex encoded hash, e.g. "sha256:<hash>".
+type digester interface {
+	Name() string
+	New() hash.Hash
+}
+
+type hashDigester struct {
+	name string
+	new  func() hash.Hash
+}
+
+func (d hashDigester) Name() string   { return d.name }
+func (d hashDigester) New() hash.Hash { return d.new() }
+
+var (
+	sha256Digester digester = hashDigester{"sha256", sha256.New}
+	sha512Digester digester = hashDigester{"sha512", sha512.New}
+)
+
+// digesters are the digest algorithms blobs can be addressed with. sha256 is
+// the default.
+var digesters = []digester{sha256Digester, sha512Digester}
+
+var errUnknownDigestAlgorithm = errors.New("unknown digest algorithm")
+
+// parseDigester returns the digester named name, or the default if name is
+// empty.
+func parseDigester(name string) (digester, error) {
+	if name == "" {
+		return sha256Digester, nil
+	}
+
+	i := slices.IndexFunc(digesters, func(d digester) bool { return d.Name() == name })
+	if i < 0 {
+		names := make([]string, len(digesters))
+		for i, d := range digesters {
+			names[i] = d.Name()
+		}
+
+		return nil, fmt.Errorf("%w %q, expected one of %s", errUnknownDigestAlgorithm, name, strings.Join(names, ", "))
+	}
+
+	return digesters[i], nil
+}
+
+// digesterFor returns the digester of digest, which is separated from its
+// hash by ":" or, in blob file names, "-". It fails with
+// [ErrInvalidDigestFormat] if the hash isn't the digester's length.
+func digesterFor(digest string) (digester, error) {
+	name, sum, ok := strings.Cut(strings.Replace(digest, "-", ":", 1), ":")
+	if !ok {
+		return nil, ErrInvalidDigestFormat
+	}
+
+	d, err := parseDigester(name)
+	if name == "" || err != nil {
+		return nil, ErrInvalidDigestFormat
+	}
+
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != d.New().Size() {
+		return nil, ErrInvalidDigestFormat
+	}
+
+	return d, nil
+}
+
+// canonicalDigest returns digest in the form blobs are addressed by, so
+// digests written as sha256-<hex> or with upper case hex compare equal to
+// sha256:<hex>.
+func canonicalDigest(digest string) string {
+	return strings.ToLower(strings.Replace(digest, "-", ":", 1))
+}
+
+func formatDigest(d digester, h hash.Hash) string {
+	return fmt.Sprintf("%s:%x", d.Name(), h.Sum(nil))
+}
+
+type digesterKey struct{}
+
+// withDigester returns ctx carrying d, the digester the blobs written by a
+// create are addressed by.
+func withDigester(ctx context.Context, d digester) context.Context {
+	return context.WithValue(ctx, digesterKey{}, d)
+}
+
+// digesterFrom returns the digester of the create ctx is for, or sha256 if
+// it hasn't one.
+func digesterFrom(ctx context.Context) digester {
+	if d, ok := ctx.Value(digesterKey{}).(digester); ok {
+		return d
+	}
+
+	return sha256Digester
+}
+
+// readdressLayers addresses the blobs of layers with d. The blobs a create
+// writes are already addressed with its digester, so these are the blobs it
+// reuses, such as those of the model it's created from, whose digests use
+// another algorithm. They're hashed again and linked, or copied, to the new
+// digest, and the old blobs are kept for whatever else uses them.
+func readdressLayers(layers []Layer, d digester) ([]Layer, error) {
+	layers = slices.Clone(layers)
+	for i, layer := range layers {
+		if strings.HasPrefix(layer.Digest, d.Name()+":") {
+			continue
+		}
+
+		src, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+
+		h := d.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		digest := formatDigest(d, h)
+		dst, err := GetBlobsPath(digest)
+		if err != nil {
+			return nil, err
+		}
+
+		pinBlob(digest)
+		status := "using existing layer"
+		if _, err := os.Stat(dst); errors.Is(err, os.ErrNotExist) {
+			status = "creating new layer"
+			if err := os.Link(src, dst); err != nil {
+				if err := copyFile(src, dst); err != nil {
+					return nil, err
+				}
+			}
+			blobCreated(digest)
+		} else if err != nil {
+			return nil, err
+		}
+
+		layers[i].Digest = digest
+		layers[i].status = fmt.Sprintf("%s %s", status, digest)
+		layers[i].created = status == "creating new layer"
+	}
+
+	return layers, nil
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// digestCacheMu serializes reads and writes of the digest cache file
+var digestCacheMu sync.Mutex
+
+// digestCacheEntry is the digest of a file as it was when it was hashed. The
+// entry is stale once the file's size or modification time changes.
+type digestCacheEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Digest  string `json:"digest"`
+}
+
+func digestCachePath() string {
+	return filepath.Join(envconfig.Models(), "digests.json")
+}
+
+func readDigestCache() map[string]digestCacheEntry {
+	entries := make(map[string]digestCacheEntry)
+
+	b, err := os.ReadFile(digestCachePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return entries
+	} else if err != nil {
+		slog.Warn("couldn't read digest cache", "error", err)
+		return entries
+	}
+
+	if err := json.Unmarshal(b, &entries); err != nil {
+		slog.Warn("ignoring invalid digest cache", "error", err)
+		return make(map[string]digestCacheEntry)
+	}
+
+	return entries
+}
+
+func writeDigestCache(entries map[string]digestCacheEntry) error {
+	// drop entries for files which no longer exist
+	for p := range entries {
+		if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+			delete(entries, p)
+		}
+	}
+
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	p := digestCachePath()
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), "digests-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), p)
+}
+
+func digestCacheEntryFor(fi fs.FileInfo) digestCacheEntry {
+	return digestCacheEntry{Size: fi.Size(), ModTime: fi.ModTime().UnixNano()}
+}
+
+// hashFile returns the digest of the content of f using d. Unlike
+// [fileDigest] it always reads f, so it's what checks a blob's content.
+func hashFile(f io.Reader, d digester) (string, error) {
+	h := d.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return formatDigest(d, h), nil
+}
+
+// fileDigest returns the digest of the file at p using d. Digests are cached
+// on disk so a file isn't hashed again until its size or modification time
+// changes.
+func fileDigest(p string, d digester) (string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	want := digestCacheEntryFor(fi)
+
+	digestCacheMu.Lock()
+	entry, ok := readDigestCache()[p]
+	digestCacheMu.Unlock()
+
+	if ok && entry.Size == want.Size && entry.ModTime == want.ModTime && strings.HasPrefix(entry.Digest, d.Name()+":") {
+		return entry.Digest, nil
+	}
+
+	want.Digest, err = hashFile(f, d)
+	if err != nil {
+		return "", err
+	}
+
+	digestCacheMu.Lock()
+	defer digestCacheMu.Unlock()
+
+	entries := readDigestCache()
+	entries[p] = want
+	if err := writeDigestCache(entries); err != nil {
+		slog.Warn("couldn't write digest cache", "error", err)
+	}
+
+	return want.Digest, nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileDigest(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	p := filepath.Join(t.TempDir(), "blob")
+	if err := os.WriteFile(p, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := GetSHA256Digest(bytes.NewReader([]byte("hello")))
+	got, err := fileDigest(p, sha256Digester)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != want {
+		t.Fatalf("expected digest %s, actual %s", want, got)
+	}
+
+	entries := readDigestCache()
+	entry, ok := entries[p]
+	if !ok || entry.Digest != want {
+		t.Fatalf("expected %s to be cached, actual %v", p, entries)
+	}
+
+	// an unchanged file is read from the cache rather than hashed
+	entry.Digest = "sha256:cached"
+	entries[p] = entry
+	if err := writeDigestCache(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := fileDigest(p, sha256Digester); err != nil {
+		t.Fatal(err)
+	} else if got != "sha256:cached" {
+		t.Errorf("expected the cached digest, actual %s", got)
+	}
+
+	// changing the file invalidates its entry
+	if err := os.WriteFile(p, []byte("hello, world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ = GetSHA256Digest(bytes.NewReader([]byte("hello, world")))
+	if got, err := fileDigest(p, sha256Digester); err != nil {
+		t.Fatal(err)
+	} else if got != want {
+		t.Errorf("expected digest %s, actual %s", want, got)
+	}
+
+	// so does touching it without changing its size
+	entries = readDigestCache()
+	entry = entries[p]
+	entry.Digest = "sha256:stale"
+	entries[p] = entry
+	if err := writeDigestCache(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(p, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := fileDigest(p, sha256Digester); err != nil {
+		t.Fatal(err)
+	} else if got != want {
+		t.Errorf("expected digest %s, actual %s", want, got)
+	}
+
+	// entries for removed files are dropped
+	if err := os.Remove(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeDigestCache(readDigestCache()); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries := readDigestCache(); len(entries) != 0 {
+		t.Errorf("expected no entries, actual %v", entries)
+	}
+}
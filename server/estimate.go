@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
+)
+
+// memoryWarning estimates the memory the model named name needs to run with
+// a context of numCtx, or its num_ctx parameter if numCtx is 0. It returns a
+// warning if the model is unlikely to fit in the memory of gpus, and so will
+// run partly or entirely on the CPU. The estimate is the same one the
+// scheduler makes when it loads the model.
+func memoryWarning(name model.Name, numCtx int, gpus discover.GpuInfoList) (string, error) {
+	m, err := GetModel(name.String())
+	if err != nil {
+		return "", err
+	}
+
+	backend := "cpu"
+	if len(gpus) > 0 {
+		backend = gpus[0].Library
+	}
+
+	opts, err := modelOptions(m, backend, nil, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if numCtx > 0 {
+		opts.NumCtx = numCtx
+	}
+
+	// the model is meant to run on the CPU
+	if opts.NumGPU == 0 {
+		return "", nil
+	}
+
+	ggml, err := llm.LoadModel(m.ModelPath, 0)
+	if err != nil {
+		return "", err
+	}
+
+	var best llm.MemoryEstimate
+	var free uint64
+	layers := int(ggml.KV().BlockCount()) + 1
+	for _, gpus := range gpus.ByLibrary() {
+		if gpus[0].Library == "cpu" {
+			continue
+		}
+
+		estimate := llm.EstimateGPULayers(gpus, ggml, m.ProjectorPaths, opts)
+		if estimate.Layers >= layers {
+			return "", nil
+		}
+
+		if free == 0 || estimate.Layers > best.Layers {
+			best = estimate
+			free = 0
+			for _, gpu := range gpus {
+				free += gpu.FreeMemory
+			}
+		}
+	}
+
+	if free == 0 {
+		return fmt.Sprintf("no GPU was detected so %s will run on the CPU", name.DisplayShortest()), nil
+	}
+
+	offload := fmt.Sprintf("%d of its %d layers will run on the GPU", best.Layers, layers)
+	if best.Layers == 0 {
+		offload = "it will run on the CPU"
+	}
+
+	return fmt.Sprintf("%s needs about %s with a context of %d but %s of GPU memory is free, so %s",
+		name.DisplayShortest(), format.HumanBytes2(best.TotalSize), opts.NumCtx, format.HumanBytes2(free), offload), nil
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+// ExportHandler streams a model as a single GGUF file. The layers which
+// aren't part of the model's GGUF are stored as key values:
+//
+//	ollama.template    the template
+//	ollama.system      the system prompt
+//	ollama.parameters  the parameters, encoded as JSON
+//	ollama.messages    the messages, encoded as JSON
+//	general.license    the licenses
+//
+// KV overrides are applied to the key values they override. Models with
+// adapters or projectors can't be exported since they're separate files. A bundle of only the layers stored as key values
+// can be exported from any model.
+func (s *Server) ExportHandler(c *gin.Context) {
+	var req api.ExportRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return
+	}
+
+	name, err := getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	m, err := GetModel(name.String())
+	if errors.Is(err, os.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Bundle {
+		c.JSON(http.StatusOK, modelBundle(m))
+		return
+	}
+
+	if len(m.AdapterPaths) > 0 || len(m.ProjectorPaths) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "models with adapters or projectors can't be exported as a single GGUF file"})
+		return
+	}
+
+	f, err := os.Open(m.ModelPath)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	kv, err := exportKV(m, f)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, size, err := llm.SetGGUFKV(f, fi.Size(), kv)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	slog.Info("exporting model", "model", name.DisplayShortest(), "size", size)
+	c.DataFromReader(http.StatusOK, size, "application/octet-stream", r, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", name.Model+"-"+name.Tag+".gguf"),
+	})
+}
+
+// exportKV returns the key values which store m's layers in its GGUF file f.
+func exportKV(m *Model, f io.ReadSeeker) (llm.KV, error) {
+	kv := llm.KV{}
+	if m.Template != nil && m.Template.String() != template.DefaultTemplate.String() {
+		kv["ollama.template"] = m.Template.String()
+	}
+
+	if m.System != "" {
+		kv["ollama.system"] = m.System
+	}
+
+	if len(m.Options) > 0 {
+		b, err := json.Marshal(m.Options)
+		if err != nil {
+			return nil, err
+		}
+		kv["ollama.parameters"] = string(b)
+	}
+
+	if len(m.Messages) > 0 {
+		b, err := json.Marshal(m.Messages)
+		if err != nil {
+			return nil, err
+		}
+		kv["ollama.messages"] = string(b)
+	}
+
+	if len(m.License) > 0 {
+		kv["general.license"] = strings.Join(m.License, "\n\n")
+	}
+
+	if len(m.KVOverrides) > 0 {
+		ggml, _, err := llm.DecodeGGML(f, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range m.KVOverrides {
+			v, err := exportOverride(ggml.KV()[k], v)
+			if err != nil {
+				return nil, fmt.Errorf("override %s: %w", k, err)
+			}
+			kv[k] = v
+		}
+	}
+
+	return kv, nil
+}
+
+// exportOverride converts an override, which is decoded from JSON, to the
+// type of the key value it overrides.
+func exportOverride(existing, v any) (any, error) {
+	if existing == nil {
+		return nil, errors.New("model doesn't have the key")
+	}
+
+	e := reflect.ValueOf(existing)
+	out := reflect.New(e.Type()).Elem()
+
+	f, isNumber := v.(float64)
+	switch kind := e.Kind(); {
+	case kind == reflect.Bool || kind == reflect.String:
+		if reflect.TypeOf(v) != e.Type() {
+			return nil, fmt.Errorf("expected a %s, got %T", e.Type(), v)
+		}
+		out.Set(reflect.ValueOf(v))
+	case !isNumber:
+		return nil, fmt.Errorf("expected a %s, got %T", e.Type(), v)
+	case out.CanInt():
+		out.SetInt(int64(f))
+	case out.CanUint():
+		out.SetUint(uint64(f))
+	case out.CanFloat():
+		out.SetFloat(f)
+	default:
+		return nil, fmt.Errorf("can't override a %s", e.Type())
+	}
+
+	return out.Interface(), nil
+}
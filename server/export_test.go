@@ -0,0 +1,264 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestExportHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture": "llama",
+		"llama.context_length": uint32(128),
+	}, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:       "test",
+		Files:       map[string]string{"test.gguf": digest},
+		Template:    "{{ .System }} {{ .Prompt }}",
+		System:      "be brief",
+		License:     []string{"MIT", "Apache"},
+		Parameters:  map[string]any{"temperature": float64(0.5)},
+		Messages:    []api.Message{{Role: "user", Content: "hi"}},
+		KVOverrides: map[string]any{"llama.context_length": float64(2048)},
+		Stream:      &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	export := func(t *testing.T, name string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		b, err := json.Marshal(api.ExportRequest{Model: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/export", bytes.NewReader(b))
+		s.ExportHandler(c)
+		return w
+	}
+
+	w = export(t, "test")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="test-latest.gguf"` {
+		t.Errorf("unexpected Content-Disposition %q", cd)
+	}
+
+	ggml, _, err := llm.DecodeGGML(bytes.NewReader(w.Body.Bytes()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, expect := range map[string]any{
+		"general.architecture": "llama",
+		"llama.context_length": uint32(2048),
+		"ollama.template":      "{{ .System }} {{ .Prompt }}",
+		"ollama.system":        "be brief",
+		"ollama.parameters":    `{"temperature":0.5}`,
+		"ollama.messages":      `[{"role":"user","content":"hi"}]`,
+		"general.license":      "MIT\n\nApache",
+	} {
+		if actual := ggml.KV()[k]; actual != expect {
+			t.Errorf("%s: expected %#v, actual %#v", k, expect, actual)
+		}
+	}
+
+	t.Run("client", func(t *testing.T) {
+		srv := httptest.NewServer(s.GenerateRoutes())
+		defer srv.Close()
+
+		u, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b bytes.Buffer
+		var last api.ProgressResponse
+		if err := api.NewClient(u, srv.Client()).Export(context.Background(), &api.ExportRequest{Model: "test"}, &b, func(resp api.ProgressResponse) error {
+			if resp.Status != "success" {
+				last = resp
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(b.Bytes(), w.Body.Bytes()) {
+			t.Error("expected the client to write the exported model")
+		}
+
+		if last.Total != int64(b.Len()) || last.Completed != last.Total {
+			t.Errorf("expected progress to complete at %d bytes, actual %+v", b.Len(), last)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if w := export(t, "missing"); w.Code != http.StatusNotFound {
+			t.Errorf("expected status code 404, actual %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("adapter", func(t *testing.T) {
+		_, adapter := createBinFile(t, llm.KV{
+			"general.architecture": "llama",
+			"general.type":         "adapter",
+		}, nil)
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:    "adapted",
+			From:     "test",
+			Adapters: map[string]string{"adapter.gguf": adapter},
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		if w := export(t, "adapted"); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestExportBundle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:      "persona",
+		Files:      map[string]string{"test.gguf": digest},
+		Template:   "{{ .System }} {{ .Prompt }}",
+		System:     "be brief",
+		License:    []string{"MIT"},
+		Parameters: map[string]any{"temperature": float64(0.5), "top_k": float64(20)},
+		Messages:   []api.Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}},
+		Stream:     &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	w = createRequest(t, s.ExportHandler, api.ExportRequest{Model: "persona", Bundle: true})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	var bundle api.ModelBundle
+	if err := json.NewDecoder(w.Body).Decode(&bundle); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(bundle, api.ModelBundle{
+		Version:    api.ModelBundleVersion,
+		Template:   "{{ .System }} {{ .Prompt }}",
+		System:     "be brief",
+		Parameters: map[string]any{"temperature": float64(0.5), "top_k": float64(20)},
+		Messages:   []api.Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}},
+		License:    []string{"MIT"},
+	}) {
+		t.Errorf("unexpected bundle %+v", bundle)
+	}
+
+	_, digest = createBinFile(t, llm.KV{"general.architecture": "llama", "general.name": "base"}, nil)
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "base",
+		Files:  map[string]string{"base.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Run("apply", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:      "applied",
+			From:       "base",
+			Bundle:     &bundle,
+			Parameters: map[string]any{"top_k": float64(40)},
+			Stream:     &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("applied")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Template.String() != bundle.Template {
+			t.Errorf("expected template %q, actual %q", bundle.Template, m.Template.String())
+		}
+
+		if m.System != bundle.System {
+			t.Errorf("expected system %q, actual %q", bundle.System, m.System)
+		}
+
+		if expect := map[string]any{"temperature": float64(0.5), "top_k": float64(40)}; !reflect.DeepEqual(m.Options, expect) {
+			t.Errorf("expected parameters %v, actual %v", expect, m.Options)
+		}
+
+		if len(m.Messages) != 2 || m.Messages[1].Content != "hello" {
+			t.Errorf("expected the bundle's messages, actual %+v", m.Messages)
+		}
+
+		if !slices.Equal(m.License, bundle.License) {
+			t.Errorf("expected license %v, actual %v", bundle.License, m.License)
+		}
+
+		base, err := GetModel("base")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.ModelPath != base.ModelPath {
+			t.Errorf("expected the base model's weights, actual %s", m.ModelPath)
+		}
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "applied",
+			From:   "base",
+			Bundle: &api.ModelBundle{Version: api.ModelBundleVersion + 1, System: "be brief"},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -15,7 +15,7 @@ func fixBlobs(dir string) error {
 		}
 		baseName := filepath.Base(path)
 		typ, sha, ok := strings.Cut(baseName, ":")
-		if ok && typ == "sha256" {
+		if _, err := parseDigester(typ); ok && typ != "" && err == nil {
 			newPath := filepath.Join(filepath.Dir(path), typ+"-"+sha)
 			if err := os.Rename(path, newPath); err != nil {
 				return err
@@ -0,0 +1,444 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ollama/ollama/llama"
+)
+
+var (
+	errInvalidGrammar   = errors.New("invalid grammar")
+	errInvalidSchema    = errors.New("invalid schema")
+	errGrammarAndSchema = errors.New("only one of 'grammar' or 'schema' can be specified")
+	errFormatAndGrammar = errors.New("'format' can't be combined with 'grammar' or 'schema'")
+	errInvalidFormat    = errors.New(`invalid format, expected "json" or a JSON schema`)
+)
+
+// modelFormat is the content of a model's grammar layer, which constrains
+// its output by default. Format is only set to "json".
+type modelFormat struct {
+	Grammar string          `json:"grammar,omitempty"`
+	Schema  json.RawMessage `json:"schema,omitempty"`
+	Format  json.RawMessage `json:"format,omitempty"`
+}
+
+// format returns the format and grammar of a completion with the format
+// requested. The model's grammar or schema is used if the request doesn't
+// set a format.
+func (m *Model) format(requested json.RawMessage) (json.RawMessage, string) {
+	switch string(requested) {
+	case "", "null", `""`:
+		if len(m.Format) > 0 {
+			return m.Format, ""
+		}
+
+		return m.Schema, m.Grammar
+	}
+
+	return requested, ""
+}
+
+// setGrammar replaces the grammar layer of a model after checking the
+// grammar or schema parses.
+func setGrammar(ctx context.Context, layers []Layer, grammar string, schema json.RawMessage) ([]Layer, error) {
+	var format modelFormat
+	switch {
+	case grammar != "" && len(schema) > 0:
+		return nil, badRequestError{errGrammarAndSchema}
+	case grammar != "":
+		if err := checkGrammar(grammar); err != nil {
+			return nil, badRequestError{fmt.Errorf("%w: %w", errInvalidGrammar, err)}
+		}
+		format.Grammar = grammar
+	default:
+		if err := checkSchema(schema); err != nil {
+			return nil, badRequestError{fmt.Errorf("%w: %w", errInvalidSchema, err)}
+		}
+		format.Schema = schema
+	}
+
+	layers = removeLayer(layers, "application/vnd.ollama.image.grammar")
+	layer, err := newJSONLayer(ctx, format, "application/vnd.ollama.image.grammar")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(layers, layer), nil
+}
+
+// setFormat replaces the grammar layer of a model with its default format,
+// which is "json" or a JSON schema.
+func setFormat(ctx context.Context, layers []Layer, format json.RawMessage) ([]Layer, error) {
+	var s string
+	if err := json.Unmarshal(format, &s); err != nil {
+		// anything but a string has to be a schema
+		return setGrammar(ctx, layers, "", format)
+	} else if s != "json" {
+		return nil, badRequestError{fmt.Errorf("%w: %s", errInvalidFormat, format)}
+	}
+
+	layers = removeLayer(layers, "application/vnd.ollama.image.grammar")
+	layer, err := newJSONLayer(ctx, modelFormat{Format: json.RawMessage(`"json"`)}, "application/vnd.ollama.image.grammar")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(layers, layer), nil
+}
+
+// grammarError is the location of a syntax error in a grammar or schema.
+// Lines and columns start at 1.
+type grammarError struct {
+	Line, Column int
+	Msg          string
+}
+
+func (e grammarError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+func newGrammarError(src string, offset int, format string, args ...any) grammarError {
+	before := src[:min(offset, len(src))]
+	line := strings.Count(before, "\n") + 1
+	column := utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1
+	return grammarError{Line: line, Column: column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// grammarParser checks the syntax of a GBNF grammar the way the runner's
+// grammar parser reads it:
+//
+//	root ::= "yes" | "no" ws
+//	ws   ::= [ \t\n]*
+//
+// A grammar is a rule per line. Alternatives, groups, literals, character
+// classes, rule references and the *, +, ? and {m,n} repetitions are
+// checked, along with every referenced rule being defined and the grammar
+// having a root rule.
+type grammarParser struct {
+	src string
+	pos int
+
+	rules map[string]bool
+	// refs are the offsets, plus one, of the first reference to each rule
+	refs map[string]int
+}
+
+// checkGrammar returns a [grammarError] locating the first syntax error in
+// src, if there is one.
+func checkGrammar(src string) error {
+	p := grammarParser{src: src, rules: make(map[string]bool), refs: make(map[string]int)}
+	return p.parse()
+}
+
+func (p *grammarParser) errorf(format string, args ...any) error {
+	return newGrammarError(p.src, p.pos, format, args...)
+}
+
+func (p *grammarParser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+
+	return 0
+}
+
+func (p *grammarParser) skipSpace(newlines bool) {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\r' && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case newlines && (c == '\r' || c == '\n'):
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func isGrammarWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-'
+}
+
+func (p *grammarParser) name() string {
+	start := p.pos
+	for p.pos < len(p.src) && isGrammarWordChar(p.src[p.pos]) {
+		p.pos++
+	}
+
+	return p.src[start:p.pos]
+}
+
+func (p *grammarParser) parse() error {
+	p.skipSpace(true)
+	for p.pos < len(p.src) {
+		if err := p.rule(); err != nil {
+			return err
+		}
+	}
+
+	if len(p.rules) == 0 {
+		return p.errorf("grammar has no rules")
+	}
+
+	// report the first undefined rule
+	var undefined string
+	for name, offset := range p.refs {
+		if !p.rules[name] && (undefined == "" || offset < p.refs[undefined]) {
+			undefined = name
+		}
+	}
+
+	if undefined != "" {
+		return newGrammarError(p.src, p.refs[undefined]-1, "undefined rule %q", undefined)
+	}
+
+	if !p.rules["root"] {
+		return p.errorf("grammar has no root rule")
+	}
+
+	return nil
+}
+
+func (p *grammarParser) rule() error {
+	name := p.name()
+	if name == "" {
+		return p.errorf("expected a rule name")
+	}
+
+	p.skipSpace(false)
+	if !strings.HasPrefix(p.src[p.pos:], "::=") {
+		return p.errorf("expected ::= after rule %q", name)
+	}
+	p.pos += 3
+	p.skipSpace(true)
+
+	if err := p.alternatives(false); err != nil {
+		return err
+	}
+
+	switch p.peek() {
+	case '\r', '\n', 0:
+	default:
+		return p.errorf("expected the end of rule %q", name)
+	}
+
+	p.rules[name] = true
+	p.skipSpace(true)
+	return nil
+}
+
+func (p *grammarParser) alternatives(nested bool) error {
+	if err := p.sequence(nested); err != nil {
+		return err
+	}
+
+	for p.peek() == '|' {
+		p.pos++
+		p.skipSpace(true)
+		if err := p.sequence(nested); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *grammarParser) sequence(nested bool) error {
+	var items int
+	for p.pos < len(p.src) {
+		switch c := p.peek(); {
+		case c == '"':
+			if err := p.literal(); err != nil {
+				return err
+			}
+		case c == '[':
+			if err := p.class(); err != nil {
+				return err
+			}
+		case isGrammarWordChar(c):
+			start := p.pos
+			if name := p.name(); p.refs[name] == 0 {
+				// rules can be referenced before they're defined so they're
+				// checked once every rule has been read
+				p.refs[name] = start + 1
+			}
+		case c == '(':
+			p.pos++
+			p.skipSpace(true)
+			if err := p.alternatives(true); err != nil {
+				return err
+			}
+
+			if p.peek() != ')' {
+				return p.errorf("expected )")
+			}
+			p.pos++
+		case c == '.':
+			p.pos++
+		case c == '*' || c == '+' || c == '?':
+			if items == 0 {
+				return p.errorf("expected an item before %c", c)
+			}
+			p.pos++
+			items--
+		case c == '{':
+			if items == 0 {
+				return p.errorf("expected an item before {")
+			}
+
+			if err := p.repetition(); err != nil {
+				return err
+			}
+			items--
+		default:
+			return nil
+		}
+
+		items++
+		p.skipSpace(nested)
+	}
+
+	return nil
+}
+
+func (p *grammarParser) literal() error {
+	p.pos++
+	for {
+		switch p.peek() {
+		case 0, '\r', '\n':
+			return p.errorf("unterminated literal")
+		case '"':
+			p.pos++
+			return nil
+		}
+
+		if err := p.char(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *grammarParser) class() error {
+	p.pos++
+	if p.peek() == '^' {
+		p.pos++
+	}
+
+	for p.peek() != ']' {
+		if p.pos >= len(p.src) {
+			return p.errorf("unterminated character class")
+		}
+
+		if err := p.char(); err != nil {
+			return err
+		}
+
+		if p.peek() == '-' && p.pos+1 < len(p.src) && p.src[p.pos+1] != ']' {
+			p.pos++
+			if err := p.char(); err != nil {
+				return err
+			}
+		}
+	}
+
+	p.pos++
+	return nil
+}
+
+func (p *grammarParser) char() error {
+	if p.peek() != '\\' {
+		_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.pos += size
+		return nil
+	}
+
+	p.pos++
+	c := p.peek()
+	digits := 0
+	switch c {
+	case 'x':
+		digits = 2
+	case 'u':
+		digits = 4
+	case 'U':
+		digits = 8
+	case 't', 'n', 'r', '\\', '"', '[', ']':
+		p.pos++
+		return nil
+	default:
+		return p.errorf("unknown escape \\%c", c)
+	}
+
+	p.pos++
+	for range digits {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", rune(p.peek())) {
+			return p.errorf("expected %d hex digits after \\%c", digits, c)
+		}
+		p.pos++
+	}
+
+	return nil
+}
+
+func (p *grammarParser) repetition() error {
+	p.pos++
+	p.skipSpace(true)
+
+	number := func() bool {
+		start := p.pos
+		for p.peek() >= '0' && p.peek() <= '9' {
+			p.pos++
+		}
+
+		ok := p.pos > start
+		p.skipSpace(true)
+		return ok
+	}
+
+	if !number() {
+		return p.errorf("expected a number of repetitions")
+	}
+
+	if p.peek() == ',' {
+		p.pos++
+		p.skipSpace(true)
+		number()
+	}
+
+	if p.peek() != '}' {
+		return p.errorf("expected }")
+	}
+
+	p.pos++
+	return nil
+}
+
+// checkSchema checks that schema is a JSON schema which can be converted to
+// a grammar. Syntax errors are located with a [grammarError].
+func checkSchema(schema json.RawMessage) error {
+	var v map[string]any
+	if err := json.Unmarshal(schema, &v); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			// the offset is after the invalid character
+			return newGrammarError(string(schema), max(int(syntax.Offset)-1, 0), "%v", err)
+		}
+
+		return fmt.Errorf("schema must be a JSON object: %w", err)
+	}
+
+	if llama.SchemaToGrammar(schema) == nil {
+		return errors.New("schema can't be converted to a grammar")
+	}
+
+	return nil
+}
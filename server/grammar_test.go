@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestCheckGrammar(t *testing.T) {
+	cases := []struct {
+		name    string
+		grammar string
+		expect  string
+	}{
+		{"valid", "root ::= answer ws\nanswer ::= \"yes\" | \"no\"\nws ::= [ \\t\\n]*\n", ""},
+		{"groups and repetitions", "root ::= (\"a\" [b-d]+ | item){2,3} .?\n# items\nitem ::= \"\\x41\\u00e9\" [^\\]]*", ""},
+		{"nested newlines", "root ::= (\n  \"a\" |\n  \"b\"\n)", ""},
+		{"empty", "", "line 1, column 1: grammar has no rules"},
+		{"no root", "answer ::= \"yes\"", "line 1, column 17: grammar has no root rule"},
+		{"undefined rule", "root ::= answer\nanswer ::= \"yes\" wz\n", "line 2, column 18: undefined rule \"wz\""},
+		{"missing ::=", "root = \"yes\"", "line 1, column 6: expected ::= after rule \"root\""},
+		{"unterminated literal", "root ::= \"yes\nws ::= \" \"", "line 1, column 14: unterminated literal"},
+		{"unclosed group", "root ::= (\"yes\" | \"no\"", "line 1, column 23: expected )"},
+		{"repetition without item", "root ::= * \"yes\"", "line 1, column 10: expected an item before *"},
+		{"bad escape", "root ::= \"\\q\"", "line 1, column 12: unknown escape \\q"},
+		{"short hex escape", "root ::= \"\\x4\"", "line 1, column 14: expected 2 hex digits after \\x"},
+		{"unexpected character", "root ::= \"yes\" ;", "line 1, column 16: expected the end of rule \"root\""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkGrammar(tt.grammar)
+			if tt.expect == "" {
+				if err != nil {
+					t.Fatalf("expected no error, actual %v", err)
+				}
+				return
+			}
+
+			var gerr grammarError
+			if !errors.As(err, &gerr) || err.Error() != tt.expect {
+				t.Errorf("expected %q, actual %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	if err := checkSchema(json.RawMessage(`{"type": "object", "properties": {"answer": {"type": "string"}}}`)); err != nil {
+		t.Errorf("expected no error, actual %v", err)
+	}
+
+	err := checkSchema(json.RawMessage("{\n  \"type\": \"object\",\n  \"properties\": }"))
+	if expect := "line 3, column 17: invalid character '}' looking for beginning of value"; err == nil || err.Error() != expect {
+		t.Errorf("expected %q, actual %v", expect, err)
+	}
+
+	if err := checkSchema(json.RawMessage(`"json"`)); err == nil {
+		t.Error("expected a schema which isn't an object to be invalid")
+	}
+}
+
+func TestModelFormat(t *testing.T) {
+	m := Model{Grammar: `root ::= "yes"`}
+
+	if format, grammar := m.format(nil); format != nil || grammar != m.Grammar {
+		t.Errorf("expected the model's grammar, actual %s %q", format, grammar)
+	}
+
+	if format, grammar := m.format(json.RawMessage(`"json"`)); string(format) != `"json"` || grammar != "" {
+		t.Errorf("expected the requested format, actual %s %q", format, grammar)
+	}
+
+	m = Model{Schema: json.RawMessage(`{"type": "object"}`)}
+	if format, grammar := m.format(json.RawMessage(`null`)); string(format) != `{"type": "object"}` || grammar != "" {
+		t.Errorf("expected the model's schema, actual %s %q", format, grammar)
+	}
+
+	m = Model{Format: json.RawMessage(`"json"`)}
+	if format, grammar := m.format(nil); string(format) != `"json"` || grammar != "" {
+		t.Errorf("expected the model's format, actual %s %q", format, grammar)
+	}
+
+	if format, grammar := m.format(json.RawMessage(`{"type": "object"}`)); string(format) != `{"type": "object"}` || grammar != "" {
+		t.Errorf("expected the requested format, actual %s %q", format, grammar)
+	}
+}
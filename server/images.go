@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
@@ -21,6 +22,8 @@ import (
 	"strconv"
 	"strings"
 
+	"golang.org/x/crypto/ssh"
+
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/llm"
@@ -43,6 +46,7 @@ const (
 	CapabilityCompletion = Capability("completion")
 	CapabilityTools      = Capability("tools")
 	CapabilityInsert     = Capability("insert")
+	CapabilityVision     = Capability("vision")
 )
 
 type registryOptions struct {
@@ -51,6 +55,11 @@ type registryOptions struct {
 	Password string
 	Token    string
 
+	// Headers are added to every request, and UserAgent replaces the
+	// server's User-Agent if it's set
+	Headers   http.Header
+	UserAgent string
+
 	CheckRedirect func(req *http.Request, via []*http.Request) error
 }
 
@@ -62,15 +71,101 @@ type Model struct {
 	ParentModel    string
 	AdapterPaths   []string
 	ProjectorPaths []string
+	KVOverrides    llm.KV
 	System         string
+	Systems        map[string]string
 	License        []string
 	Digest         string
 	Options        map[string]interface{}
 	Messages       []api.Message
+	Safety         *api.SafetyClassification
+	Card           *api.ModelCard
+
+	// SignedBy is the fingerprint of the key which signed the manifest
+	SignedBy string
+
+	// Grammar and Schema constrain the model's output by default, as does
+	// Format when it's "json"
+	Grammar string
+	Schema  json.RawMessage
+	Format  json.RawMessage
 
 	Template *template.Template
 }
 
+// KeepAlive returns the model's default keep_alive parameter, or nil if it
+// isn't set.
+func (m *Model) KeepAlive() *api.Duration {
+	v, ok := m.Options["keep_alive"]
+	if !ok {
+		return nil
+	}
+
+	d, err := parseKeepAlive(v)
+	if err != nil {
+		slog.Warn("invalid keep_alive parameter", "model", m.ShortName, "error", err)
+		return nil
+	}
+
+	return d
+}
+
+// think reports whether the model should reason before it responds: the
+// request's think setting if it has one, otherwise the model's think parameter.
+func (m *Model) think(requested *bool) bool {
+	if requested != nil {
+		return *requested
+	}
+
+	v, ok := m.Options["think"]
+	if !ok {
+		return false
+	}
+
+	think, err := parseThink(v)
+	if err != nil {
+		slog.Warn("invalid think parameter", "model", m.ShortName, "error", err)
+		return false
+	}
+
+	return think
+}
+
+// parseThink parses v as a boolean, accepting the strings a Modelfile
+// parameter may be written as.
+func parseThink(v any) (bool, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strconv.ParseBool(v)
+	default:
+		return false, fmt.Errorf("expected a boolean, got %T", v)
+	}
+}
+
+// parseKeepAlive parses v as a duration such as "10m" or a number of seconds
+// in the same way as a request's keep_alive.
+func parseKeepAlive(v any) (*api.Duration, error) {
+	if s, ok := v.(string); ok {
+		if n, err := strconv.Atoi(s); err == nil {
+			v = n
+		}
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var d api.Duration
+	if err := d.UnmarshalJSON(b); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
 // CheckCapabilities checks if the model has the specified capabilities returning an error describing
 // any missing or unknown capabilities
 func (m *Model) CheckCapabilities(caps ...Capability) error {
@@ -104,6 +199,9 @@ func (m *Model) CheckCapabilities(caps ...Capability) error {
 			if !slices.Contains(vars, "suffix") {
 				errs = append(errs, errCapabilityInsert)
 			}
+		case CapabilityVision:
+			// vision only selects the context length, so requests with
+			// images for models without a projector aren't refused
 		default:
 			slog.Error("unknown capability", "capability", cap)
 			return fmt.Errorf("unknown capability: %s", cap)
@@ -155,6 +253,8 @@ func (m *Model) String() string {
 
 	for k, v := range m.Options {
 		switch v := v.(type) {
+		case map[string]any:
+			// backend specific parameters can't be written in a Modelfile
 		case []any:
 			for _, s := range v {
 				modelfile.Commands = append(modelfile.Commands, parser.Command{
@@ -194,12 +294,39 @@ type ConfigV2 struct {
 	ModelType     string   `json:"model_type"`
 	FileType      string   `json:"file_type"`
 
+	SpecialTokens *api.SpecialTokens `json:"special_tokens,omitempty"`
+
+	// TensorQuantizations are the types of the model's tensor groups if it
+	// was quantized with a policy
+	TensorQuantizations map[string]string `json:"tensor_quantizations,omitempty"`
+
+	// Protected models can't be overwritten by a create
+	Protected bool `json:"protected,omitempty"`
+
+	// Draft models aren't listed unless drafts are asked for
+	Draft bool `json:"draft,omitempty"`
+
+	// DerivedFrom is the lineage of a model created from another: the model
+	// it was created from first, then the lineage of that model.
+	DerivedFrom []DerivedModel `json:"derived_from,omitempty"`
+
 	// required by spec
 	Architecture string `json:"architecture"`
 	OS           string `json:"os"`
 	RootFS       RootFS `json:"rootfs"`
 }
 
+// DerivedModel is a model another was created from, as it was when the
+// other was created.
+type DerivedModel struct {
+	Model  string `json:"model"`
+	Digest string `json:"digest"`
+}
+
+func (m DerivedModel) String() string {
+	return m.Model + "@" + m.Digest
+}
+
 type RootFS struct {
 	Type    string   `json:"type"`
 	DiffIDs []string `json:"diff_ids"`
@@ -241,6 +368,18 @@ func GetModel(name string) (*Model, error) {
 		Template:  template.DefaultTemplate,
 	}
 
+	fp, err := mp.GetManifestPath()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := verifyManifest(fp)
+	if err != nil {
+		return nil, err
+	} else if key != nil {
+		model.SignedBy = ssh.FingerprintSHA256(key)
+	}
+
 	if manifest.Config.Digest != "" {
 		filename, err := GetBlobsPath(manifest.Config.Digest)
 		if err != nil {
@@ -294,6 +433,16 @@ func GetModel(name string) (*Model, error) {
 			}
 
 			model.System = string(bts)
+		case "application/vnd.ollama.image.systems":
+			systems, err := os.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+			defer systems.Close()
+
+			if err = json.NewDecoder(systems).Decode(&model.Systems); err != nil {
+				return nil, err
+			}
 		case "application/vnd.ollama.image.params":
 			params, err := os.Open(filename)
 			if err != nil {
@@ -305,6 +454,16 @@ func GetModel(name string) (*Model, error) {
 			if err = json.NewDecoder(params).Decode(&model.Options); err != nil {
 				return nil, err
 			}
+		case "application/vnd.ollama.image.overrides":
+			overrides, err := os.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+			defer overrides.Close()
+
+			if err = json.NewDecoder(overrides).Decode(&model.KVOverrides); err != nil {
+				return nil, err
+			}
 		case "application/vnd.ollama.image.messages":
 			msgs, err := os.Open(filename)
 			if err != nil {
@@ -315,6 +474,38 @@ func GetModel(name string) (*Model, error) {
 			if err = json.NewDecoder(msgs).Decode(&model.Messages); err != nil {
 				return nil, err
 			}
+		case "application/vnd.ollama.image.grammar":
+			grammar, err := os.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+			defer grammar.Close()
+
+			var format modelFormat
+			if err = json.NewDecoder(grammar).Decode(&format); err != nil {
+				return nil, err
+			}
+			model.Grammar, model.Schema, model.Format = format.Grammar, format.Schema, format.Format
+		case "application/vnd.ollama.image.safety":
+			safety, err := os.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+			defer safety.Close()
+
+			if err = json.NewDecoder(safety).Decode(&model.Safety); err != nil {
+				return nil, err
+			}
+		case "application/vnd.ollama.image.card":
+			card, err := os.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+			defer card.Close()
+
+			if err = json.NewDecoder(card).Decode(&model.Card); err != nil {
+				return nil, err
+			}
 		case "application/vnd.ollama.image.license":
 			bts, err := os.ReadFile(filename)
 			if err != nil {
@@ -349,6 +540,11 @@ func CopyModel(src, dst model.Name) error {
 		return err
 	}
 
+	// signatures aren't copied since they're of the source's manifest
+	if err := removeSignature(dstpath); err != nil {
+		return err
+	}
+
 	srcpath := filepath.Join(manifests, src.Filepath())
 	srcfile, err := os.Open(srcpath)
 	if err != nil {
@@ -366,7 +562,9 @@ func CopyModel(src, dst model.Name) error {
 	return err
 }
 
-func deleteUnusedLayers(deleteMap map[string]struct{}) error {
+// removeUsedBlobs removes the digests of the blobs used by a manifest, or a
+// manifest saved for rollback, from digests.
+func removeUsedBlobs(digests map[string]struct{}) error {
 	// Ignore corrupt manifests to avoid blocking deletion of layers that are freshly orphaned
 	manifests, err := Manifests(true)
 	if err != nil {
@@ -375,10 +573,27 @@ func deleteUnusedLayers(deleteMap map[string]struct{}) error {
 
 	for _, manifest := range manifests {
 		for _, layer := range manifest.Layers {
-			delete(deleteMap, layer.Digest)
+			delete(digests, layer.Digest)
+		}
+
+		delete(digests, manifest.Config.Digest)
+	}
+
+	// keep the layers of manifests saved for rollback
+	for _, manifest := range previousManifests() {
+		for _, layer := range manifest.Layers {
+			delete(digests, layer.Digest)
 		}
 
-		delete(deleteMap, manifest.Config.Digest)
+		delete(digests, manifest.Config.Digest)
+	}
+
+	return nil
+}
+
+func deleteUnusedLayers(deleteMap map[string]struct{}) error {
+	if err := removeUsedBlobs(deleteMap); err != nil {
+		return err
 	}
 
 	// only delete the files which are still in the deleteMap
@@ -388,7 +603,11 @@ func deleteUnusedLayers(deleteMap map[string]struct{}) error {
 			slog.Info(fmt.Sprintf("couldn't get file path for '%s': %v", k, err))
 			continue
 		}
-		if err := os.Remove(fp); err != nil {
+		if err := removeUnpinnedBlob(k, fp); errors.Is(err, errBlobPinned) {
+			// a create in flight is using the blob
+			delete(deleteMap, k)
+			continue
+		} else if err != nil {
 			slog.Info(fmt.Sprintf("couldn't remove file '%s': %v", fp, err))
 			continue
 		}
@@ -431,6 +650,14 @@ func PruneLayers() error {
 
 	slog.Info(fmt.Sprintf("total blobs: %d", len(deleteMap)))
 
+	if err := keepUploadedBlobs(deleteMap); err != nil {
+		slog.Warn("couldn't check for uploaded blobs", "error", err)
+	}
+
+	if err := keepCheckpointBlobs(deleteMap); err != nil {
+		slog.Warn("couldn't check for quantization checkpoints", "error", err)
+	}
+
 	if err := deleteUnusedLayers(deleteMap); err != nil {
 		slog.Error(fmt.Sprintf("couldn't remove unused layers: %v", err))
 		return nil
@@ -511,7 +738,7 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	}
 
 	headers := make(http.Header)
-	headers.Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	headers.Set("Content-Type", cmp.Or(manifest.MediaType, dockerManifestMediaType))
 	resp, err := makeRequestWithRetry(ctx, http.MethodPut, requestURL, headers, bytes.NewReader(manifestJSON), regOpts)
 	if err != nil {
 		return err
@@ -611,6 +838,10 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return err
 	}
 
+	if err := removeSignature(fp); err != nil {
+		return err
+	}
+
 	err = os.WriteFile(fp, manifestJSON, 0o644)
 	if err != nil {
 		slog.Info(fmt.Sprintf("couldn't write to %s", fp))
@@ -633,7 +864,7 @@ func pullModelManifest(ctx context.Context, mp ModelPath, regOpts *registryOptio
 	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
 
 	headers := make(http.Header)
-	headers.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	headers.Set("Accept", dockerManifestMediaType+", "+ociManifestMediaType)
 	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, regOpts)
 	if err != nil {
 		return nil, err
@@ -736,15 +967,24 @@ func makeRequest(ctx context.Context, method string, requestURL *url.URL, header
 		req.Header = headers
 	}
 
+	userAgent := fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version())
 	if regOpts != nil {
+		for k, vs := range regOpts.Headers {
+			req.Header[k] = slices.Clone(vs)
+		}
+
+		// a registry token replaces an Authorization header once the
+		// registry has challenged the request
 		if regOpts.Token != "" {
 			req.Header.Set("Authorization", "Bearer "+regOpts.Token)
 		} else if regOpts.Username != "" && regOpts.Password != "" {
 			req.SetBasicAuth(regOpts.Username, regOpts.Password)
 		}
+
+		userAgent = cmp.Or(regOpts.UserAgent, userAgent)
 	}
 
-	req.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	req.Header.Set("User-Agent", userAgent)
 
 	if s := req.Header.Get("Content-Length"); s != "" {
 		contentLength, err := strconv.ParseInt(s, 10, 64)
@@ -807,15 +1047,26 @@ func verifyBlob(digest string) error {
 		return err
 	}
 
+	d, err := digesterFor(digest)
+	if err != nil {
+		return err
+	}
+
 	f, err := os.Open(fp)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	fileDigest, _ := GetSHA256Digest(f)
-	if digest != fileDigest {
-		return fmt.Errorf("%w: want %s, got %s", errDigestMismatch, digest, fileDigest)
+	// the blob is hashed again rather than trusting the digest cache, whose
+	// entries only change with a file's size or modification time
+	got, err := hashFile(f, d)
+	if err != nil {
+		return err
+	}
+
+	if digest != got {
+		return fmt.Errorf("%w: want %s, got %s", errDigestMismatch, digest, got)
 	}
 
 	return nil
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+var errInlineTooLarge = errors.New("inline files are too large")
+
+// createBodyOverhead is how much larger than its encoded inline files a
+// create request body can be, for the rest of the request.
+const createBodyOverhead = 32 << 20
+
+// maxCreateBodySize is the size create request bodies are limited to, so
+// inline files over OLLAMA_MAX_INLINE_SIZE are refused before they're read.
+func maxCreateBodySize() int64 {
+	return int64(base64.StdEncoding.EncodedLen(int(envconfig.MaxInlineSize()))) + createBodyOverhead
+}
+
+// decodedLen returns the length of the base64 encoded s once it's decoded,
+// without decoding it.
+func decodedLen(s string) uint64 {
+	n := base64.StdEncoding.DecodedLen(len(s))
+	if strings.HasSuffix(s, "==") {
+		n -= 2
+	} else if strings.HasSuffix(s, "=") {
+		n--
+	}
+
+	return uint64(max(n, 0))
+}
+
+// writeInlineFiles writes the base64 encoded content of each of the inline
+// files and adapters to its own blob and returns their names mapped to their
+// digests. Their total decoded size is limited to OLLAMA_MAX_INLINE_SIZE.
+func writeInlineFiles(ctx context.Context, inlineFiles, inlineAdapters map[string]string) (_, _ map[string]string, err error) {
+	files, adapters := make(map[string]string), make(map[string]string)
+	defer func() {
+		if err != nil {
+			removeUnpackedFiles(ctx, files, nil)
+			removeUnpackedFiles(ctx, adapters, nil)
+		}
+	}()
+
+	remaining := envconfig.MaxInlineSize()
+	for _, inline := range []struct {
+		content, digests map[string]string
+	}{
+		{inlineFiles, files},
+		{inlineAdapters, adapters},
+	} {
+		for _, name := range slices.Sorted(maps.Keys(inline.content)) {
+			if clean := path.Clean(name); clean != name || name == "." || name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+				return nil, nil, badRequestError{fmt.Errorf("invalid inline file name %q", name)}
+			}
+
+			// the size is checked before the content is decoded
+			if decodedLen(inline.content[name]) > remaining {
+				return nil, nil, badRequestError{fmt.Errorf("%w: files exceed the %d byte limit", errInlineTooLarge, envconfig.MaxInlineSize())}
+			}
+
+			b, err := base64.StdEncoding.DecodeString(inline.content[name])
+			if err != nil {
+				return nil, nil, badRequestError{fmt.Errorf("invalid inline file %s: %w", name, err)}
+			}
+			remaining -= uint64(len(b))
+
+			layer, err := newCreateLayer(ctx, bytes.NewReader(b), "")
+			if err != nil {
+				return nil, nil, err
+			}
+
+			inline.digests[name] = layer.Digest
+		}
+	}
+
+	return files, adapters, nil
+}
+
+// mergeInlineFiles adds the inline files to files. A file can't be both
+// listed and inline.
+func mergeInlineFiles(files, inline map[string]string) (map[string]string, error) {
+	merged := maps.Clone(files)
+	if merged == nil {
+		merged = make(map[string]string, len(inline))
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(inline)) {
+		if _, ok := merged[name]; ok {
+			return nil, badRequestError{fmt.Errorf("%s is both a file and an inline file", name)}
+		}
+		merged[name] = inline[name]
+	}
+
+	if len(merged) == 0 {
+		return nil, nil
+	}
+
+	return merged, nil
+}
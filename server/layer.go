@@ -1,7 +1,7 @@
 package server
 
 import (
-	"crypto/sha256"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -13,24 +13,56 @@ type Layer struct {
 	Digest    string `json:"digest"`
 	Size      int64  `json:"size"`
 	From      string `json:"from,omitempty"`
-	status    string
+
+	// Source records where the layer's content came from, e.g. a model name
+	// or file name. It's kept in the manifest and isn't part of the digest.
+	Source string `json:"source,omitempty"`
+
+	status string
+
+	// created is set if the layer's blob was written by this process rather
+	// than already existing, so a create which fails can remove it.
+	created bool
+
+	// checkpoints are the quantization checkpoints the layer was created
+	// from. They're removed once the layer is part of a manifest.
+	checkpoints []*quantizeCheckpoint
 }
 
+// layerCopyBufferSize is the size of the buffer a layer's content is streamed
+// through to its blob, so writing a layer uses the same memory whatever the
+// layer's size.
+const layerCopyBufferSize = 1 << 20
+
 func NewLayer(r io.Reader, mediatype string) (Layer, error) {
+	return newLayer(r, mediatype, sha256Digester)
+}
+
+// newCreateLayer writes the blob of a layer for the create ctx is for,
+// addressed by the create's digester so it isn't hashed again once it's
+// written.
+func newCreateLayer(ctx context.Context, r io.Reader, mediatype string) (Layer, error) {
+	return newLayer(r, mediatype, digesterFrom(ctx))
+}
+
+// newLayer writes the blob of a layer addressed by d's digest.
+func newLayer(r io.Reader, mediatype string, d digester) (Layer, error) {
 	blobs, err := GetBlobsPath("")
 	if err != nil {
 		return Layer{}, err
 	}
 
-	temp, err := os.CreateTemp(blobs, "sha256-")
+	temp, err := scratchTemp(blobs, d.Name()+"-")
 	if err != nil {
 		return Layer{}, err
 	}
 	defer temp.Close()
 	defer os.Remove(temp.Name())
 
-	sha256sum := sha256.New()
-	n, err := io.Copy(io.MultiWriter(temp, sha256sum), r)
+	// r's WriteTo, if any, is hidden so the copy always goes through the
+	// buffer rather than however r would write itself
+	h := d.New()
+	n, err := io.CopyBuffer(io.MultiWriter(temp, h), struct{ io.Reader }{r}, make([]byte, layerCopyBufferSize))
 	if err != nil {
 		return Layer{}, err
 	}
@@ -39,18 +71,20 @@ func NewLayer(r io.Reader, mediatype string) (Layer, error) {
 		return Layer{}, err
 	}
 
-	digest := fmt.Sprintf("sha256:%x", sha256sum.Sum(nil))
+	digest := formatDigest(d, h)
 	blob, err := GetBlobsPath(digest)
 	if err != nil {
 		return Layer{}, err
 	}
 
-	status := "using existing layer"
+	pinBlob(digest)
+	status, created := "using existing layer", false
 	if _, err := os.Stat(blob); err != nil {
-		status = "creating new layer"
-		if err := os.Rename(temp.Name(), blob); err != nil {
+		status, created = "creating new layer", true
+		if err := moveFile(temp.Name(), blob); err != nil {
 			return Layer{}, err
 		}
+		blobCreated(digest)
 		if err := os.Chmod(blob, 0o644); err != nil {
 			return Layer{}, err
 		}
@@ -61,6 +95,7 @@ func NewLayer(r io.Reader, mediatype string) (Layer, error) {
 		Digest:    digest,
 		Size:      n,
 		status:    fmt.Sprintf("%s %s", status, digest),
+		created:   created,
 	}, nil
 }
 
@@ -74,6 +109,7 @@ func NewLayerFromLayer(digest, mediatype, from string) (Layer, error) {
 		return Layer{}, err
 	}
 
+	pinBlob(digest)
 	fi, err := os.Stat(blob)
 	if err != nil {
 		return Layer{}, err
@@ -106,19 +142,15 @@ func (l *Layer) Remove() error {
 		return nil
 	}
 
-	// Ignore corrupt manifests to avoid blocking deletion of layers that are freshly orphaned
-	ms, err := Manifests(true)
-	if err != nil {
+	// manifests saved for rollback keep their layers too
+	digests := map[string]struct{}{l.Digest: {}}
+	if err := removeUsedBlobs(digests); err != nil {
 		return err
 	}
 
-	for _, m := range ms {
-		for _, layer := range append(m.Layers, m.Config) {
-			if layer.Digest == l.Digest {
-				// something is using this layer
-				return nil
-			}
-		}
+	if len(digests) == 0 {
+		// something is using this layer
+		return nil
 	}
 
 	blob, err := GetBlobsPath(l.Digest)
@@ -126,5 +158,10 @@ func (l *Layer) Remove() error {
 		return err
 	}
 
-	return os.Remove(blob)
+	if err := removeUnpinnedBlob(l.Digest, blob); !errors.Is(err, errBlobPinned) {
+		return err
+	}
+
+	// a create in flight is using this layer
+	return nil
 }
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+// LayersHandler lists the layers of an existing model from its manifest, so
+// the result of a create can be inspected without loading the model.
+func (s *Server) LayersHandler(c *gin.Context) {
+	var r api.LayersRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(r.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %q", errtypes.InvalidModelNameErrMsg, r.Model)})
+		return
+	}
+
+	m, err := ParseNamedManifest(name)
+	if errors.Is(err, os.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": modelNotFoundError(r.Model).Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := api.LayersResponse{Model: name.DisplayShortest(), Digest: m.qualifiedDigest()}
+	resp.Config, err = modelLayer(m.Config)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp.Layers = make([]api.ModelLayer, 0, len(m.Layers))
+	for _, layer := range m.Layers {
+		l, err := modelLayer(layer)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		resp.Layers = append(resp.Layers, l)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// modelLayer describes layer with the size of its blob, which is only
+// stat'd rather than read.
+func modelLayer(layer Layer) (api.ModelLayer, error) {
+	l := api.ModelLayer{MediaType: layer.MediaType, Digest: layer.Digest, Size: layer.Size, Source: layer.Source}
+
+	p, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		return l, err
+	}
+
+	fi, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		l.Missing = true
+		return l, nil
+	} else if err != nil {
+		return l, err
+	}
+
+	l.Size = fi.Size()
+	return l, nil
+}
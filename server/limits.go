@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/types/model"
+)
+
+var (
+	errModelLimit = errors.New("model limit reached")
+	errStoreFull  = errors.New("store size limit reached")
+)
+
+// checkStoreLimits refuses a create of the models named names if the models
+// directory already holds as many models as OLLAMA_MAX_MODELS allows, or
+// its models are as large as OLLAMA_MAX_STORE_SIZE allows. Names which
+// already exist are replaced so they don't count against the model limit.
+func checkStoreLimits(names []model.Name) error {
+	maxModels, maxSize := envconfig.MaxModels(), envconfig.MaxStoreSize()
+	if maxModels == 0 && maxSize == 0 {
+		return nil
+	}
+
+	ms, err := Manifests(true)
+	if err != nil {
+		return err
+	}
+
+	if maxModels > 0 {
+		existing := slices.Collect(maps.Keys(ms))
+		count := uint(len(ms))
+		for _, name := range names {
+			if !slices.ContainsFunc(existing, name.EqualFold) {
+				count++
+			}
+		}
+
+		if count > maxModels {
+			return fmt.Errorf("%w: the store has %d models and the limit is %d", errModelLimit, len(ms), maxModels)
+		}
+	}
+
+	if maxSize > 0 {
+		size := storeSize(ms)
+		if size >= maxSize {
+			return fmt.Errorf("%w: the store's models use %s and the limit is %s", errStoreFull, format.HumanBytes2(size), format.HumanBytes2(maxSize))
+		}
+	}
+
+	return nil
+}
+
+// storeSize returns the size of the blobs of the manifests in ms. Models
+// share blobs, such as those created from one another, so each blob is only
+// counted once.
+func storeSize(ms map[model.Name]*Manifest) uint64 {
+	var size uint64
+	counted := make(map[string]bool)
+	for _, m := range ms {
+		for _, layer := range append(m.Layers, m.Config) {
+			if !counted[layer.Digest] {
+				counted[layer.Digest] = true
+				size += uint64(layer.Size)
+			}
+		}
+	}
+
+	return size
+}
+
+// storeLimitStatus is the status code of a create refused by
+// [checkStoreLimits].
+func storeLimitStatus(err error) int {
+	switch {
+	case errors.Is(err, errModelLimit):
+		return http.StatusConflict
+	case errors.Is(err, errStoreFull):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package server
+
+func longPath(p string) string {
+	return p
+}
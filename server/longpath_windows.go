@@ -0,0 +1,31 @@
+package server
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxDirPath is MAX_PATH less the room Windows reserves for an 8.3 filename.
+// Directory operations fail beyond this length without the extended-length
+// prefix.
+const maxDirPath = 248
+
+// longPath returns p with the \\?\ extended-length prefix if p is too long for
+// the legacy Win32 APIs. Short paths are returned unchanged.
+func longPath(p string) string {
+	if len(p) < maxDirPath || strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+
+	if strings.HasPrefix(abs, `\\`) {
+		// UNC paths use \\?\UNC\server\share instead of \\server\share
+		return `\\?\UNC\` + abs[2:]
+	}
+
+	return `\\?\` + abs
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	long := `C:\` + strings.Repeat(`nested\`, 40) + "blob"
+
+	cases := map[string]string{
+		`C:\Users\ollama\.ollama\models\blobs`: `C:\Users\ollama\.ollama\models\blobs`,
+		long:                                   `\\?\` + long,
+		`\\?\` + long:                          `\\?\` + long,
+		`\\server\share\` + long[3:]:           `\\?\UNC\server\share\` + long[3:],
+	}
+
+	for in, want := range cases {
+		if got := longPath(in); got != want {
+			t.Errorf("longPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -10,10 +11,21 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/types/model"
 )
 
+const (
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+
+	// ociConfigMediaType marks a config layer to be written in an OCI
+	// image manifest rather than a Docker one
+	ociConfigMediaType = "application/vnd.oci.image.config.v1+json"
+)
+
 type Manifest struct {
 	SchemaVersion int     `json:"schemaVersion"`
 	MediaType     string  `json:"mediaType"`
@@ -33,11 +45,21 @@ func (m *Manifest) Size() (size int64) {
 	return
 }
 
+// qualifiedDigest returns the digest of m with its algorithm, e.g.
+// "sha256:<hash>". Manifests are always addressed with sha256.
+func (m *Manifest) qualifiedDigest() string {
+	return canonicalDigest(sha256Digester.Name() + ":" + m.digest)
+}
+
 func (m *Manifest) Remove() error {
 	if err := os.Remove(m.filepath); err != nil {
 		return err
 	}
 
+	if err := removeSignature(m.filepath); err != nil {
+		return err
+	}
+
 	manifests, err := GetManifestPath()
 	if err != nil {
 		return err
@@ -70,8 +92,10 @@ func ParseNamedManifest(n model.Name) (*Manifest, error) {
 		return nil, err
 	}
 
-	p := filepath.Join(manifests, n.Filepath())
+	return parseManifestFile(longPath(filepath.Join(manifests, n.Filepath())))
+}
 
+func parseManifestFile(p string) (*Manifest, error) {
 	var m Manifest
 	f, err := os.Open(p)
 	if err != nil {
@@ -102,27 +126,143 @@ func WriteManifest(name model.Name, config Layer, layers []Layer) error {
 		return err
 	}
 
-	p := filepath.Join(manifests, name.Filepath())
+	p := longPath(filepath.Join(manifests, name.Filepath()))
 	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
 		return err
 	}
 
+	// a signature is only of the manifest it was made for
+	if err := removeSignature(filepath.Join(manifests, name.Filepath())); err != nil {
+		return err
+	}
+
 	f, err := os.Create(p)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
+	return json.NewEncoder(f).Encode(newManifest(config, layers))
+}
+
+// newManifest returns the manifest of a model with config and layers as
+// [WriteManifest] writes it.
+func newManifest(config Layer, layers []Layer) Manifest {
 	m := Manifest{
 		SchemaVersion: 2,
-		MediaType:     "application/vnd.docker.distribution.manifest.v2+json",
+		MediaType:     dockerManifestMediaType,
 		Config:        config,
 		Layers:        layers,
 	}
 
+	if config.MediaType == ociConfigMediaType {
+		m.MediaType = ociManifestMediaType
+	}
+
+	return m
+}
+
+type pendingManifestsKey struct{}
+
+// pendingManifests are the manifests of the models of a batch create which
+// have been built but not written, keyed by their lower case names.
+type pendingManifests map[string]*Manifest
+
+// withPendingManifests returns a context in which [parseLocalManifest] finds
+// the manifests in ms, so models of a batch can be created from models
+// earlier in the batch.
+func withPendingManifests(ctx context.Context, ms pendingManifests) context.Context {
+	return context.WithValue(ctx, pendingManifestsKey{}, ms)
+}
+
+// add records the manifest of a model of the batch named name, with the
+// digest it has once it's written.
+func (ms pendingManifests) add(name model.Name, config Layer, layers []Layer) error {
+	m := newManifest(config, layers)
+
+	sha256sum := sha256.New()
+	if err := json.NewEncoder(sha256sum).Encode(m); err != nil {
+		return err
+	}
+
+	m.digest = hex.EncodeToString(sha256sum.Sum(nil))
+	ms[strings.ToLower(name.String())] = &m
+	return nil
+}
+
+// parseLocalManifest returns the manifest of the local model name, which
+// may be a model of the batch in ctx whose manifest hasn't been written yet.
+func parseLocalManifest(ctx context.Context, name model.Name) (*Manifest, error) {
+	if ms, ok := ctx.Value(pendingManifestsKey{}).(pendingManifests); ok {
+		if m, ok := ms[strings.ToLower(name.String())]; ok {
+			return m, nil
+		}
+	}
+
+	return ParseNamedManifest(name)
+}
+
+// previousManifestPath returns the path of the manifest n had before it was
+// last replaced by a create. Previous manifests are kept outside of the
+// manifests directory so they aren't listed as models.
+func previousManifestPath(n model.Name) string {
+	return longPath(filepath.Join(envconfig.Models(), "previous", n.Filepath()))
+}
+
+// previousManifests returns every manifest kept for rollback, skipping any
+// which can't be read.
+func previousManifests() []*Manifest {
+	matches, err := filepath.Glob(filepath.Join(envconfig.Models(), "previous", "*", "*", "*", "*"))
+	if err != nil {
+		slog.Warn("couldn't list previous manifests", "error", err)
+		return nil
+	}
+
+	var ms []*Manifest
+	for _, match := range matches {
+		m, err := parseManifestFile(match)
+		if err != nil {
+			slog.Warn("bad previous manifest", "path", match, "error", err)
+			continue
+		}
+
+		ms = append(ms, m)
+	}
+
+	return ms
+}
+
+func parsePreviousManifest(n model.Name) (*Manifest, error) {
+	if !n.IsFullyQualified() {
+		return nil, model.Unqualified(n)
+	}
+
+	return parseManifestFile(previousManifestPath(n))
+}
+
+func writePreviousManifest(n model.Name, m *Manifest) error {
+	p := previousManifestPath(n)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
 	return json.NewEncoder(f).Encode(m)
 }
 
+func removePreviousManifest(n model.Name) error {
+	if err := os.Remove(previousManifestPath(n)); err != nil {
+		return err
+	}
+
+	return PruneDirectory(filepath.Join(envconfig.Models(), "previous"))
+}
+
 func Manifests(continueOnError bool) (map[model.Name]*Manifest, error) {
 	manifests, err := GetManifestPath()
 	if err != nil {
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -17,21 +18,103 @@ import (
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/template"
+	"github.com/ollama/ollama/types/errtypes"
 	"github.com/ollama/ollama/types/model"
 )
 
 var intermediateBlobs map[string]string = make(map[string]string)
 
+var errFromDigestMismatch = errors.New("model digest doesn't match")
+
 type layerGGML struct {
 	Layer
 	*llm.GGML
 }
 
-func parseFromModel(ctx context.Context, name model.Name, fn func(api.ProgressResponse)) (layers []*layerGGML, err error) {
-	m, err := ParseNamedManifest(name)
+// parseFromName parses the name of the model a create builds on. The name
+// can pin the model's manifest with its digest, e.g. llama3.2@sha256:<hash>,
+// which is returned in its canonical form.
+func parseFromName(s string) (model.Name, string, error) {
+	s, digest, pinned := strings.Cut(s, "@")
+	name := model.ParseName(s)
+	if !name.IsValid() {
+		return model.Name{}, "", badRequestError{errors.New(errtypes.InvalidModelNameErrMsg)}
+	}
+
+	if pinned {
+		// manifests are always addressed with sha256
+		digest = strings.ToLower(strings.Replace(digest, "-", ":", 1))
+		if d, err := digesterFor(digest); err != nil || d.Name() != sha256Digester.Name() {
+			return model.Name{}, "", badRequestError{fmt.Errorf("%w '%s', expected sha256:<hash>", ErrInvalidDigestFormat, digest)}
+		}
+	}
+
+	return name, digest, nil
+}
+
+// checkFromDigest checks the manifest m of the model name has digest, if
+// digest isn't empty.
+func checkFromDigest(name model.Name, m *Manifest, digest string) error {
+	if actual := m.qualifiedDigest(); digest != "" && actual != digest {
+		return fmt.Errorf("%w: %s is %s, not %s", errFromDigestMismatch, name.DisplayShortest(), actual, digest)
+	}
+
+	return nil
+}
+
+// credentialHeaders are the headers whose values aren't logged.
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key", "X-Auth-Token"}
+
+// fromRegistryOptions returns the options of the requests which pull the
+// model in r.From, with the headers and User-Agent of r.
+func fromRegistryOptions(r api.CreateRequest) (*registryOptions, error) {
+	regOpts := &registryOptions{UserAgent: r.UserAgent}
+	if strings.ContainsAny(r.UserAgent, "\r\n\x00") {
+		return nil, badRequestError{errors.New("invalid user_agent")}
+	}
+
+	for k, v := range r.Headers {
+		if k == "" || strings.ContainsFunc(k, func(r rune) bool {
+			return r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r)
+		}) {
+			return nil, badRequestError{fmt.Errorf("invalid header name %q", k)}
+		}
+
+		if strings.ContainsAny(v, "\r\n\x00") {
+			return nil, badRequestError{fmt.Errorf("invalid value of header %q", k)}
+		}
+
+		if regOpts.Headers == nil {
+			regOpts.Headers = make(http.Header)
+		}
+		regOpts.Headers.Set(k, v)
+	}
+
+	return regOpts, nil
+}
+
+// redactHeaders returns a copy of h which can be logged, with the values of
+// credential headers replaced.
+func redactHeaders(h http.Header) http.Header {
+	h = h.Clone()
+	for k := range h {
+		if slices.ContainsFunc(credentialHeaders, func(s string) bool { return strings.EqualFold(s, k) }) {
+			h[k] = []string{"REDACTED"}
+		}
+	}
+
+	return h
+}
+
+// parseFromModel returns the layers of the model name, pulling it with
+// regOpts if it doesn't exist. If digest isn't empty the model's manifest
+// must have that digest.
+func parseFromModel(ctx context.Context, name model.Name, digest string, regOpts *registryOptions, fn func(api.ProgressResponse)) (layers []*layerGGML, err error) {
+	m, err := parseLocalManifest(ctx, name)
 	switch {
 	case errors.Is(err, os.ErrNotExist):
-		if err := PullModel(ctx, name.String(), &registryOptions{}, fn); err != nil {
+		requestLogger(ctx).Info("pulling model", "model", name.DisplayShortest(), "headers", redactHeaders(regOpts.Headers), "user_agent", regOpts.UserAgent)
+		if err := PullModel(ctx, name.String(), regOpts, fn); err != nil {
 			return nil, err
 		}
 
@@ -43,12 +126,23 @@ func parseFromModel(ctx context.Context, name model.Name, fn func(api.ProgressRe
 		return nil, err
 	}
 
-	for _, layer := range m.Layers {
-		layer, err := NewLayerFromLayer(layer.Digest, layer.MediaType, name.DisplayShortest())
+	if err := checkFromDigest(name, m, digest); err != nil {
+		return nil, err
+	}
+
+	if _, err := sourceConfig(m); err != nil {
+		return nil, err
+	}
+
+	for _, l := range m.Layers {
+		layer, err := NewLayerFromLayer(l.Digest, l.MediaType, name.DisplayShortest())
 		if err != nil {
 			return nil, err
 		}
 
+		// keep the original source of layers inherited through several models
+		layer.Source = cmp.Or(l.Source, name.DisplayShortest())
+
 		switch layer.MediaType {
 		case "application/vnd.ollama.image.model",
 			"application/vnd.ollama.image.projector",
@@ -78,13 +172,17 @@ func parseFromModel(ctx context.Context, name model.Name, fn func(api.ProgressRe
 	return layers, nil
 }
 
-func detectChatTemplate(layers []*layerGGML) ([]*layerGGML, error) {
+func detectChatTemplate(ctx context.Context, layers []*layerGGML) ([]*layerGGML, error) {
 	for _, layer := range layers {
+		if layer.GGML == nil {
+			continue
+		}
+
 		if s := layer.GGML.KV().ChatTemplate(); s != "" {
 			if t, err := template.Named(s); err != nil {
 				slog.Debug("template detection", "error", err)
 			} else {
-				layer, err := NewLayer(t.Reader(), "application/vnd.ollama.image.template")
+				layer, err := newCreateLayer(ctx, t.Reader(), "application/vnd.ollama.image.template")
 				if err != nil {
 					return nil, err
 				}
@@ -93,12 +191,7 @@ func detectChatTemplate(layers []*layerGGML) ([]*layerGGML, error) {
 				layers = append(layers, &layerGGML{layer, nil})
 
 				if t.Parameters != nil {
-					var b bytes.Buffer
-					if err := json.NewEncoder(&b).Encode(t.Parameters); err != nil {
-						return nil, err
-					}
-
-					layer, err := NewLayer(&b, "application/vnd.ollama.image.params")
+					layer, err := newCanonicalJSONLayer(ctx, t.Parameters, "application/vnd.ollama.image.params")
 					if err != nil {
 						return nil, err
 					}
@@ -153,11 +246,12 @@ func parseObjects(s string) []map[string]any {
 	return objs
 }
 
-// parseToolCalls attempts to parse a JSON string into a slice of ToolCalls.
-// mxyng: this only really works if the input contains tool calls in some JSON format
-func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
+// toolCallFormat finds the keys of a tool call's name and arguments in the
+// JSON objects t renders tool calls as. Tool calls can only be parsed from
+// the output of models whose templates render them this way.
+func toolCallFormat(t *template.Template) (name, arguments string, ok bool) {
 	// create a subtree from the node that ranges over .ToolCalls
-	tmpl := m.Template.Subtree(func(n parse.Node) bool {
+	tmpl := t.Subtree(func(n parse.Node) bool {
 		if t, ok := n.(*parse.RangeNode); ok {
 			return slices.Contains(template.Identifiers(t.Pipe), "ToolCalls")
 		}
@@ -166,7 +260,7 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 	})
 
 	if tmpl == nil {
-		return nil, false
+		return "", "", false
 	}
 
 	var b bytes.Buffer
@@ -182,16 +276,15 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 			},
 		},
 	}); err != nil {
-		return nil, false
+		return "", "", false
 	}
 
 	templateObjects := parseObjects(b.String())
 	if len(templateObjects) == 0 {
-		return nil, false
+		return "", "", false
 	}
 
 	// find the keys that correspond to the name and arguments fields
-	var name, arguments string
 	for k, v := range templateObjects[0] {
 		switch v.(type) {
 		case string:
@@ -201,7 +294,14 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 		}
 	}
 
-	if name == "" || arguments == "" {
+	return name, arguments, name != "" && arguments != ""
+}
+
+// parseToolCalls attempts to parse a JSON string into a slice of ToolCalls.
+// mxyng: this only really works if the input contains tool calls in some JSON format
+func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
+	name, arguments, ok := toolCallFormat(m.Template)
+	if !ok {
 		return nil, false
 	}
 
@@ -7,7 +7,6 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/ollama/ollama/envconfig"
@@ -124,12 +123,11 @@ func GetManifestPath() (string, error) {
 }
 
 func GetBlobsPath(digest string) (string, error) {
-	// only accept actual sha256 digests
-	pattern := "^sha256[:-][0-9a-fA-F]{64}$"
-	re := regexp.MustCompile(pattern)
-
-	if digest != "" && !re.MatchString(digest) {
-		return "", ErrInvalidDigestFormat
+	// only accept actual digests of the supported algorithms
+	if digest != "" {
+		if _, err := digesterFor(digest); err != nil {
+			return "", err
+		}
 	}
 
 	digest = strings.ReplaceAll(digest, ":", "-")
@@ -139,9 +137,9 @@ func GetBlobsPath(digest string) (string, error) {
 		dirPath = path
 	}
 
-	if err := os.MkdirAll(dirPath, 0o755); err != nil {
+	if err := os.MkdirAll(longPath(dirPath), 0o755); err != nil {
 		return "", err
 	}
 
-	return path, nil
+	return longPath(path), nil
 }
@@ -51,6 +51,24 @@ func TestGetBlobsPath(t *testing.T) {
 			"",
 			ErrInvalidDigestFormat,
 		},
+		{
+			"valid sha512",
+			"sha512:6c3a7e5e4b0f1b9c2f86a8b2f7a0f6a06c3d0f2bbf2e0e3f9f4d1d7b0f3a9f2c4b6f5d8e7a1c0b9e2d3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d",
+			filepath.Join(dir, "blobs", "sha512-6c3a7e5e4b0f1b9c2f86a8b2f7a0f6a06c3d0f2bbf2e0e3f9f4d1d7b0f3a9f2c4b6f5d8e7a1c0b9e2d3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d"),
+			nil,
+		},
+		{
+			"sha512 digest with a sha256 length",
+			"sha512-456402914e838a953e0cf80caa6adbe75383d9e63584a964f504a7bbb8f7aad9",
+			"",
+			ErrInvalidDigestFormat,
+		},
+		{
+			"unknown algorithm",
+			"md5-456402914e838a953e0cf80caa6adbe7",
+			"",
+			ErrInvalidDigestFormat,
+		},
 		{
 			"digest invalid chars",
 			"../sha256-456402914e838a953e0cf80caa6adbe75383d9e63584a964f504a7bbb8f7a",
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"sync"
+)
+
+var errBlobPinned = errors.New("blob is pinned by a create")
+
+// blobPins are the digests of the blobs written, or reused, while a create
+// is in flight. Its manifest, which will reference them, hasn't been written
+// yet so a blob pinned by any create isn't removed by a prune or by removing
+// another manifest's layers.
+//
+// Layers don't know which create they're written for, so a blob is pinned by
+// every create in flight when it's written. Overlapping creates keep each
+// other's blobs for longer than needed, which only delays their pruning.
+type blobPins struct {
+	digests map[string]struct{}
+
+	// created are the pinned digests whose blobs were written, rather than
+	// reused, while the pins were held
+	created map[string]struct{}
+}
+
+var (
+	pinsMu     sync.Mutex
+	activePins = make(map[*blobPins]struct{})
+)
+
+// pinBlobs starts pinning the blobs which are written until the pins are
+// released.
+func pinBlobs() *blobPins {
+	pins := &blobPins{digests: make(map[string]struct{}), created: make(map[string]struct{})}
+
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	activePins[pins] = struct{}{}
+	return pins
+}
+
+// release unpins the blobs. Releasing pins more than once, or nil pins, does
+// nothing.
+func (p *blobPins) release() {
+	if p == nil {
+		return
+	}
+
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	delete(activePins, p)
+}
+
+// unpin unpins digest for the create, so it can remove a blob it no longer
+// needs unless another create is using it. Unpinning nil pins does nothing.
+func (p *blobPins) unpin(digest string) {
+	if p == nil {
+		return
+	}
+
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	delete(p.digests, digest)
+}
+
+// wasCreated reports whether the blob of digest was written, rather than
+// found to exist, while the pins were held. Every blob is reported as written
+// for nil pins.
+func (p *blobPins) wasCreated(digest string) bool {
+	if p == nil {
+		return true
+	}
+
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	_, ok := p.created[digest]
+	return ok
+}
+
+type blobPinsKey struct{}
+
+func withBlobPins(ctx context.Context, pins *blobPins) context.Context {
+	return context.WithValue(ctx, blobPinsKey{}, pins)
+}
+
+// blobPinsFrom returns the pins of the create ctx is for, or nil if there
+// aren't any.
+func blobPinsFrom(ctx context.Context) *blobPins {
+	pins, _ := ctx.Value(blobPinsKey{}).(*blobPins)
+	return pins
+}
+
+// pinBlob pins digest for every create in flight. It's called before the
+// blob is written or found to exist so it can't be removed in between.
+func pinBlob(digest string) {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	for pins := range activePins {
+		pins.digests[digest] = struct{}{}
+	}
+}
+
+// blobCreated records that the pinned blob of digest was written, rather
+// than found to exist, for every create in flight.
+func blobCreated(digest string) {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	for pins := range activePins {
+		pins.created[digest] = struct{}{}
+	}
+}
+
+// removeCreated releases the pins and removes the blobs written while they
+// were held which no manifest references, as a canceled create leaves them.
+// Blobs which were already stored, such as uploaded files, are kept, as are
+// blobs another create in flight has pinned and quantized models with
+// checkpoints.
+func (p *blobPins) removeCreated() error {
+	pinsMu.Lock()
+	digests := maps.Clone(p.created)
+	delete(activePins, p)
+	pinsMu.Unlock()
+
+	if err := removeUsedBlobs(digests); err != nil {
+		return err
+	}
+
+	// quantized models are kept for their checkpoints
+	if err := keepCheckpointBlobs(digests); err != nil {
+		return err
+	}
+
+	for digest := range digests {
+		path, err := GetBlobsPath(digest)
+		if err != nil {
+			return err
+		}
+
+		if err := removeUnpinnedBlob(digest, path); err != nil && !errors.Is(err, errBlobPinned) && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// blobPinned reports whether a create in flight has pinned digest.
+func blobPinned(digest string) bool {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	for pins := range activePins {
+		if _, ok := pins.digests[digest]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeUnpinnedBlob removes the blob of digest at path unless a create in
+// flight has pinned it, in which case it fails with [errBlobPinned]. The pins
+// are held while the blob is removed so it can't be pinned in the meantime.
+func removeUnpinnedBlob(digest, path string) error {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	for pins := range activePins {
+		if _, ok := pins.digests[digest]; ok {
+			return errBlobPinned
+		}
+	}
+
+	return os.Remove(path)
+}
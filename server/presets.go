@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+var errUnknownPreset = errors.New("unknown preset")
+
+// samplerPresets are named sets of sampling parameters a create request can
+// use rather than listing each parameter.
+var samplerPresets = map[string]map[string]any{
+	"creative": {
+		"temperature":    1.0,
+		"top_p":          0.95,
+		"top_k":          100.0,
+		"repeat_penalty": 1.05,
+	},
+	"balanced": {
+		"temperature":    0.7,
+		"top_p":          0.9,
+		"top_k":          40.0,
+		"repeat_penalty": 1.1,
+	},
+	"precise": {
+		"temperature":    0.2,
+		"top_p":          0.5,
+		"top_k":          10.0,
+		"repeat_penalty": 1.15,
+	},
+	"deterministic": {
+		"temperature":    0.0,
+		"top_p":          1.0,
+		"top_k":          1.0,
+		"repeat_penalty": 1.0,
+	},
+}
+
+// presetParameters returns params with the parameters of the preset named
+// name added. Parameters already in params take precedence over the preset.
+func presetParameters(name string, params map[string]any) (map[string]any, error) {
+	preset, ok := samplerPresets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w '%s', available presets: %s", errUnknownPreset, name, strings.Join(slices.Sorted(maps.Keys(samplerPresets)), ", "))
+	}
+
+	expanded := maps.Clone(preset)
+	maps.Copy(expanded, params)
+	return expanded, nil
+}
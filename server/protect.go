@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+var errModelProtected = errors.New("model is protected")
+
+func readManifestConfig(m *Manifest) (ConfigV2, error) {
+	var config ConfigV2
+
+	f, err := m.Config.Open()
+	if err != nil {
+		return config, err
+	}
+	defer f.Close()
+
+	err = json.NewDecoder(f).Decode(&config)
+	return config, err
+}
+
+// checkProtected returns errModelProtected if any of the existing models in
+// names is protected.
+func checkProtected(names []model.Name) error {
+	for _, name := range names {
+		m, err := ParseNamedManifest(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		config, err := readManifestConfig(m)
+		if err != nil {
+			return err
+		}
+
+		if config.Protected {
+			return fmt.Errorf("%w: %q can't be overwritten until it's unprotected", errModelProtected, name.DisplayShortest())
+		}
+	}
+
+	return nil
+}
+
+// ProtectHandler protects a model from being overwritten by a create, or
+// unprotects it.
+func (s *Server) ProtectHandler(c *gin.Context) {
+	var r api.ProtectRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(r.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return
+	}
+
+	name, err := getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	m, err := ParseNamedManifest(name)
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", r.Model)})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	config, err := readManifestConfig(m)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if config.Protected == r.Protected {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	config.Protected = r.Protected
+	if err := replaceManifestConfig(name, m, config); isSignatureError(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// PromoteHandler clears the draft status of a model so it's listed again.
+// Promoting a model which isn't a draft does nothing.
+func (s *Server) PromoteHandler(c *gin.Context) {
+	var r api.PromoteRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(r.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return
+	}
+
+	name, err := getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	m, err := ParseNamedManifest(name)
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", r.Model)})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	config, err := readManifestConfig(m)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !config.Draft {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	config.Draft = false
+	if err := replaceManifestConfig(name, m, config); isSignatureError(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// replaceManifestConfig rewrites the manifest m of name with config, keeping
+// its layers, and removes its old config unless pruning is disabled. A
+// signed manifest is signed again with the key which signed it.
+func replaceManifestConfig(name model.Name, m *Manifest, config ConfigV2) error {
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return err
+	}
+
+	p := filepath.Join(manifests, name.Filepath())
+	signer, err := manifestSigner(p)
+	if err != nil {
+		return err
+	}
+
+	// the config keeps the digest algorithm of the model
+	d, err := digesterFor(m.Config.Digest)
+	if err != nil {
+		return err
+	}
+
+	configLayer, err := createConfigLayer(m.Layers, config, d)
+	if err != nil {
+		return err
+	}
+
+	if m.Config.MediaType == ociConfigMediaType {
+		configLayer.MediaType = ociConfigMediaType
+	}
+
+	if err := WriteManifest(name, *configLayer, m.Layers); err != nil {
+		return err
+	}
+
+	if signer != nil {
+		if err := signManifest(p, signer); err != nil {
+			return err
+		}
+	}
+
+	if !envconfig.NoPrune() {
+		if err := m.Config.Remove(); err != nil {
+			slog.Warn("couldn't remove old config", "digest", m.Config.Digest, "error", err)
+		}
+	}
+
+	return nil
+}
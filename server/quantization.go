@@ -0,0 +1,239 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+var errUnknownQuantization = errors.New("unknown quantization")
+
+// quantizations returns the file types a model can be quantized to by a
+// create. It's the list creates are checked against, so it's what's served
+// to clients too.
+func quantizations() []string {
+	return llm.FileTypes()
+}
+
+// parseQuantization returns the file type named name, which isn't case
+// sensitive. An empty name is returned as it is since it means the model
+// isn't quantized.
+func parseQuantization(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+
+	name = strings.ToUpper(name)
+	if types := quantizations(); !slices.Contains(types, name) {
+		return "", fmt.Errorf("%w %q, expected one of %s", errUnknownQuantization, name, strings.Join(types, ", "))
+	}
+
+	return name, nil
+}
+
+func (s *Server) QuantizationsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, api.QuantizationsResponse{Quantizations: quantizations()})
+}
+
+// CreatePreviewHandler estimates the size of a model once it's quantized
+// without quantizing it. The estimate is made from the shapes and types of
+// the model's tensors, so the model isn't loaded.
+func (s *Server) CreatePreviewHandler(c *gin.Context) {
+	var r api.CreatePreviewRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(r.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %q", errtypes.InvalidModelNameErrMsg, r.Model)})
+		return
+	}
+
+	quantize, err := parseQuantization(r.Quantize)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if quantize == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "quantize is required"})
+		return
+	}
+
+	m, err := GetModel(name.String())
+	if errors.Is(err, os.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": modelNotFoundError(r.Model).Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	fi, err := os.Stat(m.ModelPath)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ggml, err := llm.LoadModel(m.ModelPath, 0)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	size, err := llm.EstimateQuantizedSize(ggml, uint64(fi.Size()), quantize)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.CreatePreviewResponse{
+		Model:         name.DisplayShortest(),
+		Quantization:  ggml.KV().FileType().String(),
+		Size:          fi.Size(),
+		Quantize:      quantize,
+		EstimatedSize: int64(size),
+	})
+}
+
+// tensorGroups are the groups of tensors a quantization policy can quantize
+// to their own types, in the order they're reported.
+var tensorGroups = []string{"attention", "ffn", "output", "token_embd"}
+
+// tensorGroup returns the group of the tensor named name, or "" if it isn't
+// in one.
+func tensorGroup(name string) string {
+	parts := strings.Split(name, ".")
+	switch {
+	case len(parts) > 2 && parts[0] == "blk" && strings.HasPrefix(parts[2], "attn_"):
+		return "attention"
+	case len(parts) > 2 && parts[0] == "blk" && strings.HasPrefix(parts[2], "ffn_"):
+		return "ffn"
+	case parts[0] == "output":
+		return "output"
+	case parts[0] == "token_embd":
+		return "token_embd"
+	default:
+		return ""
+	}
+}
+
+// parseQuantizePolicy returns policy with its quantizations parsed, failing
+// if it names a group which isn't in [tensorGroups].
+func parseQuantizePolicy(policy map[string]string) (map[string]string, error) {
+	parsed := make(map[string]string, len(policy))
+	for _, group := range slices.Sorted(maps.Keys(policy)) {
+		if !slices.Contains(tensorGroups, group) {
+			return nil, fmt.Errorf("unknown tensor group %q in quantize_policy, expected one of %s", group, strings.Join(tensorGroups, ", "))
+		}
+
+		q, err := parseQuantization(policy[group])
+		if err != nil {
+			return nil, fmt.Errorf("quantize_policy %s: %w", group, err)
+		} else if q == "" {
+			return nil, fmt.Errorf("quantize_policy %s: missing quantization", group)
+		}
+
+		parsed[group] = q
+	}
+
+	return parsed, nil
+}
+
+// checkQuantizePolicy checks that the model in ggml has tensors in each
+// group of policy, so a policy doesn't silently do nothing.
+func checkQuantizePolicy(ggml *llm.GGML, policy map[string]string) error {
+	for _, group := range slices.Sorted(maps.Keys(policy)) {
+		if !slices.ContainsFunc(ggml.Tensors().Items, func(t *llm.Tensor) bool { return tensorGroup(t.Name) == group }) {
+			return fmt.Errorf("quantize_policy %s: the model has no %s tensors", group, group)
+		}
+	}
+
+	return nil
+}
+
+// tensorGroupPatterns are regular expressions matching the names of the
+// tensors in each of [tensorGroups], which llama.cpp quantizes them by.
+var tensorGroupPatterns = map[string]string{
+	"attention":  `^blk\.[0-9]+\.attn_`,
+	"ffn":        `^blk\.[0-9]+\.ffn_`,
+	"output":     `^output\.`,
+	"token_embd": `^token_embd\.`,
+}
+
+// quantizeTensorTypes returns the tensor types the groups of policy are
+// quantized to by llama.cpp, keyed by the patterns of their tensors' names.
+// Groups quantized to quantizeType are left to llama.cpp, which may keep
+// some of their tensors at a higher precision.
+func quantizeTensorTypes(policy map[string]string, quantizeType string) (map[string]uint32, error) {
+	types := make(map[string]uint32)
+	for group, q := range policy {
+		if q == strings.ToUpper(quantizeType) {
+			continue
+		}
+
+		ft, err := llm.ParseFileType(q)
+		if err != nil {
+			return nil, err
+		}
+
+		kind, err := ft.TensorKind()
+		if err != nil {
+			return nil, fmt.Errorf("quantize_policy %s: %w", group, err)
+		}
+
+		types[tensorGroupPatterns[group]] = kind
+	}
+
+	return types, nil
+}
+
+// tensorQuantizations returns the type of each tensor group of the model in
+// ggml: the type most of the group's parameters are quantized to, since a
+// quantization type may keep some tensors at a higher precision.
+func tensorQuantizations(ggml *llm.GGML) map[string]string {
+	params := make(map[string]map[string]uint64)
+	for _, t := range ggml.Tensors().Items {
+		group := tensorGroup(t.Name)
+		if group == "" {
+			continue
+		}
+
+		n := uint64(1)
+		for _, d := range t.Shape {
+			n *= d
+		}
+
+		if params[group] == nil {
+			params[group] = make(map[string]uint64)
+		}
+		params[group][t.TypeName()] += n
+	}
+
+	quantizations := make(map[string]string, len(params))
+	for group, types := range params {
+		var most uint64
+		for _, name := range slices.Sorted(maps.Keys(types)) {
+			if types[name] > most {
+				quantizations[group], most = name, types[name]
+			}
+		}
+	}
+
+	return quantizations
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
+)
+
+// checkModelsDir reports whether the blobs directory exists, is writable and
+// has at least OLLAMA_MIN_FREE_SPACE bytes free.
+func checkModelsDir() api.ReadyResponse {
+	r := api.ReadyResponse{Status: "not ready", Required: envconfig.MinFreeSpace()}
+
+	p, err := GetBlobsPath("")
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Path = p
+
+	f, err := os.CreateTemp(p, "ready-")
+	if err != nil {
+		r.Error = fmt.Sprintf("models directory isn't writable: %v", err)
+		return r
+	}
+	f.Close()
+	os.Remove(f.Name())
+	r.Writable = true
+
+	r.Free, err = diskFree(p)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	if r.Free < r.Required {
+		r.Error = fmt.Sprintf("models directory has %s free, at least %s is required", format.HumanBytes2(r.Free), format.HumanBytes2(r.Required))
+		return r
+	}
+
+	r.Status = "ready"
+	return r
+}
+
+// ReadyHandler reports whether the models directory is usable, responding
+// with 503 Service Unavailable when it isn't.
+func (s *Server) ReadyHandler(c *gin.Context) {
+	r := checkModelsDir()
+	if r.Status != "ready" {
+		slog.Warn("models directory isn't ready", "path", r.Path, "error", r.Error)
+		c.JSON(http.StatusServiceUnavailable, r)
+		return
+	}
+
+	c.JSON(http.StatusOK, r)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestReadyHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ready := func(t *testing.T) (int, api.ReadyResponse) {
+		t.Helper()
+
+		var s Server
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/ready", nil)
+		s.ReadyHandler(c)
+
+		var r api.ReadyResponse
+		if err := json.NewDecoder(w.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+
+		return w.Code, r
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		p := t.TempDir()
+		t.Setenv("OLLAMA_MODELS", p)
+		t.Setenv("OLLAMA_MIN_FREE_SPACE", "0")
+
+		code, r := ready(t)
+		if code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %+v", code, r)
+		}
+
+		if r.Status != "ready" || !r.Writable || r.Path != filepath.Join(p, "blobs") {
+			t.Errorf("unexpected response %+v", r)
+		}
+	})
+
+	t.Run("not enough space", func(t *testing.T) {
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+		t.Setenv("OLLAMA_MIN_FREE_SPACE", strconv.FormatUint(1<<63, 10))
+
+		code, r := ready(t)
+		if code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status code 503, actual %d: %+v", code, r)
+		}
+
+		if !r.Writable || !strings.Contains(r.Error, "is required") {
+			t.Errorf("unexpected response %+v", r)
+		}
+	})
+
+	t.Run("not writable", func(t *testing.T) {
+		if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+			t.Skip("directory permissions aren't enforced")
+		}
+
+		p := t.TempDir()
+		t.Setenv("OLLAMA_MODELS", p)
+		t.Setenv("OLLAMA_MIN_FREE_SPACE", "0")
+
+		blobs := filepath.Join(p, "blobs")
+		if err := os.Mkdir(blobs, 0o555); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.Chmod(blobs, 0o755) })
+
+		code, r := ready(t)
+		if code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status code 503, actual %d: %+v", code, r)
+		}
+
+		if r.Writable || !strings.Contains(r.Error, "isn't writable") {
+			t.Errorf("unexpected response %+v", r)
+		}
+	})
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+	"github.com/ollama/ollama/types/model"
+)
+
+// RecoverHandler reports the models whose blobs no manifest references, and
+// writes their manifests if the request asks to. It's how models are
+// recovered after their manifests are lost, e.g. to a partial restore.
+func (s *Server) RecoverHandler(c *gin.Context) {
+	var r api.RecoverRequest
+	if err := c.ShouldBindJSON(&r); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	models, err := recoverModels(c.Request.Context(), r.Write)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.RecoverResponse{Models: models})
+}
+
+// orphanedBlobs returns the digests of the blobs which aren't used by any
+// manifest or pinned by a create in flight, in order.
+func orphanedBlobs() ([]string, error) {
+	p, err := GetBlobsPath("")
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := make(map[string]struct{})
+	for _, entry := range entries {
+		digest := strings.Replace(entry.Name(), "-", ":", 1)
+		if _, err := GetBlobsPath(digest); err != nil {
+			// partial downloads and uploads
+			continue
+		}
+
+		digests[digest] = struct{}{}
+	}
+
+	if err := removeUsedBlobs(digests); err != nil {
+		return nil, err
+	}
+
+	var orphaned []string
+	for digest := range digests {
+		if !blobPinned(digest) {
+			orphaned = append(orphaned, digest)
+		}
+	}
+
+	slices.Sort(orphaned)
+	return orphaned, nil
+}
+
+// recoverModels finds the orphaned blobs which hold a model and names a
+// model for each of them from its metadata. If write is set, their
+// manifests are written with the model's detected template, if any, as a
+// create from the blob would.
+//
+// Adapters, projectors and the parts of split models can't be told apart
+// from the model they belong to, so they're left to be pruned.
+func recoverModels(ctx context.Context, write bool) ([]api.RecoveredModel, error) {
+	digests, err := orphanedBlobs()
+	if err != nil {
+		return nil, err
+	}
+
+	var models []api.RecoveredModel
+	for _, digest := range digests {
+		ggml, err := readRecoverableModel(digest)
+		if err != nil {
+			return nil, err
+		} else if ggml == nil {
+			continue
+		}
+
+		name := recoveredName(ggml.KV(), digest)
+		if _, err := ParseNamedManifest(name); err == nil {
+			// the blob was recovered before and the model since replaced
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		m := api.RecoveredModel{
+			Model:         name.DisplayShortest(),
+			Digest:        digest,
+			Architecture:  ggml.KV().Architecture(),
+			ParameterSize: parameterSize(ggml),
+			Quantization:  ggml.KV().FileType().String(),
+		}
+
+		if s := ggml.KV().ChatTemplate(); s != "" {
+			if t, err := template.Named(s); err == nil {
+				m.Template = t.Name
+			}
+		}
+
+		if write {
+			if err := writeRecoveredModel(ctx, name, digest); err != nil {
+				return nil, fmt.Errorf("recovering %s: %w", digest, err)
+			}
+
+			m.Written = true
+		}
+
+		models = append(models, m)
+	}
+
+	return models, nil
+}
+
+// readRecoverableModel decodes the blob of digest if it holds a model which
+// can be recovered, or returns nil if it doesn't.
+func readRecoverableModel(digest string) (*llm.GGML, error) {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	contentType, err := detectContentType(io.NewSectionReader(f, 0, 512))
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "gguf" {
+		return nil, nil
+	}
+
+	// a model the runner can't load is left for a newer version to recover
+	if v, err := llm.ReadGGUFVersion(io.NewSectionReader(f, 0, 8)); err != nil || llm.CheckGGUFVersion(v) != nil {
+		return nil, nil
+	}
+
+	ggml, _, err := llm.DecodeGGML(f, 0)
+	if err != nil {
+		// a blob which only looks like a model isn't recoverable
+		return nil, nil
+	}
+
+	kv := ggml.KV()
+	if isAdapterKV(kv) || kv.Kind() == "projector" || kv.SplitCount() > 1 {
+		return nil, nil
+	}
+
+	if _, ok := kv[fmt.Sprintf("%s.vision.block_count", kv.Architecture())]; ok {
+		return nil, nil
+	}
+
+	return ggml, nil
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// recoveredName names the model in the blob of digest after its metadata,
+// e.g. "recovered/llama-3.2-3b-instruct:<digest prefix>". Recovered models
+// are kept in their own namespace so they're easy to find and rename.
+func recoveredName(kv llm.KV, digest string) model.Name {
+	general, _ := kv["general.name"].(string)
+	s := strings.ToLower(cmp.Or(general, kv.Architecture()))
+	s = strings.Trim(invalidNameChars.ReplaceAllString(s, "-"), "-.")
+	if len(s) > 64 {
+		s = s[:64]
+	}
+
+	_, sum, _ := strings.Cut(digest, ":")
+	tag := sum[:min(12, len(sum))]
+
+	name := model.ParseName("recovered/" + s + ":" + tag)
+	if !name.IsValid() {
+		name = model.ParseName("recovered/model:" + tag)
+	}
+
+	return name
+}
+
+// writeRecoveredModel writes the manifest of the model named name from the
+// blob of digest. The model's layers are detected as they are by a create
+// from the blob, but it isn't quantized or otherwise changed.
+func writeRecoveredModel(ctx context.Context, name model.Name, digest string) error {
+	// the template's blobs are written before the manifest which uses them
+	pins := pinBlobs()
+	defer pins.release()
+
+	fn := func(api.ProgressResponse) {}
+	baseLayers, err := ggufLayers(ctx, digest, fn)
+	if err != nil {
+		return err
+	}
+
+	baseLayers, err = detectChatTemplate(ctx, baseLayers)
+	if err != nil {
+		return err
+	}
+
+	config := ConfigV2{
+		OS:           "linux",
+		Architecture: "amd64",
+		RootFS: RootFS{
+			Type: "layers",
+		},
+	}
+
+	var layers []Layer
+	for _, layer := range baseLayers {
+		if layer.GGML != nil {
+			config.ModelFormat = cmp.Or(config.ModelFormat, layer.GGML.Name())
+			config.ModelType = cmp.Or(config.ModelType, parameterSize(layer.GGML))
+			config.FileType = cmp.Or(config.FileType, layer.GGML.KV().FileType().String())
+		}
+
+		layers = append(layers, layer.Layer)
+	}
+
+	config.ModelFamilies = modelFamilies(baseLayers)
+	if len(config.ModelFamilies) > 0 {
+		config.ModelFamily = config.ModelFamilies[0]
+	}
+
+	configLayer, err := createConfigLayer(layers, config, sha256Digester)
+	if err != nil {
+		return err
+	}
+
+	return WriteManifest(name, *configLayer, layers)
+}
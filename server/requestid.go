@@ -0,0 +1,31 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+)
+
+// requestIDHeader is the header a client sets to correlate its request with
+// the server's logs. A request ID is generated when it isn't set.
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns the default logger with the request ID of ctx, if
+// any, attached to every entry.
+func requestLogger(ctx context.Context) *slog.Logger {
+	if id := requestID(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+
+	return slog.Default()
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// rootFSTypes are the types of the root filesystem in a model's config. Only
+// "layers", where each layer is a blob and a diff ID of the root filesystem,
+// is supported; other types need a storage backend which reads them.
+var rootFSTypes = []string{"layers"}
+
+var errUnknownRootFSType = errors.New("unknown rootfs type")
+
+// parseRootFSType returns the root filesystem type named name, or the
+// default if name is empty.
+func parseRootFSType(name string) (string, error) {
+	if name == "" {
+		return rootFSTypes[0], nil
+	}
+
+	if !slices.Contains(rootFSTypes, name) {
+		return "", fmt.Errorf("%w %q, expected one of %s", errUnknownRootFSType, name, strings.Join(rootFSTypes, ", "))
+	}
+
+	return name, nil
+}
@@ -11,6 +11,7 @@ import (
 	"io"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"math"
 	"net"
 	"net/http"
@@ -19,6 +20,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -30,6 +32,7 @@ import (
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/discover"
 	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/model/mllama"
 	"github.com/ollama/ollama/openai"
@@ -63,9 +66,32 @@ var (
 	errBadTemplate = errors.New("template error")
 )
 
-func modelOptions(model *Model, requestOpts map[string]interface{}) (api.Options, error) {
+func modelOptions(model *Model, backend string, caps []Capability, requestOpts map[string]interface{}) (api.Options, error) {
+	modelOpts := maps.Clone(model.Options)
+	for _, k := range modelParameters {
+		delete(modelOpts, k)
+	}
+
 	opts := api.DefaultOptions()
-	if err := opts.FromMap(model.Options); err != nil {
+	if err := opts.FromMap(modelOpts); err != nil {
+		return api.Options{}, err
+	}
+
+	contexts, err := capabilityContexts(model.Options["capability_num_ctx"])
+	if err != nil {
+		return api.Options{}, err
+	}
+
+	if n := capabilityContext(contexts, caps); n > 0 {
+		opts.NumCtx = n
+	}
+
+	backendOpts, err := backendParameters(model.Options["backends"])
+	if err != nil {
+		return api.Options{}, err
+	}
+
+	if err := opts.FromMap(backendOpts[backend]); err != nil {
 		return api.Options{}, err
 	}
 
@@ -92,11 +118,15 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capabil
 		return nil, nil, nil, fmt.Errorf("%s %w", name, err)
 	}
 
-	opts, err := modelOptions(model, requestOpts)
+	opts, err := modelOptions(model, s.sched.backend(), caps, requestOpts)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
+	if keepAlive == nil {
+		keepAlive = model.KeepAlive()
+	}
+
 	runnerCh, errCh := s.sched.GetRunner(ctx, model, opts, keepAlive)
 	var runner *runnerRef
 	select {
@@ -171,6 +201,9 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	if req.Suffix != "" {
 		caps = append(caps, CapabilityInsert)
 	}
+	if len(req.Images) > 0 {
+		caps = append(caps, CapabilityVision)
+	}
 
 	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
@@ -239,7 +272,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			}
 		}
 
-		var values template.Values
+		values := template.Values{Think: m.think(req.Think)}
 		if req.Suffix != "" {
 			values.Prompt = prompt
 			values.Suffix = req.Suffix
@@ -247,8 +280,8 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			var msgs []api.Message
 			if req.System != "" {
 				msgs = append(msgs, api.Message{Role: "system", Content: req.System})
-			} else if m.System != "" {
-				msgs = append(msgs, api.Message{Role: "system", Content: m.System})
+			} else if system := m.system(req.Locale); system != "" {
+				msgs = append(msgs, api.Message{Role: "system", Content: system})
 			}
 
 			if req.Context == nil {
@@ -292,10 +325,12 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		// TODO (jmorganca): avoid building the response twice both here and below
 		var sb strings.Builder
 		defer close(ch)
+		format, grammar := m.format(req.Format)
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
 			Prompt:  prompt,
 			Images:  images,
-			Format:  req.Format,
+			Format:  format,
+			Grammar: grammar,
 			Options: opts,
 		}, func(cr llm.CompletionResponse) {
 			res := api.GenerateResponse{
@@ -591,7 +626,7 @@ func (s *Server) PullHandler(c *gin.Context) {
 		defer cancel()
 
 		if err := PullModel(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
-			ch <- gin.H{"error": err.Error()}
+			fn(api.ProgressResponse{Error: err.Error()})
 		}
 	}()
 
@@ -641,12 +676,12 @@ func (s *Server) PushHandler(c *gin.Context) {
 
 		name, err := getExistingName(model.ParseName(mname))
 		if err != nil {
-			ch <- gin.H{"error": err.Error()}
+			fn(api.ProgressResponse{Error: err.Error()})
 			return
 		}
 
 		if err := PushModel(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
-			ch <- gin.H{"error": err.Error()}
+			fn(api.ProgressResponse{Error: err.Error()})
 		}
 	}()
 
@@ -786,7 +821,7 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 		ParentModel:       m.ParentModel,
 		Format:            m.Config.ModelFormat,
 		Family:            m.Config.ModelFamily,
-		Families:          m.Config.ModelFamilies,
+		Families:          uniqueFamilies(m.Config.ModelFamilies),
 		ParameterSize:     m.Config.ModelType,
 		QuantizationLevel: m.Config.FileType,
 	}
@@ -806,18 +841,57 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 	}
 
 	resp := &api.ShowResponse{
-		License:    strings.Join(m.License, "\n"),
-		System:     m.System,
-		Template:   m.Template.String(),
-		Details:    modelDetails,
-		Messages:   msgs,
-		ModifiedAt: manifest.fi.ModTime(),
+		License:       strings.Join(m.License, "\n"),
+		System:        m.System,
+		Systems:       m.Systems,
+		Template:      m.Template.String(),
+		Details:       modelDetails,
+		Messages:      msgs,
+		ModifiedAt:    manifest.fi.ModTime(),
+		SpecialTokens: m.Config.SpecialTokens,
+		Safety:        m.Safety,
+		Card:          m.Card,
+		Grammar:       m.Grammar,
+		Schema:        m.Schema,
+		Format:        m.Format,
+		SignedBy:      m.SignedBy,
+	}
+
+	for _, derived := range m.Config.DerivedFrom {
+		resp.DerivedFrom = append(resp.DerivedFrom, derived.String())
+	}
+
+	for _, layer := range manifest.Layers {
+		if layer.Source != "" {
+			resp.Sources = append(resp.Sources, api.LayerSource{
+				MediaType: layer.MediaType,
+				Digest:    layer.Digest,
+				Source:    layer.Source,
+			})
+		}
 	}
 
 	var params []string
 	cs := 30
 	for k, v := range m.Options {
 		switch val := v.(type) {
+		case map[string]any:
+			if k == "capability_num_ctx" {
+				// shown as <capability>:num_ctx
+				contexts, _ := capabilityContexts(val)
+				for _, c := range slices.Sorted(maps.Keys(contexts)) {
+					params = append(params, fmt.Sprintf("%-*s %#v", cs, string(c)+":num_ctx", contexts[c]))
+				}
+				break
+			}
+
+			// backend specific parameters are shown as <backend>:<parameter>
+			backends, _ := backendParameters(val)
+			for _, backend := range slices.Sorted(maps.Keys(backends)) {
+				for _, name := range slices.Sorted(maps.Keys(backends[backend])) {
+					params = append(params, fmt.Sprintf("%-*s %#v", cs, backend+":"+name, backends[backend][name]))
+				}
+			}
 		case []interface{}:
 			for _, nv := range val {
 				params = append(params, fmt.Sprintf("%-*s %#v", cs, k, nv))
@@ -884,6 +958,12 @@ func getKVData(digest string, verbose bool) (llm.KV, error) {
 }
 
 func (s *Server) ListHandler(c *gin.Context) {
+	drafts, err := strconv.ParseBool(cmp.Or(c.Query("drafts"), "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "drafts must be true or false"})
+		return
+	}
+
 	ms, err := Manifests(true)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -908,6 +988,10 @@ func (s *Server) ListHandler(c *gin.Context) {
 			}
 		}
 
+		if cf.Draft && !drafts {
+			continue
+		}
+
 		// tag should never be masked
 		models = append(models, api.ListModelResponse{
 			Model:      n.DisplayShortest(),
@@ -922,6 +1006,7 @@ func (s *Server) ListHandler(c *gin.Context) {
 				ParameterSize:     cf.ModelType,
 				QuantizationLevel: cf.FileType,
 			},
+			Draft: cf.Draft,
 		})
 	}
 
@@ -1025,7 +1110,9 @@ func (s *Server) CreateBlobHandler(c *gin.Context) {
 		return
 	}
 
-	layer, err := NewLayer(c.Request.Body, "")
+	// GetBlobsPath has already checked the digest
+	d, _ := digesterFor(c.Param("digest"))
+	layer, err := newLayer(c.Request.Body, "", d)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1036,6 +1123,11 @@ func (s *Server) CreateBlobHandler(c *gin.Context) {
 		return
 	}
 
+	if err := recordUploadedBlob(layer.Digest); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.Status(http.StatusCreated)
 }
 
@@ -1148,13 +1240,31 @@ func (s *Server) GenerateRoutes() http.Handler {
 	r.POST("/api/embed", s.EmbedHandler)
 	r.POST("/api/embeddings", s.EmbeddingsHandler)
 	r.POST("/api/create", s.CreateHandler)
+	r.POST("/api/create/batch", s.CreateBatchHandler)
+	r.POST("/api/create/cancel", s.CancelCreateHandler)
+	r.POST("/api/create/preview", s.CreatePreviewHandler)
 	r.POST("/api/push", s.PushHandler)
 	r.POST("/api/copy", s.CopyHandler)
+	r.POST("/api/rollback", s.RollbackHandler)
+	r.POST("/api/protect", s.ProtectHandler)
+	r.POST("/api/promote", s.PromoteHandler)
+	r.POST("/api/diff", s.DiffHandler)
+	r.POST("/api/recover", s.RecoverHandler)
+	r.POST("/api/verify", s.VerifyHandler)
+	r.POST("/api/layers", s.LayersHandler)
+	r.POST("/api/template", s.TemplateHandler)
+	r.POST("/api/export", s.ExportHandler)
 	r.DELETE("/api/delete", s.DeleteHandler)
 	r.POST("/api/show", s.ShowHandler)
 	r.POST("/api/blobs/:digest", s.CreateBlobHandler)
 	r.HEAD("/api/blobs/:digest", s.HeadBlobHandler)
+	r.POST("/api/uploads", s.StartUploadHandler)
+	r.PATCH("/api/uploads/:id", s.UploadChunkHandler)
+	r.GET("/api/uploads/:id", s.UploadStatusHandler)
+	r.DELETE("/api/uploads/:id", s.DeleteUploadHandler)
 	r.GET("/api/ps", s.PsHandler)
+	r.GET("/api/ready", s.ReadyHandler)
+	r.HEAD("/api/ready", s.ReadyHandler)
 
 	// Compatibility endpoints
 	r.POST("/v1/chat/completions", openai.ChatMiddleware(), s.ChatHandler)
@@ -1169,6 +1279,8 @@ func (s *Server) GenerateRoutes() http.Handler {
 		})
 
 		r.Handle(method, "/api/tags", s.ListHandler)
+		r.Handle(method, "/api/create/schema", s.CreateSchemaHandler)
+		r.Handle(method, "/api/quantizations", s.QuantizationsHandler)
 		r.Handle(method, "/api/version", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"version": version.Version})
 		})
@@ -1207,6 +1319,22 @@ func Serve(ln net.Listener) error {
 		return err
 	}
 
+	if err := removeCreateTemps(); err != nil {
+		slog.Warn("couldn't remove the intermediates of interrupted creates", "path", createTempDir(), "error", err)
+	}
+
+	if err := removeExpiredUploads(); err != nil {
+		slog.Warn("couldn't remove expired uploads", "error", err)
+	}
+
+	if err := removeExpiredCheckpoints(); err != nil {
+		slog.Warn("couldn't remove expired quantization checkpoints", "error", err)
+	}
+
+	if r := checkModelsDir(); r.Status != "ready" {
+		slog.Warn("models directory isn't ready, creates and pulls may fail", "path", r.Path, "writable", r.Writable, "free", format.HumanBytes2(r.Free), "error", r.Error)
+	}
+
 	if !envconfig.NoPrune() {
 		if _, err := Manifests(false); err != nil {
 			slog.Warn("corrupt manifests detected, skipping prune operation.  Re-pull or delete to clear", "error", err)
@@ -1280,20 +1408,11 @@ func waitForStream(c *gin.Context, ch chan interface{}) {
 	for resp := range ch {
 		switch r := resp.(type) {
 		case api.ProgressResponse:
-			if r.Status == "success" {
-				c.JSON(http.StatusOK, r)
-				return
-			}
-		case gin.H:
-			status, ok := r["status"].(int)
-			if !ok {
-				status = http.StatusInternalServerError
-			}
-			if errorMsg, ok := r["error"].(string); ok {
-				c.JSON(status, gin.H{"error": errorMsg})
+			if r.Error != "" {
+				c.JSON(cmp.Or(r.StatusCode, http.StatusInternalServerError), gin.H{"error": r.Error})
 				return
-			} else {
-				c.JSON(status, gin.H{"error": "unexpected error format in progress response"})
+			} else if r.Status == "success" {
+				c.JSON(http.StatusOK, r)
 				return
 			}
 		default:
@@ -1329,6 +1448,92 @@ func streamResponse(c *gin.Context, ch chan any) {
 	})
 }
 
+// streamJSONArrayResponse streams the values from ch as the elements of a
+// single JSON array for clients which don't accept NDJSON.
+func streamJSONArrayResponse(c *gin.Context, ch chan any) {
+	c.Header("Content-Type", "application/json")
+	first := true
+	c.Stream(func(w io.Writer) bool {
+		val, ok := <-ch
+		if !ok {
+			end := "\n]\n"
+			if first {
+				end = "[]\n"
+			}
+
+			if _, err := io.WriteString(w, end); err != nil {
+				slog.Info(fmt.Sprintf("streamJSONArrayResponse: w.Write failed with %s", err))
+			}
+
+			return false
+		}
+
+		sep := ",\n"
+		if first {
+			sep, first = "[\n", false
+		}
+
+		bts, err := json.Marshal(val)
+		if err != nil {
+			slog.Info(fmt.Sprintf("streamJSONArrayResponse: json.Marshal failed with %s", err))
+			// the array is still closed so the response is valid JSON, with
+			// the error as its last element
+			bts, _ = json.Marshal(gin.H{"error": err.Error()})
+			if _, err := w.Write(append([]byte(sep), append(bts, "\n]\n"...)...)); err != nil {
+				slog.Info(fmt.Sprintf("streamJSONArrayResponse: w.Write failed with %s", err))
+			}
+
+			return false
+		}
+
+		if _, err := w.Write(append([]byte(sep), bts...)); err != nil {
+			slog.Info(fmt.Sprintf("streamJSONArrayResponse: w.Write failed with %s", err))
+			return false
+		}
+
+		return true
+	})
+}
+
+// streamEventsResponse streams the values from ch as server-sent events for
+// browser clients. Each value is the data of an event, and a "done" event
+// follows the last. The done event's data is an empty object since browsers
+// don't dispatch events without data.
+func streamEventsResponse(c *gin.Context, ch chan any) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Stream(func(w io.Writer) bool {
+		val, ok := <-ch
+		if !ok {
+			if _, err := io.WriteString(w, "event: done\ndata: {}\n\n"); err != nil {
+				slog.Info(fmt.Sprintf("streamEventsResponse: w.Write failed with %s", err))
+			}
+
+			return false
+		}
+
+		bts, err := json.Marshal(val)
+		if err != nil {
+			slog.Info(fmt.Sprintf("streamEventsResponse: json.Marshal failed with %s", err))
+			// the stream still ends with an event so clients don't wait
+			// for one, with the error in place of done
+			bts, _ = json.Marshal(gin.H{"error": err.Error()})
+			if _, err := fmt.Fprintf(w, "event: error\ndata: %s\n\n", bts); err != nil {
+				slog.Info(fmt.Sprintf("streamEventsResponse: w.Write failed with %s", err))
+			}
+
+			return false
+		}
+
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", bts); err != nil {
+			slog.Info(fmt.Sprintf("streamEventsResponse: w.Write failed with %s", err))
+			return false
+		}
+
+		return true
+	})
+}
+
 func (s *Server) PsHandler(c *gin.Context) {
 	models := []api.ProcessModelResponse{}
 
@@ -1412,6 +1617,9 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	if len(req.Tools) > 0 {
 		caps = append(caps, CapabilityTools)
 	}
+	if slices.ContainsFunc(req.Messages, func(m api.Message) bool { return len(m.Images) > 0 }) {
+		caps = append(caps, CapabilityVision)
+	}
 
 	name := model.ParseName(req.Model)
 	if !name.IsValid() {
@@ -1447,11 +1655,11 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	}
 
 	msgs := append(m.Messages, req.Messages...)
-	if req.Messages[0].Role != "system" && m.System != "" {
-		msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
+	if system := m.system(req.Locale); req.Messages[0].Role != "system" && system != "" {
+		msgs = append([]api.Message{{Role: "system", Content: system}}, msgs...)
 	}
 
-	prompt, images, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, req.Tools)
+	prompt, images, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, req.Tools, m.think(req.Think))
 	if err != nil {
 		slog.Error("chat prompt error", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -1465,10 +1673,12 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		defer close(ch)
 		var sb strings.Builder
 		var toolCallIndex int = 0
+		format, grammar := m.format(req.Format)
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
 			Prompt:  prompt,
 			Images:  images,
-			Format:  req.Format,
+			Format:  format,
+			Grammar: grammar,
 			Options: opts,
 		}, func(r llm.CompletionResponse) {
 			res := api.ChatResponse{
@@ -1,25 +1,50 @@
 package server
 
 import (
+	"archive/tar"
 	"bytes"
 	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"maps"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/app/lifecycle"
+	"github.com/ollama/ollama/discover"
 	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
 )
 
 var stream bool = false
@@ -85,6 +110,7 @@ func createRequest(t *testing.T, fn func(*gin.Context), body any) *httptest.Resp
 	}
 
 	c.Request = &http.Request{
+		URL:  &url.URL{},
 		Body: io.NopCloser(&b),
 	}
 
@@ -105,6 +131,25 @@ func checkFileExists(t *testing.T, p string, expect []string) {
 	}
 }
 
+// configBlob returns the path of the config blob of the model name. The
+// configs of models created from others record their source's manifest
+// digest, which depends on where the test's files are.
+func configBlob(t *testing.T, name string) string {
+	t.Helper()
+
+	m, err := ParseNamedManifest(model.ParseName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := GetBlobsPath(m.Config.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
 func TestCreateFromBin(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -131,8 +176,8 @@ func TestCreateFromBin(t *testing.T) {
 	})
 
 	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
+		filepath.Join(p, "blobs", "sha256-4ea706c4344442ea051050b7b96fca607db73210ddd28be89af6e72fcfc9c8af"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
-		filepath.Join(p, "blobs", "sha256-ca239d7bd8ea90e4a5d2e6bf88f8d74a47b14336e73eb4e18bed4dd325018116"),
 	})
 }
 
@@ -174,10 +219,74 @@ func TestCreateFromModel(t *testing.T) {
 		filepath.Join(p, "manifests", "registry.ollama.ai", "library", "test2", "latest"),
 	})
 
-	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
+	expect := []string{
+		filepath.Join(p, "blobs", "sha256-4ea706c4344442ea051050b7b96fca607db73210ddd28be89af6e72fcfc9c8af"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
-		filepath.Join(p, "blobs", "sha256-ca239d7bd8ea90e4a5d2e6bf88f8d74a47b14336e73eb4e18bed4dd325018116"),
+		configBlob(t, "test2"),
+	}
+	slices.Sort(expect)
+	checkFileExists(t, filepath.Join(p, "blobs", "*"), expect)
+}
+
+func TestCreateFromModelDigest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
 	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	m, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := strings.Repeat("0", 64)
+	cases := []struct {
+		name   string
+		from   string
+		strip  bool
+		status int
+		expect string
+	}{
+		{"pinned", "test@sha256:" + m.digest, false, http.StatusOK, ""},
+		{"pinned with tag", "test:latest@sha256-" + strings.ToUpper(m.digest), false, http.StatusOK, ""},
+		{"pinned new layers", "test@sha256:" + m.digest, true, http.StatusOK, ""},
+		{"mismatch", "test@sha256:" + wrong, false, http.StatusBadRequest, "model digest doesn't match: test:latest is sha256:" + m.digest + ", not sha256:" + wrong},
+		{"mismatch new layers", "test@sha256:" + wrong, true, http.StatusBadRequest, "model digest doesn't match"},
+		{"short digest", "test@sha256:" + m.digest[:12], false, http.StatusBadRequest, "invalid digest format"},
+		{"other algorithm", "test@sha512:" + strings.Repeat(m.digest, 2), false, http.StatusBadRequest, "invalid digest format"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Name:          "test2",
+				From:          tt.from,
+				StripMetadata: tt.strip,
+				Stream:        &stream,
+			})
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status code %d, actual %d: %s", tt.status, w.Code, w.Body.String())
+			}
+
+			if !strings.Contains(w.Body.String(), tt.expect) {
+				t.Errorf("expected %q, actual %s", tt.expect, w.Body.String())
+			}
+		})
+	}
 }
 
 func TestCreateRemovesLayers(t *testing.T) {
@@ -204,9 +313,9 @@ func TestCreateRemovesLayers(t *testing.T) {
 	})
 
 	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
+		filepath.Join(p, "blobs", "sha256-917cfc78a383e478d22daa3e37e65fb7fbf5a783ff85b0e3a6a8309eb8e0b382"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
 		filepath.Join(p, "blobs", "sha256-b507b9c2f6ca642bffcd06665ea7c91f235fd32daeefdf875a0f938db05fb315"),
-		filepath.Join(p, "blobs", "sha256-bc80b03733773e0728011b2f4adf34c458b400e1aad48cb28d61170f3a2ad2d6"),
 	})
 
 	w = createRequest(t, s.CreateHandler, api.CreateRequest{
@@ -225,8 +334,8 @@ func TestCreateRemovesLayers(t *testing.T) {
 	})
 
 	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
-		filepath.Join(p, "blobs", "sha256-8f2c2167d789c6b2302dff965160fa5029f6a24096d262c1cbb469f21a045382"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
+		filepath.Join(p, "blobs", "sha256-ad9dd0687ded0b7e8ca2fb13bfb8062cc29d2b84c2b0c60111339a7d15bde92e"),
 		filepath.Join(p, "blobs", "sha256-fe7ac77b725cda2ccad03f88a880ecdfd7a33192d6cae08fce2c0ee1455991ed"),
 	})
 }
@@ -255,7 +364,7 @@ func TestCreateUnsetsSystem(t *testing.T) {
 	})
 
 	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
-		filepath.Join(p, "blobs", "sha256-8585df945d1069bc78b79bd10bb73ba07fbc29b0f5479a31a601c0d12731416e"),
+		filepath.Join(p, "blobs", "sha256-4636be5c9b9c693c9e52e0b2e56b53cb1e560d2d5581d000f39c0175b3ed9715"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
 		filepath.Join(p, "blobs", "sha256-f29e82a8284dbdf5910b1555580ff60b04238b8da9d5e51159ada67a4d0d5851"),
 	})
@@ -276,8 +385,8 @@ func TestCreateUnsetsSystem(t *testing.T) {
 	})
 
 	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
+		filepath.Join(p, "blobs", "sha256-4ea706c4344442ea051050b7b96fca607db73210ddd28be89af6e72fcfc9c8af"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
-		filepath.Join(p, "blobs", "sha256-ca239d7bd8ea90e4a5d2e6bf88f8d74a47b14336e73eb4e18bed4dd325018116"),
 	})
 }
 
@@ -309,9 +418,9 @@ func TestCreateMergeParameters(t *testing.T) {
 	})
 
 	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
-		filepath.Join(p, "blobs", "sha256-1d0ad71299d48c2fb7ae2b98e683643e771f8a5b72be34942af90d97a91c1e37"),
-		filepath.Join(p, "blobs", "sha256-4a384beaf47a9cbe452dfa5ab70eea691790f3b35a832d12933a1996685bf2b6"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
+		filepath.Join(p, "blobs", "sha256-bc229e7136c11d102ca3f1568b2ac0a88259a416a723f5b96bebc9a6dd3fc648"),
+		filepath.Join(p, "blobs", "sha256-f2deb85a20e6fadbce6926ec072e718bcd22ca6e24dbf4a91ef2ccd806f13e85"),
 	})
 
 	// in order to merge parameters, the second model must be created FROM the first
@@ -350,15 +459,17 @@ func TestCreateMergeParameters(t *testing.T) {
 		t.Logf("Contents of %s:\n%s", entry.Name(), string(content))
 	}
 
-	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
-		filepath.Join(p, "blobs", "sha256-1d0ad71299d48c2fb7ae2b98e683643e771f8a5b72be34942af90d97a91c1e37"),
-		filepath.Join(p, "blobs", "sha256-4a384beaf47a9cbe452dfa5ab70eea691790f3b35a832d12933a1996685bf2b6"),
-		filepath.Join(p, "blobs", "sha256-4cd9d4ba6b734d9b4cbd1e5caa60374c00722e993fce5e1e2d15a33698f71187"),
+	blobs := []string{
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
-		filepath.Join(p, "blobs", "sha256-e29a7b3c47287a2489c895d21fe413c20f859a85d20e749492f52a838e36e1ba"),
-	})
+		filepath.Join(p, "blobs", "sha256-bc229e7136c11d102ca3f1568b2ac0a88259a416a723f5b96bebc9a6dd3fc648"),
+		filepath.Join(p, "blobs", "sha256-c0c455da9970c49b8025ed3bcc8cad4b0f078e44c165dc11151e8b28e047f8b4"),
+		filepath.Join(p, "blobs", "sha256-f2deb85a20e6fadbce6926ec072e718bcd22ca6e24dbf4a91ef2ccd806f13e85"),
+		configBlob(t, "test2"),
+	}
+	slices.Sort(blobs)
+	checkFileExists(t, filepath.Join(p, "blobs", "*"), blobs)
 
-	actual, err := os.ReadFile(filepath.Join(p, "blobs", "sha256-e29a7b3c47287a2489c895d21fe413c20f859a85d20e749492f52a838e36e1ba"))
+	actual, err := os.ReadFile(filepath.Join(p, "blobs", "sha256-c0c455da9970c49b8025ed3bcc8cad4b0f078e44c165dc11151e8b28e047f8b4"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -393,15 +504,17 @@ func TestCreateMergeParameters(t *testing.T) {
 		filepath.Join(p, "manifests", "registry.ollama.ai", "library", "test2", "latest"),
 	})
 
-	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
-		filepath.Join(p, "blobs", "sha256-12f58bb75cb3042d69a7e013ab87fb3c3c7088f50ddc62f0c77bd332f0d44d35"),
-		filepath.Join(p, "blobs", "sha256-1d0ad71299d48c2fb7ae2b98e683643e771f8a5b72be34942af90d97a91c1e37"),
-		filepath.Join(p, "blobs", "sha256-257aa726584f24970a4f240765e75a7169bfbe7f4966c1f04513d6b6c860583a"),
-		filepath.Join(p, "blobs", "sha256-4a384beaf47a9cbe452dfa5ab70eea691790f3b35a832d12933a1996685bf2b6"),
+	blobs = []string{
+		filepath.Join(p, "blobs", "sha256-43f04d389c55f9402526208725328b37e7b43749f8d2e6b1d0c9345827fccd48"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
-	})
+		filepath.Join(p, "blobs", "sha256-bc229e7136c11d102ca3f1568b2ac0a88259a416a723f5b96bebc9a6dd3fc648"),
+		filepath.Join(p, "blobs", "sha256-f2deb85a20e6fadbce6926ec072e718bcd22ca6e24dbf4a91ef2ccd806f13e85"),
+		configBlob(t, "test2"),
+	}
+	slices.Sort(blobs)
+	checkFileExists(t, filepath.Join(p, "blobs", "*"), blobs)
 
-	actual, err = os.ReadFile(filepath.Join(p, "blobs", "sha256-12f58bb75cb3042d69a7e013ab87fb3c3c7088f50ddc62f0c77bd332f0d44d35"))
+	actual, err = os.ReadFile(filepath.Join(p, "blobs", "sha256-43f04d389c55f9402526208725328b37e7b43749f8d2e6b1d0c9345827fccd48"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -453,9 +566,9 @@ func TestCreateReplacesMessages(t *testing.T) {
 	})
 
 	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
-		filepath.Join(p, "blobs", "sha256-298baeaf6928a60cf666d88d64a1ba606feb43a2865687c39e40652e407bffc4"),
+		filepath.Join(p, "blobs", "sha256-94f56190c2ae9a3621e9a186b7c8b6aee96f252204f928c7cc2079032be28f31"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
-		filepath.Join(p, "blobs", "sha256-e0e27d47045063ccb167ae852c51d49a98eab33fabaee4633fdddf97213e40b5"),
+		filepath.Join(p, "blobs", "sha256-b07e3f98c9965511a0bb39bf6539c79a5cdc689f672b1307580f2413be33bc4d"),
 	})
 
 	w = createRequest(t, s.CreateHandler, api.CreateRequest{
@@ -488,20 +601,22 @@ func TestCreateReplacesMessages(t *testing.T) {
 	})
 
 	// Old layers will not have been pruned
-	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
-		filepath.Join(p, "blobs", "sha256-298baeaf6928a60cf666d88d64a1ba606feb43a2865687c39e40652e407bffc4"),
+	blobs := []string{
+		filepath.Join(p, "blobs", "sha256-94f56190c2ae9a3621e9a186b7c8b6aee96f252204f928c7cc2079032be28f31"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
-		filepath.Join(p, "blobs", "sha256-a60ecc9da299ec7ede453f99236e5577fd125e143689b646d9f0ddc9971bf4db"),
-		filepath.Join(p, "blobs", "sha256-e0e27d47045063ccb167ae852c51d49a98eab33fabaee4633fdddf97213e40b5"),
-		filepath.Join(p, "blobs", "sha256-f4e2c3690efef1b4b63ba1e1b2744ffeb6a7438a0110b86596069f6d9999c80b"),
-	})
+		filepath.Join(p, "blobs", "sha256-d1a8eb72ba0f7f23c6f69f3b79efbd90c49a01fd0600e455b25a1053795f23c0"),
+		filepath.Join(p, "blobs", "sha256-b07e3f98c9965511a0bb39bf6539c79a5cdc689f672b1307580f2413be33bc4d"),
+		configBlob(t, "test2"),
+	}
+	slices.Sort(blobs)
+	checkFileExists(t, filepath.Join(p, "blobs", "*"), blobs)
 
 	type message struct {
 		Role    string `json:"role"`
 		Content string `json:"content"`
 	}
 
-	f, err := os.Open(filepath.Join(p, "blobs", "sha256-a60ecc9da299ec7ede453f99236e5577fd125e143689b646d9f0ddc9971bf4db"))
+	f, err := os.Open(filepath.Join(p, "blobs", "sha256-d1a8eb72ba0f7f23c6f69f3b79efbd90c49a01fd0600e455b25a1053795f23c0"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -548,8 +663,8 @@ func TestCreateTemplateSystem(t *testing.T) {
 	})
 
 	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
-		filepath.Join(p, "blobs", "sha256-2b5e330885117c82f3fd75169ea323e141070a2947c11ddb9f79ee0b01c589c1"),
 		filepath.Join(p, "blobs", "sha256-4c5f51faac758fecaff8db42f0b7382891a4d0c0bb885f7b86be88c814a7cc86"),
+		filepath.Join(p, "blobs", "sha256-971c0c3aa1f65715cf597460f0c46fa3c838268564339a70cc280cc2fe588fd8"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
 		filepath.Join(p, "blobs", "sha256-fe7ac77b725cda2ccad03f88a880ecdfd7a33192d6cae08fce2c0ee1455991ed"),
 	})
@@ -640,7 +755,7 @@ func TestCreateLicenses(t *testing.T) {
 
 	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
 		filepath.Join(p, "blobs", "sha256-2af71558e438db0b73a20beab92dc278a94e1bbe974c00c1a33e3ab62d53a608"),
-		filepath.Join(p, "blobs", "sha256-79a39c37536ddee29cbadd5d5e2dcba8ed7f03e431f626ff38432c1c866bb7e2"),
+		filepath.Join(p, "blobs", "sha256-4a71cfd195a6453f71dac85902b65f07281d7825565dbaa33328cf3d38645898"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
 		filepath.Join(p, "blobs", "sha256-e5dcffe836b6ec8a58e492419b550e65fb8cbdc308503979e5dacb33ac7ea3b7"),
 	})
@@ -686,13 +801,50 @@ func TestCreateDetectTemplate(t *testing.T) {
 		}
 
 		checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
-			filepath.Join(p, "blobs", "sha256-0d79f567714c62c048378f2107fb332dabee0135d080c302d884317da9433cc5"),
 			filepath.Join(p, "blobs", "sha256-35360843d0c84fb1506952a131bbef13cd2bb4a541251f22535170c05b56e672"),
+			filepath.Join(p, "blobs", "sha256-393ba96abccc7833b934644f553bf3a63f88d1b35f50c1c865dcf17554e83c1d"),
 			filepath.Join(p, "blobs", "sha256-553c4a3f747b3d22a4946875f1cc8ed011c2930d83f864a0c7265f9ec0a20413"),
-			filepath.Join(p, "blobs", "sha256-de3959f841e9ef6b4b6255fa41cb9e0a45da89c3066aa72bdd07a4747f848990"),
+			filepath.Join(p, "blobs", "sha256-b195a4b39f3386daac23b14bffa231db776492401bee56aeefd7f0f1f8608a16"),
 		})
 	})
 
+	t.Run("disabled", func(t *testing.T) {
+		_, digest := createBinFile(t, llm.KV{
+			"tokenizer.chat_template": "{{ bos_token }}{% for message in messages %}{{'<|' + message['role'] + '|>' + '\n' + message['content'] + '<|end|>\n' }}{% endfor %}{% if add_generation_prompt %}{{ '<|assistant|>\n' }}{% else %}{{ eos_token }}{% endif %}",
+		}, nil)
+
+		for _, tmpl := range []string{"", "{{ .Prompt }}"} {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Name:                "test",
+				Files:               map[string]string{"test.gguf": digest},
+				Template:            tmpl,
+				NoTemplateDetection: true,
+				Stream:              &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d", w.Code)
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tmpl == "" {
+				tmpl = "{{ .Prompt }}" // the default template
+			}
+
+			if m.Template.String() != tmpl {
+				t.Errorf("expected template %q, actual %q", tmpl, m.Template.String())
+			}
+
+			if len(m.Options) > 0 {
+				t.Errorf("expected no parameters, actual %v", m.Options)
+			}
+		}
+	})
+
 	t.Run("unmatched", func(t *testing.T) {
 		_, digest := createBinFile(t, nil, nil)
 		w := createRequest(t, s.CreateHandler, api.CreateRequest{
@@ -706,8 +858,8 @@ func TestCreateDetectTemplate(t *testing.T) {
 		}
 
 		checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
+			filepath.Join(p, "blobs", "sha256-4ea706c4344442ea051050b7b96fca607db73210ddd28be89af6e72fcfc9c8af"),
 			filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
-			filepath.Join(p, "blobs", "sha256-ca239d7bd8ea90e4a5d2e6bf88f8d74a47b14336e73eb4e18bed4dd325018116"),
 		})
 	})
 }
@@ -719,7 +871,11 @@ func TestDetectModelTypeFromFiles(t *testing.T) {
 			"model.gguf": digest,
 		}
 
-		modelType := detectModelTypeFromFiles(files)
+		modelType, err := detectModelTypeFromFiles(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+
 		if modelType != "gguf" {
 			t.Fatalf("expected model type 'gguf', got %q", modelType)
 		}
@@ -731,7 +887,11 @@ func TestDetectModelTypeFromFiles(t *testing.T) {
 			fmt.Sprintf("%x", digest): digest,
 		}
 
-		modelType := detectModelTypeFromFiles(files)
+		modelType, err := detectModelTypeFromFiles(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+
 		if modelType != "gguf" {
 			t.Fatalf("expected model type 'gguf', got %q", modelType)
 		}
@@ -742,7 +902,11 @@ func TestDetectModelTypeFromFiles(t *testing.T) {
 			"model.safetensors": "sha256:abc123",
 		}
 
-		modelType := detectModelTypeFromFiles(files)
+		modelType, err := detectModelTypeFromFiles(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+
 		if modelType != "safetensors" {
 			t.Fatalf("expected model type 'safetensors', got %q", modelType)
 		}
@@ -772,10 +936,14 @@ func TestDetectModelTypeFromFiles(t *testing.T) {
 			"model.bin": digest,
 		}
 
-		modelType := detectModelTypeFromFiles(files)
+		modelType, err := detectModelTypeFromFiles(files)
 		if modelType != "" {
 			t.Fatalf("expected empty model type for unsupported file, got %q", modelType)
 		}
+
+		if !errors.Is(err, errUnknownType) || !strings.Contains(err.Error(), "unrecognized file format: model.bin") {
+			t.Fatalf("expected unrecognized file format error, got %v", err)
+		}
 	})
 
 	t.Run("file with less than 4 bytes", func(t *testing.T) {
@@ -802,9 +970,7119 @@ func TestDetectModelTypeFromFiles(t *testing.T) {
 			"noext": digest,
 		}
 
-		modelType := detectModelTypeFromFiles(files)
+		modelType, err := detectModelTypeFromFiles(files)
 		if modelType != "" {
 			t.Fatalf("expected empty model type for small file, got %q", modelType)
 		}
+
+		if !errors.Is(err, errUnknownType) || !strings.Contains(err.Error(), "couldn't read noext") {
+			t.Fatalf("expected read error, got %v", err)
+		}
+	})
+
+	t.Run("missing blob", func(t *testing.T) {
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+		files := map[string]string{
+			"noext": fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("missing"))),
+		}
+
+		_, err := detectModelTypeFromFiles(files)
+		if !errors.Is(err, errUnknownType) || !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected open error, got %v", err)
+		}
+	})
+
+	t.Run("git lfs pointers", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+
+		p := t.TempDir()
+		t.Setenv("OLLAMA_MODELS", p)
+		var s Server
+
+		pointer := func(name string) string {
+			data := fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%x\nsize 1024\n", sha256.Sum256([]byte(name)))
+			digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(data)))
+			if err := os.MkdirAll(filepath.Join(p, "blobs"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(filepath.Join(p, "blobs", strings.Replace(digest, ":", "-", 1)), []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			return digest
+		}
+
+		// files which aren't pointers, such as ones outside LFS, aren't named
+		_, other := createBinFile(t, nil, nil)
+		files := map[string]string{
+			"model-00002-of-00002.safetensors": pointer("2"),
+			"model-00001-of-00002.safetensors": pointer("1"),
+			"model.gguf":                       other,
+		}
+
+		expect := "files are Git LFS pointers rather than their content: model-00001-of-00002.safetensors, model-00002-of-00002.safetensors; run `git lfs pull` in the model's repository to download them"
+		if _, err := detectModelTypeFromFiles(files); !errors.Is(err, errLFSPointer) || err.Error() != expect {
+			t.Fatalf("expected %q, got %v", expect, err)
+		}
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "test",
+			Files:  files,
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestClassifyFiles(t *testing.T) {
+	files := map[string]string{
+		"model-00001-of-00002.safetensors": "sha256:1",
+		"model-00002-of-00002.safetensors": "sha256:2",
+		"tokenizer.json":                   "sha256:3",
+		"tokenizer_config.json":            "sha256:4",
+		"config.json":                      "sha256:5",
+		"1_Pooling/config.json":            "sha256:6",
+		"generation_config.json":           "sha256:7",
+		"README.md":                        "sha256:8",
+	}
+
+	expect := map[string]string{
+		"model-00001-of-00002.safetensors": "model",
+		"model-00002-of-00002.safetensors": "model",
+		"tokenizer.json":                   "tokenizer",
+		"tokenizer_config.json":            "tokenizer",
+		"config.json":                      "config",
+		"1_Pooling/config.json":            "config",
+		"generation_config.json":           "ignored",
+		"README.md":                        "ignored",
+	}
+
+	if actual := classifyFiles(files, "safetensors"); !maps.Equal(actual, expect) {
+		t.Errorf("expected %v, actual %v", expect, actual)
+	}
+
+	t.Run("gguf", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+		var s Server
+
+		_, digest := createBinFile(t, nil, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:  "test",
+			Files: map[string]string{"test.gguf": digest},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		var detected *api.ProgressResponse
+		for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+			var resp api.ProgressResponse
+			if err := json.Unmarshal([]byte(line), &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Files != nil {
+				detected = &resp
+			}
+		}
+
+		if detected == nil || detected.Status != "detected gguf files" {
+			t.Fatalf("expected the detected files to be reported, actual %+v", detected)
+		}
+
+		if expect := map[string]string{"test.gguf": "model"}; !maps.Equal(detected.Files, expect) {
+			t.Errorf("expected %v, actual %v", expect, detected.Files)
+		}
+	})
+}
+
+func TestCreateBatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	t.Run("success", func(t *testing.T) {
+		w := createRequest(t, s.CreateBatchHandler, api.CreateBatchRequest{
+			Models: []api.CreateRequest{
+				{Model: "base", Files: map[string]string{"test.gguf": digest}},
+				{Model: "variant", Files: map[string]string{"test.gguf": digest}, System: "Say hi!"},
+			},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		checkFileExists(t, filepath.Join(p, "manifests", "*", "*", "*", "*"), []string{
+			filepath.Join(p, "manifests", "registry.ollama.ai", "library", "base", "latest"),
+			filepath.Join(p, "manifests", "registry.ollama.ai", "library", "variant", "latest"),
+		})
+	})
+
+	t.Run("failure commits nothing", func(t *testing.T) {
+		blobs, err := filepath.Glob(filepath.Join(p, "blobs", "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := createRequest(t, s.CreateBatchHandler, api.CreateBatchRequest{
+			Models: []api.CreateRequest{
+				{Model: "other", Files: map[string]string{"test.gguf": digest}, System: "only in a failed batch"},
+				{Model: "broken", Files: map[string]string{"test.gguf": digest}, Template: "{{ .Prompt"},
+			},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+
+		checkFileExists(t, filepath.Join(p, "manifests", "*", "*", "*", "*"), []string{
+			filepath.Join(p, "manifests", "registry.ollama.ai", "library", "base", "latest"),
+			filepath.Join(p, "manifests", "registry.ollama.ai", "library", "variant", "latest"),
+		})
+
+		// the blobs the batch wrote are removed with it
+		checkFileExists(t, filepath.Join(p, "blobs", "*"), blobs)
+	})
+
+	t.Run("from a model in the batch", func(t *testing.T) {
+		w := createRequest(t, s.CreateBatchHandler, api.CreateBatchRequest{
+			Models: []api.CreateRequest{
+				{Model: "newbase", Files: map[string]string{"test.gguf": digest}, System: "a new base"},
+				{Model: "derived", From: "newbase", Template: "{{ .System }} {{ .Prompt }}"},
+				{Model: "metadata", From: "newbase", System: "a new system prompt"},
+			},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		base, err := ParseNamedManifest(model.ParseName("newbase"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"derived", "metadata"} {
+			m, err := ParseNamedManifest(model.ParseName(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.ContainsFunc(m.Layers, func(l Layer) bool {
+				return l.MediaType == "application/vnd.ollama.image.model" && l.Digest == base.Layers[0].Digest
+			}) {
+				t.Errorf("%s: expected the model layer of newbase, actual %+v", name, m.Layers)
+			}
+
+			config, err := readManifestConfig(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expect := []DerivedModel{{Model: "newbase:latest", Digest: "sha256:" + base.digest}}
+			if !slices.Equal(config.DerivedFrom, expect) {
+				t.Errorf("%s: expected to be derived from %v, actual %v", name, expect, config.DerivedFrom)
+			}
+		}
+	})
+
+	t.Run("from a later model in the batch", func(t *testing.T) {
+		w := createRequest(t, s.CreateBatchHandler, api.CreateBatchRequest{
+			Models: []api.CreateRequest{
+				{Model: "early", From: "late", System: "too early"},
+				{Model: "late", Files: map[string]string{"test.gguf": digest}},
+			},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		if !strings.Contains(w.Body.String(), "must come before it in the batch") {
+			t.Errorf("expected the order to be rejected, actual %s", w.Body.String())
+		}
+	})
+
+	t.Run("duplicate names", func(t *testing.T) {
+		w := createRequest(t, s.CreateBatchHandler, api.CreateBatchRequest{
+			Models: []api.CreateRequest{
+				{Model: "dup", Files: map[string]string{"test.gguf": digest}},
+				{Model: "DUP", Files: map[string]string{"test.gguf": digest}},
+			},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+	})
+}
+
+func TestCreateParameterSize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	cases := []struct {
+		name          string
+		kv            llm.KV
+		parameterSize string
+		expect        string
+	}{
+		{"estimated", nil, "", "3K"},
+		{"override", nil, "8B", "8B"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			// tensor data is read as it's written so each file needs its own
+			tensors := []llm.Tensor{
+				{Name: "token_embd.weight", Shape: []uint64{1000, 2}, WriterTo: bytes.NewReader(make([]byte, 8000))},
+				{Name: "output.weight", Shape: []uint64{1000}, WriterTo: bytes.NewReader(make([]byte, 4000))},
+			}
+
+			_, digest := createBinFile(t, tt.kv, tensors)
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:         "test",
+				Files:         map[string]string{"test.gguf": digest},
+				ParameterSize: tt.parameterSize,
+				Stream:        &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d", w.Code)
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m.Config.ModelType != tt.expect {
+				t.Errorf("expected model type %q, actual %q", tt.expect, m.Config.ModelType)
+			}
+		})
+	}
+}
+
+func TestCreateAliases(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:   "test",
+		Aliases: []string{"test:v1.2", "test:latest"},
+		Files:   map[string]string{"test.gguf": digest},
+		Stream:  &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	checkFileExists(t, filepath.Join(p, "manifests", "*", "*", "*", "*"), []string{
+		filepath.Join(p, "manifests", "registry.ollama.ai", "library", "test", "latest"),
+		filepath.Join(p, "manifests", "registry.ollama.ai", "library", "test", "v1.2"),
+	})
+
+	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
+		filepath.Join(p, "blobs", "sha256-4ea706c4344442ea051050b7b96fca607db73210ddd28be89af6e72fcfc9c8af"),
+		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
+	})
+
+	t.Run("invalid alias", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:   "test",
+			Aliases: []string{"bad name"},
+			Files:   map[string]string{"test.gguf": digest},
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
 	})
 }
+
+func TestCreateContextFromModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	t.Setenv("OLLAMA_MAX_DEFAULT_CONTEXT", "8192")
+	var s Server
+
+	cases := []struct {
+		name             string
+		contextLength    uint32
+		contextFromModel bool
+		parameters       map[string]any
+		expect           any
+	}{
+		{"disabled", 32768, false, nil, nil},
+		{"capped", 32768, true, nil, float64(8192)},
+		{"trained", 4096, true, nil, float64(4096)},
+		{"explicit", 32768, true, map[string]any{"num_ctx": 2048}, float64(2048)},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, digest := createBinFile(t, llm.KV{
+				"general.architecture": "llama",
+				"llama.context_length": tt.contextLength,
+			}, nil)
+
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:            "test",
+				Files:            map[string]string{"test.gguf": digest},
+				ContextFromModel: tt.contextFromModel,
+				Parameters:       tt.parameters,
+				Stream:           &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d", w.Code)
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m.Options["num_ctx"] != tt.expect {
+				t.Errorf("expected num_ctx %v, actual %v", tt.expect, m.Options["num_ctx"])
+			}
+		})
+	}
+}
+
+func TestCreateRollback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test.gguf": digest},
+		System: "Say hi!",
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:   "test",
+		Files:   map[string]string{"test.gguf": digest},
+		System:  "Say bye!",
+		NoPrune: true,
+		Stream:  &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.RollbackHandler, api.RollbackRequest{Model: "test"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.System != "Say hi!" {
+		t.Errorf("expected system %q, actual %q", "Say hi!", m.System)
+	}
+
+	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
+		filepath.Join(p, "blobs", "sha256-4636be5c9b9c693c9e52e0b2e56b53cb1e560d2d5581d000f39c0175b3ed9715"),
+		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
+		filepath.Join(p, "blobs", "sha256-f29e82a8284dbdf5910b1555580ff60b04238b8da9d5e51159ada67a4d0d5851"),
+	})
+
+	t.Run("no previous version", func(t *testing.T) {
+		w := createRequest(t, s.RollbackHandler, api.RollbackRequest{Model: "test"})
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected status code 404, actual %d", w.Code)
+		}
+	})
+
+	t.Run("pruned previous version", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "test",
+			Files:  map[string]string{"test.gguf": digest},
+			System: "Say bye!",
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		// the previous version's layers were removed so it isn't kept
+		w = createRequest(t, s.RollbackHandler, api.RollbackRequest{Model: "test"})
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected status code 404, actual %d", w.Code)
+		}
+	})
+
+	t.Run("previous layers kept", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:   "test",
+			Files:   map[string]string{"test.gguf": digest},
+			System:  "Say hello!",
+			NoPrune: true,
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		// removing the layers of another model doesn't remove the
+		// previous version's
+		layer, err := NewLayer(strings.NewReader("Say bye!"), "application/vnd.ollama.image.system")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := layer.Remove(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(filepath.Join(p, "blobs", strings.Replace(layer.Digest, ":", "-", 1))); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("protected", func(t *testing.T) {
+		if w := createRequest(t, s.ProtectHandler, api.ProtectRequest{Model: "test", Protected: true}); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		w := createRequest(t, s.RollbackHandler, api.RollbackRequest{Model: "test"})
+		if w.Code != http.StatusConflict {
+			t.Fatalf("expected status code 409, actual %d", w.Code)
+		}
+
+		m, err := GetModel("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.System != "Say hello!" {
+			t.Errorf("expected system %q, actual %q", "Say hello!", m.System)
+		}
+	})
+}
+
+func TestCreateConfigLayerOrder(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var layers []Layer
+	for mediatype, content := range map[string]string{
+		"application/vnd.ollama.image.template": "{{ .Prompt }}",
+		"application/vnd.ollama.image.system":   "Say hi!",
+		"application/vnd.ollama.image.license":  "MIT",
+		"application/vnd.ollama.image.params":   `{"temperature":0.5}`,
+	} {
+		layer, err := NewLayer(strings.NewReader(content), mediatype)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers = append(layers, layer)
+	}
+
+	reversed := slices.Clone(layers)
+	slices.Reverse(reversed)
+
+	a, err := createConfigLayer(layers, ConfigV2{}, sha256Digester)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := createConfigLayer(reversed, ConfigV2{}, sha256Digester)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Digest != b.Digest {
+		t.Errorf("expected config digests to match, got %s and %s", a.Digest, b.Digest)
+	}
+}
+
+func TestCreateLayerSource(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test2",
+		From:   "test",
+		System: "Say hi!",
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	for _, name := range []string{"test", "test2"} {
+		resp, err := GetModelInfo(api.ShowRequest{Model: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := []api.LayerSource{{
+			MediaType: "application/vnd.ollama.image.model",
+			Digest:    "sha256:a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99",
+			Source:    "test.gguf",
+		}}
+
+		if !slices.Equal(resp.Sources, expect) {
+			t.Errorf("%s: expected sources %v, actual %v", name, expect, resp.Sources)
+		}
+	}
+}
+
+func TestNewJSONLayer(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	v := map[string]any{"grammar": strings.Repeat("root ::= x\n", 1<<16), "temperature": 0.5}
+
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+
+	layer, err := newJSONLayer(context.Background(), v, "application/vnd.ollama.image.params")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := fmt.Sprintf("sha256:%x", sha256.Sum256(b.Bytes())); layer.Digest != want {
+		t.Errorf("expected digest %s, actual %s", want, layer.Digest)
+	}
+
+	if layer.Size != int64(b.Len()) {
+		t.Errorf("expected size %d, actual %d", b.Len(), layer.Size)
+	}
+
+	if _, err := newJSONLayer(context.Background(), map[string]any{"bad": make(chan int)}, "application/vnd.ollama.image.params"); err == nil {
+		t.Error("expected error encoding unsupported value")
+	}
+}
+
+func TestCreateSplitGGUF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, llm.KV{
+		"split.no":    uint32(0),
+		"split.count": uint32(2),
+	}, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test-00001-of-00002.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400, actual %d", w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), errSplitGGUFUnsupported.Error()) {
+		t.Errorf("expected split GGUF error, actual %s", w.Body.String())
+	}
+
+	checkFileExists(t, filepath.Join(p, "manifests", "*", "*", "*", "*"), nil)
+}
+
+func TestCreateSchema(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var s Server
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/create/schema", nil)
+	s.CreateSchemaHandler(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var schema struct {
+		Properties map[string]struct {
+			Type       string            `json:"type"`
+			Enum       []string          `json:"enum"`
+			OneOf      []json.RawMessage `json:"oneOf"`
+			Deprecated bool              `json:"deprecated"`
+			Properties map[string]struct {
+				Type  string `json:"type"`
+				Items struct {
+					Type string `json:"type"`
+				} `json:"items"`
+			} `json:"properties"`
+		} `json:"properties"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&schema); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := schema.Properties["model"]; p.Type != "string" {
+		t.Errorf("expected model to be a string, actual %q", p.Type)
+	}
+
+	if p := schema.Properties["quantize"]; !slices.Contains(p.Enum, "Q4_K_M") || slices.Contains(p.Enum, "unknown") {
+		t.Errorf("unexpected quantize enum %v", p.Enum)
+	}
+
+	if p := schema.Properties["license"]; len(p.OneOf) != 2 {
+		t.Errorf("expected license to be a string or an array, actual %d options", len(p.OneOf))
+	}
+
+	if p := schema.Properties["name"]; !p.Deprecated {
+		t.Error("expected name to be deprecated")
+	}
+
+	parameters := schema.Properties["parameters"].Properties
+	if p := parameters["num_ctx"]; p.Type != "integer" {
+		t.Errorf("expected num_ctx to be an integer, actual %q", p.Type)
+	}
+
+	if p := parameters["temperature"]; p.Type != "number" {
+		t.Errorf("expected temperature to be a number, actual %q", p.Type)
+	}
+
+	if p := parameters["stop"]; p.Type != "array" || p.Items.Type != "string" {
+		t.Errorf("expected stop to be an array of strings, actual %q of %q", p.Type, p.Items.Type)
+	}
+
+	// every parameter a create accepts is described, and nothing else
+	expect := slices.Concat(optionNames(), modelParameters)
+	for alias := range api.ParameterAliases {
+		expect = append(expect, alias)
+	}
+	slices.Sort(expect)
+
+	if actual := slices.Sorted(maps.Keys(parameters)); !slices.Equal(actual, expect) {
+		t.Errorf("expected parameters %v, actual %v", expect, actual)
+	}
+
+	// and every model parameter is accepted by create
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	values := map[string]any{
+		"keep_alive":         "10m",
+		"think":              true,
+		"backends":           map[string]any{"cuda": map[string]any{"num_batch": float64(1024)}},
+		"capability_num_ctx": map[string]any{"tools": float64(16384)},
+	}
+
+	if actual := slices.Sorted(maps.Keys(values)); !slices.Equal(actual, slices.Sorted(slices.Values(modelParameters))) {
+		t.Fatalf("expected values for %v, actual %v", modelParameters, actual)
+	}
+
+	for k, v := range values {
+		if _, err := setParameters(context.Background(), nil, map[string]any{k: v}); err != nil {
+			t.Errorf("%s: %v", k, err)
+		}
+	}
+}
+
+func TestCreateQuantizations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/quantizations", nil)
+	s.QuantizationsHandler(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var resp api.QuantizationsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{"F16", "Q4_0", "Q4_K_M", "Q8_0"} {
+		if !slices.Contains(resp.Quantizations, q) {
+			t.Errorf("expected %s in %v", q, resp.Quantizations)
+		}
+	}
+
+	_, digest := createBinFile(t, nil, nil)
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"test.gguf": digest},
+		Quantize: "q3",
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	var errResp struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&errResp); err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := `unknown quantization "Q3", expected one of ` + strings.Join(resp.Quantizations, ", "); errResp.Error != expect {
+		t.Errorf("expected %q, actual %q", expect, errResp.Error)
+	}
+
+	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{filepath.Join(p, "blobs", strings.Replace(digest, ":", "-", 1))})
+}
+
+func TestCreateLayersFrom(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "base",
+		Files:    map[string]string{"test.gguf": digest},
+		Template: "{{ .Prompt }}",
+		System:   "Say hi!",
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	_, digest = createBinFile(t, llm.KV{"general.architecture": "other"}, nil)
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "prompts",
+		Files:    map[string]string{"other.gguf": digest},
+		Template: "[INST] {{ .Prompt }} [/INST]",
+		System:   "Say bye!",
+		License:  "MIT",
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	t.Run("template and system", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:      "test",
+			From:       "base",
+			LayersFrom: "prompts",
+			LayerTypes: []string{"template", "system"},
+			Stream:     &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		resp, err := GetModelInfo(api.ShowRequest{Model: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Template != "[INST] {{ .Prompt }} [/INST]" {
+			t.Errorf("unexpected template %q", resp.Template)
+		}
+
+		if resp.System != "Say bye!" {
+			t.Errorf("unexpected system %q", resp.System)
+		}
+
+		if resp.License != "" {
+			t.Errorf("expected no license, actual %q", resp.License)
+		}
+	})
+
+	t.Run("explicit template wins", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:      "test",
+			From:       "base",
+			LayersFrom: "prompts",
+			LayerTypes: []string{"template", "system"},
+			Template:   "{{ .System }} {{ .Prompt }}",
+			Stream:     &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		resp, err := GetModelInfo(api.ShowRequest{Model: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Template != "{{ .System }} {{ .Prompt }}" {
+			t.Errorf("unexpected template %q", resp.Template)
+		}
+	})
+
+	cases := []struct {
+		name string
+		req  api.CreateRequest
+	}{
+		{"unknown type", api.CreateRequest{LayersFrom: "prompts", LayerTypes: []string{"model"}}},
+		{"no types", api.CreateRequest{LayersFrom: "prompts"}},
+		{"missing model", api.CreateRequest{LayersFrom: "missing", LayerTypes: []string{"template"}}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Model = "test2"
+			tt.req.From = "base"
+			tt.req.Stream = &stream
+			w := createRequest(t, s.CreateHandler, tt.req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateMultipleBaseModels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, a := createBinFile(t, llm.KV{"general.architecture": "llama", "general.name": "a"}, nil)
+	_, b := createBinFile(t, llm.KV{"general.architecture": "llama", "general.name": "b"}, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"a.gguf": a, "b.gguf": b},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400, actual %d", w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), "a.gguf and b.gguf are both llama models") {
+		t.Errorf("unexpected error %s", w.Body.String())
+	}
+
+	checkFileExists(t, filepath.Join(p, "manifests", "*", "*", "*", "*"), nil)
+}
+
+func TestCreateKeepAlive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	cases := []struct {
+		value  any
+		expect time.Duration
+	}{
+		{"10m", 10 * time.Minute},
+		{"300", 300 * time.Second},
+		{float64(60), time.Minute},
+		{"-1", time.Duration(math.MaxInt64)},
+	}
+
+	for _, tt := range cases {
+		t.Run(fmt.Sprint(tt.value), func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:      "test",
+				Files:      map[string]string{"test.gguf": digest},
+				Parameters: map[string]any{"keep_alive": tt.value},
+				Stream:     &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d", w.Code)
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if d := m.KeepAlive(); d == nil || d.Duration != tt.expect {
+				t.Errorf("expected keep_alive %s, actual %v", tt.expect, d)
+			}
+
+			if _, err := modelOptions(m, "cpu", nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:      "test",
+			Files:      map[string]string{"test.gguf": digest},
+			Parameters: map[string]any{"keep_alive": "forever"},
+			Stream:     &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+	})
+}
+
+func TestCreateMetadataOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	// a metadata only update mustn't read the model blob so corrupting it
+	// shouldn't affect it
+	blob, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(blob, []byte("not a gguf file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:         "test2",
+		From:          "test",
+		System:        "Say hi!",
+		ParameterSize: "7B",
+		Stream:        &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.System != "Say hi!" {
+		t.Errorf("unexpected system %q", m.System)
+	}
+
+	if m.Config.ModelFamily != "llama" {
+		t.Errorf("expected model family llama, actual %q", m.Config.ModelFamily)
+	}
+
+	if m.Config.ModelType != "7B" {
+		t.Errorf("expected model type 7B, actual %q", m.Config.ModelType)
+	}
+
+	mf, err := ParseNamedManifest(model.ParseName("test2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.ContainsFunc(mf.Layers, func(l Layer) bool { return l.Digest == digest }) {
+		t.Errorf("expected the model layer %s to be reused", digest)
+	}
+
+	// changing the model's blobs still decodes them
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test3",
+		From:     "test",
+		Quantize: "q4_0",
+		Stream:   &stream,
+	})
+
+	if w.Code == http.StatusOK {
+		t.Fatalf("expected an error decoding the corrupt model")
+	}
+}
+
+func TestCreateTemplateName(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	for name, text := range map[string]string{
+		filepath.Join("chatml", "latest.gotmpl"): "<|im_start|>{{ .Prompt }}",
+		filepath.Join("chatml", "v1.gotmpl"):     "<|im_start|>user {{ .Prompt }}",
+		filepath.Join("plain", "latest.gotmpl"):  "{{ .Prompt }}",
+	} {
+		p := filepath.Join(envconfig.Templates(), name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, digest := createBinFile(t, nil, nil)
+
+	cases := []struct {
+		name   string
+		expect string
+	}{
+		{"chatml", "<|im_start|>{{ .Prompt }}"},
+		{"chatml:latest", "<|im_start|>{{ .Prompt }}"},
+		{"chatml:v1", "<|im_start|>user {{ .Prompt }}"},
+		{"plain", "{{ .Prompt }}"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:        "test",
+				Files:        map[string]string{"test.gguf": digest},
+				TemplateName: tt.name,
+				Stream:       &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m.Template.String() != tt.expect {
+				t.Errorf("expected template %q, actual %q", tt.expect, m.Template.String())
+			}
+		})
+	}
+
+	for _, name := range []string{"chatml:v2", "missing", "../chatml", "chatml:../../v1"} {
+		t.Run(name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:        "test",
+				Files:        map[string]string{"test.gguf": digest},
+				TemplateName: name,
+				Stream:       &stream,
+			})
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d", w.Code)
+			}
+
+			if !strings.Contains(w.Body.String(), "available templates: chatml:latest, chatml:v1, plain:latest") {
+				t.Errorf("expected the available templates, actual %s", w.Body.String())
+			}
+		})
+	}
+
+	t.Run("template and name", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:        "test",
+			Files:        map[string]string{"test.gguf": digest},
+			Template:     "{{ .Prompt }}",
+			TemplateName: "plain",
+			Stream:       &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+	})
+}
+
+func TestCreateTemplateInclude(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	for name, text := range map[string]string{
+		filepath.Join("tools", "latest.gotmpl"):  "{{ range .Tools }}{{ . }}{{ end }}",
+		filepath.Join("chatml", "latest.gotmpl"): "{{- /* include \"tools\" */ -}}\n<|im_start|>{{ .Prompt }}",
+		filepath.Join("loop", "latest.gotmpl"):   "{{ /* include \"cycle\" */ }}",
+		filepath.Join("cycle", "latest.gotmpl"):  "{{ /* include \"loop\" */ }}",
+	} {
+		p := filepath.Join(envconfig.Templates(), name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, digest := createBinFile(t, nil, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "base",
+		Files:    map[string]string{"test.gguf": digest},
+		Template: "[INST] {{ .Prompt }} [/INST]",
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	cases := []struct {
+		name         string
+		template     string
+		templateName string
+		expect       string
+	}{
+		{"registered", "{{ /* include \"tools\" */ }} {{ .Prompt }}", "", "{{ range .Tools }}{{ . }}{{ end }} {{ .Prompt }}"},
+		{"trim", "{{ .System }}\n\n{{- /* include \"tools\" */ -}}\n\n{{ .Prompt }}", "", "{{ .System }}{{ range .Tools }}{{ . }}{{ end }}{{ .Prompt }}"},
+		{"nested", "{{ /* include \"chatml\" */ }}", "", "{{ range .Tools }}{{ . }}{{ end }}<|im_start|>{{ .Prompt }}"},
+		{"template name", "", "chatml", "{{ range .Tools }}{{ . }}{{ end }}<|im_start|>{{ .Prompt }}"},
+		{"model", "<s>{{ /* include \"model:base\" */ }}", "", "<s>[INST] {{ .Prompt }} [/INST]"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:        "test",
+				Files:        map[string]string{"test.gguf": digest},
+				Template:     tt.template,
+				TemplateName: tt.templateName,
+				Stream:       &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m.Template.String() != tt.expect {
+				t.Errorf("expected template %q, actual %q", tt.expect, m.Template.String())
+			}
+		})
+	}
+
+	errCases := []struct {
+		name         string
+		template     string
+		templateName string
+		expect       string
+	}{
+		{"circular", "{{ /* include \"loop\" */ }}", "", "circular template include: loop -> cycle -> loop"},
+		{"circular name", "", "loop", "circular template include: loop -> cycle -> loop"},
+		{"missing template", "{{ /* include \"missing\" */ }}", "", "unknown template 'missing'"},
+		{"missing model", "{{ /* include \"model:missing\" */ }}", "", "can't include the template of model 'missing': model not found"},
+	}
+
+	for _, tt := range errCases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:        "test",
+				Files:        map[string]string{"test.gguf": digest},
+				Template:     tt.template,
+				TemplateName: tt.templateName,
+				Stream:       &stream,
+			})
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp struct {
+				Error string `json:"error"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.HasPrefix(resp.Error, tt.expect) {
+				t.Errorf("expected %q, actual %q", tt.expect, resp.Error)
+			}
+		})
+	}
+}
+
+func TestCreateDuplicateLicenses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	cases := []struct {
+		license any
+		expect  []string
+	}{
+		{[]string{"MIT", "MIT"}, []string{"MIT"}},
+		{[]string{"MIT", "Apache-2.0", "MIT", "Apache-2.0"}, []string{"MIT", "Apache-2.0"}},
+		{[]string{"MIT", "MIT License"}, []string{"MIT", "MIT License"}},
+	}
+
+	for _, tt := range cases {
+		t.Run(fmt.Sprint(tt.license), func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:   "test",
+				Files:   map[string]string{"test.gguf": digest},
+				License: tt.license,
+				Stream:  &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d", w.Code)
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(m.License, tt.expect) {
+				t.Errorf("expected licenses %v, actual %v", tt.expect, m.License)
+			}
+		})
+	}
+
+	t.Run("inherited", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:   "test2",
+			From:    "test",
+			License: "MIT",
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		m, err := GetModel("test2")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expect := []string{"MIT", "MIT License"}; !slices.Equal(m.License, expect) {
+			t.Errorf("expected licenses %v, actual %v", expect, m.License)
+		}
+	})
+}
+
+func TestCreateAccept(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	create := func(t *testing.T, accept string, stream *bool) *httptest.ResponseRecorder {
+		t.Helper()
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(api.CreateRequest{
+			Model:  "test",
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: stream,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		w := NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/create", &b)
+		if accept != "" {
+			c.Request.Header.Set("Accept", accept)
+		}
+
+		s.CreateHandler(c)
+		return w.ResponseRecorder
+	}
+
+	for _, accept := range []string{"", "*/*", "application/x-ndjson", "application/x-ndjson, application/json"} {
+		t.Run("ndjson "+accept, func(t *testing.T) {
+			w := create(t, accept, nil)
+			if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Errorf("expected content type application/x-ndjson, actual %s", ct)
+			}
+
+			lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+			for _, line := range lines {
+				var resp api.ProgressResponse
+				if err := json.Unmarshal([]byte(line), &resp); err != nil {
+					t.Fatalf("expected a JSON object per line, actual %q: %v", line, err)
+				}
+			}
+
+			if !strings.Contains(lines[len(lines)-1], "success") {
+				t.Errorf("expected success, actual %s", lines[len(lines)-1])
+			}
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		w := create(t, "application/json", nil)
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected content type application/json, actual %s", ct)
+		}
+
+		var resps []api.ProgressResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resps); err != nil {
+			t.Fatalf("expected a JSON array, actual %q: %v", w.Body.String(), err)
+		}
+
+		if len(resps) < 2 || resps[len(resps)-1].Status != "success" {
+			t.Errorf("unexpected progress %v", resps)
+		}
+	})
+
+	t.Run("events", func(t *testing.T) {
+		w := create(t, "text/event-stream", nil)
+		if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("expected content type text/event-stream, actual %s", ct)
+		}
+
+		events := strings.Split(strings.TrimSuffix(w.Body.String(), "\n\n"), "\n\n")
+		if last := events[len(events)-1]; last != "event: done\ndata: {}" {
+			t.Errorf("expected a done event, actual %q", last)
+		}
+
+		var resps []api.ProgressResponse
+		for _, event := range events[:len(events)-1] {
+			data, ok := strings.CutPrefix(event, "data: ")
+			if !ok {
+				t.Fatalf("expected a data event, actual %q", event)
+			}
+
+			var resp api.ProgressResponse
+			if err := json.Unmarshal([]byte(data), &resp); err != nil {
+				t.Fatalf("expected JSON data, actual %q: %v", data, err)
+			}
+			resps = append(resps, resp)
+		}
+
+		if len(resps) < 2 || resps[len(resps)-1].Status != "success" {
+			t.Errorf("unexpected progress %v", resps)
+		}
+	})
+
+	t.Run("not streamed", func(t *testing.T) {
+		for _, accept := range []string{"application/x-ndjson", "application/json", "text/event-stream"} {
+			w := create(t, accept, &stream)
+
+			var resp api.ProgressResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("expected a single JSON object, actual %q: %v", w.Body.String(), err)
+			}
+
+			if resp.Status != "success" {
+				t.Errorf("expected success, actual %s", resp.Status)
+			}
+		}
+	})
+}
+
+func TestCreateSpecialTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	// more tokens than are collected by default
+	tokens := make([]string, 2000)
+	types := make([]int32, len(tokens))
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("t%d", i)
+		types[i] = 1
+	}
+	tokens[0], tokens[1], tokens[2], tokens[1999] = "<s>", "</s>", "<pad>", "<|im_end|>"
+	types[0], types[1], types[2], types[1999] = 3, 3, 3, 4
+
+	_, digest := createBinFile(t, llm.KV{
+		"tokenizer.ggml.tokens":           tokens,
+		"tokenizer.ggml.token_type":       types,
+		"tokenizer.ggml.bos_token_id":     uint32(0),
+		"tokenizer.ggml.eos_token_id":     uint32(1),
+		"tokenizer.ggml.padding_token_id": uint32(2),
+	}, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model: "test",
+		Files: map[string]string{"test.gguf": digest},
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	expect := &api.SpecialTokens{
+		BOS: &api.Token{ID: 0, Text: "<s>"},
+		EOS: &api.Token{ID: 1, Text: "</s>"},
+		PAD: &api.Token{ID: 2, Text: "<pad>"},
+		Added: []api.Token{
+			{ID: 0, Text: "<s>"},
+			{ID: 1, Text: "</s>"},
+			{ID: 2, Text: "<pad>"},
+			{ID: 1999, Text: "<|im_end|>"},
+		},
+	}
+
+	var reported *api.SpecialTokens
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		var resp api.ProgressResponse
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.SpecialTokens != nil {
+			reported = resp.SpecialTokens
+		}
+	}
+
+	want, err := json.Marshal(expect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := json.Marshal(reported); !bytes.Equal(got, want) {
+		t.Errorf("expected progress to report %s, actual %s", want, got)
+	}
+
+	resp, err := GetModelInfo(api.ShowRequest{Model: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := json.Marshal(resp.SpecialTokens); !bytes.Equal(got, want) {
+		t.Errorf("expected show to report %s, actual %s", want, got)
+	}
+}
+
+func TestCreateFromArchive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	bin, _ := createBinFile(t, nil, nil)
+	gguf, err := os.ReadFile(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := func(t *testing.T, compress bool, files map[string][]byte) string {
+		t.Helper()
+
+		var b bytes.Buffer
+		var w io.Writer = &b
+		if compress {
+			w = gzip.NewWriter(&b)
+		}
+
+		tw := tar.NewWriter(w)
+		for _, name := range slices.Sorted(maps.Keys(files)) {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name]))}); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := tw.Write(files[name]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if compress {
+			if err := w.(*gzip.Writer).Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		layer, err := NewLayer(&b, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return layer.Digest
+	}
+
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compressed %t", compress), func(t *testing.T) {
+			digest := archive(t, compress, map[string][]byte{"mymodel/test.gguf": gguf})
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:   "test",
+				Archive: digest,
+				Stream:  &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			resp, err := GetModelInfo(api.ShowRequest{Model: "test"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expect := []api.LayerSource{{
+				MediaType: "application/vnd.ollama.image.model",
+				Digest:    "sha256:a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99",
+				Source:    "test.gguf",
+			}}
+
+			if !slices.Equal(resp.Sources, expect) {
+				t.Errorf("expected sources %v, actual %v", expect, resp.Sources)
+			}
+		})
+	}
+
+	t.Run("unpacked files are removed", func(t *testing.T) {
+		notes := []byte("some notes")
+		digest := archive(t, true, map[string][]byte{"test.gguf": gguf, "notes.txt": notes})
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:   "test2",
+			Archive: digest,
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+
+		notesDigest, _ := GetSHA256Digest(bytes.NewReader(notes))
+		blob, err := GetBlobsPath(notesDigest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(blob); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s to be removed, actual %v", blob, err)
+		}
+
+		// the model from the earlier archive still uses its blob
+		if _, err := os.Stat(filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99")); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		t.Setenv("OLLAMA_MAX_ARCHIVE_SIZE", "16")
+		digest := archive(t, false, map[string][]byte{"test.gguf": gguf})
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:   "test2",
+			Archive: digest,
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+
+		if !strings.Contains(w.Body.String(), errArchiveTooLarge.Error()) {
+			t.Errorf("unexpected error %s", w.Body.String())
+		}
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		digest := archive(t, false, map[string][]byte{"../test.gguf": gguf})
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:   "test2",
+			Archive: digest,
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:   "test2",
+			Archive: "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+	})
+}
+
+func TestCreateInlineFiles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	var s Server
+
+	name, _ := createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)
+	gguf, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(gguf)
+	config := []byte(`{"inline": true}`)
+
+	t.Run("files", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:       "test",
+			InlineFiles: map[string]string{"test.gguf": encoded},
+			Stream:      &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if digest, _ := GetSHA256Digest(bytes.NewReader(gguf)); filepath.Base(m.ModelPath) != strings.Replace(digest, ":", "-", 1) {
+			t.Errorf("expected the model to be the inline file %s, actual %s", digest, m.ModelPath)
+		}
+	})
+
+	cases := []struct {
+		name   string
+		env    string
+		r      api.CreateRequest
+		expect string
+	}{
+		{
+			name:   "too large",
+			env:    "16",
+			r:      api.CreateRequest{InlineFiles: map[string]string{"test.gguf": encoded}},
+			expect: errInlineTooLarge.Error(),
+		},
+		{
+			name:   "too large to decode",
+			env:    "16",
+			r:      api.CreateRequest{InlineFiles: map[string]string{"test.gguf": strings.Repeat("!", 64)}},
+			expect: errInlineTooLarge.Error(),
+		},
+		{
+			name:   "invalid content",
+			r:      api.CreateRequest{InlineFiles: map[string]string{"a.json": base64.StdEncoding.EncodeToString(config), "b.json": "not base64"}},
+			expect: "invalid inline file b.json",
+		},
+		{
+			name:   "invalid name",
+			r:      api.CreateRequest{InlineFiles: map[string]string{"../test.gguf": encoded}},
+			expect: `invalid inline file name "../test.gguf"`,
+		},
+		{
+			name: "listed and inline",
+			r: api.CreateRequest{
+				Files:       map[string]string{"test.gguf": "sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+				InlineFiles: map[string]string{"test.gguf": encoded},
+			},
+			expect: "test.gguf is both a file and an inline file",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("OLLAMA_MAX_INLINE_SIZE", tt.env)
+			}
+
+			tt.r.Model, tt.r.Stream = "test2", &stream
+			w := createRequest(t, s.CreateHandler, tt.r)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d", w.Code)
+			}
+
+			var resp struct{ Error string }
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(resp.Error, tt.expect) {
+				t.Errorf("expected error %q, actual %q", tt.expect, resp.Error)
+			}
+		})
+	}
+
+	t.Run("body too large", func(t *testing.T) {
+		t.Setenv("OLLAMA_MAX_INLINE_SIZE", "16")
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:    "test2",
+			From:     "test",
+			Template: strings.Repeat("a", createBodyOverhead),
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected status code 413, actual %d", w.Code)
+		}
+	})
+
+	for _, n := range []int{0, 1, 2, 3, 4, 5} {
+		if s := base64.StdEncoding.EncodeToString(make([]byte, n)); decodedLen(s) != uint64(n) {
+			t.Errorf("expected %q to decode to %d bytes, actual %d", s, n, decodedLen(s))
+		}
+	}
+
+	// the inline file written before the invalid one was removed
+	digest, _ := GetSHA256Digest(bytes.NewReader(config))
+	blob, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(blob); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %s to be removed, actual %v", blob, err)
+	}
+
+	t.Run("already stored", func(t *testing.T) {
+		name, digest := createBinFile(t, llm.KV{"general.architecture": "stored"}, nil)
+		stored, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:       "test2",
+			InlineFiles: map[string]string{"a.gguf": base64.StdEncoding.EncodeToString(stored), "b.json": "not base64"},
+			Stream:      &stream,
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+
+		// the blob was stored before the create so it isn't removed
+		blob, err := GetBlobsPath(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(blob); err != nil {
+			t.Errorf("expected %s to be kept, actual %v", blob, err)
+		}
+	})
+}
+
+func TestCreateQuantizeWithAdapter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, base := createBinFile(t, llm.KV{
+		"general.architecture": "llama",
+		"general.file_type":    uint32(1), // F16
+	}, nil)
+
+	// adapters with file types which can't be quantized are left alone
+	_, adapter := createBinFile(t, llm.KV{
+		"general.architecture": "llama",
+		"general.type":         "adapter",
+		"general.file_type":    uint32(2), // Q4_0
+	}, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"test.gguf": base},
+		Adapters: map[string]string{"adapter.gguf": adapter},
+		Quantize: "f16",
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	mf, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mediatypes []string
+	for _, l := range mf.Layers {
+		if l.Digest == adapter {
+			mediatypes = append(mediatypes, l.MediaType)
+		}
+	}
+
+	if expect := []string{"application/vnd.ollama.image.adapter"}; !slices.Equal(mediatypes, expect) {
+		t.Errorf("expected the adapter to be kept unchanged, actual %v", mediatypes)
+	}
+}
+
+func TestCreateOCIManifest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	cases := []struct {
+		oci                        bool
+		mediatype, configMediaType string
+	}{
+		{false, "application/vnd.docker.distribution.manifest.v2+json", "application/vnd.docker.container.image.v1+json"},
+		{true, "application/vnd.oci.image.manifest.v1+json", "application/vnd.oci.image.config.v1+json"},
+	}
+
+	for _, tt := range cases {
+		t.Run(fmt.Sprintf("oci %t", tt.oci), func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:  "test",
+				Files:  map[string]string{"test.gguf": digest},
+				System: "Say hi!",
+				OCI:    tt.oci,
+				Stream: &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d", w.Code)
+			}
+
+			mf, err := ParseNamedManifest(model.ParseName("test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if mf.MediaType != tt.mediatype {
+				t.Errorf("expected manifest media type %s, actual %s", tt.mediatype, mf.MediaType)
+			}
+
+			if mf.Config.MediaType != tt.configMediaType {
+				t.Errorf("expected config media type %s, actual %s", tt.configMediaType, mf.Config.MediaType)
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m.System != "Say hi!" {
+				t.Errorf("expected system %q, actual %q", "Say hi!", m.System)
+			}
+		})
+	}
+}
+
+func TestCreatePrune(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "other",
+		Files:  map[string]string{"test.gguf": digest},
+		System: "Say hi!",
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:   "test",
+		Files:   map[string]string{"test.gguf": digest},
+		System:  "Say bye!",
+		NoPrune: true,
+		Stream:  &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	// a blob left behind by a failed create
+	_, orphan := createBinFile(t, llm.KV{"general.name": "orphan"}, nil)
+
+	// a blob uploaded for a create which hasn't used it yet
+	uploaded := []byte("uploaded")
+	uploadedDigest, _ := GetSHA256Digest(bytes.NewReader(uploaded))
+	rec := NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Params = gin.Params{{Key: "digest", Value: uploadedDigest}}
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/blobs/"+uploadedDigest, bytes.NewReader(uploaded))
+	s.CreateBlobHandler(c)
+	if code := c.Writer.Status(); code != http.StatusCreated {
+		t.Fatalf("expected status code 201, actual %d", code)
+	}
+
+	before, err := GetModelInfo(api.ShowRequest{Model: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:   "test",
+		Files:   map[string]string{"test.gguf": digest},
+		System:  "Say something else!",
+		NoPrune: true,
+		Prune:   true,
+		Stream:  &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	blobExists := func(digest string) bool {
+		t.Helper()
+		p, err := GetBlobsPath(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = os.Stat(p)
+		return err == nil
+	}
+
+	if blobExists(orphan) {
+		t.Errorf("expected unreferenced blob %s to be removed", orphan)
+	}
+
+	if !blobExists(uploadedDigest) {
+		t.Errorf("expected uploaded blob %s to be kept", uploadedDigest)
+	}
+
+	t.Run("expired upload", func(t *testing.T) {
+		t.Setenv("OLLAMA_UPLOAD_TTL", "1ns")
+		if err := PruneLayers(); err != nil {
+			t.Fatal(err)
+		}
+
+		if blobExists(uploadedDigest) {
+			t.Errorf("expected uploaded blob %s to be removed", uploadedDigest)
+		}
+	})
+
+	for _, name := range []string{"other", "test"} {
+		mf, err := ParseNamedManifest(model.ParseName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, layer := range append(mf.Layers, mf.Config) {
+			if !blobExists(layer.Digest) {
+				t.Errorf("%s: expected blob %s to be kept", name, layer.Digest)
+			}
+		}
+	}
+
+	// the previous version kept by no_prune can still be restored
+	w = createRequest(t, s.RollbackHandler, api.RollbackRequest{Model: "test"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	after, err := GetModelInfo(api.ShowRequest{Model: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after.System != before.System {
+		t.Errorf("expected system %q after rollback, actual %q", before.System, after.System)
+	}
+}
+
+func TestCreateDefaultQuantize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	cases := []struct {
+		name     string
+		env      string
+		fileType uint32
+		quantize string
+		code     int
+		expect   string
+	}{
+		{"already quantized", "q4_K_M", 2, "", http.StatusOK, "Q4_0"},
+		{"already the default", "F16", 1, "", http.StatusOK, "F16"},
+		{"explicit quantize of a quantized model", "q4_K_M", 2, "q4_K_M", http.StatusInternalServerError, ""},
+		{"invalid default", "bogus", 1, "", http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_DEFAULT_QUANTIZE", tt.env)
+
+			_, digest := createBinFile(t, llm.KV{
+				"general.architecture": "llama",
+				"general.file_type":    tt.fileType,
+			}, nil)
+
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:    "test",
+				Files:    map[string]string{"test.gguf": digest},
+				Quantize: tt.quantize,
+				Stream:   &stream,
+			})
+
+			if w.Code != tt.code {
+				t.Fatalf("expected status code %d, actual %d: %s", tt.code, w.Code, w.Body.String())
+			}
+
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m.Config.FileType != tt.expect {
+				t.Errorf("expected file type %s, actual %s", tt.expect, m.Config.FileType)
+			}
+		})
+	}
+}
+
+func TestCreateRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	_, digest := createBinFile(t, nil, nil)
+
+	create := func(t *testing.T, id string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(api.CreateRequest{
+			Model: "test",
+			Files: map[string]string{"test.gguf": digest},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		w := NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/create", &b)
+		if id != "" {
+			c.Request.Header.Set("X-Request-ID", id)
+		}
+
+		s.CreateHandler(c)
+		return w.ResponseRecorder
+	}
+
+	checkProgress := func(t *testing.T, w *httptest.ResponseRecorder, id string) {
+		t.Helper()
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		if actual := w.Header().Get("X-Request-ID"); actual != id {
+			t.Errorf("expected request id header %q, actual %q", id, actual)
+		}
+
+		for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+			var resp api.ProgressResponse
+			if err := json.Unmarshal([]byte(line), &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.RequestID != id {
+				t.Errorf("expected request id %q in %q, actual %q", id, resp.Status, resp.RequestID)
+			}
+		}
+	}
+
+	t.Run("from header", func(t *testing.T) {
+		logs.Reset()
+
+		w := create(t, "create-1234")
+		checkProgress(t, w, "create-1234")
+
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			if !strings.Contains(line, "request_id=create-1234") {
+				t.Errorf("expected request id in log entry: %s", line)
+			}
+		}
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		w := create(t, "")
+
+		id := w.Header().Get("X-Request-ID")
+		if id == "" {
+			t.Fatal("expected a generated request id")
+		}
+
+		checkProgress(t, w, id)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		logs.Reset()
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(api.CreateBatchRequest{
+			Models: []api.CreateRequest{
+				{Model: "batch", Files: map[string]string{"test.gguf": digest}},
+				{Model: "batch-system", Files: map[string]string{"test.gguf": digest}, System: "system"},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		w := NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/create/batch", &b)
+		c.Request.Header.Set("X-Request-ID", "batch-1234")
+
+		s.CreateBatchHandler(c)
+		checkProgress(t, w.ResponseRecorder, "batch-1234")
+
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			if !strings.Contains(line, "request_id=batch-1234") {
+				t.Errorf("expected request id in log entry: %s", line)
+			}
+		}
+	})
+}
+
+func TestCreateMaxLayers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	create := func() *httptest.ResponseRecorder {
+		return createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:    "test",
+			Files:    map[string]string{"test.gguf": digest},
+			Template: "{{ .Prompt }}",
+			System:   "You are a helpful assistant.",
+			License:  "MIT",
+			Stream:   &stream,
+		})
+	}
+
+	t.Setenv("OLLAMA_MAX_LAYERS", "3")
+	w := create()
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400, actual %d", w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), "4 layers exceeds the maximum of 3") {
+		t.Errorf("unexpected error %s", w.Body.String())
+	}
+
+	checkFileExists(t, filepath.Join(p, "manifests", "*", "*", "*", "*"), nil)
+
+	for _, limit := range []string{"4", "0"} {
+		t.Setenv("OLLAMA_MAX_LAYERS", limit)
+		if w := create(); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200 with a limit of %s, actual %d: %s", limit, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCreateKVOverrides(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture": "llama",
+		"llama.context_length": uint32(4096),
+		"llama.rope.freq_base": float32(10000),
+	}, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:       "test",
+		Files:       map[string]string{"test.gguf": digest},
+		KVOverrides: map[string]any{"llama.rope.freq_base": 500000},
+		Stream:      &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := (llm.KV{"llama.rope.freq_base": float64(500000)}); !maps.Equal(expect, m.KVOverrides) {
+		t.Errorf("expected overrides %v, actual %v", expect, m.KVOverrides)
+	}
+
+	t.Run("inherited", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:       "test2",
+			From:        "test",
+			KVOverrides: map[string]any{"llama.context_length": 8192},
+			Stream:      &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("test2")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := llm.KV{"llama.rope.freq_base": float64(500000), "llama.context_length": float64(8192)}
+		if !maps.Equal(expect, m.KVOverrides) {
+			t.Errorf("expected overrides %v, actual %v", expect, m.KVOverrides)
+		}
+	})
+
+	for name, overrides := range map[string]map[string]any{
+		"unknown key": {"llama.rope.scale": 2},
+		"wrong type":  {"llama.context_length": "8192"},
+		"not integer": {"llama.context_length": 8192.5},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:       "test3",
+				From:        "test",
+				KVOverrides: overrides,
+				Stream:      &stream,
+			})
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			if !strings.Contains(w.Body.String(), "invalid kv_overrides") {
+				t.Errorf("unexpected error %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateFromUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	name, digest := createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)
+	bin, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the upload, not the blob createBinFile links, should store the model
+	blob, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(blob); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(t *testing.T, method, path string, body []byte, header http.Header) (int, api.UploadResponse) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		maps.Copy(req.Header, header)
+
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var upload api.UploadResponse
+		if err := json.NewDecoder(resp.Body).Decode(&upload); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.StatusCode, upload
+	}
+
+	code, upload := do(t, http.MethodPost, "/api/uploads", nil, nil)
+	if code != http.StatusCreated || upload.ID == "" {
+		t.Fatalf("expected status code 201 with an id, actual %d: %+v", code, upload)
+	}
+
+	half := len(bin) / 2
+	if code, upload := do(t, http.MethodPatch, "/api/uploads/"+upload.ID, bin[:half], nil); code != http.StatusAccepted || upload.Size != int64(half) {
+		t.Fatalf("expected status code 202 with size %d, actual %d: %+v", half, code, upload)
+	}
+
+	// retrying the first chunk is rejected rather than appended again
+	if code, _ := do(t, http.MethodPatch, "/api/uploads/"+upload.ID, bin[:half], http.Header{"Upload-Offset": {"0"}}); code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("expected status code 416, actual %d", code)
+	}
+
+	if code, upload := do(t, http.MethodPatch, "/api/uploads/"+upload.ID, bin[half:], http.Header{"Upload-Offset": {strconv.Itoa(half)}}); code != http.StatusAccepted || upload.Size != int64(len(bin)) {
+		t.Fatalf("expected status code 202 with size %d, actual %d: %+v", len(bin), code, upload)
+	}
+
+	if code, upload := do(t, http.MethodGet, "/api/uploads/"+upload.ID, nil, nil); code != http.StatusOK || upload.Size != int64(len(bin)) {
+		t.Fatalf("expected status code 200 with size %d, actual %d: %+v", len(bin), code, upload)
+	}
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test.gguf": "upload:" + upload.ID},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Layers) != 1 || m.Layers[0].Digest != digest {
+		t.Errorf("expected the uploaded model layer %s, actual %+v", digest, m.Layers)
+	}
+
+	checkFileExists(t, filepath.Join(p, "uploads", "*"), nil)
+
+	t.Run("finished upload", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "test2",
+			Files:  map[string]string{"test.gguf": "upload:" + upload.ID},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		if !strings.Contains(w.Body.String(), "unknown upload") {
+			t.Errorf("unexpected error %s", w.Body.String())
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		t.Setenv("OLLAMA_MAX_UPLOAD_SIZE", "16")
+
+		_, upload := do(t, http.MethodPost, "/api/uploads", nil, nil)
+		if code, _ := do(t, http.MethodPatch, "/api/uploads/"+upload.ID, bin[:16], nil); code != http.StatusAccepted {
+			t.Fatalf("expected status code 202, actual %d", code)
+		}
+
+		if code, _ := do(t, http.MethodPatch, "/api/uploads/"+upload.ID, bin[16:17], nil); code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected status code 413, actual %d", code)
+		}
+
+		if code, upload := do(t, http.MethodGet, "/api/uploads/"+upload.ID, nil, nil); code != http.StatusOK || upload.Size != 16 {
+			t.Fatalf("expected status code 200 with size 16, actual %d: %+v", code, upload)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		_, expired := do(t, http.MethodPost, "/api/uploads", nil, nil)
+
+		// starting an upload removes those not written to within the TTL
+		t.Setenv("OLLAMA_UPLOAD_TTL", "1ns")
+		_, upload := do(t, http.MethodPost, "/api/uploads", nil, nil)
+
+		if code, _ := do(t, http.MethodGet, "/api/uploads/"+expired.ID, nil, nil); code != http.StatusNotFound {
+			t.Errorf("expected status code 404, actual %d", code)
+		}
+
+		if code, _ := do(t, http.MethodGet, "/api/uploads/"+upload.ID, nil, nil); code != http.StatusOK {
+			t.Errorf("expected status code 200, actual %d", code)
+		}
+	})
+
+	t.Run("locks", func(t *testing.T) {
+		for _, id := range []string{"not-an-id", uuid.NewString()} {
+			if code, _ := do(t, http.MethodPatch, "/api/uploads/"+id, bin[:1], nil); code != http.StatusNotFound {
+				t.Errorf("expected status code 404 for %s, actual %d", id, code)
+			}
+		}
+
+		_, upload := do(t, http.MethodPost, "/api/uploads", nil, nil)
+		do(t, http.MethodPatch, "/api/uploads/"+upload.ID, bin[:1], nil)
+		req, err := http.NewRequest(http.MethodDelete, srv.URL+"/api/uploads/"+upload.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", resp.StatusCode)
+		}
+
+		// no lock outlives the requests for an upload
+		uploadLocks.Lock()
+		defer uploadLocks.Unlock()
+		if len(uploadLocks.locks) > 0 {
+			t.Errorf("expected no upload locks, actual %d", len(uploadLocks.locks))
+		}
+	})
+}
+
+func TestCreateProtected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:     "test",
+		Files:     map[string]string{"test.gguf": digest},
+		Protected: true,
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	for _, r := range []api.CreateRequest{
+		{Model: "test", Files: map[string]string{"test.gguf": digest}, System: "overwritten", Stream: &stream},
+		{Model: "other", Aliases: []string{"test"}, Files: map[string]string{"test.gguf": digest}, Stream: &stream},
+	} {
+		w := createRequest(t, s.CreateHandler, r)
+		if w.Code != http.StatusConflict {
+			t.Fatalf("expected status code 409, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		if !strings.Contains(w.Body.String(), "model is protected") {
+			t.Errorf("unexpected error %s", w.Body.String())
+		}
+	}
+
+	w = createRequest(t, s.CreateBatchHandler, api.CreateBatchRequest{
+		Models: []api.CreateRequest{{Model: "test", From: "test", System: "overwritten"}},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status code 409, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	// models derived from a protected model aren't protected
+	for range 2 {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "derived",
+			From:   "test",
+			System: "derived",
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	w = createRequest(t, s.ProtectHandler, api.ProtectRequest{Model: "test", Protected: false})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test.gguf": digest},
+		System: "overwritten",
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	w = createRequest(t, s.ProtectHandler, api.ProtectRequest{Model: "test", Protected: true})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status code 409, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	w = createRequest(t, s.ProtectHandler, api.ProtectRequest{Model: "missing", Protected: true})
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status code 404, actual %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCreateThink(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	cases := []struct {
+		value  any
+		code   int
+		expect bool
+	}{
+		{"true", http.StatusOK, true},
+		{"false", http.StatusOK, false},
+		{true, http.StatusOK, true},
+		{"maybe", http.StatusBadRequest, false},
+		{float64(1), http.StatusBadRequest, false},
+	}
+
+	for _, tt := range cases {
+		t.Run(fmt.Sprint(tt.value), func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:      "test",
+				Files:      map[string]string{"test.gguf": digest},
+				Parameters: map[string]any{"think": tt.value},
+				Stream:     &stream,
+			})
+
+			if w.Code != tt.code {
+				t.Fatalf("expected status code %d, actual %d: %s", tt.code, w.Code, w.Body.String())
+			}
+
+			if tt.code != http.StatusOK {
+				if !strings.Contains(w.Body.String(), "invalid think parameter") {
+					t.Errorf("unexpected error %s", w.Body.String())
+				}
+				return
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the parameter is stored as a boolean
+			if think, ok := m.Options["think"].(bool); !ok || think != tt.expect {
+				t.Errorf("expected think %t, actual %v", tt.expect, m.Options["think"])
+			}
+
+			if m.think(nil) != tt.expect {
+				t.Errorf("expected the model to default think to %t", tt.expect)
+			}
+		})
+	}
+}
+
+func TestCreateFromModelWithoutConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	name := model.ParseName("test")
+	m, err := ParseNamedManifest(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid, err := NewLayer(strings.NewReader("not json"), "application/vnd.docker.container.image.v1+json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configs := []struct {
+		name   string
+		config Layer
+		expect string
+	}{
+		{"missing", Layer{}, "source model manifest is missing its config layer"},
+		{"missing blob", Layer{MediaType: "application/vnd.docker.container.image.v1+json", Digest: "sha256:" + strings.Repeat("0", 64)}, "source model manifest is missing its config layer"},
+		{"invalid", invalid, "source model config is invalid"},
+	}
+
+	for _, config := range configs {
+		if err := WriteManifest(name, config.config, m.Layers); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, r := range []api.CreateRequest{
+			// only the text layers change, so the manifest is reused
+			{Model: "derived", From: "test", System: "derived"},
+			// the model layers are decoded again
+			{Model: "derived", From: "test", ContextFromModel: true},
+		} {
+			t.Run(config.name, func(t *testing.T) {
+				r.Stream = &stream
+				w := createRequest(t, s.CreateHandler, r)
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+				}
+
+				if !strings.Contains(w.Body.String(), config.expect) {
+					t.Errorf("expected error %q, actual %s", config.expect, w.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestCreateModelFamilies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	// the projector sorts before the model but the model's family is primary
+	_, projector := createBinFile(t, llm.KV{
+		"general.architecture":    "clip",
+		"clip.vision.block_count": uint32(1),
+	}, nil)
+
+	_, base := createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)
+
+	_, adapter := createBinFile(t, llm.KV{
+		"general.architecture": "llama",
+		"general.type":         "adapter",
+	}, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"a-mmproj.gguf": projector, "b-model.gguf": base},
+		Adapters: map[string]string{"adapter.gguf": adapter},
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Config.ModelFamily != "llama" {
+		t.Errorf("expected model family llama, actual %q", m.Config.ModelFamily)
+	}
+
+	expect := []string{"llama", "clip"}
+	if !slices.Equal(m.Config.ModelFamilies, expect) {
+		t.Errorf("expected model families %v, actual %v", expect, m.Config.ModelFamilies)
+	}
+
+	resp, err := GetModelInfo(api.ShowRequest{Model: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Details.Family != "llama" || !slices.Equal(resp.Details.Families, expect) {
+		t.Errorf("expected show to report family llama and families %v, actual %q %v", expect, resp.Details.Family, resp.Details.Families)
+	}
+}
+
+func TestCreateBackendParameters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model: "test",
+		Files: map[string]string{"test.gguf": digest},
+		Parameters: map[string]any{
+			"num_batch": float64(256),
+			"backends": map[string]any{
+				"cuda":  map[string]any{"num_batch": float64(1024), "use_mmap": false},
+				"metal": map[string]any{"num_batch": float64(512)},
+			},
+		},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		backend     string
+		requestOpts map[string]any
+		numBatch    int
+		useMMap     *bool
+	}{
+		{"cpu", nil, 256, nil},
+		{"rocm", nil, 256, nil},
+		{"metal", nil, 512, nil},
+		{"cuda", nil, 1024, &[]bool{false}[0]},
+		// request options take precedence
+		{"cuda", map[string]any{"num_batch": float64(64)}, 64, &[]bool{false}[0]},
+	}
+
+	for _, tt := range cases {
+		opts, err := modelOptions(m, tt.backend, nil, tt.requestOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if opts.NumBatch != tt.numBatch {
+			t.Errorf("%s: expected num_batch %d, actual %d", tt.backend, tt.numBatch, opts.NumBatch)
+		}
+
+		if !reflect.DeepEqual(opts.UseMMap, tt.useMMap) {
+			t.Errorf("%s: expected use_mmap %v, actual %v", tt.backend, tt.useMMap, opts.UseMMap)
+		}
+	}
+
+	// backend specific parameters are kept when the model is created from
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:      "derived",
+		From:       "test",
+		Parameters: map[string]any{"temperature": float64(0.5)},
+		Stream:     &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	derived, err := GetModel("derived")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(derived.Options["backends"], m.Options["backends"]) {
+		t.Errorf("expected backends %v, actual %v", m.Options["backends"], derived.Options["backends"])
+	}
+
+	invalid := []struct {
+		name     string
+		backends any
+	}{
+		{"not a map", "cuda"},
+		{"unknown backend", map[string]any{"vulkan": map[string]any{"num_batch": float64(1)}}},
+		{"parameters not a map", map[string]any{"cuda": float64(1)}},
+		{"unknown parameter", map[string]any{"cuda": map[string]any{"num_flux": float64(1)}}},
+		{"keep_alive", map[string]any{"cuda": map[string]any{"keep_alive": "5m"}}},
+		{"wrong type", map[string]any{"metal": map[string]any{"use_mmap": "no"}}},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:      "invalid",
+				Files:      map[string]string{"test.gguf": digest},
+				Parameters: map[string]any{"backends": tt.backends},
+				Stream:     &stream,
+			})
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			if !strings.Contains(w.Body.String(), "invalid backends parameter") {
+				t.Errorf("expected an invalid backends parameter error, actual %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateEstimateMemory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	var tensors []llm.Tensor
+	for _, name := range []string{"blk.0.attn.weight", "blk.1.attn.weight", "output.weight"} {
+		tensors = append(tensors, llm.Tensor{Name: name, Kind: 0, Shape: []uint64{1 << 20}, WriterTo: bytes.NewReader(make([]byte, 4<<20))})
+	}
+
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture":          "llama",
+		"llama.context_length":          uint32(4096),
+		"llama.embedding_length":        uint32(4096),
+		"llama.block_count":             uint32(2),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(32),
+		"tokenizer.ggml.tokens":         []string{" "},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, tensors)
+
+	gpu := func(library string, free uint64) discover.GpuInfoList {
+		g := discover.GpuInfo{Library: library}
+		g.TotalMemory = free
+		g.FreeMemory = free
+		return discover.GpuInfoList{g}
+	}
+
+	cases := []struct {
+		name     string
+		estimate bool
+		context  int
+		gpus     discover.GpuInfoList
+		expect   string
+	}{
+		{"not requested", false, 0, gpu("cuda", format.MebiByte), ""},
+		{"fits", true, 0, gpu("cuda", 64*format.GibiByte), ""},
+		{"partial", true, 0, gpu("cuda", 64*format.MebiByte), "needs about 76.0 MiB with a context of 2048 but 64.0 MiB of GPU memory is free, so it will run on the CPU"},
+		{"context", true, 4096, gpu("cuda", 64*format.MebiByte), "with a context of 4096"},
+		{"cpu", true, 0, gpu("cpu", 64*format.GibiByte), "no GPU was detected so test:latest will run on the CPU"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Server{sched: &Scheduler{getGpuFn: func() discover.GpuInfoList { return tt.gpus }}}
+
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:           "test",
+				Files:           map[string]string{"test.gguf": digest},
+				EstimateMemory:  tt.estimate,
+				EstimateContext: tt.context,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			var warnings []string
+			var last string
+			for d := json.NewDecoder(w.Body); ; {
+				var resp api.ProgressResponse
+				if err := d.Decode(&resp); errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+
+				if strings.HasPrefix(resp.Status, "warning:") {
+					warnings = append(warnings, resp.Status)
+				}
+				last = resp.Status
+			}
+
+			if last != "success" {
+				t.Errorf("expected the create to succeed, actual %q", last)
+			}
+
+			if tt.expect == "" && len(warnings) > 0 {
+				t.Errorf("expected no warning, actual %v", warnings)
+			} else if tt.expect != "" && (len(warnings) != 1 || !strings.Contains(warnings[0], tt.expect)) {
+				t.Errorf("expected a warning containing %q, actual %v", tt.expect, warnings)
+			}
+		})
+	}
+}
+
+func TestCreateStripMetadata(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture":         "llama",
+		"general.file_type":            uint32(1),
+		"general.description":          "a model",
+		"llama.context_length":         uint32(4096),
+		"llama.rope.freq_base":         float32(10000),
+		"tokenizer.ggml.tokens":        []string{"a", "b"},
+		"tokenizer.chat_template":      "{{ messages }}",
+		"training.log":                 strings.Repeat("step ", 1<<16),
+		"general.quantization_version": uint32(2),
+	}, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:         "test",
+		Files:         map[string]string{"test.gguf": digest},
+		StripMetadata: true,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	if !strings.Contains(w.Body.String(), "stripped 2 metadata keys, saving 320.1 KiB") {
+		t.Errorf("expected the bytes saved to be reported, actual %s", w.Body.String())
+	}
+
+	keys := func(t *testing.T, name string) []string {
+		t.Helper()
+
+		m, err := GetModel(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ggml, err := llm.LoadModel(m.ModelPath, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return slices.Sorted(maps.Keys(ggml.KV()))
+	}
+
+	expect := []string{
+		"general.architecture",
+		"general.file_type",
+		"general.parameter_count",
+		"general.quantization_version",
+		"llama.context_length",
+		"llama.rope.freq_base",
+		"tokenizer.chat_template",
+		"tokenizer.ggml.tokens",
+	}
+
+	if actual := keys(t, "test"); !slices.Equal(actual, expect) {
+		t.Errorf("expected keys %v, actual %v", expect, actual)
+	}
+
+	t.Run("from", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "unstripped",
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		w = createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:         "stripped",
+			From:          "unstripped",
+			StripMetadata: true,
+			Stream:        &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		if actual := keys(t, "stripped"); !slices.Equal(actual, expect) {
+			t.Errorf("expected keys %v, actual %v", expect, actual)
+		}
+
+		if actual := keys(t, "unstripped"); !slices.Contains(actual, "training.log") {
+			t.Errorf("expected the original model to be unchanged, actual %v", actual)
+		}
+	})
+}
+
+func TestCreateStreamError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"test.gguf": digest},
+		Template: "{{ .Prompt",
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	// every response, including the error, decodes as a progress response
+	var responses []api.ProgressResponse
+	for d := json.NewDecoder(w.Body); ; {
+		var resp api.ProgressResponse
+		if err := d.Decode(&resp); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		responses = append(responses, resp)
+	}
+
+	if len(responses) == 0 {
+		t.Fatal("expected progress responses")
+	}
+
+	last := responses[len(responses)-1]
+	if !strings.Contains(last.Error, "template error") || last.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a bad request error, actual %+v", last)
+	}
+
+	if last.RequestID == "" || last.RequestID != responses[0].RequestID {
+		t.Errorf("expected the error to have the request id %q, actual %q", responses[0].RequestID, last.RequestID)
+	}
+}
+
+func TestCreatePreset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:      "base",
+		Files:      map[string]string{"test.gguf": digest},
+		Parameters: map[string]any{"temperature": 0.9, "num_ctx": 4096.0},
+		Stream:     &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	cases := []struct {
+		name       string
+		preset     string
+		parameters map[string]any
+		expect     map[string]any
+	}{
+		{
+			"preset",
+			"precise",
+			nil,
+			map[string]any{"temperature": 0.2, "top_p": 0.5, "top_k": 10.0, "repeat_penalty": 1.15, "num_ctx": 4096.0},
+		},
+		{
+			"explicit parameters",
+			"creative",
+			map[string]any{"temperature": 0.4},
+			map[string]any{"temperature": 0.4, "top_p": 0.95, "top_k": 100.0, "repeat_penalty": 1.05, "num_ctx": 4096.0},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:      "test",
+				From:       "base",
+				Preset:     tt.preset,
+				Parameters: tt.parameters,
+				Stream:     &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !maps.Equal(m.Options, tt.expect) {
+				t.Errorf("expected parameters %v, actual %v", tt.expect, m.Options)
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "test",
+			From:   "base",
+			Preset: "spicy",
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		if !strings.Contains(w.Body.String(), "unknown preset 'spicy', available presets: balanced, creative, deterministic, precise") {
+			t.Errorf("expected the available presets to be listed, actual %s", w.Body.String())
+		}
+	})
+}
+
+func TestCreateTruncatedGGUF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := llm.WriteGGUF(f, llm.KV{"general.architecture": "llama"}, []llm.Tensor{
+		{Name: "blk.0.attn.weight", Kind: 0, Shape: []uint64{8}, WriterTo: bytes.NewReader(make([]byte, 32))},
+		{Name: "blk.1.attn.weight", Kind: 0, Shape: []uint64{8}, WriterTo: bytes.NewReader(make([]byte, 32))},
+		{Name: "output.weight", Kind: 0, Shape: []uint64{8}, WriterTo: bytes.NewReader(make([]byte, 32))},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cut the file off partway through the last tensor's data
+	if err := f.Truncate(fi.Size() - 16); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	digest, _ := GetSHA256Digest(f)
+	if err := createLink(f.Name(), filepath.Join(p, "blobs", "sha256-"+strings.TrimPrefix(digest, "sha256:"))); err != nil {
+		t.Fatal(err)
+	}
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	if !strings.Contains(w.Body.String(), "GGUF truncated: expected 3 tensors, found data for 2") {
+		t.Errorf("expected a truncated GGUF error, actual %s", w.Body.String())
+	}
+
+	checkFileExists(t, filepath.Join(p, "manifests", "*", "*", "*", "*"), []string{})
+}
+
+func TestCreateSafety(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	safety := api.SafetyClassification{ContentRating: "general", IntendedUse: "customer support"}
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "base",
+		Files:  map[string]string{"test.gguf": digest},
+		Safety: &safety,
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Run("show", func(t *testing.T) {
+		resp, err := GetModelInfo(api.ShowRequest{Model: "base"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Safety == nil || *resp.Safety != safety {
+			t.Errorf("expected safety %+v, actual %+v", safety, resp.Safety)
+		}
+	})
+
+	t.Run("inherited", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "test",
+			From:   "base",
+			System: "be brief",
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Safety == nil || *m.Safety != safety {
+			t.Errorf("expected safety %+v, actual %+v", safety, m.Safety)
+		}
+	})
+
+	t.Run("replaced", func(t *testing.T) {
+		replaced := api.SafetyClassification{ContentRating: "mature"}
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "test",
+			From:   "base",
+			Safety: &replaced,
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Safety == nil || *m.Safety != replaced {
+			t.Errorf("expected safety %+v, actual %+v", replaced, m.Safety)
+		}
+
+		mf, err := ParseNamedManifest(model.ParseName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var n int
+		for _, layer := range mf.Layers {
+			if layer.MediaType == "application/vnd.ollama.image.safety" {
+				n++
+			}
+		}
+
+		if n != 1 {
+			t.Errorf("expected 1 safety layer, actual %d", n)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "test",
+			From:   "base",
+			Safety: &api.SafetyClassification{},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestCreateQuantizeCheckpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture": "llama",
+		"general.file_type":    uint32(1),
+	}, nil)
+
+	// a model quantized by an earlier create which didn't finish
+	_, quantized := createBinFile(t, llm.KV{
+		"general.architecture": "llama",
+		"general.file_type":    uint32(2),
+	}, nil)
+
+	checkpoint := &quantizeCheckpoint{Source: digest, Type: "Q4_0"}
+	if err := checkpoint.save(quantized); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("mismatch", func(t *testing.T) {
+		for _, c := range []quantizeCheckpoint{
+			{Source: digest, Type: "Q8_0"},
+			{Source: digest, Type: "Q4_0", Policy: map[string]string{"ffn": "Q8_0"}},
+			{Source: "sha256:" + strings.Repeat("0", 64), Type: "Q4_0"},
+		} {
+			if _, err := c.open("application/vnd.ollama.image.model"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected no checkpoint for %+v, actual %v", c, err)
+			}
+		}
+
+		// a description which doesn't match its file name isn't used
+		other := quantizeCheckpoint{Source: digest, Type: "Q8_0"}
+		b, err := os.ReadFile(checkpoint.path())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(other.path(), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		defer other.remove()
+
+		if _, err := other.open("application/vnd.ollama.image.model"); !errors.Is(err, errCheckpointMismatch) {
+			t.Errorf("expected a mismatched checkpoint, actual %v", err)
+		}
+	})
+
+	t.Run("kept from prune", func(t *testing.T) {
+		digests := map[string]struct{}{quantized: {}, digest: {}}
+		if err := keepCheckpointBlobs(digests); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := digests[quantized]; ok {
+			t.Errorf("expected the quantized model to be kept")
+		}
+
+		if _, ok := digests[digest]; !ok {
+			t.Errorf("expected the source model not to be kept")
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expired := &quantizeCheckpoint{Source: digest, Type: "Q8_0"}
+		if err := expired.save(digest); err != nil {
+			t.Fatal(err)
+		}
+
+		past := time.Now().Add(-quantizeCheckpointTTL)
+		if err := os.Chtimes(expired.path(), past, past); err != nil {
+			t.Fatal(err)
+		}
+
+		digests := map[string]struct{}{digest: {}}
+		if err := keepCheckpointBlobs(digests); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := digests[digest]; !ok {
+			t.Errorf("expected the model of an expired checkpoint not to be kept")
+		}
+
+		if _, err := os.Stat(expired.path()); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected the expired checkpoint to be removed, actual %v", err)
+		}
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"test.gguf": digest},
+		Quantize: "q4_0",
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	if !strings.Contains(w.Body.String(), "resuming from an earlier quantization") {
+		t.Errorf("expected the checkpoint to be used, actual %s", w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Config.FileType != "Q4_0" {
+		t.Errorf("expected file type Q4_0, actual %s", m.Config.FileType)
+	}
+
+	checkFileExists(t, filepath.Join(p, "checkpoints", "*"), []string{})
+}
+
+func TestCreateToolTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	mistral, err := os.ReadFile(filepath.Join("testdata", "tools", "mistral.gotmpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xlam, err := os.ReadFile(filepath.Join("testdata", "tools", "xlam.gotmpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		template string
+		expect   string
+	}{
+		{"no tools", "{{ .Prompt }}", ""},
+		{"json tool calls", string(mistral), ""},
+		{
+			"plain tool calls",
+			"{{ if .Tools }}{{ .Tools }}{{ end }}{{ range .Messages }}{{ range .ToolCalls }}call {{ .Function.Name }}{{ end }}{{ .Content }}{{ end }}",
+			"the template uses tools but doesn't render tool calls as JSON with a name and arguments",
+		},
+		{"no tool results", string(xlam), "the template uses tools but doesn't render tool results"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:    "test",
+				Files:    map[string]string{"test.gguf": digest},
+				Template: tt.template,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			warned := strings.Contains(w.Body.String(), "the template uses tools")
+			if tt.expect == "" && warned {
+				t.Errorf("expected no warning, actual %s", w.Body.String())
+			} else if tt.expect != "" && !strings.Contains(w.Body.String(), tt.expect) {
+				t.Errorf("expected %q, actual %s", tt.expect, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateModelSync(t *testing.T) {
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	_, digest := createBinFile(t, nil, nil)
+
+	t.Run("without progress", func(t *testing.T) {
+		created, err := CreateModel(context.Background(), api.CreateRequest{
+			Model:  "test",
+			Files:  map[string]string{"test.gguf": digest},
+			System: "be brief",
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if created.Name.DisplayShortest() != "test:latest" {
+			t.Errorf("expected name test:latest, actual %s", created.Name.DisplayShortest())
+		}
+
+		mf, err := ParseNamedManifest(model.ParseName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if created.Digest != mf.digest {
+			t.Errorf("expected digest %s, actual %s", mf.digest, created.Digest)
+		}
+
+		if created.Config.ModelFormat != "gguf" {
+			t.Errorf("expected model format gguf, actual %q", created.Config.ModelFormat)
+		}
+	})
+
+	t.Run("with progress", func(t *testing.T) {
+		ch := make(chan api.ProgressResponse)
+		done := make(chan []string)
+		go func() {
+			var statuses []string
+			for resp := range ch {
+				statuses = append(statuses, resp.Status)
+			}
+			done <- statuses
+		}()
+
+		_, err := CreateModel(context.Background(), api.CreateRequest{
+			Model:     "test2",
+			From:      "test",
+			Protected: true,
+		}, ch)
+		close(ch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if statuses := <-done; !slices.Contains(statuses, "writing manifest") {
+			t.Errorf("expected progress to be sent, actual %v", statuses)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := CreateModel(context.Background(), api.CreateRequest{Model: "test2", From: "test"}, nil); !errors.Is(err, errModelProtected) {
+			t.Errorf("expected a protected model error, actual %v", err)
+		}
+
+		_, err := CreateModel(context.Background(), api.CreateRequest{Model: "test3"}, nil)
+		if resp := createErrorResponse(err); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected a bad request, actual %v", err)
+		}
+	})
+}
+
+func TestCreateDigestAlgorithm(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "base",
+		Files:    map[string]string{"test.gguf": digest},
+		Template: "{{ .Prompt }}",
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	algorithms := func(t *testing.T, name string) map[string]string {
+		t.Helper()
+
+		mf, err := ParseNamedManifest(model.ParseName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		algorithms := map[string]string{"config": strings.Split(mf.Config.Digest, ":")[0]}
+		for _, layer := range mf.Layers {
+			algorithms[layer.MediaType] = strings.Split(layer.Digest, ":")[0]
+
+			if err := verifyBlob(layer.Digest); err != nil {
+				t.Errorf("%s: %v", layer.MediaType, err)
+			}
+		}
+
+		return algorithms
+	}
+
+	t.Run("sha512", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:           "test",
+			From:            "base",
+			DigestAlgorithm: "sha512",
+			Stream:          &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		expect := map[string]string{
+			"config":                                "sha512",
+			"application/vnd.ollama.image.model":    "sha512",
+			"application/vnd.ollama.image.template": "sha512",
+		}
+
+		if actual := algorithms(t, "test"); !maps.Equal(actual, expect) {
+			t.Errorf("expected %v, actual %v", expect, actual)
+		}
+
+		if _, err := GetModel("test"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("default keeps digests", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "test2",
+			From:   "test",
+			System: "be brief",
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		expect := map[string]string{
+			"config":                                "sha256",
+			"application/vnd.ollama.image.model":    "sha512",
+			"application/vnd.ollama.image.template": "sha512",
+			"application/vnd.ollama.image.system":   "sha256",
+		}
+
+		if actual := algorithms(t, "test2"); !maps.Equal(actual, expect) {
+			t.Errorf("expected %v, actual %v", expect, actual)
+		}
+	})
+
+	t.Run("new layers", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:           "test4",
+			From:            "base",
+			System:          "only hashed with sha512",
+			DigestAlgorithm: "sha512",
+			Stream:          &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		if actual := algorithms(t, "test4")["application/vnd.ollama.image.system"]; actual != "sha512" {
+			t.Errorf("expected the system layer to be sha512, actual %s", actual)
+		}
+
+		// the layers written by the create are hashed with sha512 as they're
+		// written rather than being written as sha256 blobs first
+		digest, _ := GetSHA256Digest(strings.NewReader("only hashed with sha512"))
+		blob, err := GetBlobsPath(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(blob); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected no sha256 blob of the system prompt, actual %v", err)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:           "test3",
+			From:            "base",
+			DigestAlgorithm: "md5",
+			Stream:          &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("blob", func(t *testing.T) {
+		b := []byte("blob")
+		sum := sha512.Sum512(b)
+		digest := fmt.Sprintf("sha512:%x", sum)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "digest", Value: digest}}
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/blobs/"+digest, bytes.NewReader(b))
+		s.CreateBlobHandler(c)
+
+		if c.Writer.Status() != http.StatusCreated {
+			t.Fatalf("expected status code 201, actual %d: %s", c.Writer.Status(), w.Body.String())
+		}
+
+		if err := verifyBlob(digest); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestCreateSummary(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture": "llama",
+		"general.file_type":    uint32(1),
+	}, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:         "test",
+		Files:         map[string]string{"test.gguf": digest},
+		System:        "be brief",
+		ParameterSize: "7B",
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+
+	var last api.ProgressResponse
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatal(err)
+	}
+
+	if last.Status != "success" || last.Created == nil {
+		t.Fatalf("expected the last response to be a summary of the model, actual %+v", last)
+	}
+
+	mf, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := api.CreateSummary{
+		Model:         "test:latest",
+		Digest:        mf.digest,
+		Size:          mf.Size(),
+		Layers:        2,
+		Quantization:  "F16",
+		ParameterSize: "7B",
+	}
+
+	if !reflect.DeepEqual(*last.Created, expect) {
+		t.Errorf("expected %+v, actual %+v", expect, *last.Created)
+	}
+}
+
+func TestCreateGrammar(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	cases := []struct {
+		name    string
+		grammar string
+		schema  json.RawMessage
+		code    int
+		expect  string
+	}{
+		{"grammar", "root ::= \"yes\" | \"no\"", nil, http.StatusOK, ""},
+		{"schema", "", json.RawMessage(`{"type": "object", "properties": {"answer": {"type": "boolean"}}}`), http.StatusOK, ""},
+		{"invalid grammar", "root ::= answer\nanswer ::= (\"yes\" |\n  \"no)\n", nil, http.StatusBadRequest, "invalid grammar: line 3, column 7: unterminated literal"},
+		{"invalid schema", "", json.RawMessage(`["answer"]`), http.StatusBadRequest, "invalid schema: schema must be a JSON object"},
+		{"both", "root ::= \"yes\"", json.RawMessage(`{"type": "object"}`), http.StatusBadRequest, "only one of 'grammar' or 'schema' can be specified"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:   "test",
+				Files:   map[string]string{"test.gguf": digest},
+				Grammar: tt.grammar,
+				Schema:  tt.schema,
+				Stream:  &stream,
+			})
+
+			if w.Code != tt.code {
+				t.Fatalf("expected status code %d, actual %d: %s", tt.code, w.Code, w.Body.String())
+			}
+
+			if tt.code != http.StatusOK {
+				if !strings.Contains(w.Body.String(), tt.expect) {
+					t.Errorf("expected %q, actual %s", tt.expect, w.Body.String())
+				}
+				return
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m.Grammar != tt.grammar {
+				t.Errorf("expected grammar %q, actual %q", tt.grammar, m.Grammar)
+			}
+
+			var expect, actual any
+			if len(tt.schema) > 0 {
+				if err := json.Unmarshal(tt.schema, &expect); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := json.Unmarshal(m.Schema, &actual); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !reflect.DeepEqual(expect, actual) {
+				t.Errorf("expected schema %s, actual %s", tt.schema, m.Schema)
+			}
+		})
+	}
+}
+
+func TestCreateFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	cases := []struct {
+		name   string
+		r      api.CreateRequest
+		code   int
+		format string
+		schema string
+		expect string
+	}{
+		{name: "json", r: api.CreateRequest{Format: json.RawMessage(`"json"`)}, code: http.StatusOK, format: `"json"`},
+		{name: "schema", r: api.CreateRequest{Format: json.RawMessage(`{"type":"object"}`)}, code: http.StatusOK, schema: `{"type":"object"}`},
+		{name: "invalid", r: api.CreateRequest{Format: json.RawMessage(`"yaml"`)}, code: http.StatusBadRequest, expect: `invalid format, expected "json" or a JSON schema: "yaml"`},
+		{name: "invalid schema", r: api.CreateRequest{Format: json.RawMessage(`["answer"]`)}, code: http.StatusBadRequest, expect: "invalid schema: schema must be a JSON object"},
+		{name: "with grammar", r: api.CreateRequest{Format: json.RawMessage(`"json"`), Grammar: `root ::= "yes"`}, code: http.StatusBadRequest, expect: errFormatAndGrammar.Error()},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.r.Model, tt.r.Files, tt.r.Stream = "test", map[string]string{"test.gguf": digest}, &stream
+			w := createRequest(t, s.CreateHandler, tt.r)
+			if w.Code != tt.code {
+				t.Fatalf("expected status code %d, actual %d: %s", tt.code, w.Code, w.Body.String())
+			}
+
+			if tt.code != http.StatusOK {
+				var resp struct{ Error string }
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+
+				if !strings.Contains(resp.Error, tt.expect) {
+					t.Errorf("expected %q, actual %q", tt.expect, resp.Error)
+				}
+				return
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// each case replaces the format of the model the last created
+			if string(m.Format) != tt.format || string(m.Schema) != tt.schema {
+				t.Errorf("expected format %q and schema %q, actual %q and %q", tt.format, tt.schema, m.Format, m.Schema)
+			}
+		})
+	}
+}
+
+func TestCreateFileProgress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, modelDigest := createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)
+
+	// a file of two projectors is copied into a layer for each
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for range 2 {
+		if err := llm.WriteGGUF(f, llm.KV{"general.architecture": "clip", "general.type": "projector"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	projectorDigest, _ := GetSHA256Digest(f)
+	if err := createLink(f.Name(), filepath.Join(p, "blobs", strings.Replace(projectorDigest, ":", "-", 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model: "test",
+		Files: map[string]string{"model.gguf": modelDigest, "projector.gguf": projectorDigest},
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	digests := map[string]string{"model.gguf": modelDigest, "projector.gguf": projectorDigest}
+	parsed := make(map[string]bool)
+	var copied []int64
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		var resp api.ProgressResponse
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		switch resp.Status {
+		case "parsing GGUF":
+			if resp.Digest != digests[resp.File] {
+				t.Errorf("expected %s to be identified by %s, actual %q", resp.File, digests[resp.File], resp.Digest)
+			}
+			parsed[resp.File] = true
+		case "copying GGUF":
+			if resp.File != "projector.gguf" || resp.Digest != projectorDigest || resp.Total != fi.Size() {
+				t.Errorf("expected the progress of projector.gguf, actual %+v", resp)
+			}
+			copied = append(copied, resp.Completed)
+		default:
+			if resp.File != "" {
+				t.Errorf("expected %q not to be for a file, actual %s", resp.Status, resp.File)
+			}
+		}
+	}
+
+	if !parsed["model.gguf"] || !parsed["projector.gguf"] {
+		t.Errorf("expected each file to be parsed, actual %v", parsed)
+	}
+
+	if len(copied) < 2 || !slices.IsSorted(copied) || copied[len(copied)-1] != fi.Size() {
+		t.Errorf("expected the projectors to be copied, actual %v", copied)
+	}
+}
+
+func TestCreateRootFSType(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	create := func(t *testing.T, r api.CreateRequest) (*Manifest, ConfigV2) {
+		t.Helper()
+		r.Stream = &stream
+		w := createRequest(t, s.CreateHandler, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := ParseNamedManifest(model.ParseName(r.Model))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := readManifestConfig(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return m, config
+	}
+
+	t.Run("layers", func(t *testing.T) {
+		m, config := create(t, api.CreateRequest{
+			Model:    "test",
+			Files:    map[string]string{"test.gguf": digest},
+			Template: "{{ .Prompt }}",
+		})
+
+		if config.RootFS.Type != "layers" || len(config.RootFS.DiffIDs) != len(m.Layers) {
+			t.Errorf("expected a diff ID for each layer, actual %+v", config.RootFS)
+		}
+	})
+
+	t.Run("explicit layers", func(t *testing.T) {
+		m, config := create(t, api.CreateRequest{
+			Model:      "explicit",
+			From:       "test",
+			System:     "be brief",
+			RootFSType: "layers",
+		})
+
+		if config.RootFS.Type != "layers" || len(config.RootFS.DiffIDs) != len(m.Layers) {
+			t.Errorf("expected a diff ID for each layer, actual %+v", config.RootFS)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		blobs, err := filepath.Glob(filepath.Join(p, "blobs", "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:      "unknown",
+			Files:      map[string]string{"test.gguf": digest},
+			System:     "a new system prompt",
+			RootFSType: "packed",
+			Stream:     &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+
+		if !strings.Contains(w.Body.String(), `unknown rootfs type \"packed\", expected one of layers`) {
+			t.Errorf("expected the rootfs types, actual %s", w.Body.String())
+		}
+
+		checkFileExists(t, filepath.Join(p, "blobs", "*"), blobs)
+	})
+}
+
+func TestCreateManifestWriteFails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// writes to /dev/full fail as if the disk were full
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("requires /dev/full")
+	}
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "base",
+		Files:  map[string]string{"test.gguf": digest},
+		System: "be brief",
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	blobs, err := filepath.Glob(filepath.Join(p, "blobs", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the system prompt is shared with base so its blob is kept, while the
+	// template and config blobs are new
+	config, layers, err := buildModel(context.Background(), api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"test.gguf": digest},
+		System:   "be brief",
+		Template: "{{ .Prompt }}",
+	}, func(api.ProgressResponse) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created, err := filepath.Glob(filepath.Join(p, "blobs", "*")); err != nil {
+		t.Fatal(err)
+	} else if len(created) != len(blobs)+2 {
+		t.Fatalf("expected 2 new blobs, actual %v", created)
+	}
+
+	manifest := filepath.Join(p, "manifests", "registry.ollama.ai", "library", "test", "latest")
+	if err := os.MkdirAll(filepath.Dir(manifest), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("/dev/full", manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeManifests([]pendingModel{{name: model.ParseName("test"), config: *config, layers: layers}})
+	if err == nil || !strings.HasPrefix(err.Error(), "couldn't write the manifest of test:latest") {
+		t.Fatalf("expected the model to be named, actual %v", err)
+	}
+
+	checkFileExists(t, filepath.Join(p, "blobs", "*"), blobs)
+
+	if _, err := os.Lstat(manifest); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the manifest to be removed, actual %v", err)
+	}
+
+	if _, err := GetModel("base"); err != nil {
+		t.Errorf("expected base to be kept, actual %v", err)
+	}
+}
+
+func TestCreateParameterAliases(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	cases := []struct {
+		name     string
+		params   map[string]any
+		expect   map[string]any
+		warnings []string
+	}{
+		{
+			name:     "alias",
+			params:   map[string]any{"n_ctx": 4096, "n_predict": 128, "temperature": 0.5},
+			expect:   map[string]any{"num_ctx": float64(4096), "num_predict": float64(128), "temperature": 0.5},
+			warnings: []string{"warning: parameter n_ctx is deprecated, use num_ctx instead", "warning: parameter n_predict is deprecated, use num_predict instead"},
+		},
+		{
+			name:     "both names",
+			params:   map[string]any{"n_ctx": 4096, "num_ctx": 2048},
+			expect:   map[string]any{"num_ctx": float64(2048)},
+			warnings: []string{"warning: parameter n_ctx is deprecated and ignored since num_ctx is set"},
+		},
+		{
+			name:   "current names",
+			params: map[string]any{"num_ctx": 2048},
+			expect: map[string]any{"num_ctx": float64(2048)},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:      "test",
+				Files:      map[string]string{"test.gguf": digest},
+				Parameters: tt.params,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			var warnings []string
+			for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+				var resp api.ProgressResponse
+				if err := json.Unmarshal([]byte(line), &resp); err != nil {
+					t.Fatal(err)
+				}
+
+				if strings.HasPrefix(resp.Status, "warning: parameter") {
+					warnings = append(warnings, resp.Status)
+				}
+			}
+
+			if !slices.Equal(warnings, tt.warnings) {
+				t.Errorf("expected warnings %q, actual %q", tt.warnings, warnings)
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !maps.Equal(m.Options, tt.expect) {
+				t.Errorf("expected parameters %v, actual %v", tt.expect, m.Options)
+			}
+		})
+	}
+
+	t.Run("request unchanged", func(t *testing.T) {
+		params := map[string]any{"n_ctx": 4096, "temperature": 0.5}
+		resolved, _ := resolveParameterAliases(params)
+		if expect := map[string]any{"num_ctx": 4096, "temperature": 0.5}; !maps.Equal(resolved, expect) {
+			t.Errorf("expected parameters %v, actual %v", expect, resolved)
+		}
+
+		if expect := map[string]any{"n_ctx": 4096, "temperature": 0.5}; !maps.Equal(params, expect) {
+			t.Errorf("expected the request's parameters to be unchanged, actual %v", params)
+		}
+	})
+}
+
+func TestCreateSigned(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	newKey := func(t *testing.T, dir string) ssh.Signer {
+		t.Helper()
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		block, err := ssh.MarshalPrivateKey(key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, "id_ed25519"), pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return signer
+	}
+
+	keys := t.TempDir()
+	t.Setenv("OLLAMA_SIGNING_KEYS", keys)
+	fingerprint := ssh.FingerprintSHA256(newKey(t, keys).PublicKey())
+
+	_, digest := createBinFile(t, nil, nil)
+
+	create := func(t *testing.T, key string) *httptest.ResponseRecorder {
+		t.Helper()
+		return createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:      "test",
+			Files:      map[string]string{"test.gguf": digest},
+			SigningKey: key,
+			Stream:     &stream,
+		})
+	}
+
+	manifest := filepath.Join(p, "manifests", "registry.ollama.ai", "library", "test", "latest")
+	signature := filepath.Join(p, "signatures", "registry.ollama.ai", "library", "test", "latest")
+
+	t.Run("signed", func(t *testing.T) {
+		if w := create(t, "id_ed25519"); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.SignedBy != fingerprint {
+			t.Errorf("expected the model to be signed by %s, actual %q", fingerprint, m.SignedBy)
+		}
+	})
+
+	t.Run("protect", func(t *testing.T) {
+		for _, protected := range []bool{true, false} {
+			if w := createRequest(t, s.ProtectHandler, api.ProtectRequest{Model: "test", Protected: protected}); w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			m, err := GetModel("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m.SignedBy != fingerprint {
+				t.Errorf("expected the model to be signed again by %s, actual %q", fingerprint, m.SignedBy)
+			}
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:      "test",
+			From:       "test",
+			System:     "be brief",
+			SigningKey: "id_ed25519",
+			NoPrune:    true,
+			Stream:     &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		if w := createRequest(t, s.RollbackHandler, api.RollbackRequest{Model: "test"}); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.System != "" || m.SignedBy != fingerprint {
+			t.Errorf("expected the previous model signed by %s, actual %q signed by %q", fingerprint, m.System, m.SignedBy)
+		}
+	})
+
+	t.Run("key unavailable", func(t *testing.T) {
+		// the key which signed the model is still trusted but can't sign
+		trusted := filepath.Join(t.TempDir(), "trusted_signers")
+		b, err := os.ReadFile(signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var sig manifestSignature
+		if err := json.Unmarshal(b, &sig); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(trusted, []byte(sig.PublicKey+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Setenv("OLLAMA_SIGNING_KEYS", t.TempDir())
+		t.Setenv("OLLAMA_TRUSTED_SIGNERS", trusted)
+
+		before, err := os.ReadFile(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := createRequest(t, s.ProtectHandler, api.ProtectRequest{Model: "test", Protected: true})
+		if w.Code != http.StatusConflict {
+			t.Fatalf("expected status code 409, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		if !strings.Contains(w.Body.String(), "model signing key is unavailable") {
+			t.Errorf("expected the signing key to be unavailable, actual %s", w.Body.String())
+		}
+
+		after, err := os.ReadFile(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(before, after) {
+			t.Error("expected the manifest to be unchanged")
+		}
+
+		if _, err := GetModel("test"); err != nil {
+			t.Errorf("expected the model to still be signed, actual %v", err)
+		}
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		// a key the server doesn't sign with or trust
+		other := newKey(t, t.TempDir())
+		if err := signManifest(manifest, other); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := GetModel("test"); !errors.Is(err, errUntrustedSignature) {
+			t.Errorf("expected the signature to be untrusted, actual %v", err)
+		}
+
+		trusted := filepath.Join(t.TempDir(), "trusted_signers")
+		if err := os.WriteFile(trusted, append([]byte("# other\n"), ssh.MarshalAuthorizedKey(other.PublicKey())...), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Setenv("OLLAMA_TRUSTED_SIGNERS", trusted)
+
+		m, err := GetModel("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expect := ssh.FingerprintSHA256(other.PublicKey()); m.SignedBy != expect {
+			t.Errorf("expected the model to be signed by %s, actual %q", expect, m.SignedBy)
+		}
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		if w := create(t, "id_ed25519"); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		b, err := os.ReadFile(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(manifest, append(b, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := GetModel("test"); !errors.Is(err, errInvalidSignature) {
+			t.Errorf("expected the signature to be invalid, actual %v", err)
+		}
+
+		// a changed manifest isn't signed again
+		w := createRequest(t, s.ProtectHandler, api.ProtectRequest{Model: "test", Protected: true})
+		if w.Code != http.StatusConflict {
+			t.Fatalf("expected status code 409, actual %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		if w := create(t, ""); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.SignedBy != "" {
+			t.Errorf("expected the model not to be signed, actual %q", m.SignedBy)
+		}
+
+		if _, err := os.Stat(signature); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected the signature to be removed, actual %v", err)
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		outside := t.TempDir()
+		newKey(t, outside)
+
+		cases := []string{
+			"missing",
+			filepath.Join(outside, "id_ed25519"),
+			filepath.Join("..", filepath.Base(outside), "id_ed25519"),
+		}
+
+		for _, key := range cases {
+			w := create(t, key)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			// every key fails the same way whether or not it exists
+			if expect := fmt.Sprintf("invalid signing key %q", key); !strings.Contains(w.Body.String(), strings.ReplaceAll(expect, `"`, `\"`)) {
+				t.Errorf("expected %s, actual %s", expect, w.Body.String())
+			}
+		}
+	})
+
+	t.Run("no keys", func(t *testing.T) {
+		t.Setenv("OLLAMA_SIGNING_KEYS", "")
+		w := create(t, "id_ed25519")
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		if !strings.Contains(w.Body.String(), "no signing keys are configured") {
+			t.Errorf("expected no signing keys, actual %s", w.Body.String())
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if w := create(t, "id_ed25519"); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := ParseNamedManifest(model.ParseName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.Remove(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(signature); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected the signature to be removed, actual %v", err)
+		}
+	})
+}
+
+func TestCreateDerivedFrom(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	requests := []api.CreateRequest{
+		{Model: "a", Files: map[string]string{"test.gguf": digest}},
+		{Model: "b", From: "a", System: "be brief"},
+		// stripping metadata creates new model layers rather than reusing
+		// the manifest of the model in From
+		{Model: "c", From: "b", StripMetadata: true},
+	}
+
+	digests := make(map[string]string)
+	for _, r := range requests {
+		r.Stream = &stream
+		w := createRequest(t, s.CreateHandler, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := ParseNamedManifest(model.ParseName(r.Model))
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests[r.Model] = "sha256:" + m.digest
+	}
+
+	cases := []struct {
+		model  string
+		expect []string
+	}{
+		{"a", nil},
+		{"b", []string{"a:latest@" + digests["a"]}},
+		{"c", []string{"b:latest@" + digests["b"], "a:latest@" + digests["a"]}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.model, func(t *testing.T) {
+			resp, err := GetModelInfo(api.ShowRequest{Model: tt.model})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(resp.DerivedFrom, tt.expect) {
+				t.Errorf("expected lineage %v, actual %v", tt.expect, resp.DerivedFrom)
+			}
+		})
+	}
+}
+
+func TestCreateMessageRoles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	cases := []struct {
+		name     string
+		messages []api.Message
+		problems []string
+	}{
+		{
+			name: "in order",
+			messages: []api.Message{
+				{Role: "system", Content: "be brief"},
+				{Role: "user", Content: "what's the weather?"},
+				{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "weather"}}}},
+				{Role: "tool", Content: "sunny"},
+				{Role: "assistant", Content: "it's sunny"},
+			},
+		},
+		{
+			name: "out of order",
+			messages: []api.Message{
+				{Role: "user", Content: "hi"},
+				{Role: "user", Content: "hello?"},
+				{Role: "system", Content: "be brief"},
+				{Role: "tool", Content: "sunny"},
+				{Role: "narrator", Content: "meanwhile"},
+			},
+			problems: []string{
+				"messages 1 and 2 are both user messages",
+				"message 3 is a system message after the conversation started",
+				"message 4 is a tool result which doesn't follow an assistant message",
+				`message 5 has unknown role "narrator"`,
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:    "test",
+				Files:    map[string]string{"test.gguf": digest},
+				Messages: tt.messages,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			var warnings []string
+			for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+				var resp api.ProgressResponse
+				if err := json.Unmarshal([]byte(line), &resp); err != nil {
+					t.Fatal(err)
+				}
+
+				if warning, ok := strings.CutPrefix(resp.Status, "warning: "); ok {
+					warnings = append(warnings, warning)
+				}
+			}
+
+			if !slices.Equal(warnings, tt.problems) {
+				t.Errorf("expected warnings %q, actual %q", tt.problems, warnings)
+			}
+
+			w = createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:          "strict",
+				Files:          map[string]string{"test.gguf": digest},
+				Messages:       tt.messages,
+				StrictMessages: true,
+				Stream:         &stream,
+			})
+
+			if len(tt.problems) == 0 {
+				if w.Code != http.StatusOK {
+					t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+				}
+				return
+			}
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp struct {
+				Error string `json:"error"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if expect := "messages are out of order: " + strings.Join(tt.problems, "; "); resp.Error != expect {
+				t.Errorf("expected %q, actual %q", expect, resp.Error)
+			}
+		})
+	}
+}
+
+func TestCreatePinnedBlobs(t *testing.T) {
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	pins := pinBlobs()
+	defer pins.release()
+
+	layer, err := NewLayer(strings.NewReader("in flight"), "application/vnd.ollama.image.system")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a manifest which doesn't exist yet, as when a create replaces a model
+	// and removes the layers of the old one
+	other := Manifest{Layers: []Layer{layer}}
+
+	blob, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PruneLayers(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := other.RemoveLayers(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{blob})
+
+	pins.release()
+	if err := PruneLayers(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFileExists(t, filepath.Join(p, "blobs", "*"), nil)
+
+	t.Run("pinned by another create", func(t *testing.T) {
+		pins := pinBlobs()
+		defer pins.release()
+
+		layer, err := NewLayer(strings.NewReader("in flight"), "application/vnd.ollama.image.system")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a file where the registry's directory should be fails the write
+		if err := os.WriteFile(filepath.Join(p, "manifests", "registry.ollama.ai"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		// a create which fails keeps the blobs another create is using
+		failed := pinBlobs()
+		defer failed.release()
+		if err := writeManifests([]pendingModel{{
+			name:   model.ParseName("test"),
+			config: layer,
+			layers: []Layer{layer},
+			pins:   failed,
+		}}); err == nil {
+			t.Fatal("expected an error")
+		}
+
+		checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{blob})
+	})
+}
+
+func TestCreateTokenizer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture":        "llama",
+		"tokenizer.ggml.tokens":       []string{"<s>", "hello", "</s>"},
+		"tokenizer.ggml.bos_token_id": uint32(0),
+		"tokenizer.ggml.eos_token_id": uint32(1),
+	}, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "base",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:     "test",
+		From:      "base",
+		Tokenizer: &api.Tokenizer{SpecialTokens: map[string]uint32{"eos": 2}},
+		Stream:    &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	base, err := GetModel("base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.ModelPath == base.ModelPath {
+		t.Fatal("expected a new model layer with the tokenizer")
+	}
+
+	decode := func(t *testing.T, p string) llm.KV {
+		t.Helper()
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		ggml, _, err := llm.DecodeGGML(f, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ggml.KV()
+	}
+
+	kv := decode(t, m.ModelPath)
+	if id, _ := kv.SpecialTokenID("eos"); id != 2 {
+		t.Errorf("expected eos token 2, actual %d", id)
+	}
+
+	if id, _ := kv.SpecialTokenID("bos"); id != 0 {
+		t.Errorf("expected bos token 0, actual %d", id)
+	}
+
+	if tokens := kv.Tokens(); !slices.Equal(tokens, []string{"<s>", "hello", "</s>"}) {
+		t.Errorf("expected the tokens to be kept, actual %v", tokens)
+	}
+
+	if id, _ := decode(t, base.ModelPath).SpecialTokenID("eos"); id != 1 {
+		t.Errorf("expected the base model's eos token to be kept, actual %d", id)
+	}
+
+	manifest, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := readManifestConfig(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.SpecialTokens == nil || config.SpecialTokens.EOS == nil || config.SpecialTokens.EOS.ID != 2 {
+		t.Errorf("expected the config's eos token to be 2, actual %+v", config.SpecialTokens)
+	}
+
+	t.Run("derived", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:     "derived",
+			From:      "test",
+			Tokenizer: &api.Tokenizer{SpecialTokens: map[string]uint32{"bos": 1}},
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("derived")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the tokenizer of the model in From is kept
+		kv := decode(t, m.ModelPath)
+		if id, _ := kv.SpecialTokenID("eos"); id != 2 {
+			t.Errorf("expected eos token 2, actual %d", id)
+		}
+
+		if id, _ := kv.SpecialTokenID("bos"); id != 1 {
+			t.Errorf("expected bos token 1, actual %d", id)
+		}
+	})
+
+	cases := []struct {
+		name      string
+		tokenizer api.Tokenizer
+		expect    string
+	}{
+		{"empty", api.Tokenizer{}, "invalid tokenizer: nothing to replace"},
+		{"tokens", api.Tokenizer{Tokens: []string{"<s>", "</s>"}}, "invalid tokenizer: 2 tokens doesn't match the model's vocabulary of 3 tokens"},
+		{"scores", api.Tokenizer{Scores: []float32{0, 0, 0, 0}}, "invalid tokenizer: 4 scores doesn't match the model's vocabulary of 3 tokens"},
+		{"special token", api.Tokenizer{SpecialTokens: map[string]uint32{"eos": 3}}, "invalid tokenizer: eos token 3 is outside the model's vocabulary of 3 tokens"},
+		{"special token kind", api.Tokenizer{SpecialTokens: map[string]uint32{"EOS.x": 1}}, `invalid tokenizer: invalid special token kind "EOS.x"`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:     "invalid",
+				From:      "base",
+				Tokenizer: &tt.tokenizer,
+				Stream:    &stream,
+			})
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp struct {
+				Error string `json:"error"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Error != tt.expect {
+				t.Errorf("expected %q, actual %q", tt.expect, resp.Error)
+			}
+		})
+	}
+}
+
+func TestCreateStoreLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	create := func(t *testing.T, name string) *httptest.ResponseRecorder {
+		t.Helper()
+		return createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  name,
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+	}
+
+	if w := create(t, "test"); w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	// a model sharing the first's layers, which are only counted once
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test2",
+		From:     "test",
+		Template: "{{ .Prompt }}",
+		Stream:   &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	ms, err := Manifests(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := ms[model.ParseName("test")], ms[model.ParseName("test2")]
+	expect := a.Size() + b.Size()
+	for _, layer := range append(a.Layers, a.Config) {
+		if slices.ContainsFunc(append(b.Layers, b.Config), func(l Layer) bool { return l.Digest == layer.Digest }) {
+			expect -= layer.Size
+		}
+	}
+
+	size := int64(storeSize(ms))
+	if size != expect || size == a.Size()+b.Size() {
+		t.Fatalf("expected the shared layers to be counted once in %d bytes, actual %d", expect, size)
+	}
+
+	cases := []struct {
+		name      string
+		maxModels string
+		maxSize   string
+		model     string
+		code      int
+		expect    string
+	}{
+		{"under the model limit", "3", "", "test3", http.StatusOK, ""},
+		{"model limit", "2", "", "test3", http.StatusConflict, "model limit reached: the store has 2 models and the limit is 2"},
+		{"model limit replacing a model", "2", "", "test", http.StatusOK, ""},
+		{"under the size limit", "", strconv.FormatInt(size+1, 10), "test3", http.StatusOK, ""},
+		{"size limit", "", strconv.FormatInt(size, 10), "test", http.StatusInsufficientStorage, "store size limit reached: the store's models use " + format.HumanBytes2(uint64(size)) + " and the limit is " + format.HumanBytes2(uint64(size))},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_MAX_MODELS", tt.maxModels)
+			t.Setenv("OLLAMA_MAX_STORE_SIZE", tt.maxSize)
+
+			w := create(t, tt.model)
+			if w.Code != tt.code {
+				t.Fatalf("expected status code %d, actual %d: %s", tt.code, w.Code, w.Body.String())
+			}
+
+			if tt.code == http.StatusOK {
+				w := createRequest(t, s.DeleteHandler, api.DeleteRequest{Model: "test3"})
+				if w.Code != http.StatusOK && w.Code != http.StatusNotFound {
+					t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+				}
+				return
+			}
+
+			var resp struct {
+				Error string `json:"error"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Error != tt.expect {
+				t.Errorf("expected %q, actual %q", tt.expect, resp.Error)
+			}
+		})
+	}
+}
+
+func TestCreateWarnings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture": "llama",
+		"general.file_type":    uint32(1),
+		"llama.context_length": uint32(2048),
+	}, nil)
+
+	r := api.CreateRequest{
+		Model:      "test",
+		Files:      map[string]string{"test.gguf": digest},
+		Quantize:   "f16",
+		Parameters: map[string]any{"n_ctx": 8192},
+	}
+
+	w := createRequest(t, s.CreateHandler, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	var sent []string
+	var last api.ProgressResponse
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		last = api.ProgressResponse{}
+		if err := json.Unmarshal([]byte(line), &last); err != nil {
+			t.Fatal(err)
+		}
+
+		if last.Warning != "" {
+			if last.Status != "warning: "+last.Warning {
+				t.Errorf("expected the status to be the warning, actual %q", last.Status)
+			}
+			sent = append(sent, last.Warning)
+		}
+	}
+
+	expect := []string{
+		"the model is already F16 so it isn't quantized",
+		"parameter n_ctx is deprecated, use num_ctx instead",
+		"num_ctx 8192 is longer than the context of 2048 the model was trained with",
+	}
+
+	if !slices.Equal(sent, expect) {
+		t.Errorf("expected warnings %q, actual %q", expect, sent)
+	}
+
+	if last.Created == nil || !slices.Equal(last.Created.Warnings, expect) {
+		t.Errorf("expected a summary with warnings %q, actual %+v", expect, last.Created)
+	}
+
+	t.Run("sync", func(t *testing.T) {
+		created, err := CreateModel(context.Background(), r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(created.Warnings, expect) {
+			t.Errorf("expected warnings %q, actual %q", expect, created.Warnings)
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		created, err := CreateModel(context.Background(), api.CreateRequest{
+			Model: "test",
+			Files: map[string]string{"test.gguf": digest},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(created.Warnings) > 0 {
+			t.Errorf("expected no warnings, actual %q", created.Warnings)
+		}
+	})
+}
+
+func TestRecoverModels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture": "llama",
+		"general.name":         "Test Model 7B",
+		"general.file_type":    uint32(1),
+	}, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := os.Remove(filepath.Join(p, "manifests", "registry.ollama.ai", "library", "test", "latest")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, sum, _ := strings.Cut(digest, ":")
+	name := "recovered/test-model-7b:" + sum[:12]
+
+	recoverRequest := func(r api.RecoverRequest) api.RecoverResponse {
+		t.Helper()
+
+		w := createRequest(t, s.RecoverHandler, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.RecoverResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	expect := api.RecoveredModel{
+		Model:         name,
+		Digest:        digest,
+		Architecture:  "llama",
+		ParameterSize: "0",
+		Quantization:  "F16",
+	}
+
+	resp := recoverRequest(api.RecoverRequest{})
+	if len(resp.Models) != 1 || resp.Models[0] != expect {
+		t.Fatalf("expected %+v, actual %+v", expect, resp.Models)
+	}
+
+	checkFileExists(t, filepath.Join(p, "manifests", "*", "*", "*", "*"), nil)
+
+	expect.Written = true
+	resp = recoverRequest(api.RecoverRequest{Write: true})
+	if len(resp.Models) != 1 || resp.Models[0] != expect {
+		t.Fatalf("expected %+v, actual %+v", expect, resp.Models)
+	}
+
+	m, err := GetModel(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.ModelPath != filepath.Join(p, "blobs", strings.Replace(digest, ":", "-", 1)) {
+		t.Errorf("expected the model's blob to be reused, actual %s", m.ModelPath)
+	}
+
+	// the recovered model uses the blob so it isn't reported again
+	if resp := recoverRequest(api.RecoverRequest{}); len(resp.Models) > 0 {
+		t.Errorf("expected no models, actual %+v", resp.Models)
+	}
+}
+
+func TestCreateTempCleanup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	files := make(map[string]string)
+	for name, content := range map[string]string{
+		"model.safetensors": "not safetensors",
+		"config.json":       `{"architectures": ["LlamaForCausalLM"]}`,
+	} {
+		layer, err := NewLayer(strings.NewReader(content), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = layer.Digest
+	}
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  files,
+		Stream: &stream,
+	})
+	if w.Code == http.StatusOK {
+		t.Fatalf("expected the conversion to fail, actual %s", w.Body.String())
+	}
+
+	// the create's temp directory is removed although it failed
+	checkFileExists(t, filepath.Join(p, "tmp", "*"), nil)
+
+	t.Run("interrupted", func(t *testing.T) {
+		dir, err := mkdirCreateTemp(context.Background(), "safetensors-")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, "fp16"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := removeCreateTemps(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(createTempDir()); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected the temp directory to be removed, actual %v", err)
+		}
+	})
+}
+
+func TestVerifyModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"test.gguf": digest},
+		Template: "{{ .Prompt }}",
+		Stream:   &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	verify := func(name string) api.VerifyResponse {
+		t.Helper()
+
+		w := createRequest(t, s.VerifyHandler, api.VerifyRequest{Model: name})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.VerifyResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	statuses := func(resp api.VerifyResponse) map[string]string {
+		s := make(map[string]string)
+		for _, l := range resp.Layers {
+			s[l.MediaType] = strings.TrimSpace(l.Status + " " + l.Error)
+		}
+		return s
+	}
+
+	resp := verify("test")
+	if !resp.OK || resp.Model != "test:latest" || len(resp.Layers) != 3 {
+		t.Fatalf("expected the model's 3 layers to be ok, actual %+v", resp)
+	}
+
+	m, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range m.Layers {
+		blob, err := GetBlobsPath(l.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch l.MediaType {
+		case "application/vnd.ollama.image.model":
+			if err := os.WriteFile(blob, []byte("corrupt"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		case "application/vnd.ollama.image.template":
+			if err := os.Remove(blob); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	resp = verify("test")
+	if resp.OK {
+		t.Error("expected the model to fail verification")
+	}
+
+	got := statuses(resp)
+	if s := got["application/vnd.ollama.image.model"]; !strings.HasPrefix(s, "failed digest mismatch") {
+		t.Errorf("expected the model layer's digest to mismatch, actual %q", s)
+	}
+	if s := got["application/vnd.ollama.image.template"]; s != "failed blob is missing" {
+		t.Errorf("expected the template layer to be missing, actual %q", s)
+	}
+	if s := got["application/vnd.docker.container.image.v1+json"]; s != "ok" {
+		t.Errorf("expected the config to be ok, actual %q", s)
+	}
+
+	t.Run("cached digest", func(t *testing.T) {
+		layer, err := NewLayer(strings.NewReader("cached content"), "application/vnd.ollama.image.license")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blob, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := fileDigest(blob, sha256Digester); err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := os.Stat(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// corrupted without changing the size or modification time the
+		// digest cache is keyed by
+		if err := os.WriteFile(blob, []byte("corrupt content"[:fi.Size()]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(blob, fi.ModTime(), fi.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := verifyLayer(layer); !errors.Is(err, errDigestMismatch) {
+			t.Errorf("expected a digest mismatch, actual %v", err)
+		}
+	})
+
+	t.Run("not gguf", func(t *testing.T) {
+		layer, err := NewLayer(strings.NewReader("not gguf"), "application/vnd.ollama.image.model")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := createConfigLayer([]Layer{layer}, ConfigV2{}, sha256Digester)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := WriteManifest(model.ParseName("invalid"), *config, []Layer{layer}); err != nil {
+			t.Fatal(err)
+		}
+
+		got := statuses(verify("invalid"))
+		if s := got["application/vnd.ollama.image.model"]; !strings.HasPrefix(s, "failed invalid GGUF") {
+			t.Errorf("expected the model layer to be invalid, actual %q", s)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		w := createRequest(t, s.VerifyHandler, api.VerifyRequest{Model: "missing"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status code 404, actual %d", w.Code)
+		}
+	})
+}
+
+func TestCreateDefaultLicense(t *testing.T) {
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	license := filepath.Join(t.TempDir(), "LICENSE")
+	if err := os.WriteFile(license, []byte("Internal use only"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OLLAMA_DEFAULT_LICENSE", license)
+
+	_, digest := createBinFile(t, nil, nil)
+
+	cases := []struct {
+		name   string
+		r      api.CreateRequest
+		expect []string
+	}{
+		{
+			name:   "default",
+			r:      api.CreateRequest{Model: "test", Files: map[string]string{"test.gguf": digest}},
+			expect: []string{"Internal use only"},
+		},
+		{
+			name:   "explicit",
+			r:      api.CreateRequest{Model: "explicit", Files: map[string]string{"test.gguf": digest}, License: "MIT"},
+			expect: []string{"MIT"},
+		},
+		{
+			// the inherited license is the default so it's only added once
+			name:   "inherited",
+			r:      api.CreateRequest{Model: "derived", From: "test"},
+			expect: []string{"Internal use only"},
+		},
+		{
+			name:   "inherited and explicit",
+			r:      api.CreateRequest{Model: "derived", From: "explicit", License: []string{"Apache-2.0"}},
+			expect: []string{"MIT", "Apache-2.0"},
+		},
+		{
+			name:   "inherited and default",
+			r:      api.CreateRequest{Model: "derived", From: "explicit"},
+			expect: []string{"MIT", "Internal use only"},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CreateModel(context.Background(), tt.r, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := GetModel(tt.r.Model)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(m.License, tt.expect) {
+				t.Errorf("expected licenses %q, actual %q", tt.expect, m.License)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		t.Setenv("OLLAMA_DEFAULT_LICENSE", filepath.Join(t.TempDir(), "missing"))
+
+		_, err := CreateModel(context.Background(), api.CreateRequest{Model: "test", Files: map[string]string{"test.gguf": digest}}, nil)
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected the missing license to fail the create, actual %v", err)
+		}
+	})
+}
+
+func TestGGUFLayersStreaming(t *testing.T) {
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// 64 MiB of tensor data written from a single 4 MiB buffer
+	data := make([]byte, 4<<20)
+	var tensors []llm.Tensor
+	for i := range 16 {
+		tensors = append(tensors, llm.Tensor{Name: fmt.Sprintf("blk.%d.attn.weight", i), Kind: 0, Shape: []uint64{1 << 20}, WriterTo: bytes.NewReader(data)})
+	}
+
+	if err := llm.WriteGGUF(f, llm.KV{"general.architecture": "llama"}, tensors); err != nil {
+		t.Fatal(err)
+	}
+
+	// a projector after the model means the model is copied to its own blob
+	if err := llm.WriteGGUF(f, llm.KV{"general.architecture": "clip", "general.type": "projector"}, []llm.Tensor{
+		{Name: "v.blk.0.attn.weight", Kind: 0, Shape: []uint64{8}, WriterTo: bytes.NewReader(make([]byte, 32))},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	digest, _ := GetSHA256Digest(f)
+	if err := createLink(f.Name(), filepath.Join(p, "blobs", "sha256-"+strings.TrimPrefix(digest, "sha256:"))); err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	layers, err := ggufLayers(context.Background(), digest, func(api.ProgressResponse) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	if len(layers) != 2 || layers[0].Digest == digest || layers[0].Size < 64<<20 {
+		t.Fatalf("expected the model to be copied to its own blob, actual %+v", layers)
+	}
+
+	// the copy's memory is its buffer, not the size of the model
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+		t.Errorf("expected copying the model to allocate at most 16 MiB, actual %s", format.HumanBytes2(alloc))
+	}
+}
+
+func TestCreateCapabilityContexts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture": "llama",
+		"llama.context_length": uint32(8192),
+	}, nil)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model: "test",
+		Files: map[string]string{"test.gguf": digest},
+		Parameters: map[string]any{
+			"num_ctx":            float64(2048),
+			"capability_num_ctx": map[string]any{"tools": float64(8192), "vision": float64(4096)},
+		},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		caps        []Capability
+		requestOpts map[string]any
+		numCtx      int
+	}{
+		{[]Capability{CapabilityCompletion}, nil, 2048},
+		{[]Capability{CapabilityCompletion, CapabilityTools}, nil, 8192},
+		{[]Capability{CapabilityCompletion, CapabilityVision}, nil, 4096},
+		{[]Capability{CapabilityCompletion, CapabilityInsert}, nil, 2048},
+		// the longest context of the request's capabilities is used
+		{[]Capability{CapabilityCompletion, CapabilityVision, CapabilityTools}, nil, 8192},
+		// request options take precedence
+		{[]Capability{CapabilityCompletion, CapabilityTools}, map[string]any{"num_ctx": float64(1024)}, 1024},
+	}
+
+	for _, tt := range cases {
+		opts, err := modelOptions(m, "cpu", tt.caps, tt.requestOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if opts.NumCtx != tt.numCtx {
+			t.Errorf("%v: expected num_ctx %d, actual %d", tt.caps, tt.numCtx, opts.NumCtx)
+		}
+	}
+
+	// images for a model without a projector aren't refused
+	if err := m.CheckCapabilities(CapabilityVision); err != nil {
+		t.Errorf("expected no error, actual %v", err)
+	}
+
+	for _, tt := range []struct {
+		contexts map[string]any
+		expect   string
+	}{
+		{map[string]any{"tools": float64(16384)}, "tools num_ctx 16384 is longer than the context of 8192 the model was trained with"},
+		{map[string]any{"embed": float64(1024)}, `unknown capability "embed", expected one of tools, insert, vision`},
+		{map[string]any{"tools": float64(0)}, "tools: num_ctx must be positive"},
+		{map[string]any{"tools": "large"}, "tools: num_ctx must be an integer"},
+	} {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:      "invalid",
+			Files:      map[string]string{"test.gguf": digest},
+			Parameters: map[string]any{"capability_num_ctx": tt.contexts},
+			Stream:     &stream,
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected status code 400, actual %d", tt.contexts, w.Code)
+		}
+
+		var resp struct{ Error string }
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if expect := "invalid capability_num_ctx parameter: " + tt.expect; resp.Error != expect {
+			t.Errorf("expected %q, actual %q", expect, resp.Error)
+		}
+	}
+}
+
+func TestCreateQuantizePolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	// tensors of each kind whose sizes are a multiple of the alignment
+	tensors := func(kind uint32, fill byte) []llm.Tensor {
+		size := map[uint32]int{1: 1024, 2: 288, 8: 544}[kind]
+		var ts []llm.Tensor
+		for _, name := range []string{"token_embd.weight", "blk.0.attn_q.weight", "blk.0.ffn_up.weight", "output.weight"} {
+			ts = append(ts, llm.Tensor{Name: name, Kind: kind, Shape: []uint64{512}, WriterTo: bytes.NewReader(bytes.Repeat([]byte{fill}, size))})
+		}
+		return append(ts, llm.Tensor{Name: "blk.0.attn_norm.weight", Kind: 0, Shape: []uint64{8}, WriterTo: bytes.NewReader(bytes.Repeat([]byte{fill}, 32))})
+	}
+
+	_, digest := createBinFile(t, llm.KV{
+		"general.architecture": "llama",
+		"general.file_type":    uint32(1),
+	}, tensors(1, 0x16))
+
+	// the quantization by an earlier create so llama.cpp isn't needed, with
+	// the attention tensors quantized to Q8_0
+	mixed := tensors(2, 0x40)
+	for i, tt := range tensors(8, 0x80) {
+		if tensorGroup(tt.Name) == "attention" {
+			mixed[i] = tt
+		}
+	}
+
+	_, quantized := createBinFile(t, llm.KV{"general.architecture": "llama", "general.file_type": uint32(2)}, mixed)
+	checkpoint := &quantizeCheckpoint{Source: digest, Type: "Q4_0", Policy: map[string]string{"attention": "Q8_0", "ffn": "Q4_0"}}
+	if err := checkpoint.save(quantized); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := CreateModel(context.Background(), api.CreateRequest{
+		Model:          "test",
+		Files:          map[string]string{"test.gguf": digest},
+		Quantize:       "q4_0",
+		QuantizePolicy: map[string]string{"attention": "q8_0", "ffn": "Q4_0"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]string{"attention": "Q8_0", "ffn": "Q4_0", "output": "Q4_0", "token_embd": "Q4_0"}
+	if !maps.Equal(created.Config.TensorQuantizations, expect) {
+		t.Errorf("expected tensor quantizations %v, actual %v", expect, created.Config.TensorQuantizations)
+	}
+
+	if created.Config.FileType != "Q4_0" {
+		t.Errorf("expected file type Q4_0, actual %s", created.Config.FileType)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(m.ModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ggml, _, err := llm.DecodeGGML(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each tensor's data is from the quantization of its group
+	ts := ggml.Tensors()
+	for _, tt := range ts.Items {
+		fill := byte(0x40)
+		if tensorGroup(tt.Name) == "attention" {
+			fill = 0x80
+		}
+
+		b := make([]byte, tt.Size())
+		if _, err := f.ReadAt(b, int64(ts.Offset+tt.Offset)); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(b, bytes.Repeat([]byte{fill}, len(b))) {
+			t.Errorf("%s: expected the data of the %#x quantization", tt.Name, fill)
+		}
+	}
+
+	// the quantizations are kept by the manifest
+	checkFileExists(t, filepath.Join(p, "checkpoints", "*"), []string{})
+
+	for _, tt := range []struct {
+		name   string
+		r      api.CreateRequest
+		expect string
+	}{
+		{
+			name:   "unknown group",
+			r:      api.CreateRequest{Quantize: "q4_0", QuantizePolicy: map[string]string{"mlp": "q8_0"}},
+			expect: `unknown tensor group "mlp" in quantize_policy, expected one of attention, ffn, output, token_embd`,
+		},
+		{
+			name:   "unknown quantization",
+			r:      api.CreateRequest{Quantize: "q4_0", QuantizePolicy: map[string]string{"ffn": "q1"}},
+			expect: `quantize_policy ffn: unknown quantization "Q1"`,
+		},
+		{
+			name:   "without quantize",
+			r:      api.CreateRequest{QuantizePolicy: map[string]string{"ffn": "q8_0"}},
+			expect: "quantize_policy requires quantize",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.r.Model = "invalid"
+			tt.r.Files = map[string]string{"test.gguf": digest}
+			tt.r.Stream = &stream
+
+			w := createRequest(t, s.CreateHandler, tt.r)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d", w.Code)
+			}
+
+			var resp struct{ Error string }
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.HasPrefix(resp.Error, tt.expect) {
+				t.Errorf("expected %q, actual %q", tt.expect, resp.Error)
+			}
+		})
+	}
+
+	t.Run("missing group", func(t *testing.T) {
+		_, digest := createBinFile(t, llm.KV{
+			"general.architecture": "llama",
+			"general.file_type":    uint32(1),
+		}, tensors(1, 0x16)[:3])
+
+		_, err := CreateModel(context.Background(), api.CreateRequest{
+			Model:          "invalid",
+			Files:          map[string]string{"test.gguf": digest},
+			Quantize:       "q4_0",
+			QuantizePolicy: map[string]string{"output": "q8_0"},
+		}, nil)
+
+		var badRequest badRequestError
+		if !errors.As(err, &badRequest) || err.Error() != "quantize_policy output: the model has no output tensors" {
+			t.Errorf("expected a missing group error, actual %v", err)
+		}
+	})
+}
+
+func TestQuantizeTensorTypes(t *testing.T) {
+	types, err := quantizeTensorTypes(map[string]string{"attention": "Q8_0", "ffn": "Q4_K_M", "output": "Q4_0"}, "q4_0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the output is quantized to the model's type
+	expect := map[string]uint32{tensorGroupPatterns["attention"]: 8, tensorGroupPatterns["ffn"]: 12}
+	if !maps.Equal(types, expect) {
+		t.Errorf("expected %v, actual %v", expect, types)
+	}
+
+	// llama.cpp matches the same tensors as each group
+	for _, name := range []string{
+		"token_embd.weight",
+		"blk.0.attn_q.weight",
+		"blk.12.attn_output.weight",
+		"blk.3.ffn_down.weight",
+		"blk.3.ffn_gate_exps.weight",
+		"output.weight",
+		"output_norm.weight",
+		"blk.0.attn_norm.weight",
+		"enc.blk.0.attn_q.weight",
+	} {
+		var group string
+		for g, pattern := range tensorGroupPatterns {
+			if regexp.MustCompile(pattern).MatchString(name) {
+				group = g
+			}
+		}
+
+		if expect := tensorGroup(name); group != expect {
+			t.Errorf("%s: expected group %q, actual %q", name, expect, group)
+		}
+	}
+}
+
+func TestModelLayers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"test.gguf": digest},
+		Template: "{{ .Prompt }}",
+		Stream:   &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layers := func() api.LayersResponse {
+		t.Helper()
+
+		w := createRequest(t, s.LayersHandler, api.LayersRequest{Model: "test"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.LayersResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	resp := layers()
+	if resp.Model != "test:latest" || resp.Digest != "sha256:"+m.digest {
+		t.Errorf("unexpected model %q or digest %q", resp.Model, resp.Digest)
+	}
+
+	if resp.Config.Digest != m.Config.Digest || resp.Config.Size != m.Config.Size || resp.Config.Missing {
+		t.Errorf("expected config %+v, actual %+v", m.Config, resp.Config)
+	}
+
+	if len(resp.Layers) != len(m.Layers) {
+		t.Fatalf("expected %d layers, actual %d", len(m.Layers), len(resp.Layers))
+	}
+
+	for i, l := range m.Layers {
+		if got := resp.Layers[i]; got.MediaType != l.MediaType || got.Digest != l.Digest || got.Size != l.Size || got.Missing {
+			t.Errorf("expected layer %+v, actual %+v", l, got)
+		}
+	}
+
+	blob, err := GetBlobsPath(m.Layers[0].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(blob); err != nil {
+		t.Fatal(err)
+	}
+
+	resp = layers()
+	if got := resp.Layers[0]; !got.Missing || got.Size != m.Layers[0].Size {
+		t.Errorf("expected the missing layer to keep its manifest size, actual %+v", got)
+	}
+
+	w = createRequest(t, s.LayersHandler, api.LayersRequest{Model: "missing"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status code 404, actual %d", w.Code)
+	}
+}
+
+func TestCreateAdapterNotAdapter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	t.Run("full model", func(t *testing.T) {
+		_, base := createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)
+		_, full := createBinFile(t, llm.KV{"general.architecture": "llama", "general.name": "full"}, nil)
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:    "test",
+			Files:    map[string]string{"test.gguf": base},
+			Adapters: map[string]string{"full.gguf": full},
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp struct{ Error string }
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if expect := "adapter is not a LoRA adapter: full.gguf is a llama model"; resp.Error != expect {
+			t.Errorf("expected error %q, actual %q", expect, resp.Error)
+		}
+
+		if _, err := ParseNamedManifest(model.ParseName("test")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected no model to be created, actual %v", err)
+		}
+	})
+
+	t.Run("converted adapter", func(t *testing.T) {
+		_, base := createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)
+		_, adapter := createBinFile(t, llm.KV{
+			"general.architecture": "llama",
+			"adapter.type":         "lora",
+			"adapter.lora.alpha":   float32(16),
+		}, nil)
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:    "test",
+			Files:    map[string]string{"test.gguf": base},
+			Adapters: map[string]string{"adapter.gguf": adapter},
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := ParseNamedManifest(model.ParseName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.ContainsFunc(m.Layers, func(l Layer) bool {
+			return l.Digest == adapter && l.MediaType == "application/vnd.ollama.image.adapter"
+		}) {
+			t.Errorf("expected the adapter's layer, actual %+v", m.Layers)
+		}
+	})
+}
+
+func TestCreateSystems(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test.gguf": digest},
+		System: "You are a helpful assistant.",
+		Systems: map[string]string{
+			"fr":    "Vous êtes un assistant serviable.",
+			"pt-br": "Você é um assistente prestativo.",
+		},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := map[string]string{
+		"fr":    "Vous êtes un assistant serviable.",
+		"pt-BR": "Você é um assistente prestativo.",
+	}; !maps.Equal(m.Systems, expect) {
+		t.Errorf("expected systems %v, actual %v", expect, m.Systems)
+	}
+
+	for locale, expect := range map[string]string{
+		"":      "You are a helpful assistant.",
+		"fr":    "Vous êtes un assistant serviable.",
+		"fr-CA": "Vous êtes un assistant serviable.",
+		"pt-BR": "Você é um assistente prestativo.",
+		"pt":    "You are a helpful assistant.",
+		"de":    "You are a helpful assistant.",
+		"!!":    "You are a helpful assistant.",
+	} {
+		if actual := m.system(locale); actual != expect {
+			t.Errorf("locale %q: expected system %q, actual %q", locale, expect, actual)
+		}
+	}
+
+	t.Run("inherited", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "child",
+			From:   "test",
+			System: "You are terse.",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("child")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.system("fr") != "Vous êtes un assistant serviable." || m.system("de") != "You are terse." {
+			t.Errorf("expected the locale prompts to be inherited, actual %v", m.Systems)
+		}
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:   "child",
+			From:    "test",
+			Systems: map[string]string{},
+			Stream:  &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("child")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(m.Systems) > 0 || m.system("fr") != "You are a helpful assistant." {
+			t.Errorf("expected the locale prompts to be removed, actual %v", m.Systems)
+		}
+	})
+
+	for name, systems := range map[string]map[string]string{
+		"invalid locale":   {"not a locale": "hello"},
+		"empty prompt":     {"fr": ""},
+		"duplicate locale": {"en-us": "hello", "en-US": "hi"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:   "invalid",
+				Files:   map[string]string{"test.gguf": digest},
+				Systems: systems,
+				Stream:  &stream,
+			})
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCancelCreate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	t.Run("handler", func(t *testing.T) {
+		var causes []error
+		untrack := trackCreate("abc", []model.Name{model.ParseName("canceled")}, func(cause error) {
+			causes = append(causes, cause)
+		})
+		defer untrack()
+
+		cancel := func(r api.CancelCreateRequest) api.CancelCreateResponse {
+			t.Helper()
+
+			w := createRequest(t, s.CancelCreateHandler, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp api.CancelCreateResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			return resp
+		}
+
+		for _, r := range []api.CancelCreateRequest{
+			{Model: "other"},
+			{RequestID: "def"},
+			{Model: "canceled", RequestID: "def"},
+		} {
+			if resp := cancel(r); resp.Canceled {
+				t.Errorf("%+v: expected no create to be canceled, actual %+v", r, resp)
+			}
+		}
+
+		if len(causes) > 0 {
+			t.Fatalf("expected the create not to be canceled, actual %v", causes)
+		}
+
+		for _, r := range []api.CancelCreateRequest{
+			{Model: "CANCELED:latest"},
+			{RequestID: "abc"},
+		} {
+			resp := cancel(r)
+			if !resp.Canceled || !slices.Equal(resp.RequestIDs, []string{"abc"}) {
+				t.Errorf("%+v: expected the create to be canceled, actual %+v", r, resp)
+			}
+		}
+
+		if len(causes) != 2 || !errors.Is(causes[0], errCreateCanceled) {
+			t.Errorf("expected the create to be canceled with errCreateCanceled, actual %v", causes)
+		}
+
+		w := createRequest(t, s.CancelCreateHandler, api.CancelCreateRequest{})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code 400, actual %d", w.Code)
+		}
+	})
+
+	t.Run("blobs", func(t *testing.T) {
+		_, digest := createBinFile(t, nil, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := CreateModel(ctx, api.CreateRequest{
+			Model:    "test",
+			Files:    map[string]string{"test.gguf": digest},
+			Template: "{{ .Prompt }}",
+			System:   "You are a helpful assistant.",
+		}, nil)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the create to be canceled, actual %v", err)
+		}
+
+		if _, err := ParseNamedManifest(model.ParseName("test")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected no manifest to be written, actual %v", err)
+		}
+
+		// the uploaded file is kept so the create can be retried
+		blob, err := GetBlobsPath(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := os.ReadDir(filepath.Dir(blob))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(entries) != 1 || entries[0].Name() != filepath.Base(blob) {
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			t.Errorf("expected only the model's blob to be kept, actual %v", names)
+		}
+	})
+}
+
+func TestCreateReproducible(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	create := func(t *testing.T, reproducible bool) string {
+		t.Helper()
+
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+		var s Server
+
+		_, digest := createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:        "test",
+			Files:        map[string]string{"test.gguf": digest},
+			Template:     "{{ .Prompt }}",
+			Parameters:   map[string]any{"temperature": 0.5, "top_k": 20, "stop": []string{"<|end|>"}},
+			Reproducible: reproducible,
+			Stream:       &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := ParseNamedManifest(model.ParseName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, l := range m.Layers {
+			if reproducible && l.From != "" {
+				t.Errorf("expected %s not to record where it was created from, actual %q", l.MediaType, l.From)
+			}
+		}
+
+		return m.digest
+	}
+
+	// the models directories differ so the blobs' paths do too
+	if a, b := create(t, false), create(t, false); a == b {
+		t.Errorf("expected the manifests to record where their blobs were, actual %s for both", a)
+	}
+
+	if a, b := create(t, true), create(t, true); a != b {
+		t.Errorf("expected the same manifest digest, actual %s and %s", a, b)
+	}
+}
+
+func TestCreateGGUFVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	// versioned writes a copy of a model with its header's version set to v
+	versioned := func(t *testing.T, v uint32) string {
+		t.Helper()
+
+		name, _ := createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		binary.LittleEndian.PutUint32(b[4:8], v)
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+		blob, err := GetBlobsPath(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(blob, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		return digest
+	}
+
+	create := func(t *testing.T, digest string, allow bool) (*httptest.ResponseRecorder, api.ProgressResponse) {
+		t.Helper()
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:          "test",
+			Files:          map[string]string{"test.gguf": digest},
+			AllowNewerGGUF: allow,
+			Stream:         &stream,
+		})
+
+		var resp api.ProgressResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return w, resp
+	}
+
+	t.Run("too new", func(t *testing.T) {
+		w, resp := create(t, versioned(t, llm.MaxGGUFVersion+1), false)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d: %+v", w.Code, resp)
+		}
+
+		if !strings.Contains(resp.Error, "GGUF version is too new: version 4 is newer") {
+			t.Errorf("unexpected error %q", resp.Error)
+		}
+	})
+
+	t.Run("too new allowed", func(t *testing.T) {
+		w, resp := create(t, versioned(t, llm.MaxGGUFVersion+1), true)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %+v", w.Code, resp)
+		}
+
+		if len(resp.Created.Warnings) != 1 || !strings.Contains(resp.Created.Warnings[0], "GGUF version is too new") {
+			t.Errorf("expected a warning about the GGUF version, actual %v", resp.Created.Warnings)
+		}
+	})
+
+	t.Run("too old", func(t *testing.T) {
+		// a newer version being allowed doesn't allow older ones
+		w, resp := create(t, versioned(t, 1), true)
+		if w.Code != http.StatusBadRequest || !strings.Contains(resp.Error, "GGUF version is too old") {
+			t.Errorf("expected the old version to be rejected, actual %d: %+v", w.Code, resp)
+		}
+	})
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	type value struct {
+		Name   string          `json:"name"`
+		Schema json.RawMessage `json:"schema"`
+		Params map[string]any  `json:"params"`
+	}
+
+	a, err := canonicalJSON(value{
+		Name:   "a",
+		Schema: json.RawMessage(`{ "type": "object", "properties": { "b": {}, "a": {} } }`),
+		Params: map[string]any{"top_k": 10, "stop": []string{"<|end|>"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := canonicalJSON(value{
+		Name:   "a",
+		Schema: json.RawMessage(`{"properties":{"a":{},"b":{}},"type":"object"}`),
+		Params: map[string]any{"stop": []string{"<|end|>"}, "top_k": 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := `{"name":"a","params":{"stop":["\u003c|end|\u003e"],"top_k":10},"schema":{"properties":{"a":{},"b":{}},"type":"object"}}`
+	if string(a) != expect || string(b) != expect {
+		t.Errorf("expected %s, actual %s and %s", expect, a, b)
+	}
+
+	// numbers are kept as they're written
+	c, err := canonicalJSON(json.RawMessage(`{"n": 1.50, "m": 12345678901234567890}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := `{"m":12345678901234567890,"n":1.50}`; string(c) != expect {
+		t.Errorf("expected %s, actual %s", expect, c)
+	}
+}
+
+func TestCreateCard(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	card := api.ModelCard{
+		Description: "a small test model",
+		Examples:    []string{"Why is the sky blue?", "Write a haiku about Go"},
+		Tags:        []string{"chat", "test"},
+	}
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "base",
+		Files:  map[string]string{"test.gguf": digest},
+		Card:   &card,
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Run("show", func(t *testing.T) {
+		resp, err := GetModelInfo(api.ShowRequest{Model: "base"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(&card, resp.Card) {
+			t.Errorf("expected card %+v, actual %+v", card, resp.Card)
+		}
+	})
+
+	t.Run("inherited", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "test",
+			From:   "base",
+			System: "be brief",
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(&card, m.Card) {
+			t.Errorf("expected card %+v, actual %+v", card, m.Card)
+		}
+	})
+
+	t.Run("replaced", func(t *testing.T) {
+		replaced := api.ModelCard{Tags: []string{"code"}}
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "test",
+			From:   "test",
+			Card:   &replaced,
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(&replaced, m.Card) {
+			t.Errorf("expected card %+v, actual %+v", replaced, m.Card)
+		}
+
+		if m.System != "be brief" {
+			t.Errorf("expected system %q, actual %q", "be brief", m.System)
+		}
+	})
+
+	cases := []struct {
+		name string
+		card api.ModelCard
+	}{
+		{"empty", api.ModelCard{}},
+		{"empty example", api.ModelCard{Examples: []string{"hi", " "}}},
+		{"empty tag", api.ModelCard{Tags: []string{""}}},
+		{"repeated tag", api.ModelCard{Tags: []string{"chat", "chat"}}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:  "test",
+				From:   "base",
+				Card:   &tt.card,
+				Stream: &stream,
+			})
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("examples not strings", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, map[string]any{
+			"model":  "test",
+			"from":   "base",
+			"card":   map[string]any{"examples": []any{1, 2}},
+			"stream": false,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestCreateFileAndAdapter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	name, digest := createBinFile(t, llm.KV{"general.architecture": "llama"}, nil)
+	gguf, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the blob is the same however its digest is written, or if it's inline
+	for _, tt := range []struct {
+		name string
+		r    api.CreateRequest
+	}{
+		{"digest", api.CreateRequest{Adapters: map[string]string{"adapter.gguf": digest}}},
+		{"blob name", api.CreateRequest{Adapters: map[string]string{"adapter.gguf": strings.Replace(digest, ":", "-", 1)}}},
+		{"upper case", api.CreateRequest{Adapters: map[string]string{"adapter.gguf": "sha256:" + strings.ToUpper(strings.TrimPrefix(digest, "sha256:"))}}},
+		{"inline", api.CreateRequest{InlineAdapters: map[string]string{"adapter.gguf": base64.StdEncoding.EncodeToString(gguf)}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.r.Model, tt.r.Stream = "test", &stream
+			tt.r.Files = map[string]string{"test.gguf": digest}
+
+			w := createRequest(t, s.CreateHandler, tt.r)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp struct{ Error string }
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if expect := "blob can't be both a model file and an adapter: test.gguf is also adapter adapter.gguf"; resp.Error != expect {
+				t.Errorf("expected error %q, actual %q", expect, resp.Error)
+			}
+		})
+	}
+
+	if _, err := ParseNamedManifest(model.ParseName("test")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no model to be created, actual %v", err)
+	}
+
+	blob, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(blob); err != nil {
+		t.Errorf("expected the blob to be kept, actual %v", err)
+	}
+}
+
+func TestCreateLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	logs := t.TempDir()
+	serverLogFile := lifecycle.ServerLogFile
+	lifecycle.ServerLogFile = filepath.Join(logs, "server.log")
+	t.Cleanup(func() { lifecycle.ServerLogFile = serverLogFile })
+
+	_, digest := createBinFile(t, nil, nil)
+	create := func(t *testing.T, name string) {
+		t.Helper()
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  name,
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		create(t, "test")
+
+		if _, err := os.Stat(filepath.Join(logs, "creates")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected no create logs, actual %v", err)
+		}
+	})
+
+	t.Setenv("OLLAMA_CREATE_LOGS", "1")
+
+	t.Run("progress", func(t *testing.T) {
+		create(t, "test")
+
+		files, err := filepath.Glob(filepath.Join(logs, "creates", "test_latest-*.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(files) != 1 {
+			t.Fatalf("expected 1 create log, actual %v", files)
+		}
+
+		f, err := os.Open(files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		var last api.ProgressResponse
+		for dec := json.NewDecoder(f); ; {
+			var resp api.ProgressResponse
+			if err := dec.Decode(&resp); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.RequestID == "" {
+				t.Errorf("expected a request id, actual %+v", resp)
+			}
+
+			last = resp
+		}
+
+		if last.Status != "success" || last.Created == nil || last.Created.Model != "test:latest" {
+			t.Errorf("expected the create to succeed, actual %+v", last)
+		}
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		create(t, "test-2")
+		for range lifecycle.LogRotationCount + 2 {
+			create(t, "test")
+		}
+
+		files, err := filepath.Glob(filepath.Join(logs, "creates", "test_latest-*.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(files) != lifecycle.LogRotationCount+1 {
+			t.Errorf("expected %d create logs, actual %d", lifecycle.LogRotationCount+1, len(files))
+		}
+
+		files, err = filepath.Glob(filepath.Join(logs, "creates", "test-2_latest-*.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(files) != 1 {
+			t.Errorf("expected the other model's log to be kept, actual %v", files)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		w := createRequest(t, s.CreateBatchHandler, api.CreateBatchRequest{
+			Models: []api.CreateRequest{
+				{Model: "batch", Files: map[string]string{"test.gguf": digest}},
+				{Model: "batch-system", Files: map[string]string{"test.gguf": digest}, System: "system"},
+			},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		// a batch is logged under its first model
+		files, err := filepath.Glob(filepath.Join(logs, "creates", "batch*.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(files) != 1 || !strings.HasPrefix(filepath.Base(files[0]), "batch_latest-") {
+			t.Fatalf("expected 1 create log of the batch, actual %v", files)
+		}
+
+		b, err := os.ReadFile(files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(b), `"status":"success"`) || !strings.Contains(string(b), "creating batch-system") {
+			t.Errorf("expected the batch's progress in its log, actual %s", b)
+		}
+	})
+}
+
+func TestCreateContextParameters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:      "base",
+		Files:      map[string]string{"test.gguf": digest},
+		Parameters: map[string]any{"num_ctx": 2048, "num_keep": 24},
+		Stream:     &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	cases := []struct {
+		name       string
+		parameters map[string]any
+		err        string
+	}{
+		{"valid", map[string]any{"num_ctx": 4096, "num_keep": 4, "num_batch": 512}, ""},
+		{"batch equal to context", map[string]any{"num_ctx": 512, "num_batch": 512}, ""},
+		{"keep all", map[string]any{"num_keep": -1}, ""},
+		{"keep equal to context", map[string]any{"num_keep": 2048}, "num_keep 2048 must be less than num_ctx 2048"},
+		{"keep longer than context", map[string]any{"num_ctx": 16, "num_keep": 32}, "num_keep 32 must be less than num_ctx 16"},
+		{"keep longer than inherited context", map[string]any{"num_keep": 4096}, "num_keep 4096 must be less than num_ctx 2048"},
+		{"context shorter than inherited keep", map[string]any{"num_ctx": 16}, "num_keep 24 must be less than num_ctx 16"},
+		{"batch longer than context", map[string]any{"num_ctx": 256, "num_batch": 512}, "num_batch 512 must not be greater than num_ctx 256"},
+		{"batch longer than inherited context", map[string]any{"num_batch": 4096}, "num_batch 4096 must not be greater than num_ctx 2048"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:      "test",
+				From:       "base",
+				Parameters: tt.parameters,
+				Stream:     &stream,
+			})
+
+			if tt.err == "" {
+				if w.Code != http.StatusOK {
+					t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+				}
+				return
+			}
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp struct{ Error string }
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Error != tt.err {
+				t.Errorf("expected error %q, actual %q", tt.err, resp.Error)
+			}
+		})
+	}
+}
+
+func TestCreatePreview(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, llm.KV{"general.architecture": "llama", "general.file_type": uint32(1)}, []llm.Tensor{
+		{Name: "token_embd.weight", Kind: 1, Shape: []uint64{4, 512}, WriterTo: bytes.NewReader(make([]byte, 4096))},
+		{Name: "output.weight", Kind: 1, Shape: []uint64{4, 512}, WriterTo: bytes.NewReader(make([]byte, 4096))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	blob, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = createRequest(t, s.CreatePreviewHandler, api.CreatePreviewRequest{Model: "test", Quantize: "q4_0"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp api.CreatePreviewResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// both tensors are Q4_0 and nothing else changes
+	metadata := fi.Size() - 2*4096
+	if expect := (api.CreatePreviewResponse{
+		Model:         "test:latest",
+		Quantization:  "F16",
+		Size:          fi.Size(),
+		Quantize:      "Q4_0",
+		EstimatedSize: metadata + 2*1152,
+	}); resp != expect {
+		t.Errorf("expected %+v, actual %+v", expect, resp)
+	}
+
+	cases := []struct {
+		name string
+		req  api.CreatePreviewRequest
+		code int
+	}{
+		{"missing quantize", api.CreatePreviewRequest{Model: "test"}, http.StatusBadRequest},
+		{"unknown quantize", api.CreatePreviewRequest{Model: "test", Quantize: "q9_k"}, http.StatusBadRequest},
+		{"invalid name", api.CreatePreviewRequest{Model: "a/b/c/d/e", Quantize: "q4_0"}, http.StatusBadRequest},
+		{"not found", api.CreatePreviewRequest{Model: "missing", Quantize: "q4_0"}, http.StatusNotFound},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreatePreviewHandler, tt.req)
+			if w.Code != tt.code {
+				t.Errorf("expected status code %d, actual %d: %s", tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateScratchDir(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	scratch := filepath.Join(t.TempDir(), "scratch")
+	t.Setenv("OLLAMA_SCRATCH_DIR", scratch)
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"test.gguf": digest},
+		System: "be brief",
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.System != "be brief" {
+		t.Errorf("expected system %q, actual %q", "be brief", m.System)
+	}
+
+	// the scratch directory is made for the layers and they're moved out
+	entries, err := os.ReadDir(scratch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) > 0 {
+		t.Errorf("expected the scratch directory to be empty, actual %v", entries)
+	}
+
+	t.Run("move", func(t *testing.T) {
+		src := filepath.Join(scratch, "src")
+		if err := os.WriteFile(src, []byte("content"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		dst := filepath.Join(p, "blobs", "dst")
+		if err := moveFile(src, dst); err != nil {
+			t.Fatal(err)
+		}
+
+		if b, err := os.ReadFile(dst); err != nil || string(b) != "content" {
+			t.Errorf("expected content, actual %q: %v", b, err)
+		}
+
+		if _, err := os.Stat(src); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected src to be moved, actual %v", err)
+		}
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		src := filepath.Join(scratch, "src")
+		if err := os.WriteFile(src, []byte("content"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		dst := filepath.Join(p, "blobs", "copy")
+		if err := copyFile(src, dst); err != nil {
+			t.Fatal(err)
+		}
+
+		if b, err := os.ReadFile(dst); err != nil || string(b) != "content" {
+			t.Errorf("expected content, actual %q: %v", b, err)
+		}
+
+		matches, err := filepath.Glob(dst + "-*")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(matches) > 0 {
+			t.Errorf("expected no temp files, actual %v", matches)
+		}
+	})
+}
+
+func TestCreateUnsupportedArchitecture(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	cases := []struct {
+		arch   string
+		expect []string
+	}{
+		{"llama", nil},
+		{"other", []string{`test.gguf won't load: unsupported architecture "other", the runner supports ` + strings.Join(llm.SupportedArchitectures, ", ")}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.arch, func(t *testing.T) {
+			_, digest := createBinFile(t, llm.KV{"general.architecture": tt.arch}, nil)
+			created, err := CreateModel(context.Background(), api.CreateRequest{
+				Model: tt.arch,
+				Files: map[string]string{"test.gguf": digest},
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(created.Warnings, tt.expect) {
+				t.Errorf("expected warnings %q, actual %q", tt.expect, created.Warnings)
+			}
+
+			if _, err := GetModel(tt.arch); err != nil {
+				t.Errorf("expected the model to be created, actual %v", err)
+			}
+		})
+	}
+}
+
+func TestStreamJSONArrayMarshalError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ch := make(chan any, 2)
+	ch <- api.ProgressResponse{Status: "parsing"}
+	ch <- math.Inf(1)
+	close(ch)
+
+	w := NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/create", nil)
+	streamJSONArrayResponse(c, ch)
+
+	var resps []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &resps); err != nil {
+		t.Fatalf("expected a JSON array, actual %q: %v", w.Body.String(), err)
+	}
+
+	if len(resps) != 2 || resps[0]["status"] != "parsing" || resps[1]["error"] == nil {
+		t.Errorf("expected the progress and then an error, actual %v", resps)
+	}
+}
+
+func TestStreamEventsMarshalError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ch := make(chan any, 2)
+	ch <- api.ProgressResponse{Status: "parsing"}
+	ch <- math.Inf(1)
+	close(ch)
+
+	w := NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/create", nil)
+	streamEventsResponse(c, ch)
+
+	events := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	if len(events) != 2 || !strings.Contains(events[0], `"status":"parsing"`) {
+		t.Fatalf("expected the progress and then an error, actual %q", events)
+	}
+
+	if !strings.HasPrefix(events[1], "event: error\ndata: {\"error\":") {
+		t.Errorf("expected an error event, actual %q", events[1])
+	}
+}
+
+func TestCreateFromHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	r := api.CreateRequest{
+		Model:     "test",
+		From:      "example.com/gated/model",
+		Headers:   map[string]string{"authorization": "Bearer secret", "X-Custom": "value"},
+		UserAgent: "importer/1.0",
+	}
+
+	t.Run("sent", func(t *testing.T) {
+		var received http.Header
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Clone()
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer srv.Close()
+
+		regOpts, err := fromRegistryOptions(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		regOpts.Insecure = true
+
+		u, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := makeRequest(context.Background(), http.MethodGet, u, nil, nil, regOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		for k, v := range map[string]string{"Authorization": "Bearer secret", "X-Custom": "value", "User-Agent": "importer/1.0"} {
+			if actual := received.Get(k); actual != v {
+				t.Errorf("expected %s %q, actual %q", k, v, actual)
+			}
+		}
+	})
+
+	t.Run("redacted", func(t *testing.T) {
+		regOpts, err := fromRegistryOptions(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		redacted := redactHeaders(regOpts.Headers)
+		if actual := redacted.Get("Authorization"); actual != "REDACTED" {
+			t.Errorf("expected the authorization to be redacted, actual %q", actual)
+		}
+
+		if actual := redacted.Get("X-Custom"); actual != "value" {
+			t.Errorf("expected other headers to be kept, actual %q", actual)
+		}
+
+		if actual := regOpts.Headers.Get("Authorization"); actual != "Bearer secret" {
+			t.Errorf("expected the headers to be unchanged, actual %q", actual)
+		}
+	})
+
+	cases := []struct {
+		name      string
+		headers   map[string]string
+		userAgent string
+		expect    string
+	}{
+		{"header name", map[string]string{"Bad Header": "value"}, "", `invalid header name \"Bad Header\"`},
+		{"header value", map[string]string{"X-Custom": "a\r\nInjected: b"}, "", `invalid value of header \"X-Custom\"`},
+		{"user agent", nil, "importer\n", "invalid user_agent"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateHandler, api.CreateRequest{
+				Model:     "test",
+				From:      "example.com/gated/model",
+				Headers:   tt.headers,
+				UserAgent: tt.userAgent,
+				Stream:    &stream,
+			})
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d: %s", w.Code, w.Body.String())
+			}
+
+			if !strings.Contains(w.Body.String(), tt.expect) {
+				t.Errorf("expected %s, actual %s", tt.expect, w.Body.String())
+			}
+		})
+	}
+}
@@ -45,8 +45,8 @@ func (mockRunner) Tokenize(_ context.Context, s string) (tokens []int, err error
 	return
 }
 
-func newMockServer(mock *mockRunner) func(discover.GpuInfoList, string, *llm.GGML, []string, []string, api.Options, int) (llm.LlamaServer, error) {
-	return func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, projectors, system []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+func newMockServer(mock *mockRunner) func(discover.GpuInfoList, string, *llm.GGML, []string, []string, llm.KV, api.Options, int) (llm.LlamaServer, error) {
+	return func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, projectors, system []string, overrides llm.KV, opts api.Options, numParallel int) (llm.LlamaServer, error) {
 		return mock, nil
 	}
 }
@@ -958,4 +958,41 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:      "test-think",
+		Template:   `{{ if .Think }}<think>{{ end }}{{ .Prompt }}`,
+		Parameters: map[string]any{"think": "true"},
+		From:       "test",
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		think  *bool
+		expect string
+	}{
+		{"model default", nil, "<think>Hello!"},
+		{"request disables", &[]bool{false}[0], "Hello!"},
+	} {
+		t.Run("think "+tt.name, func(t *testing.T) {
+			w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+				Model:  "test-think",
+				Prompt: "Hello!",
+				Think:  tt.think,
+				Stream: &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Errorf("expected status 200, got %d", w.Code)
+			}
+
+			if diff := cmp.Diff(mock.CompletionRequest.Prompt, tt.expect); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
 }
@@ -2,7 +2,9 @@ package server
 
 import (
 	"encoding/json"
+	"maps"
 	"net/http"
+	"net/http/httptest"
 	"slices"
 	"testing"
 
@@ -64,3 +66,71 @@ func TestList(t *testing.T) {
 		t.Fatalf("expected slices to be equal %v", actualNames)
 	}
 }
+
+func TestListDrafts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	for _, r := range []api.CreateRequest{
+		{Name: "stable", Files: map[string]string{"test.gguf": digest}},
+		{Name: "draft", Files: map[string]string{"test.gguf": digest}, Draft: true},
+	} {
+		if w := createRequest(t, s.CreateHandler, r); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+	}
+
+	list := func(t *testing.T, query string) map[string]bool {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/tags?"+query, nil)
+		s.ListHandler(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		var resp api.ListResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		drafts := make(map[string]bool)
+		for _, m := range resp.Models {
+			drafts[m.Name] = m.Draft
+		}
+		return drafts
+	}
+
+	t.Run("default", func(t *testing.T) {
+		if got, want := list(t, ""), map[string]bool{"stable:latest": false}; !maps.Equal(got, want) {
+			t.Fatalf("expected %v, actual %v", want, got)
+		}
+	})
+
+	t.Run("drafts", func(t *testing.T) {
+		if got, want := list(t, "drafts=true"), map[string]bool{"stable:latest": false, "draft:latest": true}; !maps.Equal(got, want) {
+			t.Fatalf("expected %v, actual %v", want, got)
+		}
+	})
+
+	t.Run("promote", func(t *testing.T) {
+		if w := createRequest(t, s.PromoteHandler, api.PromoteRequest{Model: "draft"}); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		if got, want := list(t, ""), map[string]bool{"stable:latest": false, "draft:latest": false}; !maps.Equal(got, want) {
+			t.Fatalf("expected %v, actual %v", want, got)
+		}
+	})
+
+	t.Run("promote missing", func(t *testing.T) {
+		if w := createRequest(t, s.PromoteHandler, api.PromoteRequest{Model: "missing"}); w.Code != http.StatusNotFound {
+			t.Fatalf("expected status code 404, actual %d", w.Code)
+		}
+	})
+}
@@ -120,14 +120,7 @@ func Test_Routes(t *testing.T) {
 			},
 		}
 
-		modelName := model.ParseName(name)
-
-		baseLayers, err := ggufLayers(digest, fn)
-		if err != nil {
-			t.Fatalf("failed to create model: %v", err)
-		}
-
-		if err := createModel(r, modelName, baseLayers, fn); err != nil {
+		if _, err := createModels(context.Background(), r, []model.Name{model.ParseName(name)}, fn); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// modelParameters are the parameters a model can set which aren't runner
+// options, such as keep_alive which is used by the scheduler and think which
+// is used by the template. Their values are validated on create.
+var modelParameters = []string{"keep_alive", "think", "backends", "capability_num_ctx"}
+
+// createRequestSchema describes api.CreateRequest as a JSON schema so
+// clients can validate requests before submitting them.
+func createRequestSchema() map[string]any {
+	schema := typeSchema(reflect.TypeFor[api.CreateRequest]())
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "CreateRequest"
+
+	properties := schema["properties"].(map[string]any)
+	for _, k := range []string{"quantize", "quantization"} {
+		properties[k] = map[string]any{"type": "string", "enum": quantizations()}
+	}
+
+	properties["license"] = map[string]any{
+		"oneOf": []any{
+			map[string]any{"type": "string"},
+			map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+	}
+
+	parameters := typeSchema(reflect.TypeFor[api.Options]())
+	parameters["additionalProperties"] = false
+	properties["parameters"] = parameters
+
+	parameterProperties := parameters["properties"].(map[string]any)
+	options := maps.Clone(parameterProperties)
+	for _, k := range modelParameters {
+		parameterProperties[k] = modelParameterSchema(k, options)
+	}
+
+	// deprecated parameter names are still accepted
+	for alias, name := range api.ParameterAliases {
+		property := maps.Clone(parameterProperties[name].(map[string]any))
+		property["deprecated"] = true
+		parameterProperties[alias] = property
+	}
+
+	for _, k := range []string{"name", "quantization"} {
+		properties[k].(map[string]any)["deprecated"] = true
+	}
+
+	return schema
+}
+
+// modelParameterSchema describes the value of the model parameter k.
+// options describes the runner options.
+func modelParameterSchema(k string, options map[string]any) map[string]any {
+	switch k {
+	case "keep_alive":
+		// a duration such as "10m" or a number of seconds
+		return map[string]any{"oneOf": []any{
+			map[string]any{"type": "string"},
+			map[string]any{"type": "number"},
+		}}
+	case "think":
+		return map[string]any{"oneOf": []any{
+			map[string]any{"type": "boolean"},
+			map[string]any{"type": "string", "enum": []string{"true", "false"}},
+		}}
+	case "backends":
+		return map[string]any{
+			"type":          "object",
+			"propertyNames": map[string]any{"enum": runnerBackends},
+			"additionalProperties": map[string]any{
+				"type":                 "object",
+				"properties":           options,
+				"additionalProperties": false,
+			},
+		}
+	case "capability_num_ctx":
+		names := make([]string, len(contextCapabilities))
+		for i, c := range contextCapabilities {
+			names[i] = string(c)
+		}
+
+		return map[string]any{
+			"type":                 "object",
+			"propertyNames":        map[string]any{"enum": names},
+			"additionalProperties": map[string]any{"type": "integer"},
+		}
+	default:
+		return map[string]any{}
+	}
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	if t.Implements(reflect.TypeFor[json.Marshaler]()) {
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json encodes byte slices as base64 strings
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]any)
+		for _, field := range reflect.VisibleFields(t) {
+			if !field.IsExported() || field.Anonymous {
+				continue
+			}
+
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			} else if name == "" {
+				name = field.Name
+			}
+
+			properties[name] = typeSchema(field.Type)
+		}
+
+		return map[string]any{"type": "object", "properties": properties}
+	default:
+		// interfaces accept any value
+		return map[string]any{}
+	}
+}
+
+func (s *Server) CreateSchemaHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, createRequestSchema())
+}
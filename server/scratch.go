@@ -0,0 +1,44 @@
+package server
+
+import (
+	"os"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// scratchTemp creates a temp file for content on its way into dir. The file
+// is in OLLAMA_SCRATCH_DIR, if it's set, so large content can be written and
+// hashed on a fast local disk even if dir is on slow storage. It's moved
+// into dir with [moveFile] once it's complete.
+func scratchTemp(dir, pattern string) (*os.File, error) {
+	if scratch := envconfig.ScratchDir(); scratch != "" {
+		if err := os.MkdirAll(scratch, 0o755); err != nil {
+			return nil, err
+		}
+
+		dir = scratch
+	}
+
+	return os.CreateTemp(dir, pattern)
+}
+
+// moveFile moves src to dst. Files which can't be renamed, such as those on
+// a scratch disk which is a different file system to dst, are copied with
+// [copyFile] instead, so dst is never partly written, and src is removed.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+
+	if _, statErr := os.Stat(src); statErr != nil {
+		// src is missing so copying it would fail too
+		return err
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
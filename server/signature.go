@@ -0,0 +1,327 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+var (
+	errInvalidSigningKey     = errors.New("invalid signing key")
+	errInvalidSignature      = errors.New("model signature is invalid")
+	errUntrustedSignature    = errors.New("model signature is untrusted")
+	errSigningKeyUnavailable = errors.New("model signing key is unavailable")
+)
+
+// manifestSignature is a detached signature of a manifest. It's stored in
+// the signatures directory of the models directory at the manifest's path
+// relative to the manifests directory, so signatures aren't listed as
+// models. The signature is of the manifest file's bytes.
+//
+// A manifest is signed with an [ssh.Signer], so any key which can sign SSH
+// signatures, including keys held by an agent or hardware, can sign models.
+type manifestSignature struct {
+	// Digest is the digest of the manifest which was signed
+	Digest string `json:"digest"`
+
+	// PublicKey is the signer's public key in authorized_keys format
+	PublicKey string `json:"public_key"`
+
+	Format    string `json:"format"`
+	Signature string `json:"signature"`
+}
+
+// loadSigningKey reads the OpenSSH private key named name in the signing
+// keys directory. Requests can only name a key in the directory, and every
+// key which can't be used fails with the same error so a request can't tell
+// whether a file exists.
+func loadSigningKey(name string) (ssh.Signer, error) {
+	dir := envconfig.SigningKeys()
+	if dir == "" {
+		return nil, badRequestError{fmt.Errorf("%w: no signing keys are configured", errInvalidSigningKey)}
+	}
+
+	if !filepath.IsLocal(name) || filepath.Base(name) != name {
+		return nil, badRequestError{fmt.Errorf("%w %q", errInvalidSigningKey, name)}
+	}
+
+	signer, err := readSigningKey(filepath.Join(dir, name))
+	if err != nil {
+		slog.Debug("couldn't load signing key", "name", name, "error", err)
+		return nil, badRequestError{fmt.Errorf("%w %q", errInvalidSigningKey, name)}
+	}
+
+	return signer, nil
+}
+
+// readSigningKey reads the OpenSSH private key at p, which must be a regular
+// file rather than a link out of the signing keys directory.
+func readSigningKey(p string) (ssh.Signer, error) {
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s isn't a regular file", p)
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.ParsePrivateKey(b)
+}
+
+// signingKeys returns the keys in the signing keys directory, skipping any
+// which can't be read.
+func signingKeys() ([]ssh.Signer, error) {
+	dir := envconfig.SigningKeys()
+	if dir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var signers []ssh.Signer
+	for _, entry := range entries {
+		signer, err := readSigningKey(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			slog.Debug("skipping signing key", "name", entry.Name(), "error", err)
+			continue
+		}
+
+		signers = append(signers, signer)
+	}
+
+	return signers, nil
+}
+
+// trustedSigners returns the public keys whose signatures are trusted: the
+// keys in the trusted signers file and the keys the server signs with.
+func trustedSigners() ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	if p := envconfig.TrustedSigners(); p != "" {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		s := bufio.NewScanner(bytes.NewReader(b))
+		for s.Scan() {
+			line := bytes.TrimSpace(s.Bytes())
+			if len(line) == 0 || line[0] == '#' {
+				continue
+			}
+
+			key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+			if err != nil {
+				return nil, fmt.Errorf("trusted signers %s: %w", p, err)
+			}
+
+			keys = append(keys, key)
+		}
+
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	signers, err := signingKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, signer := range signers {
+		keys = append(keys, signer.PublicKey())
+	}
+
+	return keys, nil
+}
+
+// signaturePath returns the path of the signature of the manifest at p.
+func signaturePath(p string) (string, error) {
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(manifests, p)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("manifest %s isn't in %s", p, manifests)
+	}
+
+	return longPath(filepath.Join(envconfig.Models(), "signatures", rel)), nil
+}
+
+// signManifest writes a signature of the manifest at p.
+func signManifest(p string, signer ssh.Signer) error {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+
+	sig, err := signer.Sign(rand.Reader, b)
+	if err != nil {
+		return err
+	}
+
+	h := sha256Digester.New()
+	h.Write(b)
+	signature, err := json.Marshal(manifestSignature{
+		Digest:    formatDigest(sha256Digester, h),
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))),
+		Format:    sig.Format,
+		Signature: base64.StdEncoding.EncodeToString(sig.Blob),
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeSignature(p, signature)
+}
+
+// readSignature returns the signature of the manifest at p, or nil if the
+// manifest isn't signed.
+func readSignature(p string) ([]byte, error) {
+	sp, err := signaturePath(p)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(sp)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return b, err
+}
+
+// writeSignature writes b as the signature of the manifest at p.
+func writeSignature(p string, b []byte) error {
+	sp, err := signaturePath(p)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sp), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(sp, b, 0o644)
+}
+
+// removeSignature removes the signature of the manifest at p, which is
+// called whenever the manifest is replaced or removed.
+func removeSignature(p string) error {
+	sp, err := signaturePath(p)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(sp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// verifyManifest checks the signature of the manifest at p and returns the
+// key which signed it. Unsigned manifests return a nil key. A signature
+// which doesn't match the manifest fails with [errInvalidSignature] so a
+// model which was changed after it was signed isn't used, and a signature by
+// a key which isn't a trusted signer fails with [errUntrustedSignature] as
+// anyone can sign a manifest with their own key.
+func verifyManifest(p string) (ssh.PublicKey, error) {
+	b, err := readSignature(p)
+	if err != nil || b == nil {
+		return nil, err
+	}
+
+	var s manifestSignature
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidSignature, err)
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidSignature, err)
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidSignature, err)
+	}
+
+	manifest, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := key.Verify(manifest, &ssh.Signature{Format: s.Format, Blob: blob}); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidSignature, err)
+	}
+
+	trusted, err := trustedSigners()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range trusted {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s isn't a trusted signer", errUntrustedSignature, ssh.FingerprintSHA256(key))
+}
+
+// isSignatureError reports whether err is why a signed manifest can't be
+// rewritten, which is a conflict with the model rather than a server error.
+func isSignatureError(err error) bool {
+	return errors.Is(err, errInvalidSignature) || errors.Is(err, errUntrustedSignature) || errors.Is(err, errSigningKeyUnavailable)
+}
+
+// manifestSigner returns the signing key which signed the manifest at p so
+// the manifest can be signed again when it's rewritten. Unsigned manifests
+// return a nil signer. The signature must be valid and trusted so a changed
+// manifest isn't signed again, and the key must be in the signing keys
+// directory or the rewrite fails with [errSigningKeyUnavailable] rather than
+// dropping the signature.
+func manifestSigner(p string) (ssh.Signer, error) {
+	key, err := verifyManifest(p)
+	if err != nil || key == nil {
+		return nil, err
+	}
+
+	signers, err := signingKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), key.Marshal()) {
+			return signer, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s isn't in the signing keys directory", errSigningKeyUnavailable, ssh.FingerprintSHA256(key))
+}
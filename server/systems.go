@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"golang.org/x/text/language"
+)
+
+var errInvalidLocale = errors.New("invalid system prompt locale")
+
+// parseSystems checks the locales of a create's system prompts and keys
+// the prompts by their canonical tags, so "en-us" and "en-US" are the same
+// locale.
+func parseSystems(systems map[string]string) (map[string]string, error) {
+	parsed := make(map[string]string, len(systems))
+	for _, locale := range slices.Sorted(maps.Keys(systems)) {
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q", errInvalidLocale, locale)
+		}
+
+		if systems[locale] == "" {
+			return nil, fmt.Errorf("system prompt for %s is empty", locale)
+		}
+
+		key := tag.String()
+		if _, ok := parsed[key]; ok {
+			return nil, fmt.Errorf("%w %q: %s has more than one system prompt", errInvalidLocale, locale, key)
+		}
+
+		parsed[key] = systems[locale]
+	}
+
+	return parsed, nil
+}
+
+// setSystems replaces the model's system prompts for locales with systems.
+// They're kept in their own layer, next to the default system prompt, so
+// servers which don't select prompts by locale still use the default.
+func setSystems(ctx context.Context, layers []Layer, systems map[string]string) ([]Layer, error) {
+	layers = removeLayer(layers, "application/vnd.ollama.image.systems")
+	if len(systems) == 0 {
+		return layers, nil
+	}
+
+	layer, err := newJSONLayer(ctx, systems, "application/vnd.ollama.image.systems")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(layers, layer), nil
+}
+
+// system returns the model's system prompt for locale: the prompt for the
+// locale itself, e.g. "pt-BR", then for its language, e.g. "pt", and
+// otherwise the default. Locales which can't be parsed use the default.
+func (m *Model) system(locale string) string {
+	if locale == "" || len(m.Systems) == 0 {
+		return m.System
+	}
+
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return m.System
+	}
+
+	if s, ok := m.Systems[tag.String()]; ok {
+		return s
+	}
+
+	if base, confidence := tag.Base(); confidence != language.No {
+		if s, ok := m.Systems[base.String()]; ok {
+			return s
+		}
+	}
+
+	return m.System
+}
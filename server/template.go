@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/template"
+)
+
+// TemplateHandler renders a template with sample conversations so a model's
+// author can check the prompts it produces before the model is created.
+func (s *Server) TemplateHandler(c *gin.Context) {
+	var req api.TemplateRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Template == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "template is required"})
+		return
+	}
+
+	tmpl, err := template.Parse(req.Template)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid template: %v", err)})
+		return
+	}
+
+	resp := api.TemplateResponse{Prompts: make([]api.TemplatePrompt, len(req.Samples))}
+	for i, msgs := range req.Samples {
+		resp.Prompts[i] = renderSample(tmpl, msgs, req.Tools, req.Think)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// renderSample renders tmpl with msgs. If it fails, successively longer
+// prefixes of msgs are rendered to find the message the template fails on.
+func renderSample(tmpl *template.Template, msgs []api.Message, tools api.Tools, think bool) api.TemplatePrompt {
+	var b strings.Builder
+	err := tmpl.Execute(&b, template.Values{Messages: msgs, Tools: tools, Think: think})
+	if err == nil {
+		return api.TemplatePrompt{Prompt: b.String()}
+	}
+
+	for i := range msgs {
+		if err := tmpl.Execute(io.Discard, template.Values{Messages: msgs[:i+1], Tools: tools, Think: think}); err != nil {
+			return api.TemplatePrompt{Error: fmt.Sprintf("message %d (%s): %v", i, msgs[i].Role, err), Message: &i}
+		}
+	}
+
+	return api.TemplatePrompt{Error: err.Error()}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestTemplateHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var s Server
+
+	render := func(t *testing.T, req api.TemplateRequest) (*httptest.ResponseRecorder, api.TemplateResponse) {
+		t.Helper()
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(req); err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/template", &b)
+		s.TemplateHandler(c)
+
+		var resp api.TemplateResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return w, resp
+	}
+
+	t.Run("messages", func(t *testing.T) {
+		w, resp := render(t, api.TemplateRequest{
+			Template: "{{ range .Messages }}<|{{ .Role }}|>{{ .Content }}\n{{ end }}",
+			Samples: [][]api.Message{
+				{{Role: "user", Content: "hi"}},
+				{{Role: "system", Content: "be brief"}, {Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}},
+			},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		expect := []string{
+			"<|user|>hi\n",
+			"<|system|>be brief\n<|user|>hi\n<|assistant|>hello\n",
+		}
+
+		if len(resp.Prompts) != len(expect) {
+			t.Fatalf("expected %d prompts, actual %d", len(expect), len(resp.Prompts))
+		}
+
+		for i, p := range resp.Prompts {
+			if p.Error != "" || p.Prompt != expect[i] {
+				t.Errorf("sample %d: expected %q, actual %q (error %q)", i, expect[i], p.Prompt, p.Error)
+			}
+		}
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		w, resp := render(t, api.TemplateRequest{
+			Template: "{{ if .System }}{{ .System }} {{ end }}{{ .Prompt }} {{ .Response }}",
+			Samples: [][]api.Message{
+				{{Role: "system", Content: "be brief"}, {Role: "user", Content: "hi"}},
+			},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		if expect := "be brief hi "; len(resp.Prompts) != 1 || resp.Prompts[0].Prompt != expect {
+			t.Errorf("expected %q, actual %+v", expect, resp.Prompts)
+		}
+	})
+
+	t.Run("failing message", func(t *testing.T) {
+		w, resp := render(t, api.TemplateRequest{
+			Template: "{{ range .Messages }}{{ if eq .Role \"assistant\" }}{{ index .ToolCalls 0 }}{{ end }}{{ .Content }}{{ end }}",
+			Samples: [][]api.Message{
+				{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}},
+			},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		if len(resp.Prompts) != 1 {
+			t.Fatalf("expected 1 prompt, actual %d", len(resp.Prompts))
+		}
+
+		p := resp.Prompts[0]
+		if p.Message == nil || *p.Message != 1 {
+			t.Errorf("expected the template to fail on message 1, actual %v", p.Message)
+		}
+
+		if !strings.Contains(p.Error, "message 1 (assistant)") || !strings.Contains(p.Error, "index .ToolCalls 0") {
+			t.Errorf("expected the error to name the message and the template action, actual %q", p.Error)
+		}
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		w, _ := render(t, api.TemplateRequest{
+			Template: "{{ .Prompt }",
+			Samples:  [][]api.Message{{{Role: "user", Content: "hi"}}},
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+
+		if !strings.Contains(w.Body.String(), "invalid template") {
+			t.Errorf("expected an invalid template error, actual %s", w.Body.String())
+		}
+	})
+
+	t.Run("missing template", func(t *testing.T) {
+		w, _ := render(t, api.TemplateRequest{
+			Samples: [][]api.Message{{{Role: "user", Content: "hi"}}},
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status code 400, actual %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+var (
+	errUnknownTemplate = errors.New("unknown template")
+	errCircularInclude = errors.New("circular template include")
+	errIncludedModel   = errors.New("can't include the template of model")
+)
+
+// includePattern matches the include directive of a template. Includes are
+// comments so a template with them is still a valid template, e.g.
+//
+//	{{- /* include "chatml:v1" */ -}}
+//	{{ /* include "model:llama3.2" */ }}
+//
+// A reference is either a registered template, as name or name:version, or
+// the template of a model prefixed with "model:".
+var includePattern = regexp.MustCompile(`\{\{(-?)\s*/\*\s*include\s+"([^"]+)"\s*\*/\s*(-?)\}\}`)
+
+// templateNamePattern restricts template names and versions to a single path
+// element so references can't escape the template directory
+var templateNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// registeredTemplate returns the text of the template referenced by ref,
+// either name or name:version, from the template directory. Templates are
+// stored as <name>/<version>.gotmpl.
+func registeredTemplate(ref string) (string, error) {
+	name, version, _ := strings.Cut(ref, ":")
+	if version == "" {
+		version = "latest"
+	}
+
+	if templateNamePattern.MatchString(name) && templateNamePattern.MatchString(version) {
+		b, err := os.ReadFile(filepath.Join(envconfig.Templates(), name, version+".gotmpl"))
+		if err == nil {
+			return string(b), nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+
+	available, err := registeredTemplates()
+	if err != nil {
+		return "", err
+	}
+
+	if len(available) == 0 {
+		return "", fmt.Errorf("%w '%s': no templates are available in %s", errUnknownTemplate, ref, envconfig.Templates())
+	}
+
+	return "", fmt.Errorf("%w '%s', available templates: %s", errUnknownTemplate, ref, strings.Join(available, ", "))
+}
+
+// resolveIncludes replaces the include directives of a template with the
+// templates they reference, which may include other templates themselves.
+// Trim markers on a directive trim the whitespace around the included text.
+// stack lists the references being resolved, outermost first, so circular
+// includes are rejected.
+func resolveIncludes(tmpl string, stack []string) (string, error) {
+	var b strings.Builder
+	var last int
+	for _, m := range includePattern.FindAllStringSubmatchIndex(tmpl, -1) {
+		before := tmpl[last:m[0]]
+		if m[3] > m[2] {
+			before = strings.TrimRightFunc(before, unicode.IsSpace)
+		}
+		b.WriteString(before)
+
+		ref := tmpl[m[4]:m[5]]
+		if slices.Contains(stack, ref) {
+			return "", fmt.Errorf("%w: %s", errCircularInclude, strings.Join(append(stack, ref), " -> "))
+		}
+
+		included, err := includedTemplate(ref)
+		if err != nil {
+			return "", err
+		}
+
+		included, err = resolveIncludes(included, append(stack, ref))
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(included)
+
+		last = m[1]
+		if m[7] > m[6] {
+			last += len(tmpl[last:]) - len(strings.TrimLeftFunc(tmpl[last:], unicode.IsSpace))
+		}
+	}
+
+	b.WriteString(tmpl[last:])
+	return b.String(), nil
+}
+
+// includedTemplate returns the text of the template an include references.
+func includedTemplate(ref string) (string, error) {
+	name, ok := strings.CutPrefix(ref, "model:")
+	if !ok {
+		return registeredTemplate(ref)
+	}
+
+	n := model.ParseName(name)
+	if !n.IsValid() {
+		return "", fmt.Errorf("%w '%s': %s", errIncludedModel, name, errtypes.InvalidModelNameErrMsg)
+	}
+
+	m, err := GetModel(n.String())
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w '%s': model not found", errIncludedModel, name)
+	} else if err != nil {
+		return "", err
+	}
+
+	return m.Template.String(), nil
+}
+
+// registeredTemplates lists the templates in the template directory as
+// name:version.
+func registeredTemplates() ([]string, error) {
+	root := envconfig.Templates()
+	dirs, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var refs []string
+	for _, dir := range dirs {
+		if !dir.IsDir() || !templateNamePattern.MatchString(dir.Name()) {
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(root, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			if version, ok := strings.CutSuffix(f.Name(), ".gotmpl"); ok && !f.IsDir() {
+				refs = append(refs, dir.Name()+":"+version)
+			}
+		}
+	}
+
+	return refs, nil
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+var errInvalidTokenizer = errors.New("invalid tokenizer")
+
+var specialTokenPattern = regexp.MustCompile(`^[a-z]+(_[a-z]+)*$`)
+
+// setTokenizer replaces the tokenizer of the model in layers with t. The
+// tokenizer is checked against the vocabulary of the model in baseLayers
+// and written into a new model layer, so the model loads as it is and its
+// blob is pruned like any other.
+func setTokenizer(ctx context.Context, layers []Layer, baseLayers []*layerGGML, t api.Tokenizer, fn func(resp api.ProgressResponse)) ([]Layer, error) {
+	i := slices.IndexFunc(baseLayers, func(l *layerGGML) bool {
+		return l.GGML != nil && l.MediaType == "application/vnd.ollama.image.model"
+	})
+	j := slices.IndexFunc(layers, func(l Layer) bool {
+		return l.MediaType == "application/vnd.ollama.image.model"
+	})
+	if i < 0 || j < 0 {
+		return nil, badRequestError{errors.New("tokenizer requires a model")}
+	}
+
+	if err := checkTokenizer(baseLayers[i].KV(), t); err != nil {
+		return nil, badRequestError{fmt.Errorf("%w: %w", errInvalidTokenizer, err)}
+	}
+
+	fn(api.ProgressResponse{Status: "replacing tokenizer"})
+
+	// the model may already have been quantized or stripped, so the tokenizer
+	// is written into the model's layer rather than the base layer
+	layer := layers[j]
+	blob, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(blob)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, _, err := llm.SetGGUFKV(f, layer.Size, tokenizerKV(t))
+	if err != nil {
+		return nil, err
+	}
+
+	newLayer, err := newCreateLayer(ctx, r, layer.MediaType)
+	if err != nil {
+		return nil, err
+	}
+	newLayer.Source = layer.Source
+	newLayer.checkpoints = layer.checkpoints
+
+	layers = slices.Clone(layers)
+	layers[j] = newLayer
+	return layers, nil
+}
+
+// checkTokenizer checks that t is consistent with the vocabulary of the
+// model whose metadata is kv: the vocabulary must keep its size and special
+// tokens must be in it.
+func checkTokenizer(kv llm.KV, t api.Tokenizer) error {
+	if t.Tokens == nil && t.Scores == nil && t.TokenTypes == nil && t.Merges == nil && len(t.SpecialTokens) == 0 {
+		return errors.New("nothing to replace")
+	}
+
+	size := kv.VocabSize()
+	if size == 0 {
+		return errors.New("model doesn't have a vocabulary")
+	}
+
+	for name, n := range map[string]int{
+		"tokens":      len(t.Tokens),
+		"scores":      len(t.Scores),
+		"token types": len(t.TokenTypes),
+	} {
+		if n > 0 && uint64(n) != size {
+			return fmt.Errorf("%d %s doesn't match the model's vocabulary of %d tokens", n, name, size)
+		}
+	}
+
+	for _, kind := range slices.Sorted(maps.Keys(t.SpecialTokens)) {
+		if !specialTokenPattern.MatchString(kind) {
+			return fmt.Errorf("invalid special token kind %q", kind)
+		}
+
+		if id := t.SpecialTokens[kind]; uint64(id) >= size {
+			return fmt.Errorf("%s token %d is outside the model's vocabulary of %d tokens", kind, id, size)
+		}
+	}
+
+	return nil
+}
+
+// tokenizerKV returns the GGUF key values which apply t to a model.
+func tokenizerKV(t api.Tokenizer) llm.KV {
+	kv := llm.KV{}
+	if t.Tokens != nil {
+		kv["tokenizer.ggml.tokens"] = t.Tokens
+	}
+	if t.Scores != nil {
+		kv["tokenizer.ggml.scores"] = t.Scores
+	}
+	if t.TokenTypes != nil {
+		kv["tokenizer.ggml.token_type"] = t.TokenTypes
+	}
+	if t.Merges != nil {
+		kv["tokenizer.ggml.merges"] = t.Merges
+	}
+	for kind, id := range t.SpecialTokens {
+		kv[fmt.Sprintf("tokenizer.ggml.%s_token_id", kind)] = id
+	}
+
+	return kv
+}
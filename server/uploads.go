@@ -0,0 +1,478 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// uploadPrefix marks a file in a create request which refers to a chunked
+// upload rather than a blob, e.g. "upload:<id>". The upload is stored as a
+// blob when the model is created.
+const uploadPrefix = "upload:"
+
+var (
+	errUnknownUpload  = errors.New("unknown upload")
+	errUploadTooLarge = errors.New("upload is too large")
+)
+
+// uploadLocks serializes the requests for each upload. A lock is only kept
+// while a request holds or waits for it, so finished, expired and removed
+// uploads, as well as requests for unknown ones, don't leave entries behind.
+var uploadLocks = struct {
+	sync.Mutex
+	locks map[string]*uploadLock
+}{locks: make(map[string]*uploadLock)}
+
+type uploadLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockUpload locks the upload id and returns the function which unlocks it.
+// The id is checked before it's locked so an invalid id never takes a lock.
+func lockUpload(id string) (func(), error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errUnknownUpload
+	}
+
+	uploadLocks.Lock()
+	l, ok := uploadLocks.locks[id]
+	if !ok {
+		l = &uploadLock{}
+		uploadLocks.locks[id] = l
+	}
+	l.refs++
+	uploadLocks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		uploadLocks.Lock()
+		defer uploadLocks.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(uploadLocks.locks, id)
+		}
+	}, nil
+}
+
+// uploadState is the progress of an upload. The state of its digest is kept
+// so the upload is hashed as it's received rather than once it's complete.
+type uploadState struct {
+	Size int64  `json:"size"`
+	Hash []byte `json:"hash"`
+}
+
+func uploadPath(id string) (string, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return "", errUnknownUpload
+	}
+
+	dir := filepath.Join(envconfig.Models(), "uploads")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, id), nil
+}
+
+func readUploadState(id string) (string, *uploadState, error) {
+	p, err := uploadPath(id)
+	if err != nil {
+		return "", nil, err
+	}
+
+	b, err := os.ReadFile(p + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, errUnknownUpload
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	var state uploadState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return "", nil, err
+	}
+
+	return p, &state, nil
+}
+
+func writeUploadState(p string, state *uploadState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(p+".json", b, 0o644)
+}
+
+// StartUploadHandler starts a chunked upload. Chunks are appended to it with
+// UploadChunkHandler and it's referred to in a create request as
+// "upload:<id>".
+func (s *Server) StartUploadHandler(c *gin.Context) {
+	if err := removeExpiredUploads(); err != nil {
+		slog.Warn("couldn't remove expired uploads", "error", err)
+	}
+
+	id := uuid.NewString()
+	p, err := uploadPath(id)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	state := uploadState{}
+	state.Hash, err = sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := os.WriteFile(p, nil, 0o644); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := writeUploadState(p, &state); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Location", "/api/uploads/"+id)
+	c.JSON(http.StatusCreated, api.UploadResponse{ID: id})
+}
+
+// UploadChunkHandler appends the request body to an upload. If the
+// Upload-Offset header is set it must match the size of the upload so a
+// chunk isn't appended twice when a client retries.
+func (s *Server) UploadChunkHandler(c *gin.Context) {
+	id := c.Param("id")
+	unlock, err := lockUpload(id)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	defer unlock()
+
+	p, state, err := readUploadState(id)
+	if errors.Is(err, errUnknownUpload) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if s := c.GetHeader("Upload-Offset"); s != "" {
+		offset, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Offset"})
+			return
+		}
+
+		if offset != state.Size {
+			c.AbortWithStatusJSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": fmt.Sprintf("upload offset is %d, expected %d", offset, state.Size)})
+			return
+		}
+	}
+
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state.Hash); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	f, err := os.OpenFile(p, os.O_WRONLY, 0o644)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	// drop anything left by a chunk which failed part way through
+	if err := f.Truncate(state.Size); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := f.Seek(state.Size, io.SeekStart); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// a byte past the limit is read to tell a chunk which reaches it from
+	// one which exceeds it
+	limit := int64(min(envconfig.MaxUploadSize(), math.MaxInt64-1))
+	n, err := io.Copy(f, io.TeeReader(io.LimitReader(c.Request.Body, limit-state.Size+1), h))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if state.Size+n > limit {
+		// the chunk is dropped when the next one is appended
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("%s: uploads are limited to %d bytes", errUploadTooLarge, limit)})
+		return
+	}
+
+	state.Size += n
+	state.Hash, err = h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := writeUploadState(p, state); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, api.UploadResponse{ID: id, Size: state.Size})
+}
+
+// UploadStatusHandler reports the size of an upload so an interrupted
+// upload can be resumed.
+func (s *Server) UploadStatusHandler(c *gin.Context) {
+	id := c.Param("id")
+	unlock, err := lockUpload(id)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	defer unlock()
+
+	_, state, err := readUploadState(id)
+	if errors.Is(err, errUnknownUpload) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.UploadResponse{ID: id, Size: state.Size})
+}
+
+// DeleteUploadHandler cancels an upload.
+func (s *Server) DeleteUploadHandler(c *gin.Context) {
+	id := c.Param("id")
+	unlock, err := lockUpload(id)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	defer unlock()
+
+	p, _, err := readUploadState(id)
+	if errors.Is(err, errUnknownUpload) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := removeUpload(p); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func removeUpload(p string) error {
+	if err := os.Remove(p + ".json"); err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// removeExpiredUploads removes the uploads which haven't been written to for
+// longer than OLLAMA_UPLOAD_TTL, such as those a client abandoned.
+func removeExpiredUploads() error {
+	matches, err := filepath.Glob(filepath.Join(envconfig.Models(), "uploads", "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		id := strings.TrimSuffix(filepath.Base(match), ".json")
+		if _, err := uuid.Parse(id); err != nil {
+			continue
+		}
+
+		if err := removeExpiredUpload(id); err != nil {
+			slog.Warn("couldn't remove expired upload", "id", id, "error", err)
+		}
+	}
+
+	return nil
+}
+
+func removeExpiredUpload(id string) error {
+	unlock, err := lockUpload(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	p, err := uploadPath(id)
+	if err != nil {
+		return err
+	}
+
+	// the state is written with every chunk
+	fi, err := os.Stat(p + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if time.Since(fi.ModTime()) < envconfig.UploadTTL() {
+		return nil
+	}
+
+	return removeUpload(p)
+}
+
+// uploadedBlobPath returns the path of the record that the blob of digest
+// was uploaded with [Server.CreateBlobHandler].
+func uploadedBlobPath(digest string) string {
+	return filepath.Join(envconfig.Models(), "uploads", "blobs", strings.Replace(digest, ":", "-", 1))
+}
+
+// recordUploadedBlob records that the blob of digest was uploaded, so it
+// isn't pruned before a create has had the chance to use it.
+func recordUploadedBlob(digest string) error {
+	p := uploadedBlobPath(digest)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(p, nil, 0o644); err != nil {
+		return err
+	}
+
+	// an upload of a blob which was uploaded before restarts its TTL
+	now := time.Now()
+	return os.Chtimes(p, now, now)
+}
+
+// keepUploadedBlobs removes the digests of blobs uploaded within
+// OLLAMA_UPLOAD_TTL from digests, and removes the records of blobs uploaded
+// before then.
+func keepUploadedBlobs(digests map[string]struct{}) error {
+	dir := filepath.Join(envconfig.Models(), "uploads", "blobs")
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		fi, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if time.Since(fi.ModTime()) < envconfig.UploadTTL() {
+			delete(digests, strings.Replace(entry.Name(), "-", ":", 1))
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// finishUpload stores a complete upload as a blob and returns its digest.
+func finishUpload(id string) (string, error) {
+	unlock, err := lockUpload(id)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	p, state, err := readUploadState(id)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state.Hash); err != nil {
+		return "", err
+	}
+
+	// drop anything left by a chunk which failed part way through
+	if err := os.Truncate(p, state.Size); err != nil {
+		return "", err
+	}
+
+	digest := fmt.Sprintf("sha256:%x", h.Sum(nil))
+	blob, err := GetBlobsPath(digest)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(blob); errors.Is(err, os.ErrNotExist) {
+		if err := os.Rename(p, blob); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	if err := removeUpload(p); err != nil {
+		return "", err
+	}
+
+	return digest, nil
+}
+
+// resolveUploads replaces the uploads referred to in files with the digests
+// of the blobs they're stored as.
+func resolveUploads(files map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(files))
+	for name, digest := range files {
+		if id, ok := strings.CutPrefix(digest, uploadPrefix); ok {
+			var err error
+			digest, err = finishUpload(id)
+			if errors.Is(err, errUnknownUpload) {
+				return nil, badRequestError{fmt.Errorf("%w %q for %s", errUnknownUpload, id, name)}
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		resolved[name] = digest
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+// VerifyHandler checks the layers of an existing model without rebuilding
+// it, as a health check of the model's blobs.
+func (s *Server) VerifyHandler(c *gin.Context) {
+	var r api.VerifyRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(r.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %q", errtypes.InvalidModelNameErrMsg, r.Model)})
+		return
+	}
+
+	m, err := ParseNamedManifest(name)
+	if errors.Is(err, os.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": modelNotFoundError(r.Model).Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, verifyModel(name, m))
+}
+
+// ggufMediaTypes are the media types of the layers whose blobs are GGUF.
+var ggufMediaTypes = []string{
+	"application/vnd.ollama.image.model",
+	"application/vnd.ollama.image.adapter",
+	"application/vnd.ollama.image.projector",
+}
+
+// verifyModel verifies the config and layers of the model named name whose
+// manifest is m. Every layer is verified, even after one fails, so the
+// report shows all of the model's damage.
+func verifyModel(name model.Name, m *Manifest) api.VerifyResponse {
+	resp := api.VerifyResponse{Model: name.DisplayShortest(), OK: true}
+	for _, layer := range append([]Layer{m.Config}, m.Layers...) {
+		verified := api.VerifiedLayer{MediaType: layer.MediaType, Digest: layer.Digest, Status: "ok"}
+		if err := verifyLayer(layer); err != nil {
+			verified.Status, verified.Error = "failed", err.Error()
+			resp.OK = false
+		}
+
+		resp.Layers = append(resp.Layers, verified)
+	}
+
+	return resp
+}
+
+// verifyLayer checks the layer's blob against its digest and, if it's GGUF,
+// that it can be decoded.
+func verifyLayer(layer Layer) error {
+	if err := verifyBlob(layer.Digest); errors.Is(err, os.ErrNotExist) {
+		return errors.New("blob is missing")
+	} else if err != nil {
+		return err
+	}
+
+	if !slices.Contains(ggufMediaTypes, layer.MediaType) {
+		return nil
+	}
+
+	f, err := layer.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, _, err := llm.DecodeGGML(f, 0); err != nil {
+		return fmt.Errorf("invalid GGUF: %w", err)
+	}
+
+	return nil
+}
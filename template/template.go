@@ -168,6 +168,9 @@ type Values struct {
 	Prompt string
 	Suffix string
 
+	// Think is true when the model should reason before it responds
+	Think bool
+
 	// forceLegacy is a flag used to test compatibility with legacy templates
 	forceLegacy bool
 }
@@ -224,6 +227,7 @@ func (t *Template) Execute(w io.Writer, v Values) error {
 		return t.Template.Execute(w, map[string]any{
 			"Prompt":   v.Prompt,
 			"Suffix":   v.Suffix,
+			"Think":    v.Think,
 			"Response": "",
 		})
 	} else if !v.forceLegacy && slices.Contains(t.Vars(), "messages") {
@@ -231,6 +235,7 @@ func (t *Template) Execute(w io.Writer, v Values) error {
 			"System":   system,
 			"Messages": messages,
 			"Tools":    v.Tools,
+			"Think":    v.Think,
 			"Response": "",
 		})
 	}
@@ -243,6 +248,7 @@ func (t *Template) Execute(w io.Writer, v Values) error {
 			if err := t.Template.Execute(&b, map[string]any{
 				"System":   system,
 				"Prompt":   prompt,
+				"Think":    v.Think,
 				"Response": response,
 			}); err != nil {
 				return err
@@ -288,6 +294,7 @@ func (t *Template) Execute(w io.Writer, v Values) error {
 	if err := template.Must(template.New("").AddParseTree("", &tree)).Execute(&b, map[string]any{
 		"System":   system,
 		"Prompt":   prompt,
+		"Think":    v.Think,
 		"Response": response,
 	}); err != nil {
 		return err